@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/signal"
+	"syscall"
+	"text/tabwriter"
+
+	"github.com/SimonRichardson/keyval/pkg/bench"
+	"github.com/SimonRichardson/keyval/pkg/client"
+	"github.com/pkg/errors"
+)
+
+func runBench(args []string) error {
+	var (
+		flags = flag.NewFlagSet("bench", flag.ExitOnError)
+
+		common       = newClientFlags(flags)
+		concurrency  = flags.Int("concurrency", defaultBenchConcurrency, "number of requests in flight at the same time")
+		duration     = flags.Duration("duration", defaultBenchDuration, "how long to run for, zero runs until the requests are sent")
+		requests     = flags.Int("requests", 0, "number of requests to send, zero runs until the duration has elapsed")
+		keys         = flags.Int("keys", defaultBenchKeys, "number of distinct keys")
+		prefix       = flags.String("prefix", "bench:", "prefix of every key")
+		distribution = flags.String("distribution", "uniform", "distribution of the keys (uniform, zipfian or sequential)")
+		valueSize    = flags.Int("value-size", defaultBenchValueSize, "size of the values written in bytes")
+		reads        = flags.Float64("reads", defaultBenchReads, "ratio of reads to writes (0 is only writes, 1 is only reads)")
+		seed         = flags.Int64("seed", 1, "seed of the random numbers, so runs can be repeated")
+		output       = flags.String("output", "", "write the report as JSON to a file, as well as to stdout")
+	)
+
+	name := "bench [flags]"
+	flags.Usage = usageFor(flags, name)
+	if err := flags.Parse(args); err != nil {
+		return nil
+	}
+
+	d, err := bench.ParseDistribution(*distribution)
+	if err != nil {
+		return errorFor(flags, name, err)
+	}
+	config := bench.Config{
+		Concurrency:  *concurrency,
+		Duration:     *duration,
+		Requests:     *requests,
+		Keys:         *keys,
+		Prefix:       *prefix,
+		Distribution: d,
+		ValueSize:    *valueSize,
+		ReadRatio:    *reads,
+		Seed:         *seed,
+	}
+	if err := config.Validate(); err != nil {
+		return errorFor(flags, name, err)
+	}
+
+	// Errors are what's being measured, so they aren't hidden by retries.
+	c, err := common.dial(client.Options{
+		Retries:      -1,
+		MaxIdleConns: *concurrency,
+	})
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	// An interrupt stops the run early, but still reports on it.
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		c := make(chan os.Signal, 1)
+		signal.Notify(c, syscall.SIGINT, syscall.SIGTERM)
+		defer signal.Stop(c)
+		select {
+		case <-c:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	report, err := bench.Run(ctx, c, config)
+	if err != nil {
+		return err
+	}
+
+	if err := writeReport(os.Stdout, *common.addr, report); err != nil {
+		return err
+	}
+	if *output != "" {
+		b, err := json.MarshalIndent(report, "", "  ")
+		if err != nil {
+			return err
+		}
+		if err := ioutil.WriteFile(*output, append(b, '\n'), 0644); err != nil {
+			return errors.Wrap(err, "write report")
+		}
+	}
+	return nil
+}
+
+func writeReport(w io.Writer, addr string, report bench.Report) error {
+	writer := tabwriter.NewWriter(w, 0, 2, 2, ' ', 0)
+	fmt.Fprintf(writer, "addr\t%s\n", addr)
+	fmt.Fprintf(writer, "elapsed\t%s\n", report.Elapsed)
+	fmt.Fprintf(writer, "requests\t%d\n", report.Requests)
+	fmt.Fprintf(writer, "  reads\t%d\n", report.Reads)
+	fmt.Fprintf(writer, "  writes\t%d\n", report.Writes)
+	fmt.Fprintf(writer, "  misses\t%d\n", report.Misses)
+	fmt.Fprintf(writer, "  errors\t%d\n", report.Errors)
+	fmt.Fprintf(writer, "throughput\t%.0f ops/s\n", report.Throughput)
+	fmt.Fprintf(writer, "latency\t\n")
+	fmt.Fprintf(writer, "  min\t%s\n", report.Latency.Min)
+	fmt.Fprintf(writer, "  mean\t%s\n", report.Latency.Mean)
+	fmt.Fprintf(writer, "  p50\t%s\n", report.Latency.P50)
+	fmt.Fprintf(writer, "  p99\t%s\n", report.Latency.P99)
+	fmt.Fprintf(writer, "  p999\t%s\n", report.Latency.P999)
+	fmt.Fprintf(writer, "  max\t%s\n", report.Latency.Max)
+	return writer.Flush()
+}
@@ -0,0 +1,260 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/SimonRichardson/keyval/pkg/client"
+	keyvalNet "github.com/SimonRichardson/keyval/pkg/net"
+	"github.com/pkg/errors"
+)
+
+// clientFlags are the flags shared by all of the client commands.
+type clientFlags struct {
+	addr    *string
+	timeout *time.Duration
+	codec   *string
+}
+
+func newClientFlags(flags *flag.FlagSet) clientFlags {
+	return clientFlags{
+		addr:    flags.String("addr", defaultClientAddr, "address of the store API (tcp://, udp:// or http://)"),
+		timeout: flags.Duration("timeout", defaultClientTimeout, "timeout of each request"),
+		codec:   flags.String("codec", defaultClientCodec, "codec of the tcp and udp APIs (gob, json, msgpack or protobuf)"),
+	}
+}
+
+// dial creates the client for the transport of the address, the timeout and
+// codec of the options are taken from the flags.
+func (f clientFlags) dial(options client.Options) (client.Client, error) {
+	codec, err := keyvalNet.ParseCodec(*f.codec)
+	if err != nil {
+		return nil, err
+	}
+	options.Timeout = *f.timeout
+	options.Codec = codec
+
+	defaultPort := defaultAPITCPPort
+	switch addr := strings.ToLower(*f.addr); {
+	case strings.HasPrefix(addr, "udp://"):
+		defaultPort = defaultAPIUDPPort
+	case strings.HasPrefix(addr, "http://"):
+		defaultPort = defaultAPIHTTPPort
+	}
+
+	network, address, err := parseAddr(*f.addr, defaultPort)
+	if err != nil {
+		return nil, err
+	}
+	switch network {
+	case "tcp":
+		return client.NewTCP(address, options), nil
+	case "udp":
+		return client.NewUDP(address, options), nil
+	case "http":
+		return client.NewHTTP(fmt.Sprintf("http://%s/store", address), options)
+	}
+	return nil, errors.Errorf("%s: unsupported network", network)
+}
+
+func runGet(args []string) error {
+	var (
+		flags = flag.NewFlagSet("get", flag.ExitOnError)
+
+		common = newClientFlags(flags)
+		output = flags.String("format", "raw", "output format of the value (raw, hex, base64 or json)")
+	)
+
+	name := "get [flags] <key>"
+	flags.Usage = usageFor(flags, name)
+	if err := flags.Parse(args); err != nil {
+		return nil
+	}
+	if flags.NArg() != 1 {
+		return errorFor(flags, name, errors.New("expected a key"))
+	}
+	f, err := parseFormat(*output)
+	if err != nil {
+		return errorFor(flags, name, err)
+	}
+
+	c, err := common.dial(client.Options{})
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	key := flags.Arg(0)
+	value, err := c.Get(context.Background(), key)
+	if err != nil {
+		return errors.Wrap(err, key)
+	}
+
+	return writeEntry(os.Stdout, f, client.Entry{
+		Key:   key,
+		Value: value,
+	}, false)
+}
+
+func runSet(args []string) error {
+	var (
+		flags = flag.NewFlagSet("set", flag.ExitOnError)
+
+		common = newClientFlags(flags)
+		file   = flags.String("file", "", "read the value from a file, instead of the arguments or stdin")
+		ttl    = flags.Duration("ttl", 0, "time until the value expires, zero never expires")
+	)
+
+	name := "set [flags] <key> [value]"
+	flags.Usage = usageFor(flags, name)
+	if err := flags.Parse(args); err != nil {
+		return nil
+	}
+	if flags.NArg() < 1 || flags.NArg() > 2 {
+		return errorFor(flags, name, errors.New("expected a key and an optional value"))
+	}
+	if flags.NArg() == 2 && *file != "" {
+		return errorFor(flags, name, errors.New("expected either a value or a file, not both"))
+	}
+	if *ttl < 0 {
+		return errorFor(flags, name, errors.New("expected a positive ttl"))
+	}
+
+	// The value is read from the arguments, a file or stdin in that order.
+	var value []byte
+	switch {
+	case flags.NArg() == 2:
+		value = []byte(flags.Arg(1))
+	case *file != "" && *file != "-":
+		b, err := ioutil.ReadFile(*file)
+		if err != nil {
+			return err
+		}
+		value = b
+	default:
+		b, err := ioutil.ReadAll(os.Stdin)
+		if err != nil {
+			return err
+		}
+		value = b
+	}
+
+	c, err := common.dial(client.Options{})
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	key := flags.Arg(0)
+	return errors.Wrap(c.SetWithTTL(context.Background(), key, value, *ttl), key)
+}
+
+func runDel(args []string) error {
+	var (
+		flags = flag.NewFlagSet("del", flag.ExitOnError)
+
+		common = newClientFlags(flags)
+	)
+
+	name := "del [flags] <key>..."
+	flags.Usage = usageFor(flags, name)
+	if err := flags.Parse(args); err != nil {
+		return nil
+	}
+	if flags.NArg() < 1 {
+		return errorFor(flags, name, errors.New("expected at least one key"))
+	}
+
+	c, err := common.dial(client.Options{})
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	for _, key := range flags.Args() {
+		if err := c.Delete(context.Background(), key); err != nil {
+			return errors.Wrap(err, key)
+		}
+	}
+	return nil
+}
+
+func runScan(args []string) error {
+	var (
+		flags = flag.NewFlagSet("scan", flag.ExitOnError)
+
+		common = newClientFlags(flags)
+		output = flags.String("format", "raw", "output format of the values (raw, hex, base64 or json)")
+		prefix = flags.String("prefix", "", "only scan keys with the prefix")
+		start  = flags.String("start", "", "only scan keys from the start key (inclusive)")
+		end    = flags.String("end", "", "only scan keys up to the end key (exclusive)")
+		limit  = flags.Int("limit", 0, "maximum number of entries, zero scans every entry")
+	)
+
+	name := "scan [flags]"
+	flags.Usage = usageFor(flags, name)
+	if err := flags.Parse(args); err != nil {
+		return nil
+	}
+	if flags.NArg() > 0 {
+		return errorFor(flags, name, errors.New("unexpected arguments"))
+	}
+	if *limit < 0 {
+		return errorFor(flags, name, errors.New("expected a positive limit"))
+	}
+	f, err := parseFormat(*output)
+	if err != nil {
+		return errorFor(flags, name, err)
+	}
+
+	c, err := common.dial(client.Options{})
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	scanner, ok := c.(client.Scanner)
+	if !ok {
+		return errors.Errorf("%s: scanning isn't supported", *common.addr)
+	}
+
+	options := client.ScanOptions{
+		Prefix: *prefix,
+		Start:  *start,
+		End:    *end,
+	}
+	for remaining := *limit; ; {
+		// Only ask for what's remaining, so the last page isn't over fetched.
+		if *limit > 0 && remaining < keyvalNet.MaxScanLimit {
+			options.Limit = remaining
+		}
+
+		entries, cursor, err := scanner.Scan(context.Background(), options)
+		if err != nil {
+			return err
+		}
+		if err := writeEntries(os.Stdout, f, entries); err != nil {
+			return err
+		}
+
+		if remaining -= len(entries); cursor == "" || (*limit > 0 && remaining <= 0) {
+			return nil
+		}
+		options.Cursor = cursor
+	}
+}
+
+func writeEntries(w io.Writer, f format, entries []client.Entry) error {
+	for _, entry := range entries {
+		if err := writeEntry(w, f, entry, true); err != nil {
+			return err
+		}
+	}
+	return nil
+}
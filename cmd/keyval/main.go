@@ -8,6 +8,7 @@ import (
 	"strings"
 	"syscall"
 	"text/tabwriter"
+	"time"
 
 	"github.com/pkg/errors"
 )
@@ -15,22 +16,38 @@ import (
 var version = "dev"
 
 const (
-	defaultAPIHTTPPort = 8080
-	defaultAPITCPPort  = 8081
-	defaultAPIUDPPort  = 8082
-	defaultAddr        = "0.0.0.0:0"
+	defaultAPIHTTPPort     = 8080
+	defaultAPITCPPort      = 8081
+	defaultAPIUDPPort      = 8082
+	defaultAPIRESPPort     = 6379
+	defaultAPIMemcachePort = 11211
+	defaultAPIGRPCPort     = 8083
+	defaultAddr            = "0.0.0.0:0"
+	defaultStoreSweep      = time.Second
+	defaultStoreSync       = "always"
+	defaultStoreEvict      = "lru"
+	defaultStoreShards     = 32
+	defaultClientTimeout   = 5 * time.Second
+	defaultClientCodec     = "gob"
+
+	defaultBenchConcurrency = 16
+	defaultBenchDuration    = 10 * time.Second
+	defaultBenchKeys        = 10000
+	defaultBenchValueSize   = 128
+	defaultBenchReads       = 0.9
 )
 
 var (
 	defaultAPIHTTPAddr = fmt.Sprintf("tcp://0.0.0.0:%d", defaultAPIHTTPPort)
 	defaultAPITCPAddr  = fmt.Sprintf("tcp://0.0.0.0:%d", defaultAPITCPPort)
 	defaultAPIUDPAddr  = fmt.Sprintf("udp://0.0.0.0:%d", defaultAPIUDPPort)
+	defaultClientAddr  = fmt.Sprintf("tcp://127.0.0.1:%d", defaultAPITCPPort)
 )
 
 type command func([]string) error
 
 func (c command) Run(args []string) {
-	if err := c(args); c != nil {
+	if err := c(args); err != nil {
 		fmt.Fprintf(os.Stderr, "%v\n", err)
 		os.Exit(1)
 	}
@@ -52,6 +69,16 @@ func main() {
 	switch strings.ToLower(args[1]) {
 	case "store":
 		cmd = runStore
+	case "get":
+		cmd = runGet
+	case "set":
+		cmd = runSet
+	case "del":
+		cmd = runDel
+	case "scan":
+		cmd = runScan
+	case "bench":
+		cmd = runBench
 	default:
 		usage()
 		os.Exit(1)
@@ -66,6 +93,11 @@ func usage() {
 	fmt.Fprintf(os.Stderr, "\n")
 	fmt.Fprintf(os.Stderr, "MODES\n")
 	fmt.Fprintf(os.Stderr, "  store       Store API services\n")
+	fmt.Fprintf(os.Stderr, "  get         Get the value of a key\n")
+	fmt.Fprintf(os.Stderr, "  set         Set the value of a key\n")
+	fmt.Fprintf(os.Stderr, "  del         Delete the values of keys\n")
+	fmt.Fprintf(os.Stderr, "  scan        Scan the keys and values in order\n")
+	fmt.Fprintf(os.Stderr, "  bench       Generate load against a store\n")
 	fmt.Fprintf(os.Stderr, "\n")
 	fmt.Fprintf(os.Stderr, "VERSION\n")
 	fmt.Fprintf(os.Stderr, "  %s (%s)\n", version, runtime.Version())
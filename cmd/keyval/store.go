@@ -6,12 +6,19 @@ import (
 	"net"
 	"net/http"
 	"os"
+	"runtime"
+	"time"
 
 	"github.com/SimonRichardson/gexec"
+	grpcStore "github.com/SimonRichardson/keyval/pkg/grpc"
 	httpStore "github.com/SimonRichardson/keyval/pkg/http"
+	memcacheStore "github.com/SimonRichardson/keyval/pkg/memcache"
+	keyvalNet "github.com/SimonRichardson/keyval/pkg/net"
+	respStore "github.com/SimonRichardson/keyval/pkg/resp"
 	"github.com/SimonRichardson/keyval/pkg/store"
 	tcpStore "github.com/SimonRichardson/keyval/pkg/tcp"
 	udpStore "github.com/SimonRichardson/keyval/pkg/udp"
+	"github.com/SimonRichardson/keyval/pkg/wal"
 	"github.com/go-kit/kit/log"
 	"github.com/go-kit/kit/log/level"
 )
@@ -20,10 +27,30 @@ func runStore(args []string) error {
 	var (
 		flags = flag.NewFlagSet("store", flag.ExitOnError)
 
-		debug       = flags.Bool("debug", false, "debug logging")
-		apiHTTPAddr = flags.String("api.http", defaultAPIHTTPAddr, "listen address for HTTP API")
-		apiTCPAddr  = flags.String("api.tcp", defaultAPITCPAddr, "listen address for TCP API")
-		apiUDPAddr  = flags.String("api.udp", defaultAPIUDPAddr, "listen address for UDP API")
+		debug           = flags.Bool("debug", false, "debug logging")
+		apiHTTPAddr     = flags.String("api.http", defaultAPIHTTPAddr, "listen address for HTTP API")
+		apiTCPAddr      = flags.String("api.tcp", defaultAPITCPAddr, "listen address for TCP API")
+		apiUDPAddr      = flags.String("api.udp", defaultAPIUDPAddr, "listen address for UDP API")
+		apiRESPAddr     = flags.String("api.resp", "", "listen address for redis (RESP) API, empty disables it")
+		apiRESPMaxBulk  = flags.Int("api.resp.max-bulk-length", respStore.DefaultMaxBulkLength, "maximum size of a bulk string sent over the redis (RESP) API in bytes")
+		apiMemcacheAddr = flags.String("api.memcache", "", "listen address for memcached API, empty disables it")
+		apiGRPCAddr     = flags.String("api.grpc", "", "listen address for gRPC API, empty disables it")
+		apiTCPMode      = flags.String("api.tcp.mode", "codec", "protocol of the TCP API (codec or binary)")
+		apiTCPMaxSize   = flags.Int("api.tcp.max-message-size", keyvalNet.DefaultMaxMessageSize, "maximum size of a query sent over the TCP API in bytes")
+		apiHTTPBatchOps = flags.Int("api.http.batch.max-operations", httpStore.DefaultMaxBatchSize, "maximum number of operations of a batch sent over the HTTP API")
+		apiHTTPBatchMax = flags.Int64("api.http.batch.max-bytes", httpStore.DefaultMaxBatchBytes, "maximum size of a batch or a transaction sent over the HTTP API in bytes")
+		apiHTTPMaxValue = flags.Int64("api.http.max-value-size", httpStore.DefaultMaxValueSize, "maximum size of a value sent over the HTTP API in bytes")
+		apiUDPMaxSize   = flags.Int("api.udp.max-message-size", keyvalNet.DefaultMaxMessageSize, "maximum size of a query sent over the UDP API in bytes")
+		apiUDPWorkers   = flags.Int("api.udp.workers", runtime.NumCPU(), "number of UDP queries handled at the same time")
+		apiUDPQueueSize = flags.Int("api.udp.queue-size", udpStore.DefaultQueueSize, "number of UDP queries that can wait to be handled")
+		apiUDPShedding  = flags.String("api.udp.shedding", "reply", "what happens to UDP queries once the queue is full (reply or drop)")
+		storeSweep      = flags.Duration("store.sweep", defaultStoreSweep, "interval for reclaiming expired values, zero disables the sweeper")
+		storeDir        = flags.String("store.dir", "", "directory for the write-ahead log, empty keeps the store in memory only")
+		storeSync       = flags.String("store.sync", defaultStoreSync, "when to sync the write-ahead log (always, never or an interval e.g. 100ms)")
+		storeSnapshot   = flags.Duration("store.snapshot", 0, "interval for taking snapshots of the write-ahead log, zero disables them")
+		storeMaxBytes   = flags.Uint64("store.max-bytes", 0, "maximum size of all the keys and values before evicting, a value larger than the size spread across the shards is refused, zero is unbounded")
+		storeMaxItems   = flags.Uint64("store.max-items", 0, "maximum number of values before evicting, zero is unbounded")
+		storeEviction   = flags.String("store.eviction", defaultStoreEvict, "eviction policy once the store is full (lru, lfu or ttl)")
 	)
 
 	flags.Usage = usageFor(flags, "store [flags]")
@@ -83,21 +110,165 @@ func runStore(args []string) error {
 
 	level.Debug(logger).Log("UDP_API", fmt.Sprintf("%s://%s", apiUDPNetwork, apiUDPAddress))
 
+	// Setup resp api
+	var apiRESPListener net.Listener
+	if *apiRESPAddr != "" {
+		apiRESPNetwork, apiRESPAddress, err := parseAddr(*apiRESPAddr, defaultAPIRESPPort)
+		if err != nil {
+			return err
+		}
+		apiRESPListener, err = net.Listen(apiRESPNetwork, apiRESPAddress)
+		if err != nil {
+			return err
+		}
+
+		level.Debug(logger).Log("RESP_API", fmt.Sprintf("%s://%s", apiRESPNetwork, apiRESPAddress))
+	}
+
+	// Setup memcache api
+	var apiMemcacheListener net.Listener
+	if *apiMemcacheAddr != "" {
+		apiMemcacheNetwork, apiMemcacheAddress, err := parseAddr(*apiMemcacheAddr, defaultAPIMemcachePort)
+		if err != nil {
+			return err
+		}
+		apiMemcacheListener, err = net.Listen(apiMemcacheNetwork, apiMemcacheAddress)
+		if err != nil {
+			return err
+		}
+
+		level.Debug(logger).Log("MEMCACHE_API", fmt.Sprintf("%s://%s", apiMemcacheNetwork, apiMemcacheAddress))
+	}
+
+	// Setup grpc api
+	var apiGRPCListener net.Listener
+	if *apiGRPCAddr != "" {
+		apiGRPCNetwork, apiGRPCAddress, err := parseAddr(*apiGRPCAddr, defaultAPIGRPCPort)
+		if err != nil {
+			return err
+		}
+		apiGRPCListener, err = net.Listen(apiGRPCNetwork, apiGRPCAddress)
+		if err != nil {
+			return err
+		}
+
+		level.Debug(logger).Log("GRPC_API", fmt.Sprintf("%s://%s", apiGRPCNetwork, apiGRPCAddress))
+	}
+
 	// Setup store api
 	keyval := store.New()
 
+	// Setup bounded store
+	if *storeMaxBytes > 0 || *storeMaxItems > 0 {
+		eviction, err := parseEviction(*storeEviction)
+		if err != nil {
+			return err
+		}
+		keyval = store.NewBoundedBucket(defaultStoreShards, store.Limits{
+			MaxBytes: *storeMaxBytes,
+			MaxItems: *storeMaxItems,
+			Eviction: eviction,
+		})
+
+		level.Debug(logger).Log("store_max_bytes", *storeMaxBytes, "store_max_items", *storeMaxItems, "store_eviction", eviction)
+	}
+
+	// Setup durable store
+	if *storeDir != "" {
+		syncPolicy, syncInterval, err := parseSync(*storeSync)
+		if err != nil {
+			return err
+		}
+		walLog, err := wal.Open(*storeDir, wal.Options{
+			Sync:         syncPolicy,
+			SyncInterval: syncInterval,
+		})
+		if err != nil {
+			return err
+		}
+		defer walLog.Close()
+
+		if keyval, err = store.NewDurable(keyval, walLog); err != nil {
+			return err
+		}
+
+		level.Debug(logger).Log("store_dir", *storeDir, "store_sync", *storeSync)
+	}
+
+	// Setup tcp server
+	tcpServer := tcpStore.NewServer(
+		keyval,
+		log.With(logger, "component", "store_tcp_api"),
+	)
+	if tcpServer.Mode, err = tcpStore.ParseMode(*apiTCPMode); err != nil {
+		return err
+	}
+	tcpServer.MaxMessageSize = *apiTCPMaxSize
+
+	// Setup udp server
+	udpServer := udpStore.NewServer(
+		keyval,
+		log.With(logger, "component", "store_udp_api"),
+	)
+	udpServer.MaxMessageSize = *apiUDPMaxSize
+	udpServer.Workers = *apiUDPWorkers
+	udpServer.QueueSize = *apiUDPQueueSize
+	if udpServer.Shedding, err = udpStore.ParseShedding(*apiUDPShedding); err != nil {
+		return err
+	}
+
+	// Setup http store api
+	httpAPI := httpStore.NewAPI(
+		keyval,
+		log.With(logger, "component", "store_http_api"),
+	)
+	httpAPI.MaxBatchSize = *apiHTTPBatchOps
+	httpAPI.MaxBatchBytes = *apiHTTPBatchMax
+	httpAPI.MaxValueSize = *apiHTTPMaxValue
+
 	// Execution group.
 	g := gexec.NewGroup()
 	gexec.Block(g)
+	if snapshotter, ok := keyval.(store.Snapshotter); ok && *storeSnapshot > 0 {
+		stop := make(chan struct{})
+		g.Add(func() error {
+			step := time.NewTicker(*storeSnapshot)
+			defer step.Stop()
+
+			for {
+				select {
+				case <-step.C:
+					if err := snapshotter.Snapshot(); err != nil {
+						level.Error(logger).Log("snapshot", err)
+					}
+				case <-stop:
+					return nil
+				}
+			}
+		}, func(error) {
+			close(stop)
+		})
+	}
+	if *storeSweep > 0 {
+		sweeper := store.NewSweeper(keyval, *storeSweep)
+		g.Add(func() error {
+			return sweeper.Run()
+		}, func(error) {
+			sweeper.Stop()
+		})
+	}
 	{
 		g.Add(func() error {
 			mux := http.NewServeMux()
-			mux.Handle("/store/", http.StripPrefix("/store",
-				httpStore.NewAPI(
-					keyval,
-					log.With(logger, "component", "store_http_api"),
-				),
-			))
+			mux.Handle("/store/", http.StripPrefix("/store", httpAPI))
+			admin := httpStore.NewAdminAPI(
+				keyval,
+				log.With(logger, "component", "admin_http_api"),
+			)
+			admin.Report("udp", func() interface{} {
+				return udpServer.Stats()
+			})
+			mux.Handle("/admin/", http.StripPrefix("/admin", admin))
 
 			return http.Serve(apiHTTPListener, mux)
 		}, func(error) {
@@ -106,28 +277,51 @@ func runStore(args []string) error {
 	}
 	{
 		g.Add(func() error {
-			server := tcpStore.NewServer(
+			return tcpServer.Serve(apiTCPListener)
+		}, func(error) {
+			apiTCPListener.Close()
+		})
+	}
+	if apiRESPListener != nil {
+		g.Add(func() error {
+			server := respStore.NewServer(
 				keyval,
-				log.With(logger, "component", "store_tcp_api"),
+				log.With(logger, "component", "store_resp_api"),
 			)
-			return server.Serve(apiTCPListener)
+			server.MaxBulkLength = *apiRESPMaxBulk
+			return server.Serve(apiRESPListener)
 		}, func(error) {
-			apiTCPListener.Close()
+			apiRESPListener.Close()
 		})
 	}
-	{
-		var server *udpStore.Server
+	if apiMemcacheListener != nil {
 		g.Add(func() error {
-			server = udpStore.NewServer(
+			server := memcacheStore.NewServer(
 				keyval,
-				log.With(logger, "component", "store_udp_api"),
+				log.With(logger, "component", "store_memcache_api"),
 			)
-
-			return server.Serve(apiUDPListener)
+			return server.Serve(apiMemcacheListener)
+		}, func(error) {
+			apiMemcacheListener.Close()
+		})
+	}
+	if apiGRPCListener != nil {
+		server := grpcStore.NewServer(
+			keyval,
+			log.With(logger, "component", "store_grpc_api"),
+		)
+		g.Add(func() error {
+			return server.Serve(apiGRPCListener)
 		}, func(error) {
-			fmt.Println("STOP")
 			server.Stop()
-			apiTCPListener.Close()
+		})
+	}
+	{
+		g.Add(func() error {
+			return udpServer.Serve(apiUDPListener)
+		}, func(error) {
+			udpServer.Stop()
+			apiUDPListener.Close()
 		})
 	}
 	gexec.Interrupt(g)
@@ -1,11 +1,20 @@
 package main
 
 import (
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
 	"net"
 	"net/url"
 	"strconv"
 	"strings"
+	"time"
 
+	"github.com/SimonRichardson/keyval/pkg/client"
+	"github.com/SimonRichardson/keyval/pkg/store"
+	"github.com/SimonRichardson/keyval/pkg/wal"
 	"github.com/pkg/errors"
 )
 
@@ -33,3 +42,104 @@ func parseAddr(addr string, defaultPort int) (network, address string, err error
 
 	return u.Scheme, u.Host, nil
 }
+
+// "always" => SyncAlways
+// "never"  => SyncNever
+// "100ms"  => SyncInterval 100ms
+func parseSync(sync string) (policy wal.SyncPolicy, interval time.Duration, err error) {
+	switch strings.ToLower(sync) {
+	case "always":
+		return wal.SyncAlways, 0, nil
+	case "never":
+		return wal.SyncNever, 0, nil
+	}
+
+	if interval, err = time.ParseDuration(sync); err != nil || interval <= 0 {
+		return policy, 0, errors.Errorf("%s: unsupported sync format", sync)
+	}
+	return wal.SyncInterval, interval, nil
+}
+
+// "lru" => EvictLRU
+// "lfu" => EvictLFU
+// "ttl" => EvictTTL
+func parseEviction(eviction string) (store.Eviction, error) {
+	for _, e := range []store.Eviction{store.EvictLRU, store.EvictLFU, store.EvictTTL} {
+		if strings.ToLower(eviction) == e.String() {
+			return e, nil
+		}
+	}
+	return 0, errors.Errorf("%s: unsupported eviction policy", eviction)
+}
+
+// format represents how values are written out by the client commands
+type format int
+
+const (
+	formatRaw format = iota
+	formatHex
+	formatBase64
+	formatJSON
+)
+
+func (f format) String() string {
+	switch f {
+	case formatHex:
+		return "hex"
+	case formatBase64:
+		return "base64"
+	case formatJSON:
+		return "json"
+	default:
+		return "raw"
+	}
+}
+
+// "raw"    => formatRaw
+// "hex"    => formatHex
+// "base64" => formatBase64
+// "json"   => formatJSON
+func parseFormat(f string) (format, error) {
+	for _, v := range []format{formatRaw, formatHex, formatBase64, formatJSON} {
+		if strings.ToLower(f) == v.String() {
+			return v, nil
+		}
+	}
+	return 0, errors.Errorf("%s: unsupported format", f)
+}
+
+// writeEntry writes the entry in the format, the key is only written if
+// withKey is true. Raw values are written as is, so that binary values can be
+// piped to another command, every other format ends with a newline.
+func writeEntry(w io.Writer, f format, entry client.Entry, withKey bool) error {
+	var prefix string
+	if withKey {
+		prefix = entry.Key + "\t"
+	}
+
+	var err error
+	switch f {
+	case formatHex:
+		_, err = fmt.Fprintf(w, "%s%s\n", prefix, hex.EncodeToString(entry.Value))
+	case formatBase64:
+		_, err = fmt.Fprintf(w, "%s%s\n", prefix, base64.StdEncoding.EncodeToString(entry.Value))
+	case formatJSON:
+		err = json.NewEncoder(w).Encode(struct {
+			Key     string `json:"key"`
+			Value   []byte `json:"value"`
+			Version uint64 `json:"version,omitempty"`
+		}{
+			Key:     entry.Key,
+			Value:   entry.Value,
+			Version: entry.Version,
+		})
+	default:
+		if _, err = io.WriteString(w, prefix); err == nil {
+			_, err = w.Write(entry.Value)
+		}
+		if err == nil && withKey {
+			_, err = io.WriteString(w, "\n")
+		}
+	}
+	return err
+}
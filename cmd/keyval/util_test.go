@@ -1,6 +1,14 @@
 package main
 
-import "testing"
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/SimonRichardson/keyval/pkg/client"
+	"github.com/SimonRichardson/keyval/pkg/store"
+	"github.com/SimonRichardson/keyval/pkg/wal"
+)
 
 func TestParseAddr(t *testing.T) {
 	for _, testcase := range []struct {
@@ -34,3 +42,118 @@ func TestParseAddr(t *testing.T) {
 		}
 	}
 }
+
+func TestParseSync(t *testing.T) {
+	for _, testcase := range []struct {
+		sync     string
+		policy   wal.SyncPolicy
+		interval time.Duration
+	}{
+		{"always", wal.SyncAlways, 0},
+		{"NEVER", wal.SyncNever, 0},
+		{"100ms", wal.SyncInterval, time.Millisecond * 100},
+		{"1s", wal.SyncInterval, time.Second},
+	} {
+		policy, interval, err := parseSync(testcase.sync)
+		if err != nil {
+			t.Errorf("(%q): %v", testcase.sync, err)
+			continue
+		}
+		if policy != testcase.policy || interval != testcase.interval {
+			t.Errorf("(%q): want [%v %v], have [%v %v]",
+				testcase.sync,
+				testcase.policy, testcase.interval,
+				policy, interval,
+			)
+		}
+	}
+
+	for _, sync := range []string{"", "sometimes", "-1s", "0s"} {
+		if _, _, err := parseSync(sync); err == nil {
+			t.Errorf("(%q): expected error", sync)
+		}
+	}
+}
+
+func TestParseEviction(t *testing.T) {
+	for _, testcase := range []struct {
+		eviction string
+		want     store.Eviction
+	}{
+		{"lru", store.EvictLRU},
+		{"LFU", store.EvictLFU},
+		{"ttl", store.EvictTTL},
+	} {
+		have, err := parseEviction(testcase.eviction)
+		if err != nil {
+			t.Errorf("(%q): %v", testcase.eviction, err)
+			continue
+		}
+		if want := testcase.want; want != have {
+			t.Errorf("(%q): want %v, have %v", testcase.eviction, want, have)
+		}
+	}
+
+	for _, eviction := range []string{"", "fifo", "unknown"} {
+		if _, err := parseEviction(eviction); err == nil {
+			t.Errorf("(%q): expected error", eviction)
+		}
+	}
+}
+
+func TestParseFormat(t *testing.T) {
+	for _, testcase := range []struct {
+		format string
+		want   format
+	}{
+		{"raw", formatRaw},
+		{"HEX", formatHex},
+		{"base64", formatBase64},
+		{"json", formatJSON},
+	} {
+		have, err := parseFormat(testcase.format)
+		if err != nil {
+			t.Errorf("(%q): %v", testcase.format, err)
+			continue
+		}
+		if want := testcase.want; want != have {
+			t.Errorf("(%q): want %v, have %v", testcase.format, want, have)
+		}
+	}
+
+	for _, format := range []string{"", "yaml", "unknown"} {
+		if _, err := parseFormat(format); err == nil {
+			t.Errorf("(%q): expected error", format)
+		}
+	}
+}
+
+func TestWriteEntry(t *testing.T) {
+	entry := client.Entry{
+		Key:     "abc",
+		Value:   []byte("def"),
+		Version: 2,
+	}
+	for _, testcase := range []struct {
+		format  format
+		withKey bool
+		want    string
+	}{
+		{formatRaw, false, "def"},
+		{formatRaw, true, "abc\tdef\n"},
+		{formatHex, false, "646566\n"},
+		{formatHex, true, "abc\t646566\n"},
+		{formatBase64, false, "ZGVm\n"},
+		{formatBase64, true, "abc\tZGVm\n"},
+		{formatJSON, false, `{"key":"abc","value":"ZGVm","version":2}` + "\n"},
+	} {
+		var buf bytes.Buffer
+		if err := writeEntry(&buf, testcase.format, entry, testcase.withKey); err != nil {
+			t.Errorf("(%v, %v): %v", testcase.format, testcase.withKey, err)
+			continue
+		}
+		if want, have := testcase.want, buf.String(); want != have {
+			t.Errorf("(%v, %v): want %q, have %q", testcase.format, testcase.withKey, want, have)
+		}
+	}
+}
@@ -0,0 +1,201 @@
+package bench
+
+import (
+	"context"
+	"math/rand"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/SimonRichardson/keyval/pkg/client"
+	"github.com/pkg/errors"
+)
+
+// Config describes the load to generate. The run stops once either the
+// duration has elapsed or the amount of requests has been sent, whichever
+// happens first, zero disables either of them.
+type Config struct {
+	// Concurrency is how many workers send requests at the same time, each
+	// worker waits for the result before sending the next request.
+	Concurrency int `json:"concurrency"`
+	// Duration is how long to send requests for.
+	Duration time.Duration `json:"duration_ns"`
+	// Requests is how many requests to send in total.
+	Requests int `json:"requests"`
+	// Keys is how many distinct keys are used.
+	Keys int `json:"keys"`
+	// Prefix is put in front of every key, so the keys don't clash with
+	// anything else in the store.
+	Prefix string `json:"prefix"`
+	// Distribution is how the key of each request is picked.
+	Distribution Distribution `json:"distribution"`
+	// ValueSize is the size of every value written in bytes.
+	ValueSize int `json:"value_size"`
+	// ReadRatio is the share of the requests that are reads (0 to 1), the
+	// rest are writes.
+	ReadRatio float64 `json:"read_ratio"`
+	// Seed of the random numbers, so that runs can be repeated.
+	Seed int64 `json:"seed"`
+}
+
+// Validate returns an error if the Config can't be run.
+func (c Config) Validate() error {
+	switch {
+	case c.Concurrency < 1:
+		return errors.New("concurrency must be at least 1")
+	case c.Duration <= 0 && c.Requests <= 0:
+		return errors.New("either a duration or amount of requests is required")
+	case c.Duration < 0 || c.Requests < 0:
+		return errors.New("duration and requests must be positive")
+	case c.Keys < 1:
+		return errors.New("keys must be at least 1")
+	case c.ValueSize < 0:
+		return errors.New("value size must be positive")
+	case c.ReadRatio < 0 || c.ReadRatio > 1:
+		return errors.New("read ratio must be between 0 and 1")
+	}
+	return nil
+}
+
+// Report contains the results of a run, latencies are in nanoseconds when
+// encoded as JSON.
+type Report struct {
+	Config     Config        `json:"config"`
+	Elapsed    time.Duration `json:"elapsed_ns"`
+	Requests   uint64        `json:"requests"`
+	Reads      uint64        `json:"reads"`
+	Writes     uint64        `json:"writes"`
+	Misses     uint64        `json:"misses"`
+	Errors     uint64        `json:"errors"`
+	Throughput float64       `json:"throughput"`
+	Latency    Latency       `json:"latency"`
+}
+
+// Latency contains the distribution of the latencies of the requests
+type Latency struct {
+	Min  time.Duration `json:"min_ns"`
+	Mean time.Duration `json:"mean_ns"`
+	P50  time.Duration `json:"p50_ns"`
+	P99  time.Duration `json:"p99_ns"`
+	P999 time.Duration `json:"p999_ns"`
+	Max  time.Duration `json:"max_ns"`
+}
+
+// Run sends requests to the client until the Config says to stop or the
+// context is done. Reads of keys that don't exist are counted as misses
+// rather than errors, as the keys are only written as the run goes along.
+func Run(ctx context.Context, c client.Client, config Config) (Report, error) {
+	if err := config.Validate(); err != nil {
+		return Report{}, err
+	}
+
+	if config.Duration > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, config.Duration)
+		defer cancel()
+	}
+
+	var (
+		wg       sync.WaitGroup
+		sequence uint64
+		budget   = int64(config.Requests)
+		workers  = make([]*worker, config.Concurrency)
+		begin    = time.Now()
+	)
+	for k := range workers {
+		r := rand.New(rand.NewSource(config.Seed + int64(k)))
+		w := &worker{
+			client:    c,
+			config:    config,
+			rand:      r,
+			keys:      newGenerator(config.Distribution, config.Keys, r, &sequence),
+			value:     make([]byte, config.ValueSize),
+			histogram: NewHistogram(),
+		}
+		r.Read(w.value)
+		workers[k] = w
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			w.run(ctx, func() bool {
+				return config.Requests == 0 || atomic.AddInt64(&budget, -1) >= 0
+			})
+		}()
+	}
+	wg.Wait()
+
+	report := Report{
+		Config:  config,
+		Elapsed: time.Since(begin),
+	}
+	histogram := NewHistogram()
+	for _, w := range workers {
+		report.Reads += w.reads
+		report.Writes += w.writes
+		report.Misses += w.misses
+		report.Errors += w.errors
+		histogram.Merge(w.histogram)
+	}
+	report.Requests = report.Reads + report.Writes
+	if seconds := report.Elapsed.Seconds(); seconds > 0 {
+		report.Throughput = float64(report.Requests) / seconds
+	}
+	report.Latency = Latency{
+		Min:  histogram.Min(),
+		Mean: histogram.Mean(),
+		P50:  histogram.Quantile(0.5),
+		P99:  histogram.Quantile(0.99),
+		P999: histogram.Quantile(0.999),
+		Max:  histogram.Max(),
+	}
+	return report, nil
+}
+
+type worker struct {
+	client    client.Client
+	config    Config
+	rand      *rand.Rand
+	keys      generator
+	value     []byte
+	histogram *Histogram
+
+	reads, writes, misses, errors uint64
+}
+
+func (w *worker) run(ctx context.Context, next func() bool) {
+	for ctx.Err() == nil && next() {
+		var (
+			key   = w.config.Prefix + strconv.Itoa(w.keys.Next())
+			read  = w.rand.Float64() < w.config.ReadRatio
+			begin = time.Now()
+			err   error
+		)
+		if read {
+			_, err = w.client.Get(ctx, key)
+		} else {
+			err = w.client.Set(ctx, key, w.value)
+		}
+		latency := time.Since(begin)
+
+		// A request that is cut short by the end of the run isn't counted,
+		// as it didn't fail.
+		if ctx.Err() != nil {
+			return
+		}
+
+		w.histogram.Record(latency)
+		if read {
+			w.reads++
+		} else {
+			w.writes++
+		}
+		switch {
+		case err == client.ErrNotFound:
+			w.misses++
+		case err != nil:
+			w.errors++
+		}
+	}
+}
@@ -0,0 +1,294 @@
+package bench
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"math/rand"
+	"sort"
+	"testing"
+	"testing/quick"
+	"time"
+
+	"github.com/SimonRichardson/keyval/pkg/client"
+	keyvalStore "github.com/SimonRichardson/keyval/pkg/store"
+)
+
+func TestHistogram(t *testing.T) {
+	t.Parallel()
+
+	t.Run("quantiles are within the error", func(t *testing.T) {
+		fn := func(a []uint32) bool {
+			if len(a) == 0 {
+				return true
+			}
+
+			h := NewHistogram()
+			latencies := make([]time.Duration, len(a))
+			for k, v := range a {
+				latencies[k] = time.Duration(v)
+				h.Record(latencies[k])
+			}
+			sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+
+			for _, q := range []float64{0.5, 0.99, 0.999} {
+				index := int(float64(len(latencies))*q+0.999999) - 1
+				if index < 0 {
+					index = 0
+				}
+				expected, actual := latencies[index], h.Quantile(q)
+				if actual < expected || float64(actual-expected) > float64(expected)*0.02+1 {
+					t.Logf("q: %v, expected: %v, actual: %v", q, expected, actual)
+					return false
+				}
+			}
+			return h.Min() == latencies[0] && h.Max() == latencies[len(latencies)-1]
+		}
+		if err := quick.Check(fn, nil); err != nil {
+			t.Error(err)
+		}
+	})
+
+	t.Run("merge", func(t *testing.T) {
+		a, b := NewHistogram(), NewHistogram()
+		for i := 1; i <= 100; i++ {
+			a.Record(time.Duration(i))
+			b.Record(time.Duration(i + 100))
+		}
+		a.Merge(b)
+
+		if expected, actual := uint64(200), a.Count(); expected != actual {
+			t.Errorf("expected: %v, actual: %v", expected, actual)
+		}
+		if expected, actual := time.Duration(1), a.Min(); expected != actual {
+			t.Errorf("expected: %v, actual: %v", expected, actual)
+		}
+		if expected, actual := time.Duration(200), a.Max(); expected != actual {
+			t.Errorf("expected: %v, actual: %v", expected, actual)
+		}
+		if expected, actual := time.Duration(100), a.Mean(); expected != actual {
+			t.Errorf("expected: %v, actual: %v", expected, actual)
+		}
+	})
+
+	t.Run("empty", func(t *testing.T) {
+		h := NewHistogram()
+		if expected, actual := time.Duration(0), h.Quantile(0.99); expected != actual {
+			t.Errorf("expected: %v, actual: %v", expected, actual)
+		}
+		if expected, actual := time.Duration(0), h.Min(); expected != actual {
+			t.Errorf("expected: %v, actual: %v", expected, actual)
+		}
+	})
+
+	t.Run("buckets are contiguous", func(t *testing.T) {
+		fn := func(a uint64) bool {
+			a >>= 1
+			index := bucketIndex(a)
+			return index < bucketCount &&
+				a <= bucketUpperBound(index) &&
+				(index == 0 || a > bucketUpperBound(index-1))
+		}
+		if err := quick.Check(fn, nil); err != nil {
+			t.Error(err)
+		}
+	})
+}
+
+func TestDistribution(t *testing.T) {
+	t.Parallel()
+
+	t.Run("keys are in range", func(t *testing.T) {
+		for _, d := range []Distribution{Uniform, Zipfian, Sequential} {
+			var sequence uint64
+			g := newGenerator(d, 10, rand.New(rand.NewSource(1)), &sequence)
+			for i := 0; i < 1000; i++ {
+				if n := g.Next(); n < 0 || n >= 10 {
+					t.Errorf("%v expected: [0, 10), actual: %v", d, n)
+				}
+			}
+		}
+	})
+
+	t.Run("sequential wraps around", func(t *testing.T) {
+		var sequence uint64
+		g := newGenerator(Sequential, 3, nil, &sequence)
+		for i := 0; i < 7; i++ {
+			if expected, actual := i%3, g.Next(); expected != actual {
+				t.Errorf("expected: %v, actual: %v", expected, actual)
+			}
+		}
+	})
+
+	t.Run("zipfian is skewed", func(t *testing.T) {
+		var (
+			sequence uint64
+			counts   = make([]int, 100)
+			g        = newGenerator(Zipfian, 100, rand.New(rand.NewSource(1)), &sequence)
+		)
+		for i := 0; i < 10000; i++ {
+			counts[g.Next()]++
+		}
+		if counts[0] < counts[50]*10 {
+			t.Errorf("expected: %v, actual: %v", counts[50]*10, counts[0])
+		}
+	})
+
+	t.Run("parse", func(t *testing.T) {
+		for _, d := range []Distribution{Uniform, Zipfian, Sequential} {
+			actual, err := ParseDistribution(d.String())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if expected := d; expected != actual {
+				t.Errorf("expected: %v, actual: %v", expected, actual)
+			}
+		}
+		if _, err := ParseDistribution("normal"); err == nil {
+			t.Errorf("expected: error, actual: %v", err)
+		}
+	})
+}
+
+func TestRun(t *testing.T) {
+	t.Parallel()
+
+	t.Run("requests", func(t *testing.T) {
+		c := &storeClient{store: keyvalStore.New()}
+		report, err := Run(context.Background(), c, Config{
+			Concurrency:  4,
+			Requests:     1000,
+			Keys:         100,
+			Prefix:       "bench:",
+			Distribution: Uniform,
+			ValueSize:    16,
+			ReadRatio:    0.5,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if expected, actual := uint64(1000), report.Requests; expected != actual {
+			t.Errorf("expected: %v, actual: %v", expected, actual)
+		}
+		if expected, actual := report.Requests, report.Reads+report.Writes; expected != actual {
+			t.Errorf("expected: %v, actual: %v", expected, actual)
+		}
+		if report.Reads == 0 || report.Writes == 0 {
+			t.Errorf("expected: reads and writes, actual: %v and %v", report.Reads, report.Writes)
+		}
+		if expected, actual := uint64(0), report.Errors; expected != actual {
+			t.Errorf("expected: %v, actual: %v", expected, actual)
+		}
+		if report.Latency.P50 > report.Latency.P99 || report.Latency.P99 > report.Latency.P999 {
+			t.Errorf("expected: ordered percentiles, actual: %v", report.Latency)
+		}
+	})
+
+	t.Run("duration", func(t *testing.T) {
+		c := &storeClient{store: keyvalStore.New()}
+		report, err := Run(context.Background(), c, Config{
+			Concurrency: 2,
+			Duration:    time.Millisecond * 50,
+			Keys:        10,
+			ReadRatio:   1,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if expected, actual := report.Requests, report.Misses; expected != actual {
+			t.Errorf("expected: %v, actual: %v", expected, actual)
+		}
+		if report.Elapsed < time.Millisecond*50 {
+			t.Errorf("expected: %v, actual: %v", time.Millisecond*50, report.Elapsed)
+		}
+	})
+
+	t.Run("errors", func(t *testing.T) {
+		c := &storeClient{store: keyvalStore.New(), err: errors.New("bad")}
+		report, err := Run(context.Background(), c, Config{
+			Concurrency: 1,
+			Requests:    10,
+			Keys:        1,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if expected, actual := uint64(10), report.Errors; expected != actual {
+			t.Errorf("expected: %v, actual: %v", expected, actual)
+		}
+	})
+
+	t.Run("invalid config", func(t *testing.T) {
+		for _, config := range []Config{
+			{Concurrency: 0, Requests: 1, Keys: 1},
+			{Concurrency: 1, Keys: 1},
+			{Concurrency: 1, Requests: 1, Keys: 0},
+			{Concurrency: 1, Requests: 1, Keys: 1, ReadRatio: 2},
+		} {
+			if _, err := Run(context.Background(), &storeClient{}, config); err == nil {
+				t.Errorf("expected: error, actual: %v", err)
+			}
+		}
+	})
+
+	t.Run("report encodes", func(t *testing.T) {
+		b, err := json.Marshal(Report{Config: Config{Distribution: Zipfian}})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var report Report
+		if err := json.Unmarshal(b, &report); err != nil {
+			t.Fatal(err)
+		}
+		if expected, actual := Zipfian, report.Config.Distribution; expected != actual {
+			t.Errorf("expected: %v, actual: %v", expected, actual)
+		}
+	})
+}
+
+// storeClient is a client.Client that uses the store directly, so that runs
+// don't depend on the network.
+type storeClient struct {
+	store keyvalStore.Store
+	err   error
+}
+
+func (c *storeClient) Get(ctx context.Context, key string) ([]byte, error) {
+	if c.err != nil {
+		return nil, c.err
+	}
+	value, ok := c.store.Get(key)
+	if !ok {
+		return nil, client.ErrNotFound
+	}
+	return value, nil
+}
+
+func (c *storeClient) Set(ctx context.Context, key string, value []byte) error {
+	return c.SetWithTTL(ctx, key, value, 0)
+}
+
+func (c *storeClient) SetWithTTL(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	if c.err != nil {
+		return c.err
+	}
+	c.store.Set(key, value)
+	return nil
+}
+
+func (c *storeClient) Delete(ctx context.Context, key string) error {
+	if c.err != nil {
+		return c.err
+	}
+	if !c.store.Delete(key) {
+		return client.ErrNotFound
+	}
+	return nil
+}
+
+func (c *storeClient) Close() error {
+	return nil
+}
@@ -0,0 +1,108 @@
+package bench
+
+import (
+	"math/rand"
+	"strings"
+	"sync/atomic"
+
+	"github.com/pkg/errors"
+)
+
+// Distribution represents how the keys are picked for each request
+type Distribution int
+
+const (
+	// Uniform picks every key with the same probability
+	Uniform Distribution = iota
+	// Zipfian picks a few keys far more often than the rest, which is closer
+	// to how caches are used in practice
+	Zipfian
+	// Sequential picks every key in turn, wrapping around at the end
+	Sequential
+)
+
+func (d Distribution) String() string {
+	switch d {
+	case Zipfian:
+		return "zipfian"
+	case Sequential:
+		return "sequential"
+	default:
+		return "uniform"
+	}
+}
+
+// MarshalText encodes the Distribution as its name.
+func (d Distribution) MarshalText() ([]byte, error) {
+	return []byte(d.String()), nil
+}
+
+// UnmarshalText decodes the Distribution from its name.
+func (d *Distribution) UnmarshalText(b []byte) error {
+	v, err := ParseDistribution(string(b))
+	if err != nil {
+		return err
+	}
+	*d = v
+	return nil
+}
+
+// ParseDistribution returns the Distribution for the name.
+func ParseDistribution(name string) (Distribution, error) {
+	for _, d := range []Distribution{Uniform, Zipfian, Sequential} {
+		if strings.ToLower(name) == d.String() {
+			return d, nil
+		}
+	}
+	return 0, errors.Errorf("%s: unsupported distribution", name)
+}
+
+// zipfianSkew is how skewed the zipfian distribution is, it has to be greater
+// than 1 and the higher it is the more often the first keys are picked.
+const zipfianSkew = 1.1
+
+// generator returns the index of the next key, each worker has its own
+// generator as they aren't safe for concurrent use.
+type generator interface {
+	Next() int
+}
+
+// newGenerator creates a generator for the distribution over n keys. The
+// sequence is shared between all the generators, so that the workers pick
+// the keys in turn.
+func newGenerator(d Distribution, n int, r *rand.Rand, sequence *uint64) generator {
+	switch d {
+	case Zipfian:
+		return zipfian{rand.NewZipf(r, zipfianSkew, 1, uint64(n-1))}
+	case Sequential:
+		return sequential{sequence, n}
+	default:
+		return uniform{r, n}
+	}
+}
+
+type uniform struct {
+	r *rand.Rand
+	n int
+}
+
+func (u uniform) Next() int {
+	return u.r.Intn(u.n)
+}
+
+type zipfian struct {
+	z *rand.Zipf
+}
+
+func (z zipfian) Next() int {
+	return int(z.z.Uint64())
+}
+
+type sequential struct {
+	next *uint64
+	n    int
+}
+
+func (s sequential) Next() int {
+	return int((atomic.AddUint64(s.next, 1) - 1) % uint64(s.n))
+}
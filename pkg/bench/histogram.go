@@ -0,0 +1,142 @@
+package bench
+
+import (
+	"math"
+	"math/bits"
+	"time"
+)
+
+const (
+	// subBucketBits is the amount of buckets each power of two is split into,
+	// as a power of two. 64 buckets keeps the error of a latency under 2%.
+	subBucketBits  = 6
+	subBucketCount = 1 << subBucketBits
+	// bucketCount is enough buckets to hold any positive int64.
+	bucketCount = (64 - subBucketBits) * subBucketCount
+)
+
+// Histogram records latencies in log-linear buckets, so that the memory used
+// is fixed no matter how many latencies are recorded. A Histogram isn't safe
+// for concurrent use, each worker is expected to have its own and then merge
+// them once they're done.
+type Histogram struct {
+	counts [bucketCount]uint64
+	total  uint64
+	sum    time.Duration
+	min    time.Duration
+	max    time.Duration
+}
+
+// NewHistogram creates an empty Histogram
+func NewHistogram() *Histogram {
+	return &Histogram{
+		min: math.MaxInt64,
+	}
+}
+
+// Record the latency, negative latencies are recorded as zero.
+func (h *Histogram) Record(d time.Duration) {
+	if d < 0 {
+		d = 0
+	}
+	h.counts[bucketIndex(uint64(d))]++
+	h.total++
+	h.sum += d
+	if d < h.min {
+		h.min = d
+	}
+	if d > h.max {
+		h.max = d
+	}
+}
+
+// Merge the latencies of the other Histogram into this one.
+func (h *Histogram) Merge(other *Histogram) {
+	for k, v := range other.counts {
+		h.counts[k] += v
+	}
+	h.total += other.total
+	h.sum += other.sum
+	if other.min < h.min {
+		h.min = other.min
+	}
+	if other.max > h.max {
+		h.max = other.max
+	}
+}
+
+// Count returns the amount of latencies recorded.
+func (h *Histogram) Count() uint64 {
+	return h.total
+}
+
+// Mean returns the average latency.
+func (h *Histogram) Mean() time.Duration {
+	if h.total == 0 {
+		return 0
+	}
+	return h.sum / time.Duration(h.total)
+}
+
+// Min returns the lowest latency.
+func (h *Histogram) Min() time.Duration {
+	if h.total == 0 {
+		return 0
+	}
+	return h.min
+}
+
+// Max returns the highest latency.
+func (h *Histogram) Max() time.Duration {
+	return h.max
+}
+
+// Quantile returns the latency that q (0 to 1) of the latencies are at or
+// below. The latency is the upper bound of the bucket, so it's never lower
+// than the actual latency.
+func (h *Histogram) Quantile(q float64) time.Duration {
+	if h.total == 0 {
+		return 0
+	}
+
+	target := uint64(math.Ceil(q * float64(h.total)))
+	if target < 1 {
+		target = 1
+	}
+
+	var seen uint64
+	for k, v := range h.counts {
+		if seen += v; seen >= target {
+			d := time.Duration(bucketUpperBound(k))
+			if d > h.max {
+				d = h.max
+			}
+			if d < h.min {
+				d = h.min
+			}
+			return d
+		}
+	}
+	return h.max
+}
+
+// bucketIndex returns the bucket of the value. Values below subBucketCount
+// have a bucket each, after that each power of two is split into
+// subBucketCount buckets.
+func bucketIndex(v uint64) int {
+	if v < subBucketCount {
+		return int(v)
+	}
+	shift := bits.Len64(v) - subBucketBits - 1
+	return (shift+1)*subBucketCount + int(v>>uint(shift)) - subBucketCount
+}
+
+// bucketUpperBound returns the highest value that falls into the bucket.
+func bucketUpperBound(index int) uint64 {
+	if index < subBucketCount {
+		return uint64(index)
+	}
+	shift := uint(index/subBucketCount - 1)
+	base := uint64(index%subBucketCount + subBucketCount)
+	return (base+1)<<shift - 1
+}
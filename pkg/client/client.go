@@ -0,0 +1,236 @@
+package client
+
+import (
+	"context"
+	"net"
+	"time"
+
+	keyvalNet "github.com/SimonRichardson/keyval/pkg/net"
+	"github.com/pkg/errors"
+)
+
+const (
+	// DefaultTimeout is how long a single attempt of a request can take.
+	DefaultTimeout = 5 * time.Second
+	// DefaultRetries is how many times an idempotent request is retried, after
+	// the first attempt fails.
+	DefaultRetries = 3
+	// DefaultBackoff is how long to wait before the first retry, the wait is
+	// doubled for every retry after that.
+	DefaultBackoff = 50 * time.Millisecond
+	// DefaultMaxBackoff is the longest wait between retries.
+	DefaultMaxBackoff = 2 * time.Second
+	// DefaultMaxIdleConns is how many idle connections are kept for reuse.
+	DefaultMaxIdleConns = 8
+	// DefaultRetransmitInterval is how long the udp client waits for a result
+	// before sending the query again.
+	DefaultRetransmitInterval = 250 * time.Millisecond
+)
+
+var (
+	// ErrNotFound is returned when the key doesn't exist.
+	ErrNotFound = errors.New("not found")
+	// ErrBadRequest is returned when the server rejects the request as
+	// invalid.
+	ErrBadRequest = errors.New("bad request")
+	// ErrServerError is returned when the server fails to handle the request.
+	ErrServerError = errors.New("server error")
+	// ErrConflict is returned when the version expected didn't match the
+	// current version.
+	ErrConflict = errors.New("conflict")
+	// ErrClosed is returned when the Client has been closed.
+	ErrClosed = errors.New("client closed")
+)
+
+// Client represents a way to interact with a keyval store, regardless of the
+// transport used to talk to it.
+type Client interface {
+	// Get returns the value for the key, returns ErrNotFound if the key
+	// doesn't exist.
+	Get(ctx context.Context, key string) ([]byte, error)
+
+	// Set the value for the key.
+	Set(ctx context.Context, key string, value []byte) error
+
+	// SetWithTTL sets the value for the key, which expires after the ttl.
+	SetWithTTL(ctx context.Context, key string, value []byte, ttl time.Duration) error
+
+	// Delete the value for the key, returns ErrNotFound if the key doesn't
+	// exist.
+	Delete(ctx context.Context, key string) error
+
+	// Close the Client, along with any connections it holds on to.
+	Close() error
+}
+
+// ScanOptions limits which entries are returned by a scan, see store.ScanOptions.
+type ScanOptions struct {
+	Prefix string
+	Start  string
+	End    string
+	Limit  int
+	Cursor string
+}
+
+// Entry represents a value along with the key and version, as part of a scan
+type Entry struct {
+	Key     string
+	Value   []byte
+	Version uint64
+}
+
+// Scanner is implemented by the clients of APIs that can scan the keys of the
+// store in order.
+type Scanner interface {
+	// Scan returns a page of entries in key order, along with the cursor for
+	// the next page. An empty cursor means there are no more entries.
+	Scan(ctx context.Context, options ScanOptions) ([]Entry, string, error)
+}
+
+// Options configures a Client. Any option that is zero uses the default value,
+// to disable retries use a negative number of retries.
+type Options struct {
+	// Timeout is how long a single attempt of a request can take, the
+	// deadline of the context is used if it's sooner.
+	Timeout time.Duration
+	// Retries is how many times an idempotent request (Get, Set and Scan, as
+	// well as Delete over udp) is retried when the transport fails.
+	Retries int
+	// Backoff is how long to wait before the first retry.
+	Backoff time.Duration
+	// MaxBackoff is the longest wait between retries.
+	MaxBackoff time.Duration
+	// MaxIdleConns is how many idle connections are kept for reuse.
+	MaxIdleConns int
+	// RetransmitInterval is how long the udp client waits for a result before
+	// sending the query again, within a single attempt. The interval is
+	// doubled every time the query is sent again.
+	RetransmitInterval time.Duration
+	// Codec is how queries and results are encoded by the tcp and udp
+	// clients. Any codec other than gob is picked with a handshake.
+	Codec keyvalNet.Codec
+	// MaxMessageSize is the largest result that the tcp and udp clients
+	// read.
+	MaxMessageSize int
+}
+
+func (o Options) withDefaults() Options {
+	if o.Timeout == 0 {
+		o.Timeout = DefaultTimeout
+	}
+	if o.Retries == 0 {
+		o.Retries = DefaultRetries
+	} else if o.Retries < 0 {
+		o.Retries = 0
+	}
+	if o.Backoff == 0 {
+		o.Backoff = DefaultBackoff
+	}
+	if o.MaxBackoff == 0 {
+		o.MaxBackoff = DefaultMaxBackoff
+	}
+	if o.MaxIdleConns == 0 {
+		o.MaxIdleConns = DefaultMaxIdleConns
+	}
+	if o.RetransmitInterval == 0 {
+		o.RetransmitInterval = DefaultRetransmitInterval
+	}
+	if o.Codec == nil {
+		o.Codec = keyvalNet.Gob
+	}
+	if o.MaxMessageSize == 0 {
+		o.MaxMessageSize = keyvalNet.DefaultMaxMessageSize
+	}
+	return o
+}
+
+// errorFor returns the error for the status, returns nil if the status is a
+// success.
+func errorFor(status keyvalNet.Status) error {
+	switch status {
+	case keyvalNet.OK, keyvalNet.Created:
+		return nil
+	case keyvalNet.NotFound:
+		return ErrNotFound
+	case keyvalNet.BadRequest:
+		return ErrBadRequest
+	case keyvalNet.Conflict:
+		return ErrConflict
+	default:
+		return ErrServerError
+	}
+}
+
+// retry calls fn until it succeeds, up to the number of retries. Only errors
+// from the transport are retried, as an error from the server will be the same
+// the next time around.
+func retry(ctx context.Context, options Options, fn func(context.Context) error) error {
+	backoff := options.Backoff
+	for attempt := 0; ; attempt++ {
+		err := attemptWithTimeout(ctx, options.Timeout, fn)
+		if err == nil || attempt >= options.Retries || !temporary(err) {
+			return err
+		}
+
+		timer := time.NewTimer(backoff)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+
+		if backoff *= 2; backoff > options.MaxBackoff {
+			backoff = options.MaxBackoff
+		}
+	}
+}
+
+func attemptWithTimeout(ctx context.Context, timeout time.Duration, fn func(context.Context) error) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	return fn(ctx)
+}
+
+// temporary returns true if the error is from the transport, rather than an
+// answer from the server.
+func temporary(err error) bool {
+	switch errors.Cause(err) {
+	case ErrNotFound, ErrBadRequest, ErrServerError, ErrConflict, ErrClosed,
+		context.Canceled:
+		return false
+	}
+	return true
+}
+
+// deadline returns when the attempt has to complete by.
+func deadline(ctx context.Context) time.Time {
+	t, _ := ctx.Deadline()
+	return t
+}
+
+// watchConn interrupts any reads or writes of the connection once the context
+// is done, the returned func has to be called once the connection is no
+// longer in use. The func waits for the watch to stop, so that the deadline
+// can't be changed after the connection is handed to the next request.
+func watchConn(ctx context.Context, conn net.Conn) func() {
+	var (
+		done    = make(chan struct{})
+		stopped = make(chan struct{})
+	)
+	go func() {
+		defer close(stopped)
+		select {
+		case <-ctx.Done():
+			conn.SetDeadline(time.Now())
+		case <-done:
+		}
+	}()
+	return func() {
+		close(done)
+		<-stopped
+	}
+}
@@ -0,0 +1,437 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/gob"
+	"fmt"
+	"net"
+	"net/http/httptest"
+	"testing"
+	"testing/quick"
+	"time"
+
+	keyvalHTTP "github.com/SimonRichardson/keyval/pkg/http"
+	keyvalNet "github.com/SimonRichardson/keyval/pkg/net"
+	keyvalStore "github.com/SimonRichardson/keyval/pkg/store"
+	"github.com/SimonRichardson/keyval/pkg/tcp"
+	"github.com/SimonRichardson/keyval/pkg/udp"
+	"github.com/go-kit/kit/log"
+	"github.com/pkg/errors"
+)
+
+func TestClients(t *testing.T) {
+	t.Parallel()
+
+	type serve func(*testing.T) (Client, func())
+
+	testcases := []struct {
+		name  string
+		serve serve
+	}{
+		{"http", func(t *testing.T) (Client, func()) { return serveHTTP(t) }},
+		{"tcp", func(t *testing.T) (Client, func()) { return serveTCP(t, Options{}) }},
+		{"udp", func(t *testing.T) (Client, func()) { return serveUDP(t, Options{}) }},
+	}
+	for _, codec := range keyvalNet.Codecs()[1:] {
+		options := Options{Codec: codec}
+		testcases = append(testcases, []struct {
+			name  string
+			serve serve
+		}{
+			{"tcp-" + codec.String(), func(t *testing.T) (Client, func()) { return serveTCP(t, options) }},
+			{"udp-" + codec.String(), func(t *testing.T) (Client, func()) { return serveUDP(t, options) }},
+		}...)
+	}
+
+	for _, testcase := range testcases {
+		testcase := testcase
+		t.Run(testcase.name, func(t *testing.T) {
+			ctx := context.Background()
+
+			// Every client has a server of its own, so that none of the
+			// clients share a port or a store with any other test.
+			client, stop := testcase.serve(t)
+			defer stop()
+			defer client.Close()
+
+			eventually(t, func() error {
+				if _, err := client.Get(ctx, "ready"); err != ErrNotFound {
+					return errors.Errorf("expected: %v, actual: %v", ErrNotFound, err)
+				}
+				return nil
+			})
+
+			t.Run("set then get", func(t *testing.T) {
+				fn := func(a string, b []byte) bool {
+					key := fmt.Sprintf("%s:%s", testcase.name, a)
+					if err := client.Set(ctx, key, b); err != nil {
+						t.Fatal(err)
+					}
+					value, err := client.Get(ctx, key)
+					if err != nil {
+						t.Fatal(err)
+					}
+					return string(value) == string(b)
+				}
+				if err := quick.Check(fn, nil); err != nil {
+					t.Error(err)
+				}
+			})
+
+			t.Run("get missing", func(t *testing.T) {
+				if _, err := client.Get(ctx, testcase.name+":missing"); err != ErrNotFound {
+					t.Errorf("expected: %v, actual: %v", ErrNotFound, err)
+				}
+			})
+
+			t.Run("delete", func(t *testing.T) {
+				key := testcase.name + ":delete"
+				if err := client.Set(ctx, key, []byte("a")); err != nil {
+					t.Fatal(err)
+				}
+				if err := client.Delete(ctx, key); err != nil {
+					t.Errorf("expected: %v, actual: %v", nil, err)
+				}
+				if err := client.Delete(ctx, key); err != ErrNotFound {
+					t.Errorf("expected: %v, actual: %v", ErrNotFound, err)
+				}
+			})
+
+			t.Run("set with ttl", func(t *testing.T) {
+				key := testcase.name + ":ttl"
+				if err := client.SetWithTTL(ctx, key, []byte("a"), time.Millisecond); err != nil {
+					t.Fatal(err)
+				}
+				eventually(t, func() error {
+					if _, err := client.Get(ctx, key); err != ErrNotFound {
+						return errors.Errorf("expected: %v, actual: %v", ErrNotFound, err)
+					}
+					return nil
+				})
+			})
+
+			t.Run("large value", func(t *testing.T) {
+				key := testcase.name + ":large"
+				value := bytes.Repeat([]byte("abcdefgh"), 8*1024)
+				if err := client.Set(ctx, key, value); err != nil {
+					t.Fatal(err)
+				}
+				actual, err := client.Get(ctx, key)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !bytes.Equal(value, actual) {
+					t.Errorf("expected: %v, actual: %v", len(value), len(actual))
+				}
+			})
+
+			t.Run("bad request", func(t *testing.T) {
+				if _, err := client.Get(ctx, ""); err != ErrBadRequest {
+					t.Errorf("expected: %v, actual: %v", ErrBadRequest, err)
+				}
+			})
+
+			t.Run("scan", func(t *testing.T) {
+				scanner, ok := client.(Scanner)
+				if !ok {
+					t.Skip("scanning isn't supported")
+				}
+
+				prefix := testcase.name + ":scan:"
+				for i := 0; i < 5; i++ {
+					if err := client.Set(ctx, fmt.Sprintf("%s%d", prefix, i), []byte{byte(i)}); err != nil {
+						t.Fatal(err)
+					}
+				}
+
+				var (
+					keys    []string
+					options = ScanOptions{Prefix: prefix, Limit: 2}
+				)
+				for {
+					entries, cursor, err := scanner.Scan(ctx, options)
+					if err != nil {
+						t.Fatal(err)
+					}
+					for _, entry := range entries {
+						keys = append(keys, entry.Key)
+					}
+					if cursor == "" {
+						break
+					}
+					options.Cursor = cursor
+				}
+				if expected, actual := 5, len(keys); expected != actual {
+					t.Errorf("expected: %v, actual: %v", expected, actual)
+				}
+				if expected, actual := prefix+"0", keys[0]; expected != actual {
+					t.Errorf("expected: %v, actual: %v", expected, actual)
+				}
+			})
+
+			t.Run("closed", func(t *testing.T) {
+				if testcase.name == "http" {
+					t.Skip("http clients can be used after closing")
+				}
+				client.Close()
+				if _, err := client.Get(ctx, "a"); err != ErrClosed {
+					t.Errorf("expected: %v, actual: %v", ErrClosed, err)
+				}
+			})
+		})
+	}
+}
+
+// serveHTTP starts a http server with a store of its own, returning a client
+// for the server along with a func to stop the server.
+func serveHTTP(t *testing.T) (Client, func()) {
+	server := httptest.NewServer(keyvalHTTP.NewAPI(keyvalStore.New(), log.NewNopLogger()))
+
+	client, err := NewHTTP(server.URL, Options{})
+	if err != nil {
+		server.Close()
+		t.Fatal(err)
+	}
+	return client, server.Close
+}
+
+// serveTCP starts a tcp server with a store of its own on any free port. The
+// listener is bound before the client is created, so queries are queued until
+// the server accepts them.
+func serveTCP(t *testing.T, options Options) (Client, func()) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go tcp.NewServer(keyvalStore.New(), log.NewNopLogger()).Serve(listener)
+
+	return NewTCP(listener.Addr().String(), options), func() { listener.Close() }
+}
+
+// serveUDP starts a udp server with a store of its own on any free port. The
+// conn is bound before the client is created, so queries are queued until the
+// server reads them.
+func serveUDP(t *testing.T, options Options) (Client, func()) {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	go udp.NewServer(keyvalStore.New(), log.NewNopLogger()).Serve(conn)
+
+	return NewUDP(conn.LocalAddr().String(), options), func() { conn.Close() }
+}
+
+// eventually calls fn until it returns nil, failing with the last error if it
+// doesn't within a second.
+func eventually(t *testing.T, fn func() error) {
+	deadline := time.Now().Add(time.Second)
+	for {
+		err := fn()
+		if err == nil {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatal(err)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestTCPReconnects(t *testing.T) {
+	t.Parallel()
+
+	store := keyvalStore.New()
+	store.Set("a", []byte("b"))
+
+	listener, err := net.Listen("tcp", "0.0.0.0:9052")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	server := tcp.NewServer(store, log.NewNopLogger())
+	server.IdleTimeout = time.Millisecond * 10
+	go server.Serve(listener)
+
+	client := NewTCP("127.0.0.1:9052", Options{})
+	defer client.Close()
+
+	// The pooled connection is closed by the server while it's idle, the
+	// client has to notice and retry with a new connection.
+	for i := 0; i < 3; i++ {
+		value, err := client.Get(context.Background(), "a")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if expected, actual := "b", string(value); expected != actual {
+			t.Errorf("expected: %v, actual: %v", expected, actual)
+		}
+		time.Sleep(time.Millisecond * 50)
+	}
+}
+
+func TestUDPTimeout(t *testing.T) {
+	t.Parallel()
+
+	// Nothing ever replies, so every attempt times out.
+	conn, err := net.ListenPacket("udp", "127.0.0.1:9053")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	client := NewUDP("127.0.0.1:9053", Options{
+		Timeout: time.Millisecond * 10,
+		Retries: 2,
+		Backoff: time.Millisecond,
+	})
+	defer client.Close()
+
+	begin := time.Now()
+	_, err = client.Get(context.Background(), "a")
+	if err == nil {
+		t.Fatal("expected error")
+	}
+	if !temporary(err) {
+		t.Errorf("expected: %v, actual: %v", true, temporary(err))
+	}
+	if elapsed := time.Since(begin); elapsed < time.Millisecond*30 {
+		t.Errorf("expected: %v, actual: %v", time.Millisecond*30, elapsed)
+	}
+}
+
+func TestUDPRetransmits(t *testing.T) {
+	t.Parallel()
+
+	// The first datagrams are dropped, so the client has to send the query
+	// again before the attempt times out.
+	conn, err := net.ListenPacket("udp", "127.0.0.1:9060")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	ids := make(chan uint64, 10)
+	go func() {
+		buf := make([]byte, keyvalNet.MaxFrameSize)
+		for i := 0; ; i++ {
+			n, addr, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			frame, err := keyvalNet.ParseFrame(buf[:n])
+			if err != nil {
+				continue
+			}
+			ids <- frame.ID
+			if i < 2 {
+				continue
+			}
+
+			var res bytes.Buffer
+			gob.NewEncoder(&res).Encode(keyvalNet.Result{Status: keyvalNet.OK})
+			frames, _ := keyvalNet.Fragment(frame.ID, res.Bytes(), keyvalNet.DefaultFrameSize)
+			conn.WriteTo(frames[0].AppendTo(nil), addr)
+		}
+	}()
+
+	client := NewUDP("127.0.0.1:9060", Options{
+		Timeout:            time.Second,
+		Retries:            -1,
+		RetransmitInterval: time.Millisecond * 5,
+	})
+	defer client.Close()
+
+	if err := client.Delete(context.Background(), "a"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Every datagram that was sent is for the same query.
+	first := <-ids
+	for i := 0; i < 2; i++ {
+		if expected, actual := first, <-ids; expected != actual {
+			t.Errorf("expected: %v, actual: %v", expected, actual)
+		}
+	}
+}
+
+func TestRetry(t *testing.T) {
+	t.Parallel()
+
+	options := Options{
+		Retries: 3,
+		Backoff: time.Millisecond,
+	}.withDefaults()
+
+	t.Run("transport errors are retried", func(t *testing.T) {
+		var attempts int
+		err := retry(context.Background(), options, func(context.Context) error {
+			if attempts++; attempts < 3 {
+				return errors.New("connection reset")
+			}
+			return nil
+		})
+		if expected, actual := error(nil), err; expected != actual {
+			t.Errorf("expected: %v, actual: %v", expected, actual)
+		}
+		if expected, actual := 3, attempts; expected != actual {
+			t.Errorf("expected: %v, actual: %v", expected, actual)
+		}
+	})
+
+	t.Run("retries are limited", func(t *testing.T) {
+		var attempts int
+		retry(context.Background(), options, func(context.Context) error {
+			attempts++
+			return errors.New("connection reset")
+		})
+		if expected, actual := 4, attempts; expected != actual {
+			t.Errorf("expected: %v, actual: %v", expected, actual)
+		}
+	})
+
+	t.Run("server errors are not retried", func(t *testing.T) {
+		var attempts int
+		err := retry(context.Background(), options, func(context.Context) error {
+			attempts++
+			return ErrNotFound
+		})
+		if expected, actual := ErrNotFound, err; expected != actual {
+			t.Errorf("expected: %v, actual: %v", expected, actual)
+		}
+		if expected, actual := 1, attempts; expected != actual {
+			t.Errorf("expected: %v, actual: %v", expected, actual)
+		}
+	})
+
+	t.Run("cancelled context", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		err := retry(ctx, options, func(context.Context) error {
+			t.Fatal("expected no attempts")
+			return nil
+		})
+		if expected, actual := context.Canceled, err; expected != actual {
+			t.Errorf("expected: %v, actual: %v", expected, actual)
+		}
+	})
+}
+
+func TestErrorFor(t *testing.T) {
+	t.Parallel()
+
+	for status, expected := range map[keyvalNet.Status]error{
+		keyvalNet.OK:          nil,
+		keyvalNet.Created:     nil,
+		keyvalNet.NotFound:    ErrNotFound,
+		keyvalNet.BadRequest:  ErrBadRequest,
+		keyvalNet.ServerError: ErrServerError,
+		keyvalNet.Conflict:    ErrConflict,
+	} {
+		if actual := errorFor(status); expected != actual {
+			t.Errorf("expected: %v, actual: %v", expected, actual)
+		}
+	}
+}
@@ -0,0 +1,193 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// HTTP is a Client that talks to the HTTP API. Connections are pooled by the
+// underlying http.Transport.
+type HTTP struct {
+	base      *url.URL
+	options   Options
+	client    *http.Client
+	transport *http.Transport
+}
+
+// NewHTTP creates a HTTP Client for the address of the HTTP API, the address
+// is either a URL or a host and port.
+func NewHTTP(addr string, options Options) (*HTTP, error) {
+	if !strings.Contains(addr, "://") {
+		addr = "http://" + addr
+	}
+	base, err := url.Parse(addr)
+	if err != nil {
+		return nil, errors.Wrap(err, "parse address")
+	}
+
+	options = options.withDefaults()
+	transport := &http.Transport{
+		Proxy:               http.ProxyFromEnvironment,
+		MaxIdleConns:        options.MaxIdleConns,
+		MaxIdleConnsPerHost: options.MaxIdleConns,
+		IdleConnTimeout:     90 * time.Second,
+	}
+	return &HTTP{
+		base:    base,
+		options: options,
+		client: &http.Client{
+			Transport: transport,
+		},
+		transport: transport,
+	}, nil
+}
+
+// Get returns the value for the key.
+func (c *HTTP) Get(ctx context.Context, key string) ([]byte, error) {
+	// Without a key the request would be a scan instead.
+	if key == "" {
+		return nil, ErrBadRequest
+	}
+
+	var value []byte
+	err := retry(ctx, c.options, func(ctx context.Context) error {
+		var err error
+		value, err = c.do(ctx, "GET", c.url(keyValues(key, 0)), nil)
+		return err
+	})
+	return value, err
+}
+
+// Set the value for the key.
+func (c *HTTP) Set(ctx context.Context, key string, value []byte) error {
+	return c.SetWithTTL(ctx, key, value, 0)
+}
+
+// SetWithTTL sets the value for the key, which expires after the ttl.
+func (c *HTTP) SetWithTTL(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	return retry(ctx, c.options, func(ctx context.Context) error {
+		_, err := c.do(ctx, "PUT", c.url(keyValues(key, ttl)), value)
+		return err
+	})
+}
+
+// Scan returns a page of entries in key order.
+func (c *HTTP) Scan(ctx context.Context, options ScanOptions) ([]Entry, string, error) {
+	values := url.Values{}
+	for name, value := range map[string]string{
+		"prefix": options.Prefix,
+		"start":  options.Start,
+		"end":    options.End,
+	} {
+		if value != "" {
+			values.Set(name, value)
+		}
+	}
+	if options.Limit > 0 {
+		values.Set("limit", strconv.Itoa(options.Limit))
+	}
+	// The cursor is encoded, as it's not guaranteed to be url friendly.
+	if options.Cursor != "" {
+		values.Set("cursor", base64.RawURLEncoding.EncodeToString([]byte(options.Cursor)))
+	}
+
+	var result struct {
+		Entries []Entry `json:"entries"`
+		Cursor  string  `json:"cursor"`
+	}
+	err := retry(ctx, c.options, func(ctx context.Context) error {
+		b, err := c.do(ctx, "GET", c.url(values), nil)
+		if err != nil {
+			return err
+		}
+		return errors.Wrap(json.Unmarshal(b, &result), "decode")
+	})
+	if err != nil {
+		return nil, "", err
+	}
+
+	cursor, err := base64.RawURLEncoding.DecodeString(result.Cursor)
+	if err != nil {
+		return nil, "", errors.Wrap(err, "decode cursor")
+	}
+	return result.Entries, string(cursor), nil
+}
+
+// Delete the value for the key. Deletes aren't retried, as a retry of a
+// delete that did happen would report that the key doesn't exist.
+func (c *HTTP) Delete(ctx context.Context, key string) error {
+	return attemptWithTimeout(ctx, c.options.Timeout, func(ctx context.Context) error {
+		_, err := c.do(ctx, "DELETE", c.url(keyValues(key, 0)), nil)
+		return err
+	})
+}
+
+// Close the Client, along with all of the idle connections.
+func (c *HTTP) Close() error {
+	c.transport.CloseIdleConnections()
+	return nil
+}
+
+func (c *HTTP) url(values url.Values) string {
+	u := *c.base
+	u.Path = strings.TrimSuffix(u.Path, "/") + "/"
+	u.RawQuery = values.Encode()
+	return u.String()
+}
+
+func keyValues(key string, ttl time.Duration) url.Values {
+	values := url.Values{}
+	values.Set("key", key)
+	if ttl > 0 {
+		values.Set("ttl", ttl.String())
+	}
+	return values
+}
+
+func (c *HTTP) do(ctx context.Context, method, url string, body []byte) ([]byte, error) {
+	var r io.Reader
+	if body != nil {
+		r = bytes.NewReader(body)
+	}
+	req, err := http.NewRequest(method, url, r)
+	if err != nil {
+		return nil, errors.Wrap(err, "request")
+	}
+
+	resp, err := c.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, errors.Wrap(err, "do")
+	}
+	defer resp.Body.Close()
+
+	// Read the whole of the body, even on an error, so the connection can be
+	// reused.
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, errors.Wrap(err, "read")
+	}
+
+	switch code := resp.StatusCode; {
+	case code == http.StatusOK || code == http.StatusCreated:
+		return b, nil
+	case code == http.StatusNotFound:
+		return nil, ErrNotFound
+	case code == http.StatusBadRequest:
+		return nil, ErrBadRequest
+	case code == http.StatusConflict || code == http.StatusPreconditionFailed:
+		return nil, ErrConflict
+	default:
+		return nil, ErrServerError
+	}
+}
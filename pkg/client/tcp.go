@@ -0,0 +1,245 @@
+package client
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	keyvalNet "github.com/SimonRichardson/keyval/pkg/net"
+	"github.com/pkg/errors"
+)
+
+// TCP is a Client that talks to the tcp API. Connections are long lived and
+// are pooled, so that each request doesn't have to pay for a new connection.
+type TCP struct {
+	addr    string
+	options Options
+	dialer  net.Dialer
+
+	mutex  sync.Mutex
+	idle   []*tcpConn
+	closed bool
+}
+
+// NewTCP creates a TCP Client for the address of the tcp API.
+func NewTCP(addr string, options Options) *TCP {
+	return &TCP{
+		addr:    addr,
+		options: options.withDefaults(),
+	}
+}
+
+// Get returns the value for the key.
+func (c *TCP) Get(ctx context.Context, key string) ([]byte, error) {
+	var value []byte
+	err := retry(ctx, c.options, func(ctx context.Context) error {
+		res, err := c.do(ctx, keyvalNet.Query{
+			Method: keyvalNet.Select,
+			Key:    key,
+		})
+		if err != nil {
+			return err
+		}
+		value = res.Value
+		return nil
+	})
+	return value, err
+}
+
+// Set the value for the key.
+func (c *TCP) Set(ctx context.Context, key string, value []byte) error {
+	return c.SetWithTTL(ctx, key, value, 0)
+}
+
+// SetWithTTL sets the value for the key, which expires after the ttl.
+func (c *TCP) SetWithTTL(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	return retry(ctx, c.options, func(ctx context.Context) error {
+		_, err := c.do(ctx, keyvalNet.Query{
+			Method: keyvalNet.Insert,
+			Key:    key,
+			Value:  value,
+			TTL:    ttl,
+		})
+		return err
+	})
+}
+
+// Scan returns a page of entries in key order.
+func (c *TCP) Scan(ctx context.Context, options ScanOptions) ([]Entry, string, error) {
+	var (
+		entries []Entry
+		cursor  string
+	)
+	err := retry(ctx, c.options, func(ctx context.Context) error {
+		res, err := c.do(ctx, keyvalNet.Query{
+			Method: keyvalNet.Scan,
+			Prefix: options.Prefix,
+			Start:  options.Start,
+			End:    options.End,
+			Limit:  options.Limit,
+			Cursor: options.Cursor,
+		})
+		if err != nil {
+			return err
+		}
+		entries = make([]Entry, len(res.Entries))
+		for k, v := range res.Entries {
+			entries[k] = Entry(v)
+		}
+		cursor = res.Cursor
+		return nil
+	})
+	return entries, cursor, err
+}
+
+// Delete the value for the key. Deletes aren't retried, as a retry of a
+// delete that did happen would report that the key doesn't exist.
+func (c *TCP) Delete(ctx context.Context, key string) error {
+	return attemptWithTimeout(ctx, c.options.Timeout, func(ctx context.Context) error {
+		_, err := c.do(ctx, keyvalNet.Query{
+			Method: keyvalNet.Delete,
+			Key:    key,
+		})
+		return err
+	})
+}
+
+// Close the Client, along with all of the idle connections. Connections that
+// are in use are closed once they're done with.
+func (c *TCP) Close() error {
+	c.mutex.Lock()
+	idle := c.idle
+	c.idle = nil
+	c.closed = true
+	c.mutex.Unlock()
+
+	for _, conn := range idle {
+		conn.Close()
+	}
+	return nil
+}
+
+// do sends the query over a pooled connection and reads the result. If
+// anything goes wrong with the connection it's thrown away, as the stream
+// can't be trusted after that.
+func (c *TCP) do(ctx context.Context, query keyvalNet.Query) (keyvalNet.Result, error) {
+	conn, err := c.get(ctx)
+	if err != nil {
+		return keyvalNet.Result{}, err
+	}
+
+	res, err := conn.do(ctx, query)
+	if err != nil {
+		conn.Close()
+		return keyvalNet.Result{}, err
+	}
+	c.put(conn)
+
+	return res, errorFor(res.Status)
+}
+
+func (c *TCP) get(ctx context.Context) (*tcpConn, error) {
+	c.mutex.Lock()
+	if c.closed {
+		c.mutex.Unlock()
+		return nil, ErrClosed
+	}
+	if n := len(c.idle); n > 0 {
+		conn := c.idle[n-1]
+		c.idle = c.idle[:n-1]
+		c.mutex.Unlock()
+		return conn, nil
+	}
+	c.mutex.Unlock()
+
+	conn, err := c.dialer.DialContext(ctx, "tcp", c.addr)
+	if err != nil {
+		return nil, errors.Wrap(err, "dial")
+	}
+	if err := handshake(ctx, conn, c.options.Codec); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return newTCPConn(conn, c.options.Codec, c.options.MaxMessageSize), nil
+}
+
+func (c *TCP) put(conn *tcpConn) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.closed || len(c.idle) >= c.options.MaxIdleConns {
+		conn.Close()
+		return
+	}
+	c.idle = append(c.idle, conn)
+}
+
+// handshake picks the codec for the connection, gob is used when there's no
+// handshake so it doesn't need one.
+func handshake(ctx context.Context, conn net.Conn, codec keyvalNet.Codec) error {
+	if codec == keyvalNet.Gob {
+		return nil
+	}
+	defer watchConn(ctx, conn)()
+
+	conn.SetDeadline(deadline(ctx))
+	if _, err := conn.Write(keyvalNet.Handshake(codec)); err != nil {
+		return errors.Wrap(err, "handshake")
+	}
+	reply := make([]byte, keyvalNet.HandshakeSize)
+	if _, err := io.ReadFull(conn, reply); err != nil {
+		return errors.Wrap(err, "handshake")
+	}
+	if !bytes.Equal(reply, keyvalNet.Handshake(codec)) {
+		return errors.Wrap(keyvalNet.ErrUnknownCodec, "handshake")
+	}
+	return nil
+}
+
+// tcpConn holds the streams of a connection, the server expects a single
+// stream for the whole of the connection.
+type tcpConn struct {
+	net.Conn
+	writer *bufio.Writer
+	enc    keyvalNet.MessageEncoder
+	dec    keyvalNet.MessageDecoder
+	id     uint64
+}
+
+func newTCPConn(conn net.Conn, codec keyvalNet.Codec, maxMessageSize int) *tcpConn {
+	writer := bufio.NewWriter(conn)
+	return &tcpConn{
+		Conn:   conn,
+		writer: writer,
+		enc:    codec.NewEncoder(writer),
+		dec:    keyvalNet.NewDecoderSize(codec, bufio.NewReader(conn), maxMessageSize),
+	}
+}
+
+func (c *tcpConn) do(ctx context.Context, query keyvalNet.Query) (keyvalNet.Result, error) {
+	defer watchConn(ctx, c)()
+
+	c.SetDeadline(deadline(ctx))
+
+	c.id++
+	query.ID = c.id
+	if err := c.enc.Encode(query); err != nil {
+		return keyvalNet.Result{}, errors.Wrap(err, "encode")
+	}
+	if err := c.writer.Flush(); err != nil {
+		return keyvalNet.Result{}, errors.Wrap(err, "write")
+	}
+
+	var res keyvalNet.Result
+	if err := c.dec.Decode(&res); err != nil {
+		return keyvalNet.Result{}, errors.Wrap(err, "decode")
+	}
+	if res.ID != query.ID {
+		return keyvalNet.Result{}, errors.Errorf("unexpected result %d for query %d", res.ID, query.ID)
+	}
+	return res, nil
+}
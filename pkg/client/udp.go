@@ -0,0 +1,256 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	keyvalNet "github.com/SimonRichardson/keyval/pkg/net"
+	"github.com/pkg/errors"
+)
+
+// UDP is a Client that talks to the udp API. Queries and results are split
+// into frames (see keyvalNet.Frame) and every query has an ID of its own, the
+// frames of results for any other ID are ignored.
+//
+// Datagrams can be lost, so if the result doesn't arrive in time the query is
+// sent again, with the same ID and from the same socket. The server remembers
+// the results of queries that change the store, so a query that's sent again
+// gets the same result rather than being handled twice. Sockets are pooled,
+// but a socket that fails is thrown away.
+//
+// Any codec other than gob is picked by a handshake at the start of every
+// query, which the server echoes at the start of the result.
+type UDP struct {
+	addr    string
+	options Options
+	dialer  net.Dialer
+	id      uint64
+
+	mutex  sync.Mutex
+	idle   []net.Conn
+	closed bool
+}
+
+// NewUDP creates a UDP Client for the address of the udp API.
+func NewUDP(addr string, options Options) *UDP {
+	return &UDP{
+		addr:    addr,
+		options: options.withDefaults(),
+		// The IDs start from the time, so that a client that restarts doesn't
+		// reuse the IDs of the queries the server remembers.
+		id: uint64(time.Now().UnixNano()),
+	}
+}
+
+// Get returns the value for the key.
+func (c *UDP) Get(ctx context.Context, key string) ([]byte, error) {
+	res, err := c.do(ctx, keyvalNet.Query{
+		Method: keyvalNet.Select,
+		Key:    key,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return res.Value, nil
+}
+
+// Set the value for the key.
+func (c *UDP) Set(ctx context.Context, key string, value []byte) error {
+	return c.SetWithTTL(ctx, key, value, 0)
+}
+
+// SetWithTTL sets the value for the key, which expires after the ttl.
+func (c *UDP) SetWithTTL(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	_, err := c.do(ctx, keyvalNet.Query{
+		Method: keyvalNet.Insert,
+		Key:    key,
+		Value:  value,
+		TTL:    ttl,
+	})
+	return err
+}
+
+// Delete the value for the key. Unlike the other clients deletes are retried,
+// as the server sends the result of the first attempt again, rather than
+// reporting that the key doesn't exist.
+func (c *UDP) Delete(ctx context.Context, key string) error {
+	_, err := c.do(ctx, keyvalNet.Query{
+		Method: keyvalNet.Delete,
+		Key:    key,
+	})
+	return err
+}
+
+// Close the Client, along with all of the idle sockets.
+func (c *UDP) Close() error {
+	c.mutex.Lock()
+	idle := c.idle
+	c.idle = nil
+	c.closed = true
+	c.mutex.Unlock()
+
+	for _, conn := range idle {
+		conn.Close()
+	}
+	return nil
+}
+
+// do sends the query and waits for the result, retrying if the result doesn't
+// arrive. Every attempt uses the same socket and ID, so that the server can
+// tell the query has been sent again.
+func (c *UDP) do(ctx context.Context, query keyvalNet.Query) (keyvalNet.Result, error) {
+	var req bytes.Buffer
+	codec := c.options.Codec
+	if codec != keyvalNet.Gob {
+		req.Write(keyvalNet.Handshake(codec))
+	}
+	if err := codec.NewEncoder(&req).Encode(query); err != nil {
+		return keyvalNet.Result{}, errors.Wrap(err, "encode")
+	}
+	frames, err := keyvalNet.Fragment(atomic.AddUint64(&c.id, 1), req.Bytes(), keyvalNet.DefaultFrameSize)
+	if err != nil {
+		return keyvalNet.Result{}, errors.Wrap(err, "encode")
+	}
+
+	conn, err := c.get(ctx)
+	if err != nil {
+		return keyvalNet.Result{}, err
+	}
+
+	var res keyvalNet.Result
+	err = retry(ctx, c.options, func(ctx context.Context) error {
+		var err error
+		res, err = c.roundTrip(ctx, conn, frames)
+		return err
+	})
+	if err != nil {
+		conn.Close()
+		return keyvalNet.Result{}, err
+	}
+	c.put(conn)
+
+	return res, errorFor(res.Status)
+}
+
+// roundTrip sends the frames of the query and reads frames until the result
+// for the query has been put back together. If nothing arrives within the
+// retransmit interval, then the frames are sent again and the interval is
+// doubled.
+func (c *UDP) roundTrip(ctx context.Context, conn net.Conn, frames []keyvalNet.Frame) (keyvalNet.Result, error) {
+	defer watchConn(ctx, conn)()
+
+	// Every attempt uses the same socket, so the deadline of the last attempt
+	// has to be replaced.
+	conn.SetDeadline(deadline(ctx))
+
+	var (
+		id  = frames[0].ID
+		buf = make([]byte, keyvalNet.MaxFrameSize)
+	)
+	send := func() error {
+		for _, frame := range frames {
+			if _, err := conn.Write(frame.AppendTo(buf[:0])); err != nil {
+				return errors.Wrap(err, "write")
+			}
+		}
+		return nil
+	}
+	if err := send(); err != nil {
+		return keyvalNet.Result{}, err
+	}
+
+	var (
+		end         = deadline(ctx)
+		interval    = c.options.RetransmitInterval
+		retransmit  = time.Now().Add(interval)
+		reassembler = keyvalNet.NewReassembler(
+			c.options.MaxMessageSize,
+			c.options.MaxMessageSize,
+			keyvalNet.DefaultReassemblyTimeout,
+		)
+	)
+	for {
+		readDeadline := retransmit
+		if !end.IsZero() && end.Before(readDeadline) {
+			readDeadline = end
+		}
+		conn.SetReadDeadline(readDeadline)
+
+		n, err := conn.Read(buf)
+		if err != nil {
+			if err, ok := err.(net.Error); ok && err.Timeout() && ctx.Err() == nil && time.Now().Before(end) {
+				if err := send(); err != nil {
+					return keyvalNet.Result{}, err
+				}
+				interval *= 2
+				retransmit = time.Now().Add(interval)
+				continue
+			}
+			return keyvalNet.Result{}, errors.Wrap(err, "read")
+		}
+		frame, err := keyvalNet.ParseFrame(buf[:n])
+		if err != nil || frame.ID != id {
+			continue
+		}
+		message, ok, err := reassembler.Add("", frame)
+		if err != nil {
+			return keyvalNet.Result{}, errors.Wrap(err, "read")
+		}
+		if !ok {
+			continue
+		}
+
+		codec, message, _, err := keyvalNet.SplitHandshake(message)
+		if err != nil {
+			return keyvalNet.Result{}, errors.Wrap(err, "handshake")
+		}
+		if codec != c.options.Codec {
+			return keyvalNet.Result{}, errors.Wrap(keyvalNet.ErrUnknownCodec, "handshake")
+		}
+
+		var res keyvalNet.Result
+		if err := keyvalNet.NewDecoderSize(codec, bytes.NewReader(message), c.options.MaxMessageSize).Decode(&res); err != nil {
+			return keyvalNet.Result{}, errors.Wrap(err, "decode")
+		}
+		return res, nil
+	}
+}
+
+func (c *UDP) get(ctx context.Context) (net.Conn, error) {
+	c.mutex.Lock()
+	if c.closed {
+		c.mutex.Unlock()
+		return nil, ErrClosed
+	}
+	if n := len(c.idle); n > 0 {
+		conn := c.idle[n-1]
+		c.idle = c.idle[:n-1]
+		c.mutex.Unlock()
+		return conn, nil
+	}
+	c.mutex.Unlock()
+
+	conn, err := c.dialer.DialContext(ctx, "udp", c.addr)
+	if err != nil {
+		return nil, errors.Wrap(err, "dial")
+	}
+	if udpConn, ok := conn.(*net.UDPConn); ok {
+		udpConn.SetReadBuffer(keyvalNet.DefaultReadBufferSize)
+	}
+	return conn, nil
+}
+
+func (c *UDP) put(conn net.Conn) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.closed || len(c.idle) >= c.options.MaxIdleConns {
+		conn.Close()
+		return
+	}
+	c.idle = append(c.idle, conn)
+}
@@ -0,0 +1,376 @@
+package grpc
+
+import (
+	"context"
+	"net"
+	"time"
+
+	"github.com/SimonRichardson/keyval/pkg/grpc/pb"
+	keyvalNet "github.com/SimonRichardson/keyval/pkg/net"
+	"github.com/SimonRichardson/keyval/pkg/store"
+	"github.com/go-kit/kit/log"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
+)
+
+// TrailerDuration is the trailer that holds how long a call took to handle,
+// the same as the duration of the results of the other APIs.
+const TrailerDuration = "x-duration"
+
+// Server represents a way to interact with the underlying key/val store over
+// gRPC (see pb/keyval.proto for the service). The statuses of the other APIs
+// are returned as the codes of gRPC, e.g. a conflict is FAILED_PRECONDITION.
+type Server struct {
+	pb.UnimplementedKeyValServer
+
+	store  store.Store
+	logger log.Logger
+	server *grpc.Server
+}
+
+// NewServer creates a Server with the correct dependencies
+func NewServer(store store.Store, logger log.Logger) *Server {
+	s := &Server{
+		store:  store,
+		logger: logger,
+		server: grpc.NewServer(),
+	}
+	pb.RegisterKeyValServer(s.server, s)
+	return s
+}
+
+// Serve the listener for the server
+func (s *Server) Serve(listener net.Listener) error {
+	return s.server.Serve(listener)
+}
+
+// Stop the server, closing the listener and any calls that are still open.
+func (s *Server) Stop() {
+	s.server.Stop()
+}
+
+// Get returns the value and version of the key.
+func (s *Server) Get(ctx context.Context, req *pb.GetRequest) (*pb.GetResponse, error) {
+	// useful metrics
+	begin := time.Now()
+
+	// Validate user input.
+	var qp keyvalNet.QueryParams
+	if err := qp.DecodeFrom(keyvalNet.Query{Key: req.GetKey()}); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	entry, ok := s.store.Select(qp.Key)
+	if !ok {
+		return nil, status.Error(codes.NotFound, "key not found")
+	}
+
+	// Finish
+	setDuration(ctx, begin)
+	return &pb.GetResponse{
+		Value:    entry.Value,
+		Version:  entry.Version,
+		Metadata: metadataPB(entry.Metadata),
+	}, nil
+}
+
+// Set stores the value of the key, if there's a version then the value is only
+// stored if the version matches.
+func (s *Server) Set(ctx context.Context, req *pb.SetRequest) (*pb.SetResponse, error) {
+	// useful metrics
+	begin := time.Now()
+
+	// Validate user input.
+	ttl, err := durationOf(req.GetTtl())
+	if err != nil {
+		return nil, err
+	}
+	var qp keyvalNet.QueryParams
+	if err := qp.DecodeFrom(keyvalNet.Query{
+		Key:             req.GetKey(),
+		TTL:             ttl,
+		ContentType:     req.GetMetadata().GetContentType(),
+		ContentEncoding: req.GetMetadata().GetContentEncoding(),
+		Metadata:        req.GetMetadata().GetUser(),
+	}); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	var (
+		res      pb.SetResponse
+		metadata = metadataOf(req.GetMetadata())
+	)
+	if !store.Fits(s.store, qp.Key, req.GetValue(), metadata) {
+		return nil, status.Error(codes.ResourceExhausted, "value can never fit with in the store")
+	}
+	switch {
+	case req.Version != nil:
+		version, ok := store.CompareAndSwapWithMetadata(s.store, qp.Key, req.GetVersion(), req.GetValue(), qp.TTL, metadata)
+		if err := store.Err(s.store); err != nil {
+			return nil, failed(err)
+		} else if !ok {
+			return nil, status.Error(codes.FailedPrecondition, "version doesn't match")
+		}
+		res.Replaced = req.GetVersion() > 0
+		res.Version = version
+	case !metadata.IsZero():
+		res.Replaced = s.store.SetWithMetadata(qp.Key, req.GetValue(), qp.TTL, metadata)
+	case qp.TTL > 0:
+		res.Replaced = s.store.SetWithTTL(qp.Key, req.GetValue(), qp.TTL)
+	default:
+		res.Replaced = s.store.Set(qp.Key, req.GetValue())
+	}
+	if err := store.Err(s.store); err != nil {
+		return nil, failed(err)
+	}
+
+	// Finish
+	setDuration(ctx, begin)
+	return &res, nil
+}
+
+// Delete removes the key, if there's a version then the key is only removed if
+// the version matches.
+func (s *Server) Delete(ctx context.Context, req *pb.DeleteRequest) (*pb.DeleteResponse, error) {
+	// useful metrics
+	begin := time.Now()
+
+	// Validate user input.
+	var qp keyvalNet.QueryParams
+	if err := qp.DecodeFrom(keyvalNet.Query{Key: req.GetKey()}); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	if req.Version != nil {
+		ok := s.store.CompareAndDelete(qp.Key, req.GetVersion())
+		if err := store.Err(s.store); err != nil {
+			return nil, failed(err)
+		} else if !ok {
+			return nil, status.Error(codes.FailedPrecondition, "version doesn't match")
+		}
+	} else {
+		ok := s.store.Delete(qp.Key)
+		if err := store.Err(s.store); err != nil {
+			return nil, failed(err)
+		} else if !ok {
+			return nil, status.Error(codes.NotFound, "key not found")
+		}
+	}
+
+	// Finish
+	setDuration(ctx, begin)
+	return &pb.DeleteResponse{}, nil
+}
+
+// Batch applies the mutations as a transaction, if all of the conditions hold.
+func (s *Server) Batch(ctx context.Context, req *pb.BatchRequest) (*pb.BatchResponse, error) {
+	// useful metrics
+	begin := time.Now()
+
+	// Validate user input.
+	q := keyvalNet.Query{
+		Conditions: make([]keyvalNet.Condition, len(req.GetConditions())),
+		Mutations:  make([]keyvalNet.Mutation, len(req.GetMutations())),
+	}
+	for k, v := range req.GetConditions() {
+		q.Conditions[k] = keyvalNet.Condition{
+			Key:     v.GetKey(),
+			Check:   keyvalNet.Check(v.GetCheck()),
+			Version: v.GetVersion(),
+		}
+	}
+	for k, v := range req.GetMutations() {
+		ttl, err := durationOf(v.GetTtl())
+		if err != nil {
+			return nil, err
+		}
+		q.Mutations[k] = keyvalNet.Mutation{
+			Key:             v.GetKey(),
+			Operation:       keyvalNet.Operation(v.GetOperation()),
+			Value:           v.GetValue(),
+			TTL:             ttl,
+			ContentType:     v.GetMetadata().GetContentType(),
+			ContentEncoding: v.GetMetadata().GetContentEncoding(),
+			Metadata:        v.GetMetadata().GetUser(),
+		}
+	}
+	var qp keyvalNet.TxnQueryParams
+	if err := qp.DecodeFrom(q); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	conditions := make([]store.Condition, len(qp.Conditions))
+	for k, v := range qp.Conditions {
+		conditions[k] = store.Condition{
+			Key:     v.Key,
+			Check:   store.Check(v.Check),
+			Version: v.Version,
+		}
+	}
+	mutations := make([]store.Mutation, len(qp.Mutations))
+	for k, v := range qp.Mutations {
+		mutations[k] = store.Mutation{
+			Key:       v.Key,
+			Operation: store.Operation(v.Operation),
+			Value:     v.Value,
+			TTL:       v.TTL,
+			Metadata: store.Metadata{
+				ContentType:     v.ContentType,
+				ContentEncoding: v.ContentEncoding,
+				User:            v.Metadata,
+			},
+		}
+	}
+
+	var res pb.BatchResponse
+	res.Versions, res.Applied = s.store.Txn(conditions, mutations)
+	if err := store.Err(s.store); err != nil {
+		return nil, failed(err)
+	}
+
+	// Finish
+	setDuration(ctx, begin)
+	return &res, nil
+}
+
+// Scan returns a page of entries in key order.
+func (s *Server) Scan(ctx context.Context, req *pb.ScanRequest) (*pb.ScanResponse, error) {
+	// useful metrics
+	begin := time.Now()
+
+	// Validate user input.
+	var qp keyvalNet.ScanQueryParams
+	if err := qp.DecodeFrom(keyvalNet.Query{
+		Prefix: req.GetPrefix(),
+		Start:  req.GetStart(),
+		End:    req.GetEnd(),
+		Limit:  int(req.GetLimit()),
+		Cursor: req.GetCursor(),
+	}); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	entries, cursor := s.store.Scan(store.ScanOptions{
+		Prefix: qp.Prefix,
+		Start:  qp.Start,
+		End:    qp.End,
+		Limit:  qp.Limit,
+		Cursor: qp.Cursor,
+	})
+
+	res := pb.ScanResponse{
+		Entries: make([]*pb.Entry, len(entries)),
+		Cursor:  cursor,
+	}
+	for k, v := range entries {
+		res.Entries[k] = &pb.Entry{
+			Key:     v.Key,
+			Value:   v.Value,
+			Version: v.Version,
+		}
+	}
+
+	// Finish
+	setDuration(ctx, begin)
+	return &res, nil
+}
+
+// Watch streams the events to the client until either the call is cancelled
+// or the watch overflows.
+func (s *Server) Watch(req *pb.WatchRequest, stream pb.KeyVal_WatchServer) error {
+	// Validate user input.
+	var qp keyvalNet.WatchQueryParams
+	if err := qp.DecodeFrom(keyvalNet.Query{
+		Key:    req.GetKey(),
+		Prefix: req.GetPrefix(),
+	}); err != nil {
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	watcher, ok := s.store.(store.Watcher)
+	if !ok {
+		return status.Error(codes.Unimplemented, "store can't be watched")
+	}
+
+	sub := watcher.Watch(store.WatchOptions{
+		Key:    qp.Key,
+		Prefix: qp.Prefix,
+	})
+	defer sub.Close()
+
+	// Send the headers straight away, so that the client knows that the
+	// subscription has been made.
+	if err := stream.SendHeader(metadata.MD{}); err != nil {
+		return err
+	}
+
+	for {
+		select {
+		case event, ok := <-sub.Events():
+			if !ok {
+				// The client was too slow, let it know that events have been
+				// dropped, so it can read the store again and resubscribe.
+				if sub.Err() == store.ErrOverflow {
+					return status.Error(codes.Aborted, "watch overflowed")
+				}
+				return nil
+			}
+			if err := stream.Send(&pb.WatchResponse{
+				Type:    pb.WatchResponse_Type(event.Type),
+				Key:     event.Key,
+				Value:   event.Value,
+				Version: event.Version,
+			}); err != nil {
+				return err
+			}
+		case <-stream.Context().Done():
+			return stream.Context().Err()
+		}
+	}
+}
+
+// durationOf returns the duration, where nil is no duration at all.
+func durationOf(d *durationpb.Duration) (time.Duration, error) {
+	if d == nil {
+		return 0, nil
+	}
+	if err := d.CheckValid(); err != nil {
+		return 0, status.Error(codes.InvalidArgument, err.Error())
+	}
+	return d.AsDuration(), nil
+}
+
+// failed returns the error for a write that the store couldn't apply, even
+// though the write itself is valid.
+func failed(err error) error {
+	return status.Error(codes.Internal, err.Error())
+}
+
+// metadataOf returns the metadata of a value, where nil is no metadata at all.
+func metadataOf(m *pb.Metadata) store.Metadata {
+	return store.Metadata{
+		ContentType:     m.GetContentType(),
+		ContentEncoding: m.GetContentEncoding(),
+		User:            m.GetUser(),
+	}
+}
+
+// metadataPB returns the metadata of a value, values without metadata have no
+// metadata at all.
+func metadataPB(m store.Metadata) *pb.Metadata {
+	if m.IsZero() {
+		return nil
+	}
+	return &pb.Metadata{
+		ContentType:     m.ContentType,
+		ContentEncoding: m.ContentEncoding,
+		User:            m.User,
+	}
+}
+
+func setDuration(ctx context.Context, begin time.Time) {
+	grpc.SetTrailer(ctx, metadata.Pairs(TrailerDuration, time.Since(begin).String()))
+}
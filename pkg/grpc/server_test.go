@@ -0,0 +1,672 @@
+package grpc
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"net"
+	"reflect"
+	"testing"
+	"testing/quick"
+	"time"
+
+	"github.com/SimonRichardson/keyval/pkg/grpc/pb"
+	keyvalStore "github.com/SimonRichardson/keyval/pkg/store"
+	"github.com/SimonRichardson/keyval/pkg/store/mocks"
+	"github.com/go-kit/kit/log"
+	"github.com/golang/mock/gomock"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/durationpb"
+)
+
+func TestAPISelect(t *testing.T) {
+	t.Parallel()
+
+	t.Run("select with no valid content", func(t *testing.T) {
+		fn := func(a []byte) bool {
+
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+
+			store := mocks.NewMockStore(ctrl)
+
+			client, close := setupServer(t, store)
+			defer close()
+
+			key := buildKey(a)
+
+			store.EXPECT().Select(key).Return(keyvalStore.Entry{}, false)
+
+			_, err := client.Get(context.Background(), &pb.GetRequest{Key: key})
+			return status.Code(err) == codes.NotFound
+		}
+		if err := quick.Check(fn, nil); err != nil {
+			t.Error(err)
+		}
+	})
+
+	t.Run("select", func(t *testing.T) {
+		fn := func(a, b []byte) bool {
+
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+
+			store := mocks.NewMockStore(ctrl)
+
+			client, close := setupServer(t, store)
+			defer close()
+
+			key := buildKey(a)
+
+			store.EXPECT().Select(key).Return(keyvalStore.Entry{Key: key, Value: b, Version: 1}, true)
+
+			var trailer metadata.MD
+			res, err := client.Get(context.Background(), &pb.GetRequest{Key: key}, grpc.Trailer(&trailer))
+			if err != nil {
+				t.Error(err)
+				return false
+			}
+
+			return bytes.Equal(res.GetValue(), b) &&
+				res.GetVersion() == 1 &&
+				len(trailer.Get(TrailerDuration)) == 1
+		}
+		if err := quick.Check(fn, nil); err != nil {
+			t.Error(err)
+		}
+	})
+
+	t.Run("select with no key", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+
+		store := mocks.NewMockStore(ctrl)
+
+		client, close := setupServer(t, store)
+		defer close()
+
+		_, err := client.Get(context.Background(), &pb.GetRequest{})
+		if expected, actual := codes.InvalidArgument, status.Code(err); expected != actual {
+			t.Errorf("expected: %v, actual: %v", expected, actual)
+		}
+	})
+}
+
+func TestAPIInsert(t *testing.T) {
+	t.Parallel()
+
+	t.Run("insert with no existing value", func(t *testing.T) {
+		fn := func(a, b []byte) bool {
+
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+
+			store := mocks.NewMockStore(ctrl)
+
+			client, close := setupServer(t, store)
+			defer close()
+
+			key := buildKey(a)
+
+			store.EXPECT().Set(key, normalizeBytes(b)).Return(false)
+
+			res, err := client.Set(context.Background(), &pb.SetRequest{Key: key, Value: b})
+			if err != nil {
+				t.Error(err)
+				return false
+			}
+
+			return !res.GetReplaced()
+		}
+		if err := quick.Check(fn, nil); err != nil {
+			t.Error(err)
+		}
+	})
+
+	t.Run("insert with existing value", func(t *testing.T) {
+		fn := func(a, b []byte) bool {
+
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+
+			store := mocks.NewMockStore(ctrl)
+
+			client, close := setupServer(t, store)
+			defer close()
+
+			key := buildKey(a)
+
+			store.EXPECT().Set(key, normalizeBytes(b)).Return(true)
+
+			res, err := client.Set(context.Background(), &pb.SetRequest{Key: key, Value: b})
+			if err != nil {
+				t.Error(err)
+				return false
+			}
+
+			return res.GetReplaced()
+		}
+		if err := quick.Check(fn, nil); err != nil {
+			t.Error(err)
+		}
+	})
+
+	t.Run("insert with ttl", func(t *testing.T) {
+		fn := func(a, b []byte) bool {
+
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+
+			store := mocks.NewMockStore(ctrl)
+
+			client, close := setupServer(t, store)
+			defer close()
+
+			key := buildKey(a)
+
+			store.EXPECT().SetWithTTL(key, normalizeBytes(b), time.Minute).Return(false)
+
+			_, err := client.Set(context.Background(), &pb.SetRequest{
+				Key:   key,
+				Value: b,
+				Ttl:   durationpb.New(time.Minute),
+			})
+			if err != nil {
+				t.Error(err)
+				return false
+			}
+			return true
+		}
+		if err := quick.Check(fn, nil); err != nil {
+			t.Error(err)
+		}
+	})
+
+	t.Run("insert with matching version", func(t *testing.T) {
+		fn := func(a, b []byte) bool {
+
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+
+			store := mocks.NewMockStore(ctrl)
+
+			client, close := setupServer(t, store)
+			defer close()
+
+			key := buildKey(a)
+
+			store.EXPECT().Txn([]keyvalStore.Condition{
+				{Key: key, Check: keyvalStore.CheckVersion, Version: 2},
+			}, []keyvalStore.Mutation{
+				{Key: key, Value: normalizeBytes(b), TTL: time.Minute},
+			}).Return([]uint64{3}, true)
+
+			version := uint64(2)
+			res, err := client.Set(context.Background(), &pb.SetRequest{
+				Key:     key,
+				Value:   b,
+				Ttl:     durationpb.New(time.Minute),
+				Version: &version,
+			})
+			if err != nil {
+				t.Error(err)
+				return false
+			}
+
+			return res.GetReplaced() && res.GetVersion() == 3
+		}
+		if err := quick.Check(fn, nil); err != nil {
+			t.Error(err)
+		}
+	})
+
+	t.Run("insert with stale version", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+
+		store := mocks.NewMockStore(ctrl)
+
+		client, close := setupServer(t, store)
+		defer close()
+
+		store.EXPECT().Txn([]keyvalStore.Condition{
+			{Key: "a", Check: keyvalStore.CheckVersion, Version: 0},
+		}, []keyvalStore.Mutation{
+			{Key: "a", Value: []byte("b")},
+		}).Return([]uint64{4}, false)
+
+		version := uint64(0)
+		_, err := client.Set(context.Background(), &pb.SetRequest{
+			Key:     "a",
+			Value:   []byte("b"),
+			Version: &version,
+		})
+		if expected, actual := codes.FailedPrecondition, status.Code(err); expected != actual {
+			t.Errorf("expected: %v, actual: %v", expected, actual)
+		}
+	})
+
+	t.Run("insert with invalid request", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+
+		store := mocks.NewMockStore(ctrl)
+
+		client, close := setupServer(t, store)
+		defer close()
+
+		for _, req := range []*pb.SetRequest{
+			{Value: []byte("b")},
+			{Key: "a", Ttl: durationpb.New(-time.Second)},
+			{Key: "a", Ttl: &durationpb.Duration{Seconds: 1, Nanos: -1}},
+		} {
+			_, err := client.Set(context.Background(), req)
+			if expected, actual := codes.InvalidArgument, status.Code(err); expected != actual {
+				t.Errorf("%v expected: %v, actual: %v", req, expected, actual)
+			}
+		}
+	})
+
+	t.Run("insert with metadata", func(t *testing.T) {
+		s := keyvalStore.New()
+
+		client, close := setupServer(t, s)
+		defer close()
+
+		metadata := &pb.Metadata{
+			ContentType: "application/json",
+			User:        map[string]string{"owner": "a"},
+		}
+		if _, err := client.Set(context.Background(), &pb.SetRequest{
+			Key:      "a",
+			Value:    []byte("{}"),
+			Metadata: metadata,
+		}); err != nil {
+			t.Fatal(err)
+		}
+
+		res, err := client.Get(context.Background(), &pb.GetRequest{Key: "a"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if expected, actual := metadata, res.GetMetadata(); !proto.Equal(expected, actual) {
+			t.Errorf("expected: %v, actual: %v", expected, actual)
+		}
+
+		_, err = client.Set(context.Background(), &pb.SetRequest{
+			Key:      "a",
+			Value:    []byte("{}"),
+			Metadata: &pb.Metadata{User: map[string]string{"a b": "c"}},
+		})
+		if expected, actual := codes.InvalidArgument, status.Code(err); expected != actual {
+			t.Errorf("expected: %v, actual: %v", expected, actual)
+		}
+	})
+}
+
+func TestAPIDelete(t *testing.T) {
+	t.Parallel()
+
+	t.Run("delete with no existing value", func(t *testing.T) {
+		fn := func(a []byte) bool {
+
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+
+			store := mocks.NewMockStore(ctrl)
+
+			client, close := setupServer(t, store)
+			defer close()
+
+			key := buildKey(a)
+
+			store.EXPECT().Delete(key).Return(false)
+
+			_, err := client.Delete(context.Background(), &pb.DeleteRequest{Key: key})
+			return status.Code(err) == codes.NotFound
+		}
+		if err := quick.Check(fn, nil); err != nil {
+			t.Error(err)
+		}
+	})
+
+	t.Run("delete", func(t *testing.T) {
+		fn := func(a []byte) bool {
+
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+
+			store := mocks.NewMockStore(ctrl)
+
+			client, close := setupServer(t, store)
+			defer close()
+
+			key := buildKey(a)
+
+			store.EXPECT().Delete(key).Return(true)
+
+			_, err := client.Delete(context.Background(), &pb.DeleteRequest{Key: key})
+			return err == nil
+		}
+		if err := quick.Check(fn, nil); err != nil {
+			t.Error(err)
+		}
+	})
+
+	t.Run("delete with version", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+
+		store := mocks.NewMockStore(ctrl)
+
+		client, close := setupServer(t, store)
+		defer close()
+
+		store.EXPECT().CompareAndDelete("a", uint64(2)).Return(true)
+		store.EXPECT().CompareAndDelete("a", uint64(3)).Return(false)
+
+		for _, testcase := range []struct {
+			version  uint64
+			expected codes.Code
+		}{
+			{2, codes.OK},
+			{3, codes.FailedPrecondition},
+		} {
+			_, err := client.Delete(context.Background(), &pb.DeleteRequest{
+				Key:     "a",
+				Version: &testcase.version,
+			})
+			if expected, actual := testcase.expected, status.Code(err); expected != actual {
+				t.Errorf("expected: %v, actual: %v", expected, actual)
+			}
+		}
+	})
+}
+
+func TestAPIScan(t *testing.T) {
+	t.Parallel()
+
+	t.Run("scan", func(t *testing.T) {
+		fn := func(a, b []byte) bool {
+
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+
+			store := mocks.NewMockStore(ctrl)
+
+			client, close := setupServer(t, store)
+			defer close()
+
+			key := buildKey(a)
+
+			store.EXPECT().Scan(keyvalStore.ScanOptions{
+				Prefix: key,
+				Limit:  10,
+				Cursor: "abc",
+			}).Return([]keyvalStore.Entry{
+				{Key: key, Value: b, Version: 1},
+			}, "def")
+
+			res, err := client.Scan(context.Background(), &pb.ScanRequest{
+				Prefix: key,
+				Limit:  10,
+				Cursor: "abc",
+			})
+			if err != nil {
+				t.Error(err)
+				return false
+			}
+
+			entries := res.GetEntries()
+			return len(entries) == 1 &&
+				entries[0].GetKey() == key &&
+				bytes.Equal(entries[0].GetValue(), b) &&
+				entries[0].GetVersion() == 1 &&
+				res.GetCursor() == "def"
+		}
+		if err := quick.Check(fn, nil); err != nil {
+			t.Error(err)
+		}
+	})
+
+	t.Run("scan with invalid limit", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+
+		store := mocks.NewMockStore(ctrl)
+
+		client, close := setupServer(t, store)
+		defer close()
+
+		_, err := client.Scan(context.Background(), &pb.ScanRequest{Limit: -1})
+		if expected, actual := codes.InvalidArgument, status.Code(err); expected != actual {
+			t.Errorf("expected: %v, actual: %v", expected, actual)
+		}
+	})
+}
+
+func TestAPIBatch(t *testing.T) {
+	t.Parallel()
+
+	t.Run("batch", func(t *testing.T) {
+		fn := func(a, b []byte) bool {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+
+			store := mocks.NewMockStore(ctrl)
+
+			client, close := setupServer(t, store)
+			defer close()
+
+			key := buildKey(a)
+
+			store.EXPECT().Txn([]keyvalStore.Condition{
+				{Key: key, Check: keyvalStore.CheckVersion, Version: 2},
+				{Key: key + "-other", Check: keyvalStore.CheckMissing},
+			}, []keyvalStore.Mutation{
+				{Key: key, Operation: keyvalStore.OperationSet, Value: normalizeBytes(b), TTL: time.Minute},
+				{Key: key + "-other", Operation: keyvalStore.OperationDelete},
+			}).Return([]uint64{3, 0}, true)
+
+			res, err := client.Batch(context.Background(), &pb.BatchRequest{
+				Conditions: []*pb.Condition{
+					{Key: key, Check: pb.Condition_VERSION, Version: 2},
+					{Key: key + "-other", Check: pb.Condition_MISSING},
+				},
+				Mutations: []*pb.Mutation{
+					{Key: key, Operation: pb.Mutation_SET, Value: b, Ttl: durationpb.New(time.Minute)},
+					{Key: key + "-other", Operation: pb.Mutation_DELETE},
+				},
+			})
+			if err != nil {
+				t.Error(err)
+				return false
+			}
+
+			return res.GetApplied() &&
+				reflect.DeepEqual(res.GetVersions(), []uint64{3, 0})
+		}
+		if err := quick.Check(fn, nil); err != nil {
+			t.Error(err)
+		}
+	})
+
+	t.Run("batch with failing condition", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+
+		store := mocks.NewMockStore(ctrl)
+
+		client, close := setupServer(t, store)
+		defer close()
+
+		store.EXPECT().Txn([]keyvalStore.Condition{
+			{Key: "a", Check: keyvalStore.CheckExists},
+		}, []keyvalStore.Mutation{}).Return([]uint64{0}, false)
+
+		res, err := client.Batch(context.Background(), &pb.BatchRequest{
+			Conditions: []*pb.Condition{
+				{Key: "a", Check: pb.Condition_EXISTS},
+			},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if expected, actual := false, res.GetApplied(); expected != actual {
+			t.Errorf("expected: %v, actual: %v", expected, actual)
+		}
+	})
+
+	t.Run("batch with invalid request", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+
+		store := mocks.NewMockStore(ctrl)
+
+		client, close := setupServer(t, store)
+		defer close()
+
+		for _, req := range []*pb.BatchRequest{
+			{Conditions: []*pb.Condition{{Check: pb.Condition_EXISTS}}},
+			{Conditions: []*pb.Condition{{Key: "a", Check: 7}}},
+			{Mutations: []*pb.Mutation{{Key: "a", Operation: 7}}},
+			{Mutations: []*pb.Mutation{{Key: "a", Ttl: durationpb.New(-time.Second)}}},
+		} {
+			_, err := client.Batch(context.Background(), req)
+			if expected, actual := codes.InvalidArgument, status.Code(err); expected != actual {
+				t.Errorf("%v expected: %v, actual: %v", req, expected, actual)
+			}
+		}
+	})
+}
+
+func TestAPIWatch(t *testing.T) {
+	t.Parallel()
+
+	t.Run("watch", func(t *testing.T) {
+		s := keyvalStore.New()
+
+		client, close := setupServer(t, s)
+		defer close()
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+		defer cancel()
+
+		stream, err := client.Watch(ctx, &pb.WatchRequest{Prefix: "config/"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		// Wait for the subscription to be made.
+		if _, err := stream.Header(); err != nil {
+			t.Fatal(err)
+		}
+
+		s.Set("other", []byte("x"))
+		s.Set("config/a", []byte("a"))
+		s.Delete("config/a")
+
+		for _, expected := range []*pb.WatchResponse{
+			{Type: pb.WatchResponse_PUT, Key: "config/a", Value: []byte("a"), Version: 2},
+			{Type: pb.WatchResponse_DELETE, Key: "config/a", Version: 2},
+		} {
+			actual, err := stream.Recv()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if expected.GetType() != actual.GetType() ||
+				expected.GetKey() != actual.GetKey() ||
+				!bytes.Equal(expected.GetValue(), actual.GetValue()) ||
+				expected.GetVersion() != actual.GetVersion() {
+				t.Errorf("expected: %v, actual: %v", expected, actual)
+			}
+		}
+
+		cancel()
+		if _, err := stream.Recv(); status.Code(err) != codes.Canceled {
+			t.Errorf("expected: %v, actual: %v", codes.Canceled, err)
+		}
+	})
+
+	t.Run("watch with key and prefix", func(t *testing.T) {
+		client, close := setupServer(t, keyvalStore.New())
+		defer close()
+
+		stream, err := client.Watch(context.Background(), &pb.WatchRequest{Key: "a", Prefix: "b"})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		_, err = stream.Recv()
+		if expected, actual := codes.InvalidArgument, status.Code(err); expected != actual {
+			t.Errorf("expected: %v, actual: %v", expected, actual)
+		}
+	})
+
+	t.Run("watch without watcher", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+
+		store := mocks.NewMockStore(ctrl)
+
+		client, close := setupServer(t, store)
+		defer close()
+
+		stream, err := client.Watch(context.Background(), &pb.WatchRequest{})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		_, err = stream.Recv()
+		if expected, actual := codes.Unimplemented, status.Code(err); expected != actual {
+			t.Errorf("expected: %v, actual: %v", expected, actual)
+		}
+	})
+}
+
+// setupServer serves the store in process, returning a client of the server
+// and a func to close them both.
+func setupServer(t *testing.T, store keyvalStore.Store) (pb.KeyValClient, func()) {
+	listener := bufconn.Listen(1 << 20)
+
+	server := NewServer(store, log.NewNopLogger())
+	go server.Serve(listener)
+
+	conn, err := grpc.NewClient("passthrough:///bufconn",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return listener.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return pb.NewKeyValClient(conn), func() {
+		conn.Close()
+		server.Stop()
+	}
+}
+
+func buildKey(a []byte) string {
+	v := base64.RawURLEncoding.EncodeToString(a)
+	if v == "" {
+		v = "empty"
+	}
+	return v
+}
+
+// normalizeBytes returns the bytes as they're received by the server, where an
+// empty value is always nil.
+func normalizeBytes(b []byte) []byte {
+	if len(b) == 0 {
+		return nil
+	}
+	return b
+}
@@ -0,0 +1,133 @@
+package http
+
+import (
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/SimonRichardson/keyval/pkg/store"
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+)
+
+// These are the paths we're interested for the admin queries
+const (
+	APIPathSnapshot = "/snapshot"
+	APIPathStats    = "/stats"
+)
+
+// AdminAPI serves the administration api for the underlying key/value store
+type AdminAPI struct {
+	store  store.Store
+	logger log.Logger
+
+	mutex   sync.RWMutex
+	reports map[string]func() interface{}
+}
+
+// NewAdminAPI creates a AdminAPI with the correct dependencies
+func NewAdminAPI(store store.Store, logger log.Logger) *AdminAPI {
+	return &AdminAPI{
+		store:   store,
+		logger:  logger,
+		reports: make(map[string]func() interface{}),
+	}
+}
+
+// Report adds the statistics of another part of the service (e.g. one of the
+// servers) under the name, which are served as JSON from /stats/<name>.
+func (a *AdminAPI) Report(name string, stats func() interface{}) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	a.reports[name] = stats
+}
+
+func (a *AdminAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	level.Info(a.logger).Log("url", r.URL.String())
+
+	iw := &interceptingWriter{http.StatusOK, w}
+	w = iw
+
+	method, path := r.Method, r.URL.Path
+	switch {
+	case method == "POST" && path == APIPathSnapshot:
+		a.handleSnapshot(w, r)
+	case method == "GET" && path == APIPathStats:
+		a.handleStats(w, r)
+	case method == "GET" && strings.HasPrefix(path, APIPathStats+"/"):
+		a.handleReport(w, r, strings.TrimPrefix(path, APIPathStats+"/"))
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func (a *AdminAPI) handleSnapshot(w http.ResponseWriter, r *http.Request) {
+	// useful metrics
+	begin := time.Now()
+
+	defer r.Body.Close()
+
+	// Only durable stores can be snapshotted.
+	snapshotter, ok := a.store.(store.Snapshotter)
+	if !ok {
+		w.WriteHeader(http.StatusNotImplemented)
+		return
+	}
+
+	if err := snapshotter.Snapshot(); err != nil {
+		level.Error(a.logger).Log("err", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	var qr SnapshotQueryResult
+
+	// Finish
+	qr.Duration = time.Since(begin).String()
+	qr.EncodeTo(w)
+}
+
+func (a *AdminAPI) handleStats(w http.ResponseWriter, r *http.Request) {
+	// useful metrics
+	begin := time.Now()
+
+	defer r.Body.Close()
+
+	reporter, ok := a.store.(store.Reporter)
+	if !ok {
+		w.WriteHeader(http.StatusNotImplemented)
+		return
+	}
+
+	qr := StatsQueryResult{
+		Stats: reporter.Stats(),
+	}
+
+	// Finish
+	qr.Duration = time.Since(begin).String()
+	qr.EncodeTo(w)
+}
+
+func (a *AdminAPI) handleReport(w http.ResponseWriter, r *http.Request, name string) {
+	// useful metrics
+	begin := time.Now()
+
+	defer r.Body.Close()
+
+	a.mutex.RLock()
+	stats, ok := a.reports[name]
+	a.mutex.RUnlock()
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	qr := ReportQueryResult{
+		Stats: stats(),
+	}
+
+	// Finish
+	qr.Duration = time.Since(begin).String()
+	qr.EncodeTo(w)
+}
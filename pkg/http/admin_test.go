@@ -0,0 +1,149 @@
+package http
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/SimonRichardson/keyval/pkg/store"
+	"github.com/SimonRichardson/keyval/pkg/wal"
+	"github.com/go-kit/kit/log"
+)
+
+func TestAdminAPISnapshot(t *testing.T) {
+	t.Parallel()
+
+	t.Run("snapshot", func(t *testing.T) {
+		dir, err := ioutil.TempDir("", "admin")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(dir)
+
+		walLog, err := wal.Open(dir, wal.Options{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer walLog.Close()
+
+		durable, err := store.NewDurable(store.New(), walLog)
+		if err != nil {
+			t.Fatal(err)
+		}
+		durable.Set("a", []byte("b"))
+
+		api := NewAdminAPI(durable, log.NewNopLogger())
+		server := httptest.NewServer(api)
+		defer server.Close()
+
+		resp, err := http.Post(server.URL+"/snapshot", "", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+
+		if expected, actual := http.StatusOK, resp.StatusCode; expected != actual {
+			t.Errorf("expected: %v, actual: %v", expected, actual)
+		}
+	})
+
+	t.Run("snapshot with memory store", func(t *testing.T) {
+		api := NewAdminAPI(store.New(), log.NewNopLogger())
+		server := httptest.NewServer(api)
+		defer server.Close()
+
+		resp, err := http.Post(server.URL+"/snapshot", "", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+
+		if expected, actual := http.StatusNotImplemented, resp.StatusCode; expected != actual {
+			t.Errorf("expected: %v, actual: %v", expected, actual)
+		}
+	})
+}
+
+func TestAdminAPIStats(t *testing.T) {
+	t.Parallel()
+
+	s := store.NewBounded(store.Limits{MaxItems: 5})
+	for i := 0; i < 10; i++ {
+		s.Set(fmt.Sprintf("key-%d", i), []byte("value"))
+	}
+
+	api := NewAdminAPI(s, log.NewNopLogger())
+	server := httptest.NewServer(api)
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "/stats")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	if expected, actual := http.StatusOK, resp.StatusCode; expected != actual {
+		t.Errorf("expected: %v, actual: %v", expected, actual)
+	}
+
+	var result struct {
+		Items     uint64 `json:"items"`
+		Evictions uint64 `json:"evictions"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		t.Fatal(err)
+	}
+	if expected, actual := uint64(5), result.Items; expected != actual {
+		t.Errorf("expected: %v, actual: %v", expected, actual)
+	}
+	if expected, actual := uint64(5), result.Evictions; expected != actual {
+		t.Errorf("expected: %v, actual: %v", expected, actual)
+	}
+}
+
+func TestAdminAPIReport(t *testing.T) {
+	t.Parallel()
+
+	api := NewAdminAPI(store.New(), log.NewNopLogger())
+	api.Report("udp", func() interface{} {
+		return map[string]int{"shed": 3}
+	})
+	server := httptest.NewServer(api)
+	defer server.Close()
+
+	t.Run("report", func(t *testing.T) {
+		resp, err := http.Get(server.URL + "/stats/udp")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+
+		if expected, actual := http.StatusOK, resp.StatusCode; expected != actual {
+			t.Errorf("expected: %v, actual: %v", expected, actual)
+		}
+
+		var result map[string]int
+		if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+			t.Fatal(err)
+		}
+		if expected, actual := 3, result["shed"]; expected != actual {
+			t.Errorf("expected: %v, actual: %v", expected, actual)
+		}
+	})
+
+	t.Run("missing", func(t *testing.T) {
+		resp, err := http.Get(server.URL + "/stats/tcp")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+
+		if expected, actual := http.StatusNotFound, resp.StatusCode; expected != actual {
+			t.Errorf("expected: %v, actual: %v", expected, actual)
+		}
+	})
+}
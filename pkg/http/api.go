@@ -1,8 +1,14 @@
 package http
 
 import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
 	"io/ioutil"
+	"mime"
 	"net/http"
+	"strings"
 	"time"
 
 	"github.com/SimonRichardson/keyval/pkg/store"
@@ -15,19 +21,59 @@ const (
 	APIPathSelect = "/"
 	APIPathInsert = "/"
 	APIPathDelete = "/"
+	APIPathScan   = "/"
+	APIPathTxn    = "/_txn"
+	APIPathWatch  = "/_watch"
+	APIPathBatch  = "/_batch"
+
+	// APIPathKeys addresses a key by the rest of the path, rather than by the
+	// key query, e.g. /keys/a%2Fb is the key "a/b".
+	APIPathKeys = "/keys/"
 )
 
+const (
+	// DefaultMaxBatchSize is the default number of operations of a batch.
+	DefaultMaxBatchSize = 1000
+
+	// DefaultMaxBatchBytes is the default size of the body of a batch.
+	DefaultMaxBatchBytes = 4 << 20
+
+	// DefaultMaxValueSize is the default size of a value.
+	DefaultMaxValueSize = 32 << 20
+)
+
+// errValueTooLarge is returned when a value is larger than the maximum size of
+// a value.
+var errValueTooLarge = errors.New("value too large")
+
+// errBodyTooLarge is returned when the body of a batch or a transaction is
+// larger than the maximum size of a batch.
+var errBodyTooLarge = errors.New("body too large")
+
 // API serves the api for the underlying key/value store
 type API struct {
 	store  store.Store
 	logger log.Logger
+
+	// MaxBatchSize is the maximum number of operations of a batch.
+	MaxBatchSize int
+
+	// MaxBatchBytes is the maximum size of the body of a batch or a
+	// transaction in bytes.
+	MaxBatchBytes int64
+
+	// MaxValueSize is the maximum size of a value in bytes.
+	MaxValueSize int64
 }
 
 // NewAPI creates a API with the correct dependencies
 func NewAPI(store store.Store, logger log.Logger) *API {
 	return &API{
-		store:  store,
-		logger: logger,
+		store:         store,
+		logger:        logger,
+		MaxBatchSize:  DefaultMaxBatchSize,
+		MaxBatchBytes: DefaultMaxBatchBytes,
+		MaxValueSize:  DefaultMaxValueSize,
 	}
 }
 
@@ -39,12 +85,58 @@ func (a *API) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 
 	method, path := r.Method, r.URL.Path
 	switch {
-	case method == "GET" && path == APIPathSelect:
+	case strings.HasPrefix(path, APIPathKeys):
+		a.serveKey(w, r)
+	case path == APIPathSelect && hasKey(r):
+		a.serveKey(w, r)
+	case path == APIPathScan:
+		switch method {
+		case "GET", "HEAD":
+			a.handleScan(w, r)
+		case "PUT", "POST", "DELETE":
+			// Writes without a key are still routed, so that they fail
+			// validation rather than the method.
+			a.serveKey(w, r)
+		default:
+			methodNotAllowed(w, "DELETE", "GET", "HEAD", "POST", "PUT")
+		}
+	case path == APIPathTxn:
+		if method != "POST" {
+			methodNotAllowed(w, "POST")
+			return
+		}
+		a.handleTxn(w, r)
+	case path == APIPathBatch:
+		if method != "POST" {
+			methodNotAllowed(w, "POST")
+			return
+		}
+		a.handleBatch(w, r)
+	case path == APIPathWatch:
+		if method != "GET" {
+			methodNotAllowed(w, "GET")
+			return
+		}
+		a.handleWatch(w, r)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+// serveKey serves the queries of a single key, which is either addressed by the
+// path or the key query.
+func (a *API) serveKey(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "GET", "HEAD":
 		a.handleSelect(w, r)
-	case method == "PUT" && path == APIPathInsert:
+	case "PUT":
 		a.handleInsert(w, r)
-	case method == "DELETE" && path == APIPathDelete:
+	case "POST":
+		a.handleCreate(w, r)
+	case "DELETE":
 		a.handleDelete(w, r)
+	default:
+		methodNotAllowed(w, "DELETE", "GET", "HEAD", "POST", "PUT")
 	}
 }
 
@@ -61,14 +153,21 @@ func (a *API) handleSelect(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	value, ok := a.store.Get(qp.Key)
+	entry, ok := a.store.Select(qp.Key)
 	if !ok {
 		w.WriteHeader(http.StatusNotFound)
 		return
 	}
 
 	qr := SelectQueryResult{Params: qp}
-	qr.Value = value
+	qr.Value = entry.Value
+	qr.Version = entry.Version
+	qr.LastModified = entry.Modified
+	qr.Metadata = entry.Metadata
+	qr.NotModified = notModified(r, entry)
+	if matchIfRange(r, entry) {
+		qr.Range = r.Header.Get(httpHeaderRange)
+	}
 
 	// Finish
 	qr.Duration = time.Since(begin).String()
@@ -85,16 +184,122 @@ func (a *API) handleInsert(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusBadRequest)
 		return
 	}
+	if err := qp.DecodeMetadataFrom(r.Header); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
 
-	value, err := ioutil.ReadAll(r.Body)
+	value, err := a.readValue(r)
 	defer r.Body.Close()
-	if err != nil {
+	if err == errValueTooLarge || (err == nil && !store.Fits(a.store, qp.Key, value, qp.Metadata)) {
+		// A value that can never fit with in a bounded store is too large,
+		// the same as one that's over the maximum size of a value.
+		w.WriteHeader(http.StatusRequestEntityTooLarge)
+		return
+	} else if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	qr := InsertQueryResult{Params: qp}
+
+	// An If-Match insert can't be used along with If-None-Match.
+	match, noneMatch := r.Header.Get(httpHeaderIfMatch), r.Header.Get(httpHeaderIfNoneMatch)
+	if match != "" {
+		if noneMatch != "" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		version, ok := a.compareAndSwap(qp.Key, match, value, qp.TTL, qp.Metadata)
+		if err := store.Err(a.store); err != nil {
+			a.failed(w, err)
+			return
+		} else if !ok {
+			w.WriteHeader(http.StatusPreconditionFailed)
+			return
+		}
+		// Only an existing value can match, so it's always an update.
+		qr.Version = version
+	} else if noneMatch != "" {
+		version, created, ok := a.swapUnlessMatch(qp.Key, noneMatch, value, qp.TTL, qp.Metadata)
+		if err := store.Err(a.store); err != nil {
+			a.failed(w, err)
+			return
+		} else if !ok {
+			w.WriteHeader(http.StatusPreconditionFailed)
+			return
+		}
+		qr.Created = created
+		qr.Version = version
+	} else {
+		// The store reports whether the value replaced an existing value, so
+		// the value is only created if it didn't.
+		var replaced bool
+		if !qp.Metadata.IsZero() {
+			replaced = a.store.SetWithMetadata(qp.Key, value, qp.TTL, qp.Metadata)
+		} else if qp.TTL > 0 {
+			replaced = a.store.SetWithTTL(qp.Key, value, qp.TTL)
+		} else {
+			replaced = a.store.Set(qp.Key, value)
+		}
+		qr.Created = !replaced
+	}
+	if err := store.Err(a.store); err != nil {
+		a.failed(w, err)
+		return
+	}
+
+	// Finish
+	qr.Duration = time.Since(begin).String()
+	qr.EncodeTo(w)
+}
+
+func (a *API) handleCreate(w http.ResponseWriter, r *http.Request) {
+	// useful metrics
+	begin := time.Now()
+
+	// Validate user input.
+	var qp QueryParams
+	if err := qp.DecodeFrom(r.URL, queryRequired); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	if err := qp.DecodeMetadataFrom(r.Header); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	value, err := a.readValue(r)
+	defer r.Body.Close()
+	if err == errValueTooLarge || (err == nil && !store.Fits(a.store, qp.Key, value, qp.Metadata)) {
+		// A value that can never fit with in a bounded store is too large,
+		// the same as one that's over the maximum size of a value.
+		w.WriteHeader(http.StatusRequestEntityTooLarge)
+		return
+	} else if err != nil {
 		w.WriteHeader(http.StatusBadRequest)
 		return
 	}
 
+	// A create is a transaction on whether the key is missing, unlike a compare
+	// and swap it can be used with a ttl.
+	versions, ok := a.store.Txn([]store.Condition{
+		{Key: qp.Key, Check: store.CheckMissing},
+	}, []store.Mutation{
+		{Key: qp.Key, Operation: store.OperationSet, Value: value, TTL: qp.TTL, Metadata: qp.Metadata},
+	})
+	if err := store.Err(a.store); err != nil {
+		a.failed(w, err)
+		return
+	} else if !ok {
+		w.WriteHeader(http.StatusConflict)
+		return
+	}
+
 	qr := InsertQueryResult{Params: qp}
-	qr.Created = a.store.Set(qp.Key, value)
+	qr.Created = true
+	qr.Version = versions[0]
 
 	// Finish
 	qr.Duration = time.Since(begin).String()
@@ -114,10 +319,42 @@ func (a *API) handleDelete(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	ok := a.store.Delete(qp.Key)
-	if !ok {
-		w.WriteHeader(http.StatusNotFound)
-		return
+	match, noneMatch := r.Header.Get(httpHeaderIfMatch), r.Header.Get(httpHeaderIfNoneMatch)
+	if match != "" {
+		if noneMatch != "" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		ok := a.compareAndDelete(qp.Key, match)
+		if err := store.Err(a.store); err != nil {
+			a.failed(w, err)
+			return
+		} else if !ok {
+			w.WriteHeader(http.StatusPreconditionFailed)
+			return
+		}
+	} else if noneMatch != "" {
+		existed, ok := a.deleteUnlessMatch(qp.Key, noneMatch)
+		if err := store.Err(a.store); err != nil {
+			a.failed(w, err)
+			return
+		} else if !existed {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if !ok {
+			w.WriteHeader(http.StatusPreconditionFailed)
+			return
+		}
+	} else {
+		ok := a.store.Delete(qp.Key)
+		if err := store.Err(a.store); err != nil {
+			a.failed(w, err)
+			return
+		} else if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
 	}
 
 	qr := DeleteQueryResult{Params: qp}
@@ -127,6 +364,401 @@ func (a *API) handleDelete(w http.ResponseWriter, r *http.Request) {
 	qr.EncodeTo(w)
 }
 
+func (a *API) handleScan(w http.ResponseWriter, r *http.Request) {
+	// useful metrics
+	begin := time.Now()
+
+	defer r.Body.Close()
+
+	// Validate user input.
+	var qp ScanQueryParams
+	if err := qp.DecodeFrom(r.URL); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	entries, cursor := a.store.Scan(store.ScanOptions{
+		Prefix: qp.Prefix,
+		Start:  qp.Start,
+		End:    qp.End,
+		Limit:  qp.Limit,
+		Cursor: qp.Cursor,
+	})
+
+	qr := ScanQueryResult{Params: qp}
+	qr.Entries = entries
+	qr.Cursor = cursor
+
+	// Finish
+	qr.Duration = time.Since(begin).String()
+	qr.EncodeTo(w)
+}
+
+func (a *API) handleTxn(w http.ResponseWriter, r *http.Request) {
+	// useful metrics
+	begin := time.Now()
+
+	defer r.Body.Close()
+
+	// Validate user input.
+	body, err := a.readBody(r)
+	if err == errBodyTooLarge {
+		w.WriteHeader(http.StatusRequestEntityTooLarge)
+		return
+	} else if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	var qp TxnQueryParams
+	if err := qp.DecodeFrom(bytes.NewReader(body)); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	// Every value is held to the same limits as an insert, none of the
+	// mutations are applied if any of them are too large.
+	for _, m := range qp.Mutations {
+		if m.Operation == store.OperationSet &&
+			(int64(len(m.Value)) > a.MaxValueSize || !store.Fits(a.store, m.Key, m.Value, m.Metadata)) {
+			w.WriteHeader(http.StatusRequestEntityTooLarge)
+			return
+		}
+	}
+
+	qr := TxnQueryResult{Params: qp}
+	qr.Versions, qr.Applied = a.store.Txn(qp.Conditions, qp.Mutations)
+	if err := store.Err(a.store); err != nil {
+		a.failed(w, err)
+		return
+	}
+
+	// Finish
+	qr.Duration = time.Since(begin).String()
+	qr.EncodeTo(w)
+}
+
+func (a *API) handleBatch(w http.ResponseWriter, r *http.Request) {
+	// useful metrics
+	begin := time.Now()
+
+	defer r.Body.Close()
+
+	// Validate user input.
+	body, err := a.readBody(r)
+	if err == errBodyTooLarge {
+		w.WriteHeader(http.StatusRequestEntityTooLarge)
+		return
+	} else if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	var qp BatchQueryParams
+	if err := qp.DecodeFrom(bytes.NewReader(body), ndjson(r.Header), a.MaxBatchSize); err == errBatchTooLarge {
+		w.WriteHeader(http.StatusRequestEntityTooLarge)
+		return
+	} else if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	for k, op := range qp.Operations {
+		if op.Type == store.BatchSet && (int64(len(op.Value)) > a.MaxValueSize || !store.Fits(a.store, op.Key, op.Value, op.Metadata)) {
+			qp.Rejected[k] = http.StatusRequestEntityTooLarge
+		}
+	}
+
+	qr := BatchQueryResult{Params: qp}
+	qr.Results = store.Batch(a.store, qp.Valid())
+	if err := store.Err(a.store); err != nil {
+		a.failed(w, err)
+		return
+	}
+
+	// Finish
+	qr.Duration = time.Since(begin).String()
+	qr.EncodeTo(w)
+}
+
+// readBody reads the body of a batch or a transaction, reading one byte more
+// than allowed so that a body that is too large can be told apart from one
+// that fits exactly.
+func (a *API) readBody(r *http.Request) ([]byte, error) {
+	body, err := ioutil.ReadAll(io.LimitReader(r.Body, a.MaxBatchBytes+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(body)) > a.MaxBatchBytes {
+		return nil, errBodyTooLarge
+	}
+	return body, nil
+}
+
+// failed reports a write that the store couldn't apply, even though the write
+// itself is valid.
+func (a *API) failed(w http.ResponseWriter, err error) {
+	level.Error(a.logger).Log("err", err)
+	w.WriteHeader(http.StatusInternalServerError)
+}
+
+// ndjson returns true if the body is newline delimited JSON.
+func ndjson(h http.Header) bool {
+	mediaType, _, err := mime.ParseMediaType(h.Get(httpHeaderContentType))
+	return err == nil && (mediaType == "application/x-ndjson" || mediaType == "application/ndjson")
+}
+
+// watchHeartbeat is how often a comment is sent to a watch stream, so that
+// idle streams aren't closed by proxies and closed connections are noticed.
+const watchHeartbeat = 15 * time.Second
+
+func (a *API) handleWatch(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	// Validate user input.
+	var qp WatchQueryParams
+	if err := qp.DecodeFrom(r.URL); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	watcher, ok := a.store.(store.Watcher)
+	if !ok {
+		w.WriteHeader(http.StatusNotImplemented)
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	sub := watcher.Watch(store.WatchOptions{
+		Key:    qp.Key,
+		Prefix: qp.Prefix,
+	})
+	defer sub.Close()
+
+	// Send the headers straight away, so that the client knows that the
+	// subscription has been made.
+	w.Header().Set(httpHeaderContentType, "text/event-stream")
+	w.Header().Set(httpHeaderCacheControl, "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	heartbeat := time.NewTicker(watchHeartbeat)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case event, ok := <-sub.Events():
+			if !ok {
+				// The client was too slow, let it know that events have been
+				// dropped, so it can read the store again and resubscribe.
+				if sub.Err() == store.ErrOverflow {
+					fmt.Fprint(w, "event: overflow\ndata: {}\n\n")
+					flusher.Flush()
+				}
+				return
+			}
+			if err := encodeEvent(w, event); err != nil {
+				return
+			}
+			flusher.Flush()
+		case <-heartbeat.C:
+			if _, err := fmt.Fprint(w, ": heartbeat\n\n"); err != nil {
+				return
+			}
+			flusher.Flush()
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+// compareAndSwap stores the value if the current version matches any of the
+// entity tags with in the If-Match header.
+func (a *API) compareAndSwap(key, match string, value []byte, ttl time.Duration, metadata store.Metadata) (uint64, bool) {
+	versions, wildcard := parseETags(match, false)
+	if wildcard {
+		// Any existing version matches, so keep trying until either we win or
+		// the value no longer exists. If the version still matches, then the
+		// value can't fit with in a bounded store, so trying again won't help.
+		for {
+			entry, ok := a.store.Select(key)
+			if !ok {
+				return 0, false
+			}
+			version, ok := store.CompareAndSwapWithMetadata(a.store, key, entry.Version, value, ttl, metadata)
+			if ok {
+				return version, true
+			}
+			if version == entry.Version || store.Err(a.store) != nil {
+				return 0, false
+			}
+		}
+	}
+
+	for _, v := range versions {
+		if version, ok := store.CompareAndSwapWithMetadata(a.store, key, v, value, ttl, metadata); ok {
+			return version, true
+		}
+	}
+	return 0, false
+}
+
+// compareAndDelete removes the value if the current version matches any of the
+// entity tags with in the If-Match header.
+func (a *API) compareAndDelete(key, match string) bool {
+	versions, wildcard := parseETags(match, false)
+	if wildcard {
+		for {
+			entry, ok := a.store.Select(key)
+			if !ok {
+				return false
+			}
+			if a.store.CompareAndDelete(key, entry.Version) {
+				return true
+			}
+			if store.Err(a.store) != nil {
+				return false
+			}
+		}
+	}
+
+	for _, v := range versions {
+		if a.store.CompareAndDelete(key, v) {
+			return true
+		}
+	}
+	return false
+}
+
+// swapUnlessMatch stores the value unless the current version matches any of
+// the entity tags with in the If-None-Match header, e.g. "*" only stores the
+// value if it doesn't already exist. Returns true if the value didn't exist
+// before it was stored.
+func (a *API) swapUnlessMatch(key, noneMatch string, value []byte, ttl time.Duration, metadata store.Metadata) (version uint64, created, ok bool) {
+	versions, wildcard := parseETags(noneMatch, false)
+	for {
+		// A missing value has a version of zero, which never matches.
+		entry, _ := a.store.Select(key)
+		if matchETags(entry.Version, versions, wildcard) {
+			return 0, false, false
+		}
+
+		// Only store the value if the version hasn't changed since, otherwise
+		// check against the new version. If the version hasn't changed, then
+		// the value can't fit with in a bounded store.
+		result, ok := a.store.Txn([]store.Condition{
+			{Key: key, Check: store.CheckVersion, Version: entry.Version},
+		}, []store.Mutation{
+			{Key: key, Operation: store.OperationSet, Value: value, TTL: ttl, Metadata: metadata},
+		})
+		if ok {
+			return result[0], entry.Version == 0, true
+		}
+		if result[0] == entry.Version || store.Err(a.store) != nil {
+			return 0, false, false
+		}
+	}
+}
+
+// deleteUnlessMatch removes the value unless the current version matches any
+// of the entity tags with in the If-None-Match header. Returns false if the
+// value didn't exist in the first place.
+func (a *API) deleteUnlessMatch(key, noneMatch string) (existed, ok bool) {
+	versions, wildcard := parseETags(noneMatch, false)
+	for {
+		entry, found := a.store.Select(key)
+		if !found {
+			return false, false
+		}
+		if matchETags(entry.Version, versions, wildcard) {
+			return true, false
+		}
+		if a.store.CompareAndDelete(key, entry.Version) {
+			return true, true
+		}
+		if store.Err(a.store) != nil {
+			return true, false
+		}
+	}
+}
+
+// readValue reads the value from the body of a write. The query and headers of
+// the write are validated before the body is read, so that a client waiting on
+// "Expect: 100-continue" never sends the body of a write that is going to be
+// rejected. A body that declares a length that is too large is rejected
+// without reading any of it. The declared length is only used to reject the
+// body early, the body is always read as it arrives, stopping as soon as it's
+// too large, so a client can't make the value be allocated up front.
+func (a *API) readValue(r *http.Request) ([]byte, error) {
+	if r.ContentLength > a.MaxValueSize {
+		return nil, errValueTooLarge
+	}
+
+	// Read one byte more than allowed to tell a value that is too large apart
+	// from one that fits.
+	value, err := ioutil.ReadAll(io.LimitReader(r.Body, a.MaxValueSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(value)) > a.MaxValueSize {
+		return nil, errValueTooLarge
+	}
+	return value, nil
+}
+
+// matchIfRange returns true if a range can be sent for the entry, which is when
+// there isn't an If-Range header, or if the header matches either the strong
+// entity tag of the entry or exactly when the entry was last modified.
+// Otherwise the entry has changed since the client fetched the first part of
+// it, so the whole entry is sent instead.
+func matchIfRange(r *http.Request, entry store.Entry) bool {
+	ifRange := r.Header.Get(httpHeaderIfRange)
+	if ifRange == "" {
+		return true
+	}
+	if strings.HasPrefix(ifRange, `"`) || strings.HasPrefix(ifRange, "W/") {
+		versions, _ := parseETags(ifRange, false)
+		return len(versions) == 1 && matchETags(entry.Version, versions, false)
+	}
+	// The header only has a precision of seconds.
+	t, err := http.ParseTime(ifRange)
+	return err == nil && !entry.Modified.IsZero() && entry.Modified.Truncate(time.Second).Equal(t)
+}
+
+// notModified returns true if the entry matches any of the entity tags with in
+// the If-None-Match header, or if there isn't one, then whether the entry
+// hasn't been modified since the If-Modified-Since header.
+func notModified(r *http.Request, entry store.Entry) bool {
+	if noneMatch := r.Header.Get(httpHeaderIfNoneMatch); noneMatch != "" {
+		versions, wildcard := parseETags(noneMatch, true)
+		return matchETags(entry.Version, versions, wildcard)
+	}
+	if since := r.Header.Get(httpHeaderIfModifiedSince); since != "" && !entry.Modified.IsZero() {
+		// The header only has a precision of seconds.
+		t, err := http.ParseTime(since)
+		return err == nil && !entry.Modified.Truncate(time.Second).After(t)
+	}
+	return false
+}
+
+// methodNotAllowed replies that the method isn't allowed for the path, along
+// with the methods that are.
+func methodNotAllowed(w http.ResponseWriter, methods ...string) {
+	w.Header().Set(httpHeaderAllow, strings.Join(methods, ", "))
+	w.WriteHeader(http.StatusMethodNotAllowed)
+}
+
+// hasKey reports if the request has a key query, even an empty one, so that an
+// empty key fails validation rather than being treated as a scan.
+func hasKey(r *http.Request) bool {
+	_, ok := r.URL.Query()["key"]
+	return ok
+}
+
 type interceptingWriter struct {
 	code int
 	http.ResponseWriter
@@ -136,3 +768,9 @@ func (iw *interceptingWriter) WriteHeader(code int) {
 	iw.code = code
 	iw.ResponseWriter.WriteHeader(code)
 }
+
+func (iw *interceptingWriter) Flush() {
+	if flusher, ok := iw.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
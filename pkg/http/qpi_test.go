@@ -1,16 +1,25 @@
 package http
 
 import (
+	"bufio"
 	"bytes"
 	"encoding/base64"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
 	"reflect"
+	"strings"
 	"testing"
+	"testing/iotest"
 	"testing/quick"
+	"time"
 
+	keyvalStore "github.com/SimonRichardson/keyval/pkg/store"
 	"github.com/SimonRichardson/keyval/pkg/store/mocks"
 	"github.com/go-kit/kit/log"
 	"github.com/golang/mock/gomock"
@@ -33,7 +42,7 @@ func TestAPISelect(t *testing.T) {
 
 			path, key := buildPath(server.URL, a)
 
-			store.EXPECT().Get(key).Return(nil, false)
+			store.EXPECT().Select(key).Return(keyvalStore.Entry{}, false)
 
 			resp, err := http.Get(path)
 			if err != nil {
@@ -49,6 +58,27 @@ func TestAPISelect(t *testing.T) {
 		}
 	})
 
+	t.Run("select with an empty key", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+
+		store := mocks.NewMockStore(ctrl)
+
+		api := NewAPI(store, log.NewNopLogger())
+		server := httptest.NewServer(api)
+		defer server.Close()
+
+		resp, err := http.Get(fmt.Sprintf("%s/?key=", server.URL))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+
+		if expected, actual := http.StatusBadRequest, resp.StatusCode; expected != actual {
+			t.Errorf("expected: %v, actual: %v", expected, actual)
+		}
+	})
+
 	t.Run("select", func(t *testing.T) {
 		fn := func(a, b []byte) bool {
 
@@ -63,7 +93,7 @@ func TestAPISelect(t *testing.T) {
 
 			path, key := buildPath(server.URL, a)
 
-			store.EXPECT().Get(key).Return(b, true)
+			store.EXPECT().Select(key).Return(keyvalStore.Entry{Key: key, Value: b, Version: 1}, true)
 
 			resp, err := http.Get(path)
 			if err != nil {
@@ -75,6 +105,7 @@ func TestAPISelect(t *testing.T) {
 			result, err := ioutil.ReadAll(resp.Body)
 
 			return resp.StatusCode == http.StatusOK &&
+				resp.Header.Get("ETag") == `"1"` &&
 				reflect.DeepEqual(b, result)
 		}
 		if err := quick.Check(fn, nil); err != nil {
@@ -109,7 +140,7 @@ func TestAPIInsert(t *testing.T) {
 			}
 			defer resp.Body.Close()
 
-			return resp.StatusCode == http.StatusOK
+			return resp.StatusCode == http.StatusCreated
 		}
 		if err := quick.Check(fn, nil); err != nil {
 			t.Error(err)
@@ -139,7 +170,138 @@ func TestAPIInsert(t *testing.T) {
 			}
 			defer resp.Body.Close()
 
-			return resp.StatusCode == http.StatusCreated
+			return resp.StatusCode == http.StatusOK
+		}
+		if err := quick.Check(fn, nil); err != nil {
+			t.Error(err)
+		}
+	})
+
+	t.Run("insert with ttl", func(t *testing.T) {
+		fn := func(a, b []byte) bool {
+
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+
+			store := mocks.NewMockStore(ctrl)
+
+			api := NewAPI(store, log.NewNopLogger())
+			server := httptest.NewServer(api)
+			defer server.Close()
+
+			path, key := buildPath(server.URL, a)
+
+			store.EXPECT().SetWithTTL(key, b, time.Second*10).Return(true)
+
+			resp, err := Put(path+"&ttl=10s", b)
+			if err != nil {
+				t.Error(err)
+				return false
+			}
+			defer resp.Body.Close()
+
+			return resp.StatusCode == http.StatusOK
+		}
+		if err := quick.Check(fn, nil); err != nil {
+			t.Error(err)
+		}
+	})
+
+	t.Run("insert with matching version", func(t *testing.T) {
+		fn := func(a, b []byte) bool {
+
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+
+			store := mocks.NewMockStore(ctrl)
+
+			api := NewAPI(store, log.NewNopLogger())
+			server := httptest.NewServer(api)
+			defer server.Close()
+
+			path, key := buildPath(server.URL, a)
+
+			store.EXPECT().Txn([]keyvalStore.Condition{
+				{Key: key, Check: keyvalStore.CheckVersion, Version: 1},
+			}, []keyvalStore.Mutation{
+				{Key: key, Value: b, TTL: time.Minute},
+			}).Return([]uint64{2}, true)
+
+			resp, err := Do("PUT", path+"&ttl=1m", b, map[string]string{
+				"If-Match": `"1"`,
+			})
+			if err != nil {
+				t.Error(err)
+				return false
+			}
+			defer resp.Body.Close()
+
+			return resp.StatusCode == http.StatusOK &&
+				resp.Header.Get("ETag") == `"2"`
+		}
+		if err := quick.Check(fn, nil); err != nil {
+			t.Error(err)
+		}
+	})
+
+	t.Run("insert with stale version", func(t *testing.T) {
+		fn := func(a, b []byte) bool {
+
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+
+			store := mocks.NewMockStore(ctrl)
+
+			api := NewAPI(store, log.NewNopLogger())
+			server := httptest.NewServer(api)
+			defer server.Close()
+
+			path, key := buildPath(server.URL, a)
+
+			store.EXPECT().Txn([]keyvalStore.Condition{
+				{Key: key, Check: keyvalStore.CheckVersion, Version: 1},
+			}, []keyvalStore.Mutation{
+				{Key: key, Value: b},
+			}).Return([]uint64{2}, false)
+
+			resp, err := Do("PUT", path, b, map[string]string{
+				"If-Match": `"1"`,
+			})
+			if err != nil {
+				t.Error(err)
+				return false
+			}
+			defer resp.Body.Close()
+
+			return resp.StatusCode == http.StatusPreconditionFailed
+		}
+		if err := quick.Check(fn, nil); err != nil {
+			t.Error(err)
+		}
+	})
+
+	t.Run("insert with invalid ttl", func(t *testing.T) {
+		fn := func(a, b []byte) bool {
+
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+
+			store := mocks.NewMockStore(ctrl)
+
+			api := NewAPI(store, log.NewNopLogger())
+			server := httptest.NewServer(api)
+			defer server.Close()
+
+			path, _ := buildPath(server.URL, a)
+
+			resp, err := Put(path+"&ttl=-10s", b)
+			if err != nil {
+				t.Error(err)
+				return false
+			}
+			defer resp.Body.Close()
+
+			return resp.StatusCode == http.StatusBadRequest
 		}
 		if err := quick.Check(fn, nil); err != nil {
 			t.Error(err)
@@ -209,28 +371,1451 @@ func TestAPIDelete(t *testing.T) {
 			t.Error(err)
 		}
 	})
+
+	t.Run("delete with stale version", func(t *testing.T) {
+		fn := func(a []byte) bool {
+
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+
+			store := mocks.NewMockStore(ctrl)
+
+			api := NewAPI(store, log.NewNopLogger())
+			server := httptest.NewServer(api)
+			defer server.Close()
+
+			path, key := buildPath(server.URL, a)
+
+			store.EXPECT().CompareAndDelete(key, uint64(1)).Return(false)
+
+			resp, err := Do("DELETE", path, nil, map[string]string{
+				"If-Match": `"1"`,
+			})
+			if err != nil {
+				t.Error(err)
+				return false
+			}
+			defer resp.Body.Close()
+
+			return resp.StatusCode == http.StatusPreconditionFailed
+		}
+		if err := quick.Check(fn, nil); err != nil {
+			t.Error(err)
+		}
+	})
 }
 
-func buildPath(serverURL string, a []byte) (string, string) {
-	v := base64.RawURLEncoding.EncodeToString(a)
-	if v == "" {
-		v = "empty"
-	}
-	return fmt.Sprintf("%s/?key=%s", serverURL, v), v
+func TestAPIScan(t *testing.T) {
+	t.Parallel()
+
+	t.Run("scan", func(t *testing.T) {
+		fn := func(a, b []byte) bool {
+
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+
+			store := mocks.NewMockStore(ctrl)
+
+			api := NewAPI(store, log.NewNopLogger())
+			server := httptest.NewServer(api)
+			defer server.Close()
+
+			_, key := buildPath(server.URL, a)
+
+			store.EXPECT().Scan(keyvalStore.ScanOptions{
+				Prefix: key,
+				Limit:  10,
+				Cursor: "abc",
+			}).Return([]keyvalStore.Entry{
+				{Key: key, Value: b, Version: 1},
+			}, "def")
+
+			resp, err := http.Get(fmt.Sprintf("%s/?prefix=%s&limit=10&cursor=%s",
+				server.URL, key, base64.RawURLEncoding.EncodeToString([]byte("abc")),
+			))
+			if err != nil {
+				t.Error(err)
+				return false
+			}
+			defer resp.Body.Close()
+
+			var result struct {
+				Entries []struct {
+					Key     string `json:"key"`
+					Value   []byte `json:"value"`
+					Version uint64 `json:"version"`
+				} `json:"entries"`
+				Cursor string `json:"cursor"`
+			}
+			if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+				t.Error(err)
+				return false
+			}
+
+			return resp.StatusCode == http.StatusOK &&
+				len(result.Entries) == 1 &&
+				result.Entries[0].Key == key &&
+				bytes.Equal(result.Entries[0].Value, b) &&
+				result.Cursor == base64.RawURLEncoding.EncodeToString([]byte("def"))
+		}
+		if err := quick.Check(fn, nil); err != nil {
+			t.Error(err)
+		}
+	})
+
+	t.Run("scan with invalid limit", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+
+		store := mocks.NewMockStore(ctrl)
+
+		api := NewAPI(store, log.NewNopLogger())
+		server := httptest.NewServer(api)
+		defer server.Close()
+
+		resp, err := http.Get(fmt.Sprintf("%s/?limit=-1", server.URL))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+
+		if expected, actual := http.StatusBadRequest, resp.StatusCode; expected != actual {
+			t.Errorf("expected: %v, actual: %v", expected, actual)
+		}
+	})
 }
 
-func Put(url string, body []byte) (resp *http.Response, err error) {
-	req, err := http.NewRequest("PUT", url, bytes.NewReader(body))
-	if err != nil {
-		return nil, err
-	}
-	return http.DefaultClient.Do(req)
+func TestAPITxn(t *testing.T) {
+	t.Parallel()
+
+	t.Run("txn", func(t *testing.T) {
+		fn := func(a, b []byte) bool {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+
+			store := mocks.NewMockStore(ctrl)
+
+			api := NewAPI(store, log.NewNopLogger())
+			server := httptest.NewServer(api)
+			defer server.Close()
+
+			_, key := buildPath(server.URL, a)
+			if b == nil {
+				b = []byte{}
+			}
+
+			store.EXPECT().Txn([]keyvalStore.Condition{
+				{Key: key, Check: keyvalStore.CheckVersion, Version: 2},
+				{Key: key + "-other", Check: keyvalStore.CheckMissing},
+			}, []keyvalStore.Mutation{
+				{Key: key, Operation: keyvalStore.OperationSet, Value: b, TTL: time.Minute},
+				{Key: key + "-other", Operation: keyvalStore.OperationDelete},
+			}).Return([]uint64{3, 0}, true)
+
+			body, err := json.Marshal(map[string]interface{}{
+				"conditions": []map[string]interface{}{
+					{"key": key, "check": "version", "version": 2},
+					{"key": key + "-other", "check": "missing"},
+				},
+				"mutations": []map[string]interface{}{
+					{"op": "set", "key": key, "value": b, "ttl": "1m"},
+					{"op": "delete", "key": key + "-other"},
+				},
+			})
+			if err != nil {
+				t.Error(err)
+				return false
+			}
+
+			resp, err := http.Post(server.URL+"/_txn", "application/json", bytes.NewReader(body))
+			if err != nil {
+				t.Error(err)
+				return false
+			}
+			defer resp.Body.Close()
+
+			var result struct {
+				Applied  bool     `json:"applied"`
+				Versions []uint64 `json:"versions"`
+			}
+			if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+				t.Error(err)
+				return false
+			}
+
+			return resp.StatusCode == http.StatusOK &&
+				result.Applied &&
+				reflect.DeepEqual(result.Versions, []uint64{3, 0})
+		}
+		if err := quick.Check(fn, nil); err != nil {
+			t.Error(err)
+		}
+	})
+
+	t.Run("txn with failing condition", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+
+		store := mocks.NewMockStore(ctrl)
+
+		api := NewAPI(store, log.NewNopLogger())
+		server := httptest.NewServer(api)
+		defer server.Close()
+
+		store.EXPECT().Txn([]keyvalStore.Condition{
+			{Key: "a", Check: keyvalStore.CheckExists},
+		}, []keyvalStore.Mutation{}).Return([]uint64{0}, false)
+
+		resp, err := http.Post(server.URL+"/_txn", "application/json", strings.NewReader(
+			`{"conditions":[{"key":"a","check":"exists"}]}`,
+		))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+
+		if expected, actual := http.StatusConflict, resp.StatusCode; expected != actual {
+			t.Errorf("expected: %v, actual: %v", expected, actual)
+		}
+	})
+
+	t.Run("txn with invalid body", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+
+		store := mocks.NewMockStore(ctrl)
+
+		api := NewAPI(store, log.NewNopLogger())
+		server := httptest.NewServer(api)
+		defer server.Close()
+
+		for _, body := range []string{
+			``,
+			`{"conditions":[{"key":"","check":"exists"}]}`,
+			`{"conditions":[{"key":"a","check":"maybe"}]}`,
+			`{"mutations":[{"op":"upsert","key":"a"}]}`,
+			`{"mutations":[{"op":"set","key":"a","ttl":"-1s"}]}`,
+		} {
+			resp, err := http.Post(server.URL+"/_txn", "application/json", strings.NewReader(body))
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+
+			if expected, actual := http.StatusBadRequest, resp.StatusCode; expected != actual {
+				t.Errorf("%s expected: %v, actual: %v", body, expected, actual)
+			}
+		}
+	})
 }
 
-func Delete(url string) (resp *http.Response, err error) {
-	req, err := http.NewRequest("DELETE", url, nil)
-	if err != nil {
-		return nil, err
+func TestAPIBatch(t *testing.T) {
+	t.Parallel()
+
+	type result struct {
+		Status      int               `json:"status"`
+		Key         string            `json:"key"`
+		Value       []byte            `json:"value"`
+		Version     uint64            `json:"version"`
+		ContentType string            `json:"content_type"`
+		Metadata    map[string]string `json:"metadata"`
+	}
+
+	t.Run("batch", func(t *testing.T) {
+		s := keyvalStore.New()
+		s.Set("b", []byte("b"))
+
+		api := NewAPI(s, log.NewNopLogger())
+		server := httptest.NewServer(api)
+		defer server.Close()
+
+		resp, err := http.Post(server.URL+"/_batch", "application/json", strings.NewReader(`[
+			{"op": "set", "key": "a", "value": "YQ==", "content_type": "text/plain", "metadata": {"owner": "a"}},
+			{"op": "get", "key": "a"},
+			{"op": "get", "key": "c"},
+			{"op": "set", "key": "b", "value": "Yw==", "ttl": "1m"},
+			{"op": "delete", "key": "b"},
+			{"op": "delete", "key": "b"},
+			{"op": "upsert", "key": "d"},
+			{"op": "set", "key": "e", "ttl": "-1s"}
+		]`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+
+		if expected, actual := http.StatusOK, resp.StatusCode; expected != actual {
+			t.Errorf("expected: %v, actual: %v", expected, actual)
+		}
+
+		var results []result
+		if err := json.NewDecoder(resp.Body).Decode(&results); err != nil {
+			t.Fatal(err)
+		}
+		if expected, actual := 8, len(results); expected != actual {
+			t.Fatalf("expected: %v, actual: %v", expected, actual)
+		}
+
+		// Each bucket has its own versions, so only check that the replaced
+		// value has a newer version.
+		if results[3].Version < 2 {
+			t.Errorf("expected: %v, actual: %v", ">= 2", results[3].Version)
+		}
+		version := mustSelect(t, s, "a").Version
+		if expected, actual := []result{
+			{Status: http.StatusCreated, Key: "a", Version: version},
+			{Status: http.StatusOK, Key: "a", Value: []byte("a"), Version: version, ContentType: "text/plain", Metadata: map[string]string{"owner": "a"}},
+			{Status: http.StatusNotFound, Key: "c"},
+			{Status: http.StatusOK, Key: "b", Version: results[3].Version},
+			{Status: http.StatusOK, Key: "b"},
+			{Status: http.StatusNotFound, Key: "b"},
+			{Status: http.StatusBadRequest, Key: "d"},
+			{Status: http.StatusBadRequest, Key: "e"},
+		}, results; !reflect.DeepEqual(expected, actual) {
+			t.Errorf("expected: %v, actual: %v", expected, actual)
+		}
+		if _, ok := s.Get("e"); ok {
+			t.Errorf("expected: %v, actual: %v", false, ok)
+		}
+	})
+
+	t.Run("batch with ndjson", func(t *testing.T) {
+		s := keyvalStore.New()
+
+		api := NewAPI(s, log.NewNopLogger())
+		server := httptest.NewServer(api)
+		defer server.Close()
+
+		resp, err := http.Post(server.URL+"/_batch", "application/x-ndjson", strings.NewReader(
+			`{"op": "set", "key": "a", "value": "YQ=="}`+"\n"+
+				`{"op": "get", "key": "a"}`+"\n"+
+				`{"op": "delete", "key": "z"}`+"\n",
+		))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+
+		if expected, actual := "application/x-ndjson", resp.Header.Get("Content-Type"); expected != actual {
+			t.Errorf("expected: %v, actual: %v", expected, actual)
+		}
+
+		var (
+			results []result
+			decoder = json.NewDecoder(resp.Body)
+		)
+		for decoder.More() {
+			var r result
+			if err := decoder.Decode(&r); err != nil {
+				t.Fatal(err)
+			}
+			results = append(results, r)
+		}
+		if expected, actual := []result{
+			{Status: http.StatusCreated, Key: "a", Version: 1},
+			{Status: http.StatusOK, Key: "a", Value: []byte("a"), Version: 1},
+			{Status: http.StatusNotFound, Key: "z"},
+		}, results; !reflect.DeepEqual(expected, actual) {
+			t.Errorf("expected: %v, actual: %v", expected, actual)
+		}
+	})
+
+	t.Run("batch without a batcher", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+
+		store := mocks.NewMockStore(ctrl)
+
+		api := NewAPI(store, log.NewNopLogger())
+		server := httptest.NewServer(api)
+		defer server.Close()
+
+		gomock.InOrder(
+			store.EXPECT().SetWithMetadata("a", []byte("a"), time.Minute, keyvalStore.Metadata{}).Return(false),
+			store.EXPECT().Select("b").Return(keyvalStore.Entry{Key: "b", Value: []byte("b"), Version: 3}, true),
+			store.EXPECT().Delete("c").Return(true),
+		)
+
+		resp, err := http.Post(server.URL+"/_batch", "application/json", strings.NewReader(`[
+			{"op": "set", "key": "a", "value": "YQ==", "ttl": "1m"},
+			{"op": "get", "key": "b"},
+			{"op": "delete", "key": "c"}
+		]`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+
+		var results []result
+		if err := json.NewDecoder(resp.Body).Decode(&results); err != nil {
+			t.Fatal(err)
+		}
+		if expected, actual := []result{
+			{Status: http.StatusCreated, Key: "a"},
+			{Status: http.StatusOK, Key: "b", Value: []byte("b"), Version: 3},
+			{Status: http.StatusOK, Key: "c"},
+		}, results; !reflect.DeepEqual(expected, actual) {
+			t.Errorf("expected: %v, actual: %v", expected, actual)
+		}
+	})
+
+	t.Run("batch with invalid body", func(t *testing.T) {
+		s := keyvalStore.New()
+
+		api := NewAPI(s, log.NewNopLogger())
+		api.MaxBatchSize = 2
+		api.MaxBatchBytes = 128
+		server := httptest.NewServer(api)
+		defer server.Close()
+
+		for _, test := range []struct {
+			contentType, body string
+			status            int
+		}{
+			{"application/json", ``, http.StatusBadRequest},
+			{"application/json", `{"op": "get", "key": "a"}`, http.StatusBadRequest},
+			{"application/x-ndjson", `{"op": "get", "key": "a"}` + "\n[", http.StatusBadRequest},
+			{"application/json", `[{"op": "get", "key": "a"}, {"op": "get", "key": "b"}, {"op": "get", "key": "c"}]`, http.StatusRequestEntityTooLarge},
+			{"application/x-ndjson", strings.Repeat(`{"op": "get", "key": "a"}`+"\n", 3), http.StatusRequestEntityTooLarge},
+			{"application/json", `[{"op": "set", "key": "a", "value": "` + strings.Repeat("YQ==", 32) + `"}]`, http.StatusRequestEntityTooLarge},
+		} {
+			resp, err := http.Post(server.URL+"/_batch", test.contentType, strings.NewReader(test.body))
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+
+			if expected, actual := test.status, resp.StatusCode; expected != actual {
+				t.Errorf("%s expected: %v, actual: %v", test.body, expected, actual)
+			}
+		}
+		if _, ok := s.Get("a"); ok {
+			t.Errorf("expected: %v, actual: %v", false, ok)
+		}
+	})
+
+	t.Run("batch with get", func(t *testing.T) {
+		api := NewAPI(keyvalStore.New(), log.NewNopLogger())
+		server := httptest.NewServer(api)
+		defer server.Close()
+
+		resp, err := http.Get(server.URL + "/_batch")
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+
+		if expected, actual := http.StatusMethodNotAllowed, resp.StatusCode; expected != actual {
+			t.Errorf("expected: %v, actual: %v", expected, actual)
+		}
+		if expected, actual := "POST", resp.Header.Get("Allow"); expected != actual {
+			t.Errorf("expected: %v, actual: %v", expected, actual)
+		}
+	})
+}
+
+func TestAPIWatch(t *testing.T) {
+	t.Parallel()
+
+	t.Run("watch", func(t *testing.T) {
+		s := keyvalStore.New()
+
+		api := NewAPI(s, log.NewNopLogger())
+		server := httptest.NewServer(api)
+		defer server.Close()
+
+		resp, err := http.Get(server.URL + "/_watch?prefix=config/")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+
+		if expected, actual := "text/event-stream", resp.Header.Get("Content-Type"); expected != actual {
+			t.Errorf("expected: %v, actual: %v", expected, actual)
+		}
+
+		s.Set("other", []byte("x"))
+		s.Set("config/a", []byte("a"))
+		s.Delete("config/a")
+
+		reader := bufio.NewReader(resp.Body)
+		readEvent := func() string {
+			var lines []string
+			for {
+				line, err := reader.ReadString('\n')
+				if err != nil {
+					t.Fatal(err)
+				}
+				if line == "\n" {
+					return strings.Join(lines, "")
+				}
+				lines = append(lines, line)
+			}
+		}
+
+		if expected, actual := "id: 2\nevent: put\ndata: {\"key\":\"config/a\",\"value\":\"YQ==\",\"version\":2}\n", readEvent(); expected != actual {
+			t.Errorf("expected: %q, actual: %q", expected, actual)
+		}
+		if expected, actual := "id: 2\nevent: delete\ndata: {\"key\":\"config/a\",\"version\":2}\n", readEvent(); expected != actual {
+			t.Errorf("expected: %q, actual: %q", expected, actual)
+		}
+	})
+
+	t.Run("watch with key and prefix", func(t *testing.T) {
+		api := NewAPI(keyvalStore.New(), log.NewNopLogger())
+		server := httptest.NewServer(api)
+		defer server.Close()
+
+		resp, err := http.Get(server.URL + "/_watch?key=a&prefix=b")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+
+		if expected, actual := http.StatusBadRequest, resp.StatusCode; expected != actual {
+			t.Errorf("expected: %v, actual: %v", expected, actual)
+		}
+	})
+
+	t.Run("watch without watcher", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+
+		store := mocks.NewMockStore(ctrl)
+
+		api := NewAPI(store, log.NewNopLogger())
+		server := httptest.NewServer(api)
+		defer server.Close()
+
+		resp, err := http.Get(server.URL + "/_watch")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+
+		if expected, actual := http.StatusNotImplemented, resp.StatusCode; expected != actual {
+			t.Errorf("expected: %v, actual: %v", expected, actual)
+		}
+	})
+}
+
+func TestAPIKeys(t *testing.T) {
+	t.Parallel()
+
+	t.Run("select by path", func(t *testing.T) {
+		fn := func(key string, b []byte) bool {
+			if key == "" {
+				key = "empty"
+			}
+
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+
+			store := mocks.NewMockStore(ctrl)
+
+			api := NewAPI(store, log.NewNopLogger())
+			server := httptest.NewServer(api)
+			defer server.Close()
+
+			store.EXPECT().Select(key).Return(keyvalStore.Entry{Key: key, Value: b, Version: 1}, true)
+
+			resp, err := http.Get(server.URL + "/keys/" + url.PathEscape(key))
+			if err != nil {
+				t.Error(err)
+				return false
+			}
+			defer resp.Body.Close()
+
+			result, err := ioutil.ReadAll(resp.Body)
+			if err != nil {
+				t.Error(err)
+				return false
+			}
+
+			return resp.StatusCode == http.StatusOK &&
+				resp.Header.Get("X-Key") == url.PathEscape(key) &&
+				bytes.Equal(result, b)
+		}
+		if err := quick.Check(fn, nil); err != nil {
+			t.Error(err)
+		}
+	})
+
+	t.Run("head", func(t *testing.T) {
+		s := keyvalStore.New()
+		s.Set("a/b", []byte("abc"))
+
+		api := NewAPI(s, log.NewNopLogger())
+		server := httptest.NewServer(api)
+		defer server.Close()
+
+		for _, testcase := range []struct {
+			path     string
+			expected int
+			key      string
+		}{
+			{"/keys/a%2Fb", http.StatusOK, "a%2Fb"},
+			{"/keys/a/b", http.StatusOK, "a%2Fb"},
+			{"/?key=a/b", http.StatusOK, "a/b"},
+			{"/keys/missing", http.StatusNotFound, ""},
+		} {
+			resp, err := http.Head(server.URL + testcase.path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+
+			if expected, actual := testcase.expected, resp.StatusCode; expected != actual {
+				t.Errorf("%s expected: %v, actual: %v", testcase.path, expected, actual)
+			}
+			if expected, actual := testcase.key, resp.Header.Get("X-Key"); expected != actual {
+				t.Errorf("%s expected: %v, actual: %v", testcase.path, expected, actual)
+			}
+			if resp.StatusCode != http.StatusOK {
+				continue
+			}
+			if expected, actual := int64(3), resp.ContentLength; expected != actual {
+				t.Errorf("expected: %v, actual: %v", expected, actual)
+			}
+			if expected, actual := `"1"`, resp.Header.Get("ETag"); expected != actual {
+				t.Errorf("expected: %v, actual: %v", expected, actual)
+			}
+		}
+	})
+
+	t.Run("key header with control characters", func(t *testing.T) {
+		s := keyvalStore.New()
+		s.Set("a\x01b", []byte("abc"))
+
+		api := NewAPI(s, log.NewNopLogger())
+		server := httptest.NewServer(api)
+		defer server.Close()
+
+		resp, err := http.Head(server.URL + "/?key=a%01b")
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+
+		if expected, actual := "a%01b", resp.Header.Get("X-Key"); expected != actual {
+			t.Errorf("expected: %v, actual: %v", expected, actual)
+		}
+	})
+
+	t.Run("create", func(t *testing.T) {
+		fn := func(a, b []byte) bool {
+
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+
+			store := mocks.NewMockStore(ctrl)
+
+			api := NewAPI(store, log.NewNopLogger())
+			server := httptest.NewServer(api)
+			defer server.Close()
+
+			_, key := buildPath(server.URL, a)
+			if b == nil {
+				b = []byte{}
+			}
+
+			store.EXPECT().Txn([]keyvalStore.Condition{
+				{Key: key, Check: keyvalStore.CheckMissing},
+			}, []keyvalStore.Mutation{
+				{
+					Key:       key,
+					Operation: keyvalStore.OperationSet,
+					Value:     b,
+					TTL:       time.Minute,
+					Metadata:  keyvalStore.Metadata{ContentType: "application/octet-stream"},
+				},
+			}).Return([]uint64{4}, true)
+
+			resp, err := http.Post(server.URL+"/keys/"+key+"?ttl=1m", "application/octet-stream", bytes.NewReader(b))
+			if err != nil {
+				t.Error(err)
+				return false
+			}
+			defer resp.Body.Close()
+
+			return resp.StatusCode == http.StatusCreated &&
+				resp.Header.Get("ETag") == `"4"`
+		}
+		if err := quick.Check(fn, nil); err != nil {
+			t.Error(err)
+		}
+	})
+
+	t.Run("create with existing value", func(t *testing.T) {
+		s := keyvalStore.New()
+
+		api := NewAPI(s, log.NewNopLogger())
+		server := httptest.NewServer(api)
+		defer server.Close()
+
+		for _, expected := range []int{
+			http.StatusCreated,
+			http.StatusConflict,
+		} {
+			resp, err := http.Post(server.URL+"/keys/a", "text/plain", strings.NewReader("b"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+
+			if actual := resp.StatusCode; expected != actual {
+				t.Errorf("expected: %v, actual: %v", expected, actual)
+			}
+		}
+		if entry, _ := s.Select("a"); !bytes.Equal(entry.Value, []byte("b")) {
+			t.Errorf("expected: %v, actual: %v", []byte("b"), entry.Value)
+		}
+	})
+
+	t.Run("put and delete by path", func(t *testing.T) {
+		s := keyvalStore.New()
+
+		api := NewAPI(s, log.NewNopLogger())
+		server := httptest.NewServer(api)
+		defer server.Close()
+
+		resp, err := Put(server.URL+"/keys/a%20b", []byte("c"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+
+		if entry, _ := s.Select("a b"); !bytes.Equal(entry.Value, []byte("c")) {
+			t.Errorf("expected: %v, actual: %v", []byte("c"), entry.Value)
+		}
+
+		resp, err = Delete(server.URL + "/keys/a%20b")
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+
+		if expected, actual := http.StatusOK, resp.StatusCode; expected != actual {
+			t.Errorf("expected: %v, actual: %v", expected, actual)
+		}
+		if _, ok := s.Select("a b"); ok {
+			t.Errorf("expected: %v, actual: %v", false, ok)
+		}
+	})
+
+	t.Run("not found and not allowed", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+
+		store := mocks.NewMockStore(ctrl)
+
+		api := NewAPI(store, log.NewNopLogger())
+		server := httptest.NewServer(api)
+		defer server.Close()
+
+		for _, testcase := range []struct {
+			method, path string
+			expected     int
+			allow        string
+		}{
+			{"GET", "/missing", http.StatusNotFound, ""},
+			{"GET", "/keys", http.StatusNotFound, ""},
+			{"GET", "/keys/", http.StatusBadRequest, ""},
+			{"PATCH", "/keys/a", http.StatusMethodNotAllowed, "DELETE, GET, HEAD, POST, PUT"},
+			{"PATCH", "/?key=a", http.StatusMethodNotAllowed, "DELETE, GET, HEAD, POST, PUT"},
+			{"PATCH", "/", http.StatusMethodNotAllowed, "DELETE, GET, HEAD, POST, PUT"},
+			{"GET", "/_txn", http.StatusMethodNotAllowed, "POST"},
+			{"POST", "/_watch", http.StatusMethodNotAllowed, "GET"},
+		} {
+			resp, err := Do(testcase.method, server.URL+testcase.path, nil, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+
+			if expected, actual := testcase.expected, resp.StatusCode; expected != actual {
+				t.Errorf("%s %s expected: %v, actual: %v", testcase.method, testcase.path, expected, actual)
+			}
+			if expected, actual := testcase.allow, resp.Header.Get("Allow"); expected != actual {
+				t.Errorf("%s %s expected: %v, actual: %v", testcase.method, testcase.path, expected, actual)
+			}
+		}
+	})
+}
+
+func TestAPIConditional(t *testing.T) {
+	t.Parallel()
+
+	t.Run("select not modified", func(t *testing.T) {
+		s := keyvalStore.New()
+		s.Set("a", []byte("abc"))
+
+		api := NewAPI(s, log.NewNopLogger())
+		server := httptest.NewServer(api)
+		defer server.Close()
+
+		resp, err := http.Get(server.URL + "/keys/a")
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+
+		lastModified := resp.Header.Get("Last-Modified")
+		modified, err := http.ParseTime(lastModified)
+		if err != nil {
+			t.Fatal(err)
+		}
+		before := modified.Add(-time.Second).Format(http.TimeFormat)
+
+		for _, testcase := range []struct {
+			headers  map[string]string
+			expected int
+		}{
+			{map[string]string{"If-None-Match": `"1"`}, http.StatusNotModified},
+			{map[string]string{"If-None-Match": `"2", W/"1"`}, http.StatusNotModified},
+			{map[string]string{"If-None-Match": `*`}, http.StatusNotModified},
+			{map[string]string{"If-None-Match": `"2"`}, http.StatusOK},
+			{map[string]string{"If-Modified-Since": lastModified}, http.StatusNotModified},
+			{map[string]string{"If-Modified-Since": before}, http.StatusOK},
+			{map[string]string{"If-Modified-Since": "yesterday"}, http.StatusOK},
+			// If-None-Match takes precedence over If-Modified-Since.
+			{map[string]string{"If-None-Match": `"2"`, "If-Modified-Since": lastModified}, http.StatusOK},
+		} {
+			resp, err := Do("GET", server.URL+"/keys/a", nil, testcase.headers)
+			if err != nil {
+				t.Fatal(err)
+			}
+			body, err := ioutil.ReadAll(resp.Body)
+			resp.Body.Close()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if expected, actual := testcase.expected, resp.StatusCode; expected != actual {
+				t.Errorf("%v expected: %v, actual: %v", testcase.headers, expected, actual)
+			}
+			if expected, actual := `"1"`, resp.Header.Get("ETag"); expected != actual {
+				t.Errorf("expected: %v, actual: %v", expected, actual)
+			}
+			if expected, actual := lastModified, resp.Header.Get("Last-Modified"); expected != actual {
+				t.Errorf("expected: %v, actual: %v", expected, actual)
+			}
+			if resp.StatusCode == http.StatusNotModified && len(body) > 0 {
+				t.Errorf("expected: %v, actual: %v", 0, len(body))
+			}
+		}
+	})
+
+	t.Run("insert if none match", func(t *testing.T) {
+		s := keyvalStore.New()
+
+		api := NewAPI(s, log.NewNopLogger())
+		server := httptest.NewServer(api)
+		defer server.Close()
+
+		for _, testcase := range []struct {
+			headers  map[string]string
+			value    string
+			expected int
+		}{
+			{map[string]string{"If-None-Match": `*`}, "a", http.StatusCreated},
+			{map[string]string{"If-None-Match": `*`}, "b", http.StatusPreconditionFailed},
+			{map[string]string{"If-None-Match": `"1"`}, "c", http.StatusPreconditionFailed},
+			{map[string]string{"If-None-Match": `"7", "8"`}, "d", http.StatusOK},
+			{map[string]string{"If-None-Match": `*`, "If-Match": `*`}, "e", http.StatusBadRequest},
+		} {
+			resp, err := Do("PUT", server.URL+"/keys/a?ttl=1m", []byte(testcase.value), testcase.headers)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+
+			if expected, actual := testcase.expected, resp.StatusCode; expected != actual {
+				t.Errorf("%v expected: %v, actual: %v", testcase.headers, expected, actual)
+			}
+		}
+
+		entry, _ := s.Select("a")
+		if expected, actual := []byte("d"), entry.Value; !bytes.Equal(expected, actual) {
+			t.Errorf("expected: %v, actual: %v", expected, actual)
+		}
+		if entry.Expires.IsZero() {
+			t.Errorf("expected: %v, actual: %v", "ttl", entry.Expires)
+		}
+	})
+
+	t.Run("insert if match with ttl", func(t *testing.T) {
+		s := keyvalStore.New()
+		s.SetWithMetadata("a", []byte("a"), 0, keyvalStore.Metadata{ContentType: "text/plain"})
+		version := mustSelect(t, s, "a").Version
+
+		api := NewAPI(s, log.NewNopLogger())
+		server := httptest.NewServer(api)
+		defer server.Close()
+
+		resp, err := Do("PUT", server.URL+"/keys/a?ttl=1m", []byte("b"), map[string]string{
+			"If-Match":     formatETag(version),
+			"Content-Type": "application/json",
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+
+		// The value already existed, so it's updated rather than created.
+		if expected, actual := http.StatusOK, resp.StatusCode; expected != actual {
+			t.Errorf("expected: %v, actual: %v", expected, actual)
+		}
+		if expected, actual := formatETag(version+1), resp.Header.Get("ETag"); expected != actual {
+			t.Errorf("expected: %v, actual: %v", expected, actual)
+		}
+		entry := mustSelect(t, s, "a")
+		if entry.Expires.IsZero() {
+			t.Errorf("expected: %v, actual: %v", "ttl", entry.Expires)
+		}
+		if expected, actual := "application/json", entry.Metadata.ContentType; expected != actual {
+			t.Errorf("expected: %v, actual: %v", expected, actual)
+		}
+	})
+
+	t.Run("delete if none match", func(t *testing.T) {
+		s := keyvalStore.New()
+		s.Set("a", []byte("a"))
+
+		api := NewAPI(s, log.NewNopLogger())
+		server := httptest.NewServer(api)
+		defer server.Close()
+
+		for _, testcase := range []struct {
+			headers  map[string]string
+			expected int
+		}{
+			{map[string]string{"If-None-Match": `*`}, http.StatusPreconditionFailed},
+			{map[string]string{"If-None-Match": `"1"`}, http.StatusPreconditionFailed},
+			{map[string]string{"If-None-Match": `"1"`, "If-Match": `"1"`}, http.StatusBadRequest},
+			{map[string]string{"If-None-Match": `"2"`}, http.StatusOK},
+			{map[string]string{"If-None-Match": `*`}, http.StatusNotFound},
+		} {
+			resp, err := Do("DELETE", server.URL+"/keys/a", nil, testcase.headers)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+
+			if expected, actual := testcase.expected, resp.StatusCode; expected != actual {
+				t.Errorf("%v expected: %v, actual: %v", testcase.headers, expected, actual)
+			}
+		}
+	})
+}
+
+func buildPath(serverURL string, a []byte) (string, string) {
+	v := base64.RawURLEncoding.EncodeToString(a)
+	if v == "" {
+		v = "empty"
+	}
+	return fmt.Sprintf("%s/?key=%s", serverURL, v), v
+}
+
+func Put(url string, body []byte) (resp *http.Response, err error) {
+	req, err := http.NewRequest("PUT", url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	return http.DefaultClient.Do(req)
+}
+
+func Delete(url string) (resp *http.Response, err error) {
+	req, err := http.NewRequest("DELETE", url, nil)
+	if err != nil {
+		return nil, err
+	}
+	return http.DefaultClient.Do(req)
+}
+
+func TestAPIMetadata(t *testing.T) {
+	t.Parallel()
+
+	t.Run("select returns metadata", func(t *testing.T) {
+		s := keyvalStore.New()
+
+		api := NewAPI(s, log.NewNopLogger())
+		server := httptest.NewServer(api)
+		defer server.Close()
+
+		resp, err := Do("PUT", server.URL+"/keys/a", []byte("abc"), map[string]string{
+			"Content-Type":     "application/json",
+			"Content-Encoding": "gzip",
+			"X-Meta-Owner":     "a",
+			"X-Meta-owner-id":  "b",
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+
+		for _, method := range []string{"GET", "HEAD"} {
+			// Ask for the encoding, so that the client doesn't try to decode
+			// the value.
+			resp, err := Do(method, server.URL+"/keys/a", nil, map[string]string{
+				"Accept-Encoding": "gzip",
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+
+			for name, expected := range map[string]string{
+				"Content-Type":     "application/json",
+				"Content-Encoding": "gzip",
+				"X-Meta-Owner":     "a",
+				"X-Meta-Owner-Id":  "b",
+			} {
+				if actual := resp.Header.Get(name); expected != actual {
+					t.Errorf("%s %s expected: %v, actual: %v", method, name, expected, actual)
+				}
+			}
+		}
+
+		if expected, actual := map[string]string{"owner": "a", "owner-id": "b"}, mustSelect(t, s, "a").Metadata.User; !reflect.DeepEqual(expected, actual) {
+			t.Errorf("expected: %v, actual: %v", expected, actual)
+		}
+	})
+
+	t.Run("insert replaces metadata", func(t *testing.T) {
+		s := keyvalStore.New()
+		s.SetWithMetadata("a", []byte("abc"), 0, keyvalStore.Metadata{
+			User: map[string]string{"owner": "a"},
+		})
+
+		api := NewAPI(s, log.NewNopLogger())
+		server := httptest.NewServer(api)
+		defer server.Close()
+
+		resp, err := Do("PUT", server.URL+"/keys/a", []byte("def"), map[string]string{
+			"If-Match":   `"1"`,
+			"X-Meta-Tag": "b",
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+
+		if expected, actual := http.StatusOK, resp.StatusCode; expected != actual {
+			t.Errorf("expected: %v, actual: %v", expected, actual)
+		}
+		if expected, actual := (keyvalStore.Metadata{User: map[string]string{"tag": "b"}}), mustSelect(t, s, "a").Metadata; !reflect.DeepEqual(expected, actual) {
+			t.Errorf("expected: %v, actual: %v", expected, actual)
+		}
+
+		resp, err = Do("PUT", server.URL+"/keys/a", []byte("ghi"), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+
+		if metadata := mustSelect(t, s, "a").Metadata; !metadata.IsZero() {
+			t.Errorf("expected: %v, actual: %v", keyvalStore.Metadata{}, metadata)
+		}
+	})
+
+	t.Run("insert with invalid metadata", func(t *testing.T) {
+		s := keyvalStore.New()
+
+		api := NewAPI(s, log.NewNopLogger())
+		server := httptest.NewServer(api)
+		defer server.Close()
+
+		for _, headers := range []map[string]string{
+			{"X-Meta-": "a"},
+			{"X-Meta-a.b": "a"},
+			{"X-Meta-Owner": strings.Repeat("a", maxMetadataSize)},
+		} {
+			resp, err := Do("PUT", server.URL+"/keys/a", []byte("abc"), headers)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+
+			if expected, actual := http.StatusBadRequest, resp.StatusCode; expected != actual {
+				t.Errorf("%v expected: %v, actual: %v", headers, expected, actual)
+			}
+		}
+		if _, ok := s.Get("a"); ok {
+			t.Errorf("expected: %v, actual: %v", false, ok)
+		}
+	})
+}
+
+func TestAPIRange(t *testing.T) {
+	t.Parallel()
+
+	t.Run("select range", func(t *testing.T) {
+		fn := func(a, b []byte, x, y uint16) bool {
+			if len(b) == 0 {
+				return true
+			}
+			start, end := int(x)%len(b), int(y)%len(b)
+			if start > end {
+				start, end = end, start
+			}
+
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+
+			store := mocks.NewMockStore(ctrl)
+
+			api := NewAPI(store, log.NewNopLogger())
+			server := httptest.NewServer(api)
+			defer server.Close()
+
+			path, key := buildPath(server.URL, a)
+
+			store.EXPECT().Select(key).Return(keyvalStore.Entry{Key: key, Value: b, Version: 1}, true)
+
+			resp, err := Do("GET", path, nil, map[string]string{
+				"Range": fmt.Sprintf("bytes=%d-%d", start, end),
+			})
+			if err != nil {
+				t.Error(err)
+				return false
+			}
+			defer resp.Body.Close()
+
+			result, err := ioutil.ReadAll(resp.Body)
+
+			return err == nil &&
+				resp.StatusCode == http.StatusPartialContent &&
+				resp.Header.Get("Content-Range") == fmt.Sprintf("bytes %d-%d/%d", start, end, len(b)) &&
+				bytes.Equal(b[start:end+1], result)
+		}
+		if err := quick.Check(fn, nil); err != nil {
+			t.Error(err)
+		}
+	})
+
+	t.Run("select ranges", func(t *testing.T) {
+		s := keyvalStore.New()
+		s.Set("a", []byte("abcdef"))
+
+		api := NewAPI(s, log.NewNopLogger())
+		server := httptest.NewServer(api)
+		defer server.Close()
+
+		for _, test := range []struct {
+			header       string
+			status       int
+			contentRange string
+			body         string
+		}{
+			{"bytes=0-1", http.StatusPartialContent, "bytes 0-1/6", "ab"},
+			{"bytes=2-", http.StatusPartialContent, "bytes 2-5/6", "cdef"},
+			{"bytes=-2", http.StatusPartialContent, "bytes 4-5/6", "ef"},
+			{"bytes=-10", http.StatusPartialContent, "bytes 0-5/6", "abcdef"},
+			{"bytes=4-100", http.StatusPartialContent, "bytes 4-5/6", "ef"},
+			{"bytes=6-", http.StatusRequestedRangeNotSatisfiable, "bytes */6", ""},
+			{"bytes=-0", http.StatusRequestedRangeNotSatisfiable, "bytes */6", ""},
+			{"bytes=0-1,3-4", http.StatusOK, "", "abcdef"},
+			{"bytes=3-1", http.StatusOK, "", "abcdef"},
+			{"items=0-1", http.StatusOK, "", "abcdef"},
+		} {
+			resp, err := Do("GET", server.URL+"/keys/a", nil, map[string]string{
+				"Range": test.header,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			body, err := ioutil.ReadAll(resp.Body)
+			resp.Body.Close()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if expected, actual := test.status, resp.StatusCode; expected != actual {
+				t.Errorf("%s expected: %v, actual: %v", test.header, expected, actual)
+			}
+			if expected, actual := test.contentRange, resp.Header.Get("Content-Range"); expected != actual {
+				t.Errorf("%s expected: %v, actual: %v", test.header, expected, actual)
+			}
+			if expected, actual := test.body, string(body); expected != actual {
+				t.Errorf("%s expected: %v, actual: %v", test.header, expected, actual)
+			}
+			if expected, actual := "bytes", resp.Header.Get("Accept-Ranges"); expected != actual {
+				t.Errorf("%s expected: %v, actual: %v", test.header, expected, actual)
+			}
+		}
+	})
+
+	t.Run("select range with if-range", func(t *testing.T) {
+		s := keyvalStore.New()
+		s.Set("a", []byte("abcdef"))
+		entry := mustSelect(t, s, "a")
+
+		api := NewAPI(s, log.NewNopLogger())
+		server := httptest.NewServer(api)
+		defer server.Close()
+
+		for _, test := range []struct {
+			ifRange string
+			status  int
+		}{
+			{fmt.Sprintf(`"%d"`, entry.Version), http.StatusPartialContent},
+			{fmt.Sprintf(`"%d"`, entry.Version+1), http.StatusOK},
+			{fmt.Sprintf(`W/"%d"`, entry.Version), http.StatusOK},
+			{entry.Modified.UTC().Format(http.TimeFormat), http.StatusPartialContent},
+			{entry.Modified.Add(-time.Hour).UTC().Format(http.TimeFormat), http.StatusOK},
+		} {
+			resp, err := Do("GET", server.URL+"/keys/a", nil, map[string]string{
+				"Range":    "bytes=0-1",
+				"If-Range": test.ifRange,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+
+			if expected, actual := test.status, resp.StatusCode; expected != actual {
+				t.Errorf("%s expected: %v, actual: %v", test.ifRange, expected, actual)
+			}
+		}
+	})
+}
+
+func TestAPIValueSize(t *testing.T) {
+	t.Parallel()
+
+	// chunked hides the length of the body, so that the body is sent chunked.
+	type chunked struct {
+		io.Reader
+	}
+
+	t.Run("insert too large", func(t *testing.T) {
+		s := keyvalStore.New()
+
+		api := NewAPI(s, log.NewNopLogger())
+		api.MaxValueSize = 4
+		server := httptest.NewServer(api)
+		defer server.Close()
+
+		for _, method := range []string{"PUT", "POST"} {
+			resp, err := Do(method, server.URL+"/keys/a", []byte("abcde"), nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+
+			if expected, actual := http.StatusRequestEntityTooLarge, resp.StatusCode; expected != actual {
+				t.Errorf("%s expected: %v, actual: %v", method, expected, actual)
+			}
+		}
+		if _, ok := s.Get("a"); ok {
+			t.Errorf("expected: %v, actual: %v", false, ok)
+		}
+	})
+
+	t.Run("insert chunked", func(t *testing.T) {
+		s := keyvalStore.New()
+
+		api := NewAPI(s, log.NewNopLogger())
+		api.MaxValueSize = 4
+		server := httptest.NewServer(api)
+		defer server.Close()
+
+		for _, test := range []struct {
+			key, value string
+			status     int
+		}{
+			{"a", "abcd", http.StatusCreated},
+			{"b", "abcde", http.StatusRequestEntityTooLarge},
+		} {
+			req, err := http.NewRequest("PUT", server.URL+"/keys/"+test.key, chunked{strings.NewReader(test.value)})
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+
+			if expected, actual := test.status, resp.StatusCode; expected != actual {
+				t.Errorf("%s expected: %v, actual: %v", test.value, expected, actual)
+			}
+		}
+		if expected, actual := []byte("abcd"), mustSelect(t, s, "a").Value; !bytes.Equal(expected, actual) {
+			t.Errorf("expected: %v, actual: %v", expected, actual)
+		}
+		if _, ok := s.Get("b"); ok {
+			t.Errorf("expected: %v, actual: %v", false, ok)
+		}
+	})
+
+	t.Run("insert too large without sending the body", func(t *testing.T) {
+		s := keyvalStore.New()
+
+		api := NewAPI(s, log.NewNopLogger())
+		api.MaxValueSize = 4
+		server := httptest.NewServer(api)
+		defer server.Close()
+
+		// The body would fail if it was ever read, so the write has to be
+		// rejected by the length alone.
+		req, err := http.NewRequest("PUT", server.URL+"/keys/a", chunked{iotest.ErrReader(errors.New("body read"))})
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.ContentLength = 5
+		req.Header.Set("Expect", "100-continue")
+
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+
+		if expected, actual := http.StatusRequestEntityTooLarge, resp.StatusCode; expected != actual {
+			t.Errorf("expected: %v, actual: %v", expected, actual)
+		}
+	})
+
+	t.Run("insert too large for a bounded store", func(t *testing.T) {
+		// The limits are spread across the shards, so each value has to fit
+		// with in 16 bytes, along with its key.
+		s := keyvalStore.NewBoundedBucket(4, keyvalStore.Limits{MaxBytes: 64})
+		s.Set("a", []byte("abc"))
+
+		api := NewAPI(s, log.NewNopLogger())
+		server := httptest.NewServer(api)
+		defer server.Close()
+
+		for _, test := range []struct {
+			method string
+			header map[string]string
+		}{
+			{"PUT", nil},
+			{"PUT", map[string]string{"If-Match": "*"}},
+			{"PUT", map[string]string{"If-None-Match": `"100"`}},
+			{"POST", nil},
+		} {
+			resp, err := Do(test.method, server.URL+"/keys/a", make([]byte, 32), test.header)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+
+			if expected, actual := http.StatusRequestEntityTooLarge, resp.StatusCode; expected != actual {
+				t.Errorf("%s %v expected: %v, actual: %v", test.method, test.header, expected, actual)
+			}
+		}
+		if expected, actual := []byte("abc"), mustSelect(t, s, "a").Value; !bytes.Equal(expected, actual) {
+			t.Errorf("expected: %v, actual: %v", expected, actual)
+		}
+	})
+
+	t.Run("txn set too large", func(t *testing.T) {
+		s := keyvalStore.New()
+
+		api := NewAPI(s, log.NewNopLogger())
+		api.MaxValueSize = 4
+		server := httptest.NewServer(api)
+		defer server.Close()
+
+		resp, err := http.Post(server.URL+"/_txn", "application/json", strings.NewReader(`{
+			"mutations": [
+				{"op": "set", "key": "a", "value": "YWJjZA=="},
+				{"op": "set", "key": "b", "value": "YWJjZGU="}
+			]
+		}`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+
+		if expected, actual := http.StatusRequestEntityTooLarge, resp.StatusCode; expected != actual {
+			t.Errorf("expected: %v, actual: %v", expected, actual)
+		}
+		if _, ok := s.Get("a"); ok {
+			t.Errorf("expected: %v, actual: %v", false, ok)
+		}
+	})
+
+	t.Run("txn body too large", func(t *testing.T) {
+		s := keyvalStore.New()
+
+		api := NewAPI(s, log.NewNopLogger())
+		api.MaxBatchBytes = 16
+		server := httptest.NewServer(api)
+		defer server.Close()
+
+		resp, err := http.Post(server.URL+"/_txn", "application/json", strings.NewReader(`{
+			"mutations": [{"op": "set", "key": "a", "value": "YQ=="}]
+		}`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+
+		if expected, actual := http.StatusRequestEntityTooLarge, resp.StatusCode; expected != actual {
+			t.Errorf("expected: %v, actual: %v", expected, actual)
+		}
+		if _, ok := s.Get("a"); ok {
+			t.Errorf("expected: %v, actual: %v", false, ok)
+		}
+	})
+
+	t.Run("batch set too large", func(t *testing.T) {
+		s := keyvalStore.New()
+
+		api := NewAPI(s, log.NewNopLogger())
+		api.MaxValueSize = 4
+		server := httptest.NewServer(api)
+		defer server.Close()
+
+		resp, err := http.Post(server.URL+"/_batch", "application/json", strings.NewReader(`[
+			{"op": "set", "key": "a", "value": "YWJjZGU="},
+			{"op": "set", "key": "b", "value": "YWJjZA=="}
+		]`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+
+		var results []struct {
+			Status int `json:"status"`
+		}
+		if err := json.NewDecoder(resp.Body).Decode(&results); err != nil {
+			t.Fatal(err)
+		}
+		if expected, actual := 2, len(results); expected != actual {
+			t.Fatalf("expected: %v, actual: %v", expected, actual)
+		}
+		if expected, actual := http.StatusRequestEntityTooLarge, results[0].Status; expected != actual {
+			t.Errorf("expected: %v, actual: %v", expected, actual)
+		}
+		if expected, actual := http.StatusCreated, results[1].Status; expected != actual {
+			t.Errorf("expected: %v, actual: %v", expected, actual)
+		}
+		if _, ok := s.Get("a"); ok {
+			t.Errorf("expected: %v, actual: %v", false, ok)
+		}
+	})
+}
+
+func mustSelect(t *testing.T, s keyvalStore.Store, key string) keyvalStore.Entry {
+	entry, ok := s.Select(key)
+	if !ok {
+		t.Fatalf("expected: %v, actual: %v", true, ok)
+	}
+	return entry
+}
+
+func Do(method, url string, body []byte, headers map[string]string) (resp *http.Response, err error) {
+	req, err := http.NewRequest(method, url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range headers {
+		req.Header.Set(k, v)
 	}
 	return http.DefaultClient.Do(req)
 }
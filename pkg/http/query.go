@@ -1,40 +1,234 @@
 package http
 
 import (
+	"encoding/base64"
+	"encoding/json"
 	"errors"
+	"fmt"
+	"io"
 	"net/http"
 	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/SimonRichardson/keyval/pkg/store"
 )
 
 // QueryParams defines all the dimensions of a query.
 type QueryParams struct {
-	Key string
+	Key      string
+	TTL      time.Duration
+	Metadata store.Metadata
+
+	// path is true if the key is the rest of the path, rather than the key
+	// query.
+	path bool
 }
 
 // DecodeFrom populates a QueryParams from a URL.
 func (qp *QueryParams) DecodeFrom(u *url.URL, rb queryBehavior) error {
-	// Required depending on the query behavior
+	// Required depending on the query behavior, the key is either the rest of
+	// the path (already unescaped) or the key query. The path has already been
+	// cleaned by the mux, so keys with empty, "." or ".." segments can only
+	// be sent with the key query.
 	if rb == queryRequired {
-		if qp.Key = u.Query().Get("key"); qp.Key == "" {
+		if strings.HasPrefix(u.Path, APIPathKeys) {
+			qp.Key = strings.TrimPrefix(u.Path, APIPathKeys)
+			qp.path = true
+		} else {
+			qp.Key = u.Query().Get("key")
+		}
+		if qp.Key == "" {
 			return errors.New("error reading 'key' (required) query")
 		}
 	}
+
+	// TTL is always optional, but if it's supplied it has to be valid.
+	if ttl := u.Query().Get("ttl"); ttl != "" {
+		var err error
+		if qp.TTL, err = time.ParseDuration(ttl); err != nil || qp.TTL < 0 {
+			return errors.New("error reading 'ttl' (optional) query")
+		}
+	}
+	return nil
+}
+
+// maxMetadataSize is the maximum size of the content type, content encoding
+// and metadata of a value.
+const maxMetadataSize = 8 << 10
+
+// DecodeMetadataFrom populates the metadata of a QueryParams from the headers
+// of a write. The names of the metadata are the rest of the X-Meta-* headers,
+// which are case insensitive, so they're stored in lower case.
+func (qp *QueryParams) DecodeMetadataFrom(h http.Header) error {
+	qp.Metadata = store.Metadata{
+		ContentType:     h.Get(httpHeaderContentType),
+		ContentEncoding: h.Get(httpHeaderContentEncoding),
+	}
+	for name, values := range h {
+		if !strings.HasPrefix(name, httpHeaderMetaPrefix) {
+			continue
+		}
+		if qp.Metadata.User == nil {
+			qp.Metadata.User = make(map[string]string)
+		}
+		name = strings.ToLower(strings.TrimPrefix(name, httpHeaderMetaPrefix))
+		qp.Metadata.User[name] = strings.Join(values, ", ")
+	}
+	if !validMetadata(qp.Metadata) {
+		return errors.New("error reading 'X-Meta-*' (optional) header")
+	}
+	return nil
+}
+
+// validMetadata returns true if the metadata is small and every name is made
+// up of letters, digits, '-' and '_', so that it can be sent back as headers.
+func validMetadata(m store.Metadata) bool {
+	if m.Size() > maxMetadataSize {
+		return false
+	}
+	values := []string{m.ContentType, m.ContentEncoding}
+	for k, v := range m.User {
+		if k == "" || strings.IndexFunc(k, invalidMetadataName) >= 0 {
+			return false
+		}
+		values = append(values, v)
+	}
+	for _, v := range values {
+		if strings.IndexFunc(v, invalidMetadataValue) >= 0 {
+			return false
+		}
+	}
+	return true
+}
+
+func invalidMetadataName(c rune) bool {
+	return !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-' || c == '_')
+}
+
+func invalidMetadataValue(c rune) bool {
+	return (c < ' ' && c != '\t') || c == 0x7f
+}
+
+const (
+	defaultScanLimit = 100
+	maxScanLimit     = 1000
+)
+
+// ScanQueryParams defines all the dimensions of a scan query.
+type ScanQueryParams struct {
+	Prefix string
+	Start  string
+	End    string
+	Limit  int
+	Cursor string
+}
+
+// DecodeFrom populates a ScanQueryParams from a URL.
+func (qp *ScanQueryParams) DecodeFrom(u *url.URL) error {
+	values := u.Query()
+
+	qp.Prefix = values.Get("prefix")
+	qp.Start = values.Get("start")
+	qp.End = values.Get("end")
+
+	qp.Limit = defaultScanLimit
+	if limit := values.Get("limit"); limit != "" {
+		var err error
+		if qp.Limit, err = strconv.Atoi(limit); err != nil || qp.Limit <= 0 || qp.Limit > maxScanLimit {
+			return errors.New("error reading 'limit' (optional) query")
+		}
+	}
+
+	// The cursor is encoded, as it's not guaranteed to be url friendly.
+	if cursor := values.Get("cursor"); cursor != "" {
+		b, err := base64.RawURLEncoding.DecodeString(cursor)
+		if err != nil {
+			return errors.New("error reading 'cursor' (optional) query")
+		}
+		qp.Cursor = string(b)
+	}
+	return nil
+}
+
+// WatchQueryParams defines all the dimensions of a watch query.
+type WatchQueryParams struct {
+	Key    string
+	Prefix string
+}
+
+// DecodeFrom populates a WatchQueryParams from a URL.
+func (qp *WatchQueryParams) DecodeFrom(u *url.URL) error {
+	values := u.Query()
+
+	qp.Key = values.Get("key")
+	qp.Prefix = values.Get("prefix")
+
+	// Either the key or the prefix can be watched, but not both.
+	if qp.Key != "" && qp.Prefix != "" {
+		return errors.New("error reading 'key' and 'prefix' (optional) query")
+	}
 	return nil
 }
 
 // SelectQueryResult contains statistics about the query.
 type SelectQueryResult struct {
-	Params   QueryParams
-	Duration string
-	Value    []byte
+	Params       QueryParams
+	Duration     string
+	Value        []byte
+	Version      uint64
+	LastModified time.Time
+	Metadata     store.Metadata
+	NotModified  bool
+	Range        string
 }
 
 // EncodeTo encodes the SelectQueryResult to the HTTP response writer.
+// If the value isn't modified, then only the headers are sent. If there is a
+// range, then only that part of the value is sent.
 func (qr *SelectQueryResult) EncodeTo(w http.ResponseWriter) {
 	w.Header().Set(httpHeaderDuration, qr.Duration)
-	w.Header().Set(httpHeaderKey, qr.Params.Key)
+	w.Header().Set(httpHeaderKey, formatKey(qr.Params))
+	w.Header().Set(httpHeaderETag, formatETag(qr.Version))
+	if !qr.LastModified.IsZero() {
+		w.Header().Set(httpHeaderLastModified, qr.LastModified.UTC().Format(http.TimeFormat))
+	}
+
+	if qr.NotModified {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	if qr.Metadata.ContentType != "" {
+		w.Header().Set(httpHeaderContentType, qr.Metadata.ContentType)
+	}
+	if qr.Metadata.ContentEncoding != "" {
+		w.Header().Set(httpHeaderContentEncoding, qr.Metadata.ContentEncoding)
+	}
+	for k, v := range qr.Metadata.User {
+		w.Header().Set(httpHeaderMetaPrefix+k, v)
+	}
+	w.Header().Set(httpHeaderAcceptRanges, "bytes")
+
+	r, ok := parseRange(qr.Range, len(qr.Value))
+	if !ok {
+		w.Header().Set(httpHeaderContentRange, fmt.Sprintf("bytes */%d", len(qr.Value)))
+		w.WriteHeader(http.StatusRequestedRangeNotSatisfiable)
+		return
+	}
+
+	value := qr.Value
+	if r != nil {
+		value = value[r.start:r.end]
+		w.Header().Set(httpHeaderContentRange, fmt.Sprintf("bytes %d-%d/%d", r.start, r.end-1, len(qr.Value)))
+	}
+	w.Header().Set(httpHeaderContentLength, strconv.Itoa(len(value)))
+	if r != nil {
+		w.WriteHeader(http.StatusPartialContent)
+	}
 
-	if _, err := w.Write(qr.Value); err != nil {
+	if _, err := w.Write(value); err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
 	}
 }
@@ -44,12 +238,16 @@ type InsertQueryResult struct {
 	Params   QueryParams
 	Duration string
 	Created  bool
+	Version  uint64
 }
 
 // EncodeTo encodes the InsertQueryResult to the HTTP response writer.
 func (qr *InsertQueryResult) EncodeTo(w http.ResponseWriter) {
 	w.Header().Set(httpHeaderDuration, qr.Duration)
-	w.Header().Set(httpHeaderKey, qr.Params.Key)
+	w.Header().Set(httpHeaderKey, formatKey(qr.Params))
+	if qr.Version > 0 {
+		w.Header().Set(httpHeaderETag, formatETag(qr.Version))
+	}
 
 	if qr.Created {
 		w.WriteHeader(http.StatusCreated)
@@ -67,14 +265,587 @@ type DeleteQueryResult struct {
 // EncodeTo encodes the DeleteQueryResult to the HTTP response writer.
 func (qr *DeleteQueryResult) EncodeTo(w http.ResponseWriter) {
 	w.Header().Set(httpHeaderDuration, qr.Duration)
-	w.Header().Set(httpHeaderKey, qr.Params.Key)
+	w.Header().Set(httpHeaderKey, formatKey(qr.Params))
+}
+
+// ScanQueryResult contains statistics about the query.
+type ScanQueryResult struct {
+	Params   ScanQueryParams
+	Duration string
+	Entries  []store.Entry
+	Cursor   string
+}
+
+// EncodeTo encodes the ScanQueryResult to the HTTP response writer.
+func (qr *ScanQueryResult) EncodeTo(w http.ResponseWriter) {
+	w.Header().Set(httpHeaderDuration, qr.Duration)
+	w.Header().Set(httpHeaderContentType, "application/json; charset=utf-8")
+
+	type entry struct {
+		Key     string `json:"key"`
+		Value   []byte `json:"value"`
+		Version uint64 `json:"version"`
+	}
+	result := struct {
+		Entries []entry `json:"entries"`
+		Cursor  string  `json:"cursor,omitempty"`
+	}{
+		Entries: make([]entry, len(qr.Entries)),
+	}
+	for k, v := range qr.Entries {
+		result.Entries[k] = entry{
+			Key:     v.Key,
+			Value:   v.Value,
+			Version: v.Version,
+		}
+	}
+	if qr.Cursor != "" {
+		result.Cursor = base64.RawURLEncoding.EncodeToString([]byte(qr.Cursor))
+	}
+
+	if err := json.NewEncoder(w).Encode(result); err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+}
+
+const maxTxnSize = 1000
+
+// TxnQueryParams defines all the dimensions of a transaction query.
+type TxnQueryParams struct {
+	Conditions []store.Condition
+	Mutations  []store.Mutation
+}
+
+// DecodeFrom populates a TxnQueryParams from a JSON body.
+func (qp *TxnQueryParams) DecodeFrom(r io.Reader) error {
+	var body struct {
+		Conditions []struct {
+			Key     string `json:"key"`
+			Check   string `json:"check"`
+			Version uint64 `json:"version"`
+		} `json:"conditions"`
+		Mutations []struct {
+			Op              string            `json:"op"`
+			Key             string            `json:"key"`
+			Value           []byte            `json:"value"`
+			TTL             string            `json:"ttl"`
+			ContentType     string            `json:"content_type"`
+			ContentEncoding string            `json:"content_encoding"`
+			Metadata        map[string]string `json:"metadata"`
+		} `json:"mutations"`
+	}
+	if err := json.NewDecoder(r).Decode(&body); err != nil {
+		return errors.New("error reading txn (required) body")
+	}
+	if len(body.Conditions)+len(body.Mutations) > maxTxnSize {
+		return errors.New("error reading txn (required) body, too large")
+	}
+
+	qp.Conditions = make([]store.Condition, len(body.Conditions))
+	for k, v := range body.Conditions {
+		if v.Key == "" {
+			return errors.New("error reading 'key' (required) condition")
+		}
+		c := store.Condition{
+			Key:     v.Key,
+			Version: v.Version,
+		}
+		switch v.Check {
+		case "exists":
+			c.Check = store.CheckExists
+		case "missing":
+			c.Check = store.CheckMissing
+		case "version":
+			c.Check = store.CheckVersion
+		default:
+			return errors.New("error reading 'check' (required) condition")
+		}
+		qp.Conditions[k] = c
+	}
+
+	qp.Mutations = make([]store.Mutation, len(body.Mutations))
+	for k, v := range body.Mutations {
+		if v.Key == "" {
+			return errors.New("error reading 'key' (required) mutation")
+		}
+		m := store.Mutation{
+			Key:   v.Key,
+			Value: v.Value,
+			Metadata: store.Metadata{
+				ContentType:     v.ContentType,
+				ContentEncoding: v.ContentEncoding,
+				User:            v.Metadata,
+			},
+		}
+		switch v.Op {
+		case "set":
+			m.Operation = store.OperationSet
+		case "delete":
+			m.Operation = store.OperationDelete
+		default:
+			return errors.New("error reading 'op' (required) mutation")
+		}
+		if v.TTL != "" {
+			var err error
+			if m.TTL, err = time.ParseDuration(v.TTL); err != nil || m.TTL < 0 {
+				return errors.New("error reading 'ttl' (optional) mutation")
+			}
+		}
+		if !validMetadata(m.Metadata) {
+			return errors.New("error reading 'metadata' (optional) mutation")
+		}
+		if m.Operation == store.OperationSet && m.Value == nil {
+			m.Value = []byte{}
+		}
+		qp.Mutations[k] = m
+	}
+	return nil
+}
+
+// TxnQueryResult contains statistics about the query.
+type TxnQueryResult struct {
+	Params   TxnQueryParams
+	Duration string
+	Applied  bool
+	Versions []uint64
+}
+
+// EncodeTo encodes the TxnQueryResult to the HTTP response writer.
+// If the transaction wasn't applied, then the versions are the current versions
+// of the keys of the conditions.
+func (qr *TxnQueryResult) EncodeTo(w http.ResponseWriter) {
+	w.Header().Set(httpHeaderDuration, qr.Duration)
+	w.Header().Set(httpHeaderContentType, "application/json; charset=utf-8")
+
+	if !qr.Applied {
+		w.WriteHeader(http.StatusConflict)
+	}
+
+	result := struct {
+		Applied  bool     `json:"applied"`
+		Versions []uint64 `json:"versions"`
+	}{
+		Applied:  qr.Applied,
+		Versions: qr.Versions,
+	}
+	if result.Versions == nil {
+		result.Versions = []uint64{}
+	}
+
+	if err := json.NewEncoder(w).Encode(result); err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+}
+
+// errBatchTooLarge is returned when a batch has more operations than allowed.
+var errBatchTooLarge = errors.New("error reading batch (required) body, too large")
+
+// BatchQueryParams defines all the dimensions of a batch query. Operations that
+// are rejected are still kept, along with the status they're rejected with, so
+// that only they fail rather than the batch.
+type BatchQueryParams struct {
+	Operations []store.BatchOp
+	Rejected   []int
+	NDJSON     bool
+}
+
+// DecodeFrom populates a BatchQueryParams from a body that is either a JSON
+// array of operations or, if it's newline delimited, an operation per line.
+// There can be at most limit operations.
+func (qp *BatchQueryParams) DecodeFrom(r io.Reader, ndjson bool, limit int) error {
+	type operation struct {
+		Op              string            `json:"op"`
+		Key             string            `json:"key"`
+		Value           []byte            `json:"value"`
+		TTL             string            `json:"ttl"`
+		ContentType     string            `json:"content_type"`
+		ContentEncoding string            `json:"content_encoding"`
+		Metadata        map[string]string `json:"metadata"`
+	}
+
+	var (
+		body    []operation
+		decoder = json.NewDecoder(r)
+	)
+	qp.NDJSON = ndjson
+	if ndjson {
+		for {
+			var op operation
+			if err := decoder.Decode(&op); err == io.EOF {
+				break
+			} else if err != nil {
+				return errors.New("error reading batch (required) body")
+			}
+			if len(body) == limit {
+				return errBatchTooLarge
+			}
+			body = append(body, op)
+		}
+	} else if err := decoder.Decode(&body); err != nil {
+		return errors.New("error reading batch (required) body")
+	}
+	if len(body) > limit {
+		return errBatchTooLarge
+	}
+
+	qp.Operations = make([]store.BatchOp, len(body))
+	qp.Rejected = make([]int, len(body))
+	for k, v := range body {
+		op := store.BatchOp{
+			Key:   v.Key,
+			Value: v.Value,
+			Metadata: store.Metadata{
+				ContentType:     v.ContentType,
+				ContentEncoding: v.ContentEncoding,
+				User:            v.Metadata,
+			},
+		}
+		switch v.Op {
+		case "get":
+			op.Type = store.BatchGet
+		case "set":
+			op.Type = store.BatchSet
+		case "delete":
+			op.Type = store.BatchDelete
+		default:
+			qp.Rejected[k] = http.StatusBadRequest
+		}
+		if v.TTL != "" {
+			var err error
+			if op.TTL, err = time.ParseDuration(v.TTL); err != nil || op.TTL < 0 {
+				qp.Rejected[k] = http.StatusBadRequest
+			}
+		}
+		if v.Key == "" || !validMetadata(op.Metadata) {
+			qp.Rejected[k] = http.StatusBadRequest
+		}
+		if op.Type == store.BatchSet && op.Value == nil {
+			op.Value = []byte{}
+		}
+		qp.Operations[k] = op
+	}
+	return nil
+}
+
+// Valid returns the operations that aren't rejected, which are the only
+// operations that are applied.
+func (qp BatchQueryParams) Valid() []store.BatchOp {
+	ops := make([]store.BatchOp, 0, len(qp.Operations))
+	for k, op := range qp.Operations {
+		if qp.Rejected[k] == 0 {
+			ops = append(ops, op)
+		}
+	}
+	return ops
+}
+
+// BatchQueryResult contains statistics about the query.
+type BatchQueryResult struct {
+	Params   BatchQueryParams
+	Duration string
+	Results  []store.BatchResult
+}
+
+// EncodeTo encodes the BatchQueryResult to the HTTP response writer. There is
+// a result for every operation, in the same order as the operations, each with
+// the status that the operation would have had on its own, either as a JSON
+// array or a result per line if the operations were newline delimited.
+// The results of the store are only for the operations that aren't rejected.
+func (qr *BatchQueryResult) EncodeTo(w http.ResponseWriter) {
+	w.Header().Set(httpHeaderDuration, qr.Duration)
+	if qr.Params.NDJSON {
+		w.Header().Set(httpHeaderContentType, "application/x-ndjson")
+	} else {
+		w.Header().Set(httpHeaderContentType, "application/json; charset=utf-8")
+	}
+
+	type result struct {
+		Status          int               `json:"status"`
+		Key             string            `json:"key"`
+		Value           []byte            `json:"value,omitempty"`
+		Version         uint64            `json:"version,omitempty"`
+		ContentType     string            `json:"content_type,omitempty"`
+		ContentEncoding string            `json:"content_encoding,omitempty"`
+		Metadata        map[string]string `json:"metadata,omitempty"`
+	}
+	results := make([]result, len(qr.Params.Operations))
+	var n int
+	for k, op := range qr.Params.Operations {
+		results[k].Key = op.Key
+		if status := qr.Params.Rejected[k]; status != 0 {
+			results[k].Status = status
+			continue
+		}
+
+		v := qr.Results[n]
+		n++
+
+		switch op.Type {
+		case store.BatchGet:
+			if !v.OK {
+				results[k].Status = http.StatusNotFound
+				continue
+			}
+			results[k].Status = http.StatusOK
+			results[k].Value = v.Entry.Value
+			results[k].Version = v.Entry.Version
+			results[k].ContentType = v.Entry.Metadata.ContentType
+			results[k].ContentEncoding = v.Entry.Metadata.ContentEncoding
+			results[k].Metadata = v.Entry.Metadata.User
+		case store.BatchSet:
+			// Matches an insert, which is only created if it didn't replace
+			// a value.
+			if v.OK {
+				results[k].Status = http.StatusOK
+			} else {
+				results[k].Status = http.StatusCreated
+			}
+			results[k].Version = v.Entry.Version
+		case store.BatchDelete:
+			if v.OK {
+				results[k].Status = http.StatusOK
+			} else {
+				results[k].Status = http.StatusNotFound
+			}
+		}
+	}
+
+	encoder := json.NewEncoder(w)
+	if !qr.Params.NDJSON {
+		if err := encoder.Encode(results); err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+		return
+	}
+	for _, v := range results {
+		if err := encoder.Encode(v); err != nil {
+			return
+		}
+	}
+}
+
+// encodeEvent encodes the event as a server-sent event, the id of the event is
+// the version.
+func encodeEvent(w io.Writer, e store.Event) error {
+	data, err := json.Marshal(struct {
+		Key     string `json:"key"`
+		Value   []byte `json:"value,omitempty"`
+		Version uint64 `json:"version"`
+	}{
+		Key:     e.Key,
+		Value:   e.Value,
+		Version: e.Version,
+	})
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", e.Version, e.Type, data)
+	return err
+}
+
+// SnapshotQueryResult contains statistics about the query.
+type SnapshotQueryResult struct {
+	Duration string
+}
+
+// EncodeTo encodes the SnapshotQueryResult to the HTTP response writer.
+func (qr *SnapshotQueryResult) EncodeTo(w http.ResponseWriter) {
+	w.Header().Set(httpHeaderDuration, qr.Duration)
+}
+
+// StatsQueryResult contains statistics about the query.
+type StatsQueryResult struct {
+	Duration string
+	Stats    store.Stats
+}
+
+// EncodeTo encodes the StatsQueryResult to the HTTP response writer.
+func (qr *StatsQueryResult) EncodeTo(w http.ResponseWriter) {
+	w.Header().Set(httpHeaderDuration, qr.Duration)
+	w.Header().Set(httpHeaderContentType, "application/json; charset=utf-8")
+
+	result := struct {
+		Items     uint64 `json:"items"`
+		Bytes     uint64 `json:"bytes"`
+		Evictions uint64 `json:"evictions"`
+	}{
+		Items:     qr.Stats.Items,
+		Bytes:     qr.Stats.Bytes,
+		Evictions: qr.Stats.Evictions,
+	}
+
+	if err := json.NewEncoder(w).Encode(result); err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+}
+
+// ReportQueryResult contains statistics about the query.
+type ReportQueryResult struct {
+	Duration string
+	Stats    interface{}
+}
+
+// EncodeTo encodes the ReportQueryResult to the HTTP response writer.
+func (qr *ReportQueryResult) EncodeTo(w http.ResponseWriter) {
+	w.Header().Set(httpHeaderDuration, qr.Duration)
+	w.Header().Set(httpHeaderContentType, "application/json; charset=utf-8")
+
+	if err := json.NewEncoder(w).Encode(qr.Stats); err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+	}
 }
 
 const (
 	httpHeaderDuration = "X-Duration"
 	httpHeaderKey      = "X-Key"
+	httpHeaderETag     = "ETag"
+	httpHeaderIfMatch  = "If-Match"
+
+	httpHeaderIfNoneMatch     = "If-None-Match"
+	httpHeaderIfModifiedSince = "If-Modified-Since"
+	httpHeaderLastModified    = "Last-Modified"
+
+	httpHeaderContentType     = "Content-Type"
+	httpHeaderContentEncoding = "Content-Encoding"
+	httpHeaderContentLength   = "Content-Length"
+	httpHeaderContentRange    = "Content-Range"
+	httpHeaderAcceptRanges    = "Accept-Ranges"
+	httpHeaderRange           = "Range"
+	httpHeaderIfRange         = "If-Range"
+	httpHeaderMetaPrefix      = "X-Meta-"
+	httpHeaderCacheControl    = "Cache-Control"
+	httpHeaderAllow           = "Allow"
 )
 
+// formatKey formats the key in the same form as it was sent. A key from the
+// path is escaped as it would be with in the path, so that any key can be sent
+// as a header, whereas a key from the key query is sent as is, as it always
+// has been, unless it has control characters that can't be sent as a header.
+func formatKey(qp QueryParams) string {
+	if qp.path || !validHeader(qp.Key) {
+		return url.PathEscape(qp.Key)
+	}
+	return qp.Key
+}
+
+// validHeader returns true if the value can be sent as a header value as is,
+// i.e. it has no control characters other than tabs.
+func validHeader(value string) bool {
+	for i := 0; i < len(value); i++ {
+		if c := value[i]; (c < ' ' && c != '\t') || c == 0x7f {
+			return false
+		}
+	}
+	return true
+}
+
+// formatETag formats the version of a value as a strong entity tag.
+func formatETag(version uint64) string {
+	return fmt.Sprintf("%q", strconv.FormatUint(version, 10))
+}
+
+// parseETags parses a list of entity tags as versions, returning true if the
+// list matches any entity. Malformed entity tags are never able to match a
+// version, so they're skipped. Weak entity tags are skipped too, unless the
+// tags are compared weakly (i.e. If-None-Match), in which case they're treated
+// the same as strong entity tags.
+func parseETags(header string, weak bool) ([]uint64, bool) {
+	var versions []uint64
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimSpace(tag)
+		if tag == "*" {
+			return nil, true
+		}
+		if weak {
+			tag = strings.TrimPrefix(tag, "W/")
+		}
+
+		if len(tag) < 2 || tag[0] != '"' || tag[len(tag)-1] != '"' {
+			continue
+		}
+		version, err := strconv.ParseUint(tag[1:len(tag)-1], 10, 64)
+		if err != nil || version == 0 {
+			continue
+		}
+		versions = append(versions, version)
+	}
+	return versions, false
+}
+
+// matchETags returns true if the version of an existing value matches any of
+// the entity tags.
+func matchETags(version uint64, versions []uint64, wildcard bool) bool {
+	if version == 0 {
+		return false
+	}
+	if wildcard {
+		return true
+	}
+	for _, v := range versions {
+		if v == version {
+			return true
+		}
+	}
+	return false
+}
+
+// byteRange is a range of the bytes of a value, from start up to but not
+// including end.
+type byteRange struct {
+	start, end int
+}
+
+// parseRange parses the Range header for a value of the size. Only a single
+// range of bytes is supported, anything else (including several ranges or a
+// malformed range) is ignored by returning no range, so the whole of the value
+// is sent instead. Returns false if the range can't be satisfied by the value.
+func parseRange(header string, size int) (*byteRange, bool) {
+	const unit = "bytes="
+	if !strings.HasPrefix(header, unit) {
+		return nil, true
+	}
+	spec := strings.TrimSpace(strings.TrimPrefix(header, unit))
+	dash := strings.IndexByte(spec, '-')
+	if dash < 0 || strings.IndexByte(spec, ',') >= 0 {
+		return nil, true
+	}
+	first, last := strings.TrimSpace(spec[:dash]), strings.TrimSpace(spec[dash+1:])
+
+	// A suffix range is the last n bytes of the value.
+	if first == "" {
+		n, err := strconv.ParseUint(last, 10, 64)
+		if err != nil {
+			return nil, true
+		}
+		if n == 0 || size == 0 {
+			return nil, false
+		}
+		if n > uint64(size) {
+			n = uint64(size)
+		}
+		return &byteRange{size - int(n), size}, true
+	}
+
+	start, err := strconv.ParseUint(first, 10, 64)
+	if err != nil {
+		return nil, true
+	}
+	end := uint64(size)
+	if last != "" {
+		n, err := strconv.ParseUint(last, 10, 64)
+		if err != nil || n < start {
+			return nil, true
+		}
+		if n < end {
+			end = n + 1
+		}
+	}
+	if start >= uint64(size) {
+		return nil, false
+	}
+	return &byteRange{int(start), int(end)}, true
+}
+
 type queryBehavior int
 
 const (
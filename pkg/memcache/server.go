@@ -0,0 +1,569 @@
+package memcache
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/SimonRichardson/keyval/pkg/store"
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+)
+
+const (
+	// maxKeyLength is the longest key that memcached accepts.
+	maxKeyLength = 250
+	// maxItemSize is the largest value that can be stored, this matches the
+	// default of memcached.
+	maxItemSize = 1024 * 1024
+	// maxLineLength is the longest command line that can be read.
+	maxLineLength = 8 * 1024
+	// maxRelativeExptime is the largest exptime that is treated as relative to
+	// now, anything larger is treated as a unix timestamp.
+	maxRelativeExptime = 60 * 60 * 24 * 30
+)
+
+// version is reported by the version command.
+const version = "1.6.0-keyval"
+
+// Server represents a way to interact with the underlying key/val store using
+// the memcached ASCII protocol, so that existing memcached clients can be used.
+// The cas unique of an item is the version of the value with in the store.
+type Server struct {
+	store  store.Store
+	logger log.Logger
+}
+
+// NewServer creates a Server with the correct dependencies
+func NewServer(store store.Store, logger log.Logger) *Server {
+	return &Server{
+		store:  store,
+		logger: logger,
+	}
+}
+
+// Serve the listener for the server
+func (s *Server) Serve(listener net.Listener) error {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return err
+		}
+		go s.handleConn(conn)
+	}
+}
+
+// session holds the state of a connection.
+type session struct {
+	r    *bufio.Reader
+	w    *bufio.Writer
+	quit bool
+}
+
+func (s *Server) handleConn(conn net.Conn) {
+	defer conn.Close()
+
+	sess := &session{
+		r: bufio.NewReader(conn),
+		w: bufio.NewWriter(conn),
+	}
+	for !sess.quit {
+		line, err := readLine(sess.r)
+		if err != nil {
+			if err != io.EOF {
+				level.Debug(s.logger).Log("err", err)
+			}
+			return
+		}
+
+		if fields := strings.Fields(line); len(fields) > 0 {
+			s.handleCommand(sess, fields)
+		}
+
+		// Only flush once there are no more pipelined commands waiting, so
+		// the replies of pipelined commands are written together.
+		if sess.r.Buffered() > 0 {
+			continue
+		}
+		if err := sess.w.Flush(); err != nil {
+			return
+		}
+	}
+	sess.w.Flush()
+}
+
+func (s *Server) handleCommand(sess *session, fields []string) {
+	switch fields[0] {
+	case "get":
+		s.handleGet(sess, fields, false)
+	case "gets":
+		s.handleGet(sess, fields, true)
+	case "set", "add", "replace", "append", "prepend", "cas":
+		s.handleStorage(sess, fields)
+	case "delete":
+		s.handleDelete(sess, fields)
+	case "incr", "decr":
+		s.handleIncr(sess, fields)
+	case "touch":
+		s.handleTouch(sess, fields)
+	case "version":
+		sess.w.WriteString("VERSION " + version + "\r\n")
+	case "quit":
+		sess.quit = true
+	default:
+		sess.w.WriteString("ERROR\r\n")
+	}
+}
+
+// handleGet writes every item that is found, items that aren't found are
+// skipped.
+// get <key>*
+// gets <key>*
+func (s *Server) handleGet(sess *session, fields []string, cas bool) {
+	if len(fields) < 2 {
+		sess.w.WriteString("ERROR\r\n")
+		return
+	}
+
+	for _, key := range fields[1:] {
+		if !validKey(key) {
+			sess.w.WriteString("CLIENT_ERROR bad command line format\r\n")
+			return
+		}
+	}
+
+	for _, key := range fields[1:] {
+		entry, ok := s.store.Select(key)
+		if !ok {
+			continue
+		}
+
+		sess.w.WriteString("VALUE ")
+		sess.w.WriteString(key)
+		sess.w.WriteString(" ")
+		sess.w.WriteString(strconv.FormatUint(uint64(flagsOf(entry.Metadata)), 10))
+		sess.w.WriteString(" ")
+		sess.w.WriteString(strconv.Itoa(len(entry.Value)))
+		if cas {
+			sess.w.WriteString(" ")
+			sess.w.WriteString(strconv.FormatUint(entry.Version, 10))
+		}
+		sess.w.WriteString("\r\n")
+		sess.w.Write(entry.Value)
+		sess.w.WriteString("\r\n")
+	}
+	sess.w.WriteString("END\r\n")
+}
+
+// handleStorage handles all of the commands that store a value.
+// <command> <key> <flags> <exptime> <bytes> [noreply]
+// cas <key> <flags> <exptime> <bytes> <cas unique> [noreply]
+func (s *Server) handleStorage(sess *session, fields []string) {
+	command := fields[0]
+
+	args := 5
+	if command == "cas" {
+		args = 6
+	}
+	if len(fields) < args || len(fields) > args+1 {
+		sess.w.WriteString("ERROR\r\n")
+		return
+	}
+
+	var (
+		key           = fields[1]
+		flags, err0   = strconv.ParseUint(fields[2], 10, 32)
+		exptime, err1 = strconv.ParseInt(fields[3], 10, 64)
+		size, err2    = strconv.Atoi(fields[4])
+		unique        uint64
+		err3          error
+	)
+	if command == "cas" {
+		unique, err3 = strconv.ParseUint(fields[5], 10, 64)
+	}
+	noreply := len(fields) == args+1 && fields[args] == "noreply"
+	if !validKey(key) || err0 != nil || err1 != nil || err2 != nil || err3 != nil || size < 0 ||
+		(len(fields) == args+1 && !noreply) {
+		sess.w.WriteString("CLIENT_ERROR bad command line format\r\n")
+		return
+	}
+
+	// The data has to be read, even if it's too large, so that the next
+	// command can be read.
+	if size > maxItemSize {
+		if _, err := io.CopyN(io.Discard, sess.r, int64(size)+2); err != nil {
+			sess.quit = true
+			return
+		}
+		sess.w.WriteString("SERVER_ERROR object too large for cache\r\n")
+		return
+	}
+	data := make([]byte, size+2)
+	if _, err := io.ReadFull(sess.r, data); err != nil {
+		sess.quit = true
+		return
+	}
+	if data[size] != '\r' || data[size+1] != '\n' {
+		sess.w.WriteString("CLIENT_ERROR bad data chunk\r\n")
+		return
+	}
+	value := data[:size]
+
+	var reply string
+	switch command {
+	case "set":
+		reply = s.put(key, value, uint32(flags), exptime, nil)
+	case "add":
+		reply = s.put(key, value, uint32(flags), exptime, &store.Condition{
+			Key:   key,
+			Check: store.CheckMissing,
+		})
+	case "replace":
+		reply = s.put(key, value, uint32(flags), exptime, &store.Condition{
+			Key:   key,
+			Check: store.CheckExists,
+		})
+	case "cas":
+		reply = s.put(key, value, uint32(flags), exptime, &store.Condition{
+			Key:     key,
+			Check:   store.CheckVersion,
+			Version: unique,
+		})
+	case "append", "prepend":
+		reply = s.concat(key, value, command == "prepend")
+	}
+	if !noreply {
+		sess.w.WriteString(reply)
+	}
+}
+
+// put the value only if the condition holds, a condition of nil always holds.
+func (s *Server) put(key string, value []byte, flags uint32, exptime int64, condition *store.Condition) string {
+	ttl, expired := parseExptime(exptime)
+
+	mutation := store.Mutation{
+		Key:       key,
+		Operation: store.OperationSet,
+		Value:     value,
+		TTL:       ttl,
+		Metadata:  withFlags(flags),
+	}
+	// An item that has already expired is stored, but can never be read.
+	if expired {
+		mutation = store.Mutation{
+			Key:       key,
+			Operation: store.OperationDelete,
+		}
+	}
+
+	var conditions []store.Condition
+	if condition != nil {
+		conditions = append(conditions, *condition)
+	}
+
+	versions, ok := s.store.Txn(conditions, []store.Mutation{mutation})
+	if err := store.Err(s.store); err != nil {
+		return s.failed(err)
+	}
+	switch {
+	case ok:
+		return "STORED\r\n"
+	case condition == nil:
+		// Without a condition the item is only ever not stored when it can't
+		// fit with in a bounded store.
+		return "SERVER_ERROR out of memory storing object\r\n"
+	case condition.Check == store.CheckVersion && versions[0] == 0:
+		return "NOT_FOUND\r\n"
+	case condition.Check == store.CheckVersion && versions[0] == condition.Version:
+		return "SERVER_ERROR out of memory storing object\r\n"
+	case condition.Check == store.CheckVersion:
+		return "EXISTS\r\n"
+	default:
+		return "NOT_STORED\r\n"
+	}
+}
+
+// concat appends or prepends the value to the existing value, keeping the flags
+// and expiry of the existing value.
+func (s *Server) concat(key string, value []byte, prepend bool) string {
+	ok := s.update(key, func(entry store.Entry) ([]byte, bool) {
+		if prepend {
+			return append(append([]byte{}, value...), entry.Value...), true
+		}
+		return append(append([]byte{}, entry.Value...), value...), true
+	})
+	if err := store.Err(s.store); err != nil {
+		return s.failed(err)
+	} else if !ok {
+		return "NOT_STORED\r\n"
+	}
+	return "STORED\r\n"
+}
+
+// update changes the value of an existing key, keeping the flags and the
+// expiry of the existing value. The value is only changed if nothing else has
+// changed the value in the meantime, otherwise the update is tried again.
+// Returns false if the key doesn't exist, fn returns false or the store fails
+// to apply the update, e.g. the value can't fit with in a bounded store.
+func (s *Server) update(key string, fn func(store.Entry) ([]byte, bool)) bool {
+	for {
+		entry, ok := s.store.Select(key)
+		if !ok {
+			return false
+		}
+
+		var ttl time.Duration
+		if !entry.Expires.IsZero() {
+			if ttl = time.Until(entry.Expires); ttl <= 0 {
+				return false
+			}
+		}
+
+		value, ok := fn(entry)
+		if !ok {
+			return false
+		}
+
+		versions, ok := s.store.Txn([]store.Condition{{
+			Key:     key,
+			Check:   store.CheckVersion,
+			Version: entry.Version,
+		}}, []store.Mutation{{
+			Key:       key,
+			Operation: store.OperationSet,
+			Value:     value,
+			TTL:       ttl,
+			Metadata:  entry.Metadata,
+		}})
+		if ok {
+			return true
+		}
+		// The version still matching means that trying again won't help.
+		if versions[0] == entry.Version || store.Err(s.store) != nil {
+			return false
+		}
+	}
+}
+
+// delete <key> [noreply]
+func (s *Server) handleDelete(sess *session, fields []string) {
+	if len(fields) < 2 || len(fields) > 3 {
+		sess.w.WriteString("ERROR\r\n")
+		return
+	}
+	key := fields[1]
+	noreply := len(fields) == 3 && fields[2] == "noreply"
+	if !validKey(key) || (len(fields) == 3 && !noreply) {
+		sess.w.WriteString("CLIENT_ERROR bad command line format\r\n")
+		return
+	}
+
+	reply := "NOT_FOUND\r\n"
+	if s.store.Delete(key) {
+		reply = "DELETED\r\n"
+	} else if err := store.Err(s.store); err != nil {
+		reply = s.failed(err)
+	}
+	if !noreply {
+		sess.w.WriteString(reply)
+	}
+}
+
+// incr and decr treat the value as a decimal unsigned 64-bit integer, incr
+// wraps around and decr stops at zero.
+// incr <key> <value> [noreply]
+// decr <key> <value> [noreply]
+func (s *Server) handleIncr(sess *session, fields []string) {
+	if len(fields) < 3 || len(fields) > 4 {
+		sess.w.WriteString("ERROR\r\n")
+		return
+	}
+	key := fields[1]
+	delta, err := strconv.ParseUint(fields[2], 10, 64)
+	if err != nil {
+		sess.w.WriteString("CLIENT_ERROR invalid numeric delta argument\r\n")
+		return
+	}
+	noreply := len(fields) == 4 && fields[3] == "noreply"
+	if !validKey(key) || (len(fields) == 4 && !noreply) {
+		sess.w.WriteString("CLIENT_ERROR bad command line format\r\n")
+		return
+	}
+
+	var (
+		result  uint64
+		numeric = true
+	)
+	ok := s.update(key, func(entry store.Entry) ([]byte, bool) {
+		current, err := strconv.ParseUint(string(entry.Value), 10, 64)
+		if err != nil {
+			numeric = false
+			return nil, false
+		}
+		switch {
+		case fields[0] == "incr":
+			result = current + delta
+		case delta > current:
+			result = 0
+		default:
+			result = current - delta
+		}
+		return []byte(strconv.FormatUint(result, 10)), true
+	})
+
+	var reply string
+	switch err := store.Err(s.store); {
+	case err != nil:
+		reply = s.failed(err)
+	case !numeric:
+		reply = "CLIENT_ERROR cannot increment or decrement non-numeric value\r\n"
+	case !ok:
+		reply = "NOT_FOUND\r\n"
+	default:
+		reply = strconv.FormatUint(result, 10) + "\r\n"
+	}
+	if !noreply {
+		sess.w.WriteString(reply)
+	}
+}
+
+// touch <key> <exptime> [noreply]
+func (s *Server) handleTouch(sess *session, fields []string) {
+	if len(fields) < 3 || len(fields) > 4 {
+		sess.w.WriteString("ERROR\r\n")
+		return
+	}
+	key := fields[1]
+	exptime, err := strconv.ParseInt(fields[2], 10, 64)
+	noreply := len(fields) == 4 && fields[3] == "noreply"
+	if !validKey(key) || err != nil || (len(fields) == 4 && !noreply) {
+		sess.w.WriteString("CLIENT_ERROR bad command line format\r\n")
+		return
+	}
+
+	reply := "NOT_FOUND\r\n"
+	for {
+		entry, ok := s.store.Select(key)
+		if !ok {
+			break
+		}
+
+		ttl, expired := parseExptime(exptime)
+		mutation := store.Mutation{
+			Key:       key,
+			Operation: store.OperationSet,
+			Value:     entry.Value,
+			TTL:       ttl,
+			Metadata:  entry.Metadata,
+		}
+		if expired {
+			mutation = store.Mutation{
+				Key:       key,
+				Operation: store.OperationDelete,
+			}
+		}
+
+		versions, ok := s.store.Txn([]store.Condition{{
+			Key:     key,
+			Check:   store.CheckVersion,
+			Version: entry.Version,
+		}}, []store.Mutation{mutation})
+		if ok {
+			reply = "TOUCHED\r\n"
+			break
+		}
+		if err := store.Err(s.store); err != nil {
+			reply = s.failed(err)
+			break
+		}
+		if versions[0] == entry.Version {
+			reply = "SERVER_ERROR out of memory storing object\r\n"
+			break
+		}
+	}
+	if !noreply {
+		sess.w.WriteString(reply)
+	}
+}
+
+// failed returns the reply for a write that the store couldn't apply, even
+// though the write itself is valid.
+func (s *Server) failed(err error) string {
+	level.Error(s.logger).Log("err", err)
+	return "SERVER_ERROR unable to store item\r\n"
+}
+
+// parseExptime returns the ttl for the exptime, an exptime of zero never
+// expires, up to 30 days is relative to now, otherwise it's a unix timestamp.
+// Returns true if the exptime has already passed.
+func parseExptime(exptime int64) (time.Duration, bool) {
+	switch {
+	case exptime == 0:
+		return 0, false
+	case exptime < 0:
+		return 0, true
+	case exptime <= maxRelativeExptime:
+		return time.Duration(exptime) * time.Second, false
+	}
+	ttl := time.Until(time.Unix(exptime, 0))
+	return ttl, ttl <= 0
+}
+
+// validKey returns true if the key is within the length limit and has no
+// control characters, which memcached doesn't allow.
+func validKey(key string) bool {
+	if len(key) == 0 || len(key) > maxKeyLength {
+		return false
+	}
+	for k := 0; k < len(key); k++ {
+		if key[k] <= ' ' || key[k] == 0x7f {
+			return false
+		}
+	}
+	return true
+}
+
+// readLine reads up to the next \r\n, returning the line without it.
+func readLine(r *bufio.Reader) (string, error) {
+	var line []byte
+	for {
+		chunk, isPrefix, err := r.ReadLine()
+		if err != nil {
+			return "", err
+		}
+		line = append(line, chunk...)
+		if len(line) > maxLineLength {
+			return "", io.ErrShortBuffer
+		}
+		if !isPrefix {
+			return string(line), nil
+		}
+	}
+}
+
+// flagsMetadata is the name of the metadata that holds the flags of an item, so
+// that the flags are stored along with the value.
+const flagsMetadata = "memcache-flags"
+
+// flagsOf returns the flags of an item, values without flags have flags of
+// zero.
+func flagsOf(metadata store.Metadata) uint32 {
+	flags, _ := strconv.ParseUint(metadata.User[flagsMetadata], 10, 32)
+	return uint32(flags)
+}
+
+// withFlags returns the metadata of an item with the flags, flags of zero
+// aren't stored as zero is the default.
+func withFlags(flags uint32) store.Metadata {
+	if flags == 0 {
+		return store.Metadata{}
+	}
+	return store.Metadata{
+		User: map[string]string{
+			flagsMetadata: strconv.FormatUint(uint64(flags), 10),
+		},
+	}
+}
@@ -0,0 +1,337 @@
+package memcache
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+	"testing/quick"
+	"time"
+
+	keyvalStore "github.com/SimonRichardson/keyval/pkg/store"
+	"github.com/go-kit/kit/log"
+)
+
+func TestServer(t *testing.T) {
+	t.Parallel()
+
+	port := 9040
+
+	store := keyvalStore.New()
+	server := NewServer(store, log.NewNopLogger())
+	listener := setupServer(server, port)
+	defer listener.Close()
+
+	// Set up the cas unique of the item for the cas test.
+	store.Set("cas", []byte("a"))
+	entry, _ := store.Select("cas")
+	unique := entry.Version
+
+	for _, testcase := range []struct {
+		name     string
+		commands []string
+		expected []string
+	}{
+		{
+			name:     "set then get",
+			commands: []string{"set a 5 0 1\r\nb\r\n", "get a missing\r\n"},
+			expected: []string{"STORED\r\n", "VALUE a 5 1\r\nb\r\nEND\r\n"},
+		},
+		{
+			name:     "get multiple",
+			commands: []string{"set m1 0 0 1\r\na\r\n", "set m2 0 0 2\r\nbc\r\n", "get m1 m2\r\n"},
+			expected: []string{"STORED\r\n", "STORED\r\n", "VALUE m1 0 1\r\na\r\nVALUE m2 0 2\r\nbc\r\nEND\r\n"},
+		},
+		{
+			name: "add and replace",
+			commands: []string{
+				"replace r 0 0 1\r\na\r\n",
+				"add r 0 0 1\r\na\r\n",
+				"add r 0 0 1\r\nb\r\n",
+				"replace r 0 0 1\r\nc\r\n",
+				"get r\r\n",
+			},
+			expected: []string{
+				"NOT_STORED\r\n",
+				"STORED\r\n",
+				"NOT_STORED\r\n",
+				"STORED\r\n",
+				"VALUE r 0 1\r\nc\r\nEND\r\n",
+			},
+		},
+		{
+			name: "append and prepend",
+			commands: []string{
+				"append p 0 0 1\r\na\r\n",
+				"set p 7 0 1\r\nb\r\n",
+				"append p 0 0 1\r\nc\r\n",
+				"prepend p 0 0 1\r\na\r\n",
+				"get p\r\n",
+			},
+			expected: []string{
+				"NOT_STORED\r\n",
+				"STORED\r\n",
+				"STORED\r\n",
+				"STORED\r\n",
+				"VALUE p 7 3\r\nabc\r\nEND\r\n",
+			},
+		},
+		{
+			name: "cas",
+			commands: []string{
+				"gets cas\r\n",
+				fmt.Sprintf("cas cas 0 0 1 %d\r\nb\r\n", unique+1000),
+				fmt.Sprintf("cas cas 0 0 1 %d\r\nb\r\n", unique),
+				fmt.Sprintf("cas cas 0 0 1 %d\r\nc\r\n", unique),
+				"cas missing 0 0 1 1\r\nc\r\n",
+				"get cas\r\n",
+			},
+			expected: []string{
+				fmt.Sprintf("VALUE cas 0 1 %d\r\na\r\nEND\r\n", unique),
+				"EXISTS\r\n",
+				"STORED\r\n",
+				"EXISTS\r\n",
+				"NOT_FOUND\r\n",
+				"VALUE cas 0 1\r\nb\r\nEND\r\n",
+			},
+		},
+		{
+			name:     "delete",
+			commands: []string{"set d 0 0 1\r\na\r\n", "delete d\r\n", "delete d\r\n", "get d\r\n"},
+			expected: []string{"STORED\r\n", "DELETED\r\n", "NOT_FOUND\r\n", "END\r\n"},
+		},
+		{
+			name: "incr and decr",
+			commands: []string{
+				"incr i 1\r\n",
+				"set i 3 0 2\r\n10\r\n",
+				"incr i 5\r\n",
+				"decr i 3\r\n",
+				"decr i 100\r\n",
+				"get i\r\n",
+				"set n 0 0 1\r\na\r\n",
+				"incr n 1\r\n",
+				"incr i a\r\n",
+			},
+			expected: []string{
+				"NOT_FOUND\r\n",
+				"STORED\r\n",
+				"15\r\n",
+				"12\r\n",
+				"0\r\n",
+				"VALUE i 3 1\r\n0\r\nEND\r\n",
+				"STORED\r\n",
+				"CLIENT_ERROR cannot increment or decrement non-numeric value\r\n",
+				"CLIENT_ERROR invalid numeric delta argument\r\n",
+			},
+		},
+		{
+			name: "touch",
+			commands: []string{
+				"touch t 100\r\n",
+				"set t 0 0 1\r\na\r\n",
+				"touch t 100\r\n",
+				"touch t -1\r\n",
+				"get t\r\n",
+			},
+			expected: []string{"NOT_FOUND\r\n", "STORED\r\n", "TOUCHED\r\n", "TOUCHED\r\n", "END\r\n"},
+		},
+		{
+			name: "touch keeps flags",
+			commands: []string{
+				"set f 9 0 1\r\na\r\n",
+				"touch f 100\r\n",
+				"get f\r\n",
+			},
+			expected: []string{"STORED\r\n", "TOUCHED\r\n", "VALUE f 9 1\r\na\r\nEND\r\n"},
+		},
+		{
+			name:     "expired exptime",
+			commands: []string{"set e 0 -1 1\r\na\r\n", "get e\r\n"},
+			expected: []string{"STORED\r\n", "END\r\n"},
+		},
+		{
+			name: "noreply",
+			commands: []string{
+				"set q 0 0 1 noreply\r\na\r\nappend q 0 0 1 noreply\r\nb\r\ndelete missing noreply\r\nget q\r\n",
+			},
+			expected: []string{"VALUE q 0 2\r\nab\r\nEND\r\n"},
+		},
+		{
+			name: "errors",
+			commands: []string{
+				"unknown\r\n",
+				"set a b 0 1\r\n",
+				"set a 0 0 1\r\nabc\r\n",
+				fmt.Sprintf("get %s\r\n", strings.Repeat("a", maxKeyLength+1)),
+				"version\r\n",
+			},
+			expected: []string{
+				"ERROR\r\n",
+				"CLIENT_ERROR bad command line format\r\n",
+				"CLIENT_ERROR bad data chunk\r\n",
+				"CLIENT_ERROR bad command line format\r\n",
+				"VERSION " + version + "\r\n",
+			},
+		},
+	} {
+		t.Run(testcase.name, func(t *testing.T) {
+			conn := dial(t, port)
+			defer conn.Close()
+
+			for k, command := range testcase.commands {
+				if expected, actual := testcase.expected[k], conn.do(t, command); expected != actual {
+					t.Errorf("%q expected: %q, actual: %q", command, expected, actual)
+				}
+			}
+		})
+	}
+}
+
+func TestServerExptime(t *testing.T) {
+	t.Parallel()
+
+	port := 9041
+
+	server := NewServer(keyvalStore.New(), log.NewNopLogger())
+	listener := setupServer(server, port)
+	defer listener.Close()
+
+	conn := dial(t, port)
+	defer conn.Close()
+
+	for _, command := range []string{
+		"set e 0 1 1\r\na\r\n",
+		fmt.Sprintf("set u 0 %d 1\r\na\r\n", time.Now().Add(time.Second).Unix()),
+		"set n 0 0 1\r\na\r\n",
+	} {
+		if expected, actual := "STORED\r\n", conn.do(t, command); expected != actual {
+			t.Errorf("expected: %q, actual: %q", expected, actual)
+		}
+	}
+
+	time.Sleep(time.Second * 2)
+
+	if expected, actual := "VALUE n 0 1\r\na\r\nEND\r\n", conn.do(t, "get e u n\r\n"); expected != actual {
+		t.Errorf("expected: %q, actual: %q", expected, actual)
+	}
+}
+
+func TestServerBounded(t *testing.T) {
+	t.Parallel()
+
+	port := 9042
+
+	server := NewServer(keyvalStore.NewBounded(keyvalStore.Limits{MaxBytes: 16}), log.NewNopLogger())
+	listener := setupServer(server, port)
+	defer listener.Close()
+
+	conn := dial(t, port)
+	defer conn.Close()
+
+	large := strings.Repeat("a", 32)
+	for _, testcase := range []struct {
+		command  string
+		expected string
+	}{
+		{"set a 0 0 1\r\nb\r\n", "STORED\r\n"},
+		{"set l 0 0 32\r\n" + large + "\r\n", "SERVER_ERROR out of memory storing object\r\n"},
+		{"append a 0 0 32\r\n" + large + "\r\n", "NOT_STORED\r\n"},
+		{"get a l\r\n", "VALUE a 0 1\r\nb\r\nEND\r\n"},
+	} {
+		if actual := conn.do(t, testcase.command); testcase.expected != actual {
+			t.Errorf("%q expected: %q, actual: %q", testcase.command, testcase.expected, actual)
+		}
+	}
+}
+
+func TestParseExptime(t *testing.T) {
+	t.Parallel()
+
+	t.Run("relative", func(t *testing.T) {
+		fn := func(a uint16) bool {
+			exptime := int64(a) + 1
+			ttl, expired := parseExptime(exptime)
+			return !expired && ttl == time.Duration(exptime)*time.Second
+		}
+		if err := quick.Check(fn, nil); err != nil {
+			t.Error(err)
+		}
+	})
+
+	t.Run("absolute", func(t *testing.T) {
+		ttl, expired := parseExptime(time.Now().Add(time.Hour * 24 * 60).Unix())
+		if expected, actual := false, expired; expected != actual {
+			t.Errorf("expected: %v, actual: %v", expected, actual)
+		}
+		if ttl < time.Hour*24*59 {
+			t.Errorf("expected: %v, actual: %v", time.Hour*24*60, ttl)
+		}
+
+		if _, expired := parseExptime(maxRelativeExptime + 1); !expired {
+			t.Errorf("expected: %v, actual: %v", true, expired)
+		}
+	})
+
+	t.Run("never", func(t *testing.T) {
+		ttl, expired := parseExptime(0)
+		if expected, actual := time.Duration(0), ttl; expected != actual {
+			t.Errorf("expected: %v, actual: %v", expected, actual)
+		}
+		if expected, actual := false, expired; expected != actual {
+			t.Errorf("expected: %v, actual: %v", expected, actual)
+		}
+	})
+}
+
+func setupServer(server *Server, port int) net.Listener {
+	apiListener, err := net.Listen("tcp", fmt.Sprintf("0.0.0.0:%d", port))
+	if err != nil {
+		panic(err)
+	}
+	go server.Serve(apiListener)
+	return apiListener
+}
+
+type conn struct {
+	net.Conn
+	reader *bufio.Reader
+}
+
+func dial(t *testing.T, port int) *conn {
+	c, err := net.Dial("tcp", fmt.Sprintf("0.0.0.0:%d", port))
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.SetDeadline(time.Now().Add(time.Second * 5))
+	return &conn{
+		Conn:   c,
+		reader: bufio.NewReader(c),
+	}
+}
+
+// do sends the command and returns the raw reply, replies to retrievals are
+// read up until the END.
+func (c *conn) do(t *testing.T, command string) string {
+	if _, err := fmt.Fprint(c, command); err != nil {
+		t.Fatal(err)
+	}
+
+	var reply string
+	for {
+		line, err := c.reader.ReadString('\n')
+		if err != nil {
+			t.Fatal(err)
+		}
+		reply += line
+		if !strings.HasPrefix(line, "VALUE ") {
+			return reply
+		}
+		data, err := c.reader.ReadString('\n')
+		if err != nil {
+			t.Fatal(err)
+		}
+		reply += data
+	}
+}
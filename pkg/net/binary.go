@@ -0,0 +1,274 @@
+package net
+
+import (
+	"encoding/binary"
+	"io"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// The binary protocol is a compact alternative to the codecs, built for the
+// operations on a single key. Every message, request or response, is a fixed
+// header followed by the key and the value (see binary.md for the spec).
+//
+//	+---------+--------+--------+--------+------------+----------+
+//	| version | opcode | status | flags  | key length | reserved |
+//	| 1 byte  | 1 byte | 1 byte | 1 byte | 2 bytes    | 2 bytes  |
+//	+---------+--------+--------+--------+------------+----------+
+//	| value length | ttl (ms) | request id | entry version | key | value |
+//	| 4 bytes      | 4 bytes  | 8 bytes    | 8 bytes       |     |       |
+//	+--------------+----------+------------+---------------+-----+-------+
+//
+// All numbers are big endian.
+const (
+	// BinaryVersion is the version of the protocol, a message with any other
+	// version is rejected.
+	BinaryVersion = 1
+	// BinaryHeaderSize is the size of the header of every message.
+	BinaryHeaderSize = 32
+	// MaxBinaryKeySize is the largest key that fits in the header.
+	MaxBinaryKeySize = 1<<16 - 1
+	// DefaultMaxBinaryValueSize is the largest value that is read by default.
+	DefaultMaxBinaryValueSize = 64 * 1024 * 1024
+
+	maxBinaryTTL = (1<<32 - 1) * time.Millisecond
+)
+
+var (
+	// ErrBinaryVersion is returned when the version of a message isn't
+	// supported.
+	ErrBinaryVersion = errors.New("binary version not supported")
+	// ErrBinaryMalformed is returned when the header of a message is invalid.
+	ErrBinaryMalformed = errors.New("binary message malformed")
+	// ErrBinaryTooLarge is returned when the key or the value of a message is
+	// larger than can be sent or read.
+	ErrBinaryTooLarge = errors.New("binary message too large")
+)
+
+// Opcode represents the operation of a binary message
+type Opcode uint8
+
+const (
+	// OpGet returns the value and version of the key.
+	OpGet Opcode = iota + 1
+	// OpSet stores the value for the key, with an optional ttl.
+	OpSet
+	// OpDelete removes the key.
+	OpDelete
+	// OpCompareAndSwap stores the value if the version of the key matches.
+	OpCompareAndSwap
+	// OpCompareAndDelete removes the key if the version of the key matches.
+	OpCompareAndDelete
+)
+
+func (o Opcode) String() string {
+	switch o {
+	case OpGet:
+		return "get"
+	case OpSet:
+		return "set"
+	case OpDelete:
+		return "delete"
+	case OpCompareAndSwap:
+		return "cas"
+	case OpCompareAndDelete:
+		return "cad"
+	default:
+		return "unknown"
+	}
+}
+
+// Method returns the Method of the query for the opcode.
+func (o Opcode) Method() (Method, bool) {
+	switch o {
+	case OpGet:
+		return Select, true
+	case OpSet:
+		return Insert, true
+	case OpDelete:
+		return Delete, true
+	case OpCompareAndSwap:
+		return CompareAndSwap, true
+	case OpCompareAndDelete:
+		return CompareAndDelete, true
+	default:
+		return 0, false
+	}
+}
+
+// BinaryFlags changes how a binary message is handled
+type BinaryFlags uint8
+
+const (
+	// FlagQuiet asks for no response unless the request fails, so that many
+	// writes can be sent without waiting for each of them.
+	FlagQuiet BinaryFlags = 1 << iota
+
+	binaryFlagsMask = FlagQuiet
+)
+
+// BinaryMessage represents either a request or a response of the binary
+// protocol. Requests have a status of OK.
+type BinaryMessage struct {
+	Opcode  Opcode
+	Status  Status
+	Flags   BinaryFlags
+	ID      uint64
+	Version uint64
+	TTL     time.Duration
+	Key     string
+	Value   []byte
+}
+
+// BinaryHeader represents the fixed part of a binary message, before the key
+// and value.
+type BinaryHeader struct {
+	Opcode      Opcode
+	Status      Status
+	Flags       BinaryFlags
+	KeyLength   int
+	ValueLength int
+	TTL         time.Duration
+	ID          uint64
+	Version     uint64
+}
+
+// ParseBinaryHeader parses the header from the start of b, which has to be at
+// least BinaryHeaderSize long.
+func ParseBinaryHeader(b []byte) (BinaryHeader, error) {
+	if len(b) < BinaryHeaderSize {
+		return BinaryHeader{}, ErrBinaryMalformed
+	}
+	if b[0] != BinaryVersion {
+		return BinaryHeader{}, ErrBinaryVersion
+	}
+	h := BinaryHeader{
+		Opcode:      Opcode(b[1]),
+		Status:      Status(b[2]),
+		Flags:       BinaryFlags(b[3]),
+		KeyLength:   int(binary.BigEndian.Uint16(b[4:])),
+		ValueLength: int(binary.BigEndian.Uint32(b[8:])),
+		TTL:         time.Duration(binary.BigEndian.Uint32(b[12:])) * time.Millisecond,
+		ID:          binary.BigEndian.Uint64(b[16:]),
+		Version:     binary.BigEndian.Uint64(b[24:]),
+	}
+	// The reserved bytes and flags have to be zero, so that they can be used
+	// by later versions.
+	if h.Flags&^binaryFlagsMask != 0 || binary.BigEndian.Uint16(b[6:]) != 0 {
+		return BinaryHeader{}, ErrBinaryMalformed
+	}
+	return h, nil
+}
+
+// AppendBinaryHeader appends the header of the message to b.
+func AppendBinaryHeader(b []byte, m BinaryMessage) ([]byte, error) {
+	if len(m.Key) > MaxBinaryKeySize || uint64(len(m.Value)) > 1<<32-1 {
+		return b, ErrBinaryTooLarge
+	}
+	if m.TTL < 0 || m.TTL > maxBinaryTTL {
+		return b, ErrBinaryMalformed
+	}
+	// The ttl rounds up to the next millisecond, so that a ttl of less than
+	// a millisecond isn't sent as no ttl at all.
+	ttl := (m.TTL + time.Millisecond - 1) / time.Millisecond
+
+	var h [BinaryHeaderSize]byte
+	h[0] = BinaryVersion
+	h[1] = byte(m.Opcode)
+	h[2] = byte(m.Status)
+	h[3] = byte(m.Flags)
+	binary.BigEndian.PutUint16(h[4:], uint16(len(m.Key)))
+	binary.BigEndian.PutUint32(h[8:], uint32(len(m.Value)))
+	binary.BigEndian.PutUint32(h[12:], uint32(ttl))
+	binary.BigEndian.PutUint64(h[16:], m.ID)
+	binary.BigEndian.PutUint64(h[24:], m.Version)
+	return append(b, h[:]...), nil
+}
+
+// BinaryEncoder writes binary messages to a writer. The value is written
+// straight from the message, so that it isn't copied for the encoding.
+type BinaryEncoder struct {
+	w   io.Writer
+	buf []byte
+}
+
+// NewBinaryEncoder creates a BinaryEncoder for the writer, the writer should
+// be buffered as the header, key and value are written separately.
+func NewBinaryEncoder(w io.Writer) *BinaryEncoder {
+	return &BinaryEncoder{
+		w:   w,
+		buf: make([]byte, 0, BinaryHeaderSize+64),
+	}
+}
+
+// Encode writes the message.
+func (e *BinaryEncoder) Encode(m BinaryMessage) error {
+	b, err := AppendBinaryHeader(e.buf[:0], m)
+	if err != nil {
+		return err
+	}
+	e.buf = append(b, m.Key...)
+	if _, err := e.w.Write(e.buf); err != nil {
+		return err
+	}
+	if len(m.Value) > 0 {
+		_, err = e.w.Write(m.Value)
+	}
+	return err
+}
+
+// BinaryDecoder reads binary messages from a reader. The key and value of
+// every message are read into a single allocation and the value is a slice of
+// it, so it can be handed on without being copied again.
+type BinaryDecoder struct {
+	r            io.Reader
+	maxValueSize int
+	header       [BinaryHeaderSize]byte
+}
+
+// NewBinaryDecoder creates a BinaryDecoder for the reader, a value that's
+// larger than maxValueSize is rejected with ErrBinaryTooLarge.
+func NewBinaryDecoder(r io.Reader, maxValueSize int) *BinaryDecoder {
+	return &BinaryDecoder{
+		r:            r,
+		maxValueSize: maxValueSize,
+	}
+}
+
+// Decode reads the next message. If the header could be read, but not the
+// rest of the message, then the message still holds the fields of the header
+// along with the error, so that the error can be sent back with the ID.
+func (d *BinaryDecoder) Decode(m *BinaryMessage) error {
+	*m = BinaryMessage{}
+	if _, err := io.ReadFull(d.r, d.header[:]); err != nil {
+		return err
+	}
+	h, err := ParseBinaryHeader(d.header[:])
+	if err != nil {
+		return err
+	}
+
+	*m = BinaryMessage{
+		Opcode:  h.Opcode,
+		Status:  h.Status,
+		Flags:   h.Flags,
+		ID:      h.ID,
+		Version: h.Version,
+		TTL:     h.TTL,
+	}
+	if h.ValueLength > d.maxValueSize {
+		return ErrBinaryTooLarge
+	}
+	if n := h.KeyLength + h.ValueLength; n > 0 {
+		buf, err := readMessage(d.r, int64(n))
+		if err != nil {
+			return err
+		}
+		m.Key = string(buf[:h.KeyLength])
+		if h.ValueLength > 0 {
+			m.Value = buf[h.KeyLength:]
+		}
+	}
+	return nil
+}
@@ -0,0 +1,167 @@
+package net
+
+import (
+	"bytes"
+	"io"
+	"reflect"
+	"testing"
+	"testing/quick"
+	"time"
+)
+
+func TestBinary(t *testing.T) {
+	t.Parallel()
+
+	t.Run("encode and decode", func(t *testing.T) {
+		fn := func(op, status uint8, quiet bool, id, version uint64, ttl uint32, key string, value []byte) bool {
+			expected := BinaryMessage{
+				Opcode:  Opcode(op),
+				Status:  Status(status),
+				ID:      id,
+				Version: version,
+				TTL:     time.Duration(ttl) * time.Millisecond,
+				Key:     key,
+				Value:   normalizeBytes(value),
+			}
+			if quiet {
+				expected.Flags = FlagQuiet
+			}
+
+			var buf bytes.Buffer
+			if err := NewBinaryEncoder(&buf).Encode(expected); err != nil {
+				t.Fatal(err)
+			}
+			if expected, actual := BinaryHeaderSize+len(key)+len(value), buf.Len(); expected != actual {
+				t.Errorf("expected: %v, actual: %v", expected, actual)
+			}
+
+			var actual BinaryMessage
+			if err := NewBinaryDecoder(&buf, DefaultMaxBinaryValueSize).Decode(&actual); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(expected, actual) {
+				t.Logf("expected: %v, actual: %v", expected, actual)
+				return false
+			}
+			return true
+		}
+		if err := quick.Check(fn, nil); err != nil {
+			t.Error(err)
+		}
+	})
+
+	t.Run("ttl rounds up", func(t *testing.T) {
+		b, err := AppendBinaryHeader(nil, BinaryMessage{TTL: time.Microsecond})
+		if err != nil {
+			t.Fatal(err)
+		}
+		h, err := ParseBinaryHeader(b)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if expected, actual := time.Millisecond, h.TTL; expected != actual {
+			t.Errorf("expected: %v, actual: %v", expected, actual)
+		}
+	})
+
+	t.Run("encode invalid", func(t *testing.T) {
+		for _, testcase := range []struct {
+			message  BinaryMessage
+			expected error
+		}{
+			{BinaryMessage{Key: string(make([]byte, MaxBinaryKeySize+1))}, ErrBinaryTooLarge},
+			{BinaryMessage{TTL: -time.Second}, ErrBinaryMalformed},
+			{BinaryMessage{TTL: maxBinaryTTL + time.Millisecond}, ErrBinaryMalformed},
+		} {
+			if _, err := AppendBinaryHeader(nil, testcase.message); err != testcase.expected {
+				t.Errorf("expected: %v, actual: %v", testcase.expected, err)
+			}
+		}
+	})
+
+	t.Run("decode malformed", func(t *testing.T) {
+		header := func(fn func(b []byte)) []byte {
+			b, _ := AppendBinaryHeader(nil, BinaryMessage{Opcode: OpGet, ID: 7})
+			fn(b)
+			return b
+		}
+		for _, testcase := range []struct {
+			name     string
+			b        []byte
+			expected error
+		}{
+			{"version", header(func(b []byte) { b[0] = BinaryVersion + 1 }), ErrBinaryVersion},
+			{"flags", header(func(b []byte) { b[3] = 0x80 }), ErrBinaryMalformed},
+			{"reserved", header(func(b []byte) { b[7] = 1 }), ErrBinaryMalformed},
+			{"short header", header(func(b []byte) {})[:BinaryHeaderSize-1], io.ErrUnexpectedEOF},
+			{"short key", header(func(b []byte) { b[5] = 4 }), io.ErrUnexpectedEOF},
+			{"value too large", header(func(b []byte) { b[9] = 1 }), ErrBinaryTooLarge},
+		} {
+			var m BinaryMessage
+			if err := NewBinaryDecoder(bytes.NewReader(testcase.b), 1024).Decode(&m); err != testcase.expected {
+				t.Errorf("%s: expected: %v, actual: %v", testcase.name, testcase.expected, err)
+			}
+		}
+	})
+
+	t.Run("header is kept on error", func(t *testing.T) {
+		b, _ := AppendBinaryHeader(nil, BinaryMessage{Opcode: OpSet, ID: 7, Value: make([]byte, 2048)})
+
+		var m BinaryMessage
+		if err := NewBinaryDecoder(bytes.NewReader(b), 1024).Decode(&m); err != ErrBinaryTooLarge {
+			t.Fatalf("expected: %v, actual: %v", ErrBinaryTooLarge, err)
+		}
+		if expected, actual := uint64(7), m.ID; expected != actual {
+			t.Errorf("expected: %v, actual: %v", expected, actual)
+		}
+	})
+
+	t.Run("end of stream", func(t *testing.T) {
+		var m BinaryMessage
+		if err := NewBinaryDecoder(bytes.NewReader(nil), 1024).Decode(&m); err != io.EOF {
+			t.Errorf("expected: %v, actual: %v", io.EOF, err)
+		}
+	})
+}
+
+func FuzzBinaryDecoder(f *testing.F) {
+	for _, m := range []BinaryMessage{
+		{Opcode: OpGet, ID: 1, Key: "a"},
+		{Opcode: OpSet, ID: 2, Key: "abc", Value: []byte("def"), TTL: time.Second},
+		{Opcode: OpCompareAndSwap, Flags: FlagQuiet, ID: 3, Version: 4, Key: "a", Value: []byte("b")},
+		{Opcode: OpGet, Status: NotFound, ID: 5},
+	} {
+		var buf bytes.Buffer
+		NewBinaryEncoder(&buf).Encode(m)
+		f.Add(buf.Bytes())
+	}
+
+	f.Fuzz(func(t *testing.T, b []byte) {
+		var (
+			dec      = NewBinaryDecoder(bytes.NewReader(b), 1024)
+			messages []BinaryMessage
+		)
+		for {
+			var m BinaryMessage
+			if err := dec.Decode(&m); err != nil {
+				break
+			}
+			if len(m.Value) > 1024 {
+				t.Fatalf("expected: at most %v, actual: %v", 1024, len(m.Value))
+			}
+			messages = append(messages, m)
+		}
+
+		// Whatever was decoded encodes back to the same bytes.
+		var buf bytes.Buffer
+		enc := NewBinaryEncoder(&buf)
+		for _, m := range messages {
+			if err := enc.Encode(m); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if expected, actual := b[:buf.Len()], buf.Bytes(); !bytes.Equal(expected, actual) {
+			t.Errorf("expected: %v, actual: %v", expected, actual)
+		}
+	})
+}
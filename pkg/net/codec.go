@@ -0,0 +1,486 @@
+package net
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/gob"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+// Queries and results can be encoded with any of the codecs. The codec is
+// picked by a handshake at the start of a tcp connection, or at the start of
+// every udp message, made up of the handshake magic followed by the ID of the
+// codec. Without a handshake gob is used, so existing clients keep working.
+//
+//	+-------+----------+
+//	| magic | codec id |
+//	| 0xcb  | 1 byte   |
+//	+-------+----------+
+//
+// Over tcp the server replies with the same two bytes once it accepts the
+// codec, or with an ID of zero before closing the connection if it doesn't.
+const (
+	// HandshakeMagic is the first byte of a handshake, a gob stream can't
+	// start with it.
+	HandshakeMagic = 0xcb
+	// HandshakeSize is the size of a handshake.
+	HandshakeSize = 2
+)
+
+var (
+	// ErrUnknownCodec is returned when the handshake is for a codec that
+	// isn't supported.
+	ErrUnknownCodec = errors.New("unknown codec")
+	// ErrCodecMessageTooLarge is returned when a length prefixed message is
+	// larger than the maximum message size of the decoder.
+	ErrCodecMessageTooLarge = errors.New("codec message too large")
+)
+
+// Codec represents a way of encoding queries and results on the wire
+type Codec interface {
+	// ID returns the byte that identifies the codec in a handshake.
+	ID() byte
+
+	// String returns the name of the codec.
+	String() string
+
+	// NewEncoder creates an encoder for a stream of either queries or
+	// results.
+	NewEncoder(w io.Writer) MessageEncoder
+
+	// NewDecoder creates a decoder for a stream of either queries or
+	// results, messages are limited to DefaultMaxMessageSize.
+	NewDecoder(r io.Reader) MessageDecoder
+}
+
+// sizedCodec defines a codec that can limit the size of the messages that are
+// decoded, before any of the message is read.
+type sizedCodec interface {
+	newDecoderSize(r io.Reader, size int) MessageDecoder
+}
+
+// NewDecoderSize creates a decoder for the codec, where a message that is
+// larger than the size is rejected with ErrCodecMessageTooLarge. Every codec
+// is limited in its own way, see the newDecoderSize of each codec.
+func NewDecoderSize(c Codec, r io.Reader, size int) MessageDecoder {
+	if s, ok := c.(sizedCodec); ok {
+		return s.newDecoderSize(r, size)
+	}
+	return c.NewDecoder(r)
+}
+
+// MessageEncoder encodes either a Query or a Result.
+type MessageEncoder interface {
+	Encode(v interface{}) error
+}
+
+// MessageDecoder decodes into either a *Query or a *Result.
+type MessageDecoder interface {
+	Decode(v interface{}) error
+}
+
+var (
+	// Gob encodes messages as a gob stream, which is only really usable from
+	// Go.
+	Gob Codec = gobCodec{}
+	// JSON encodes every message as a JSON object, prefixed by the length of
+	// the object as 4 bytes (big endian).
+	JSON Codec = jsonCodec{}
+	// MessagePack encodes every message as a MessagePack map, using the same
+	// names as the JSON codec.
+	MessagePack Codec = msgpackCodec{}
+	// Protobuf encodes every message as a protocol buffer (see keyval.proto),
+	// prefixed by the length of the message as a varint.
+	Protobuf Codec = protobufCodec{}
+)
+
+// Codecs returns every codec that's supported.
+func Codecs() []Codec {
+	return []Codec{Gob, JSON, MessagePack, Protobuf}
+}
+
+// CodecFor returns the codec for the ID of a handshake.
+func CodecFor(id byte) (Codec, error) {
+	for _, c := range Codecs() {
+		if c.ID() == id {
+			return c, nil
+		}
+	}
+	return nil, ErrUnknownCodec
+}
+
+// ParseCodec returns the codec for the name.
+func ParseCodec(name string) (Codec, error) {
+	for _, c := range Codecs() {
+		if strings.ToLower(name) == c.String() {
+			return c, nil
+		}
+	}
+	return nil, errors.Errorf("%s: unsupported codec", name)
+}
+
+// Handshake returns the handshake for the codec.
+func Handshake(c Codec) []byte {
+	return []byte{HandshakeMagic, c.ID()}
+}
+
+// ReadHandshake reads the handshake from the start of the reader, returning
+// the codec along with whether there was a handshake. If there's no handshake
+// nothing is read and the codec is Gob.
+func ReadHandshake(r *bufio.Reader) (Codec, bool, error) {
+	b, err := r.Peek(1)
+	if err != nil {
+		return nil, false, err
+	}
+	if b[0] != HandshakeMagic {
+		return Gob, false, nil
+	}
+
+	var handshake [HandshakeSize]byte
+	if _, err := io.ReadFull(r, handshake[:]); err != nil {
+		return nil, true, err
+	}
+	c, err := CodecFor(handshake[1])
+	return c, true, err
+}
+
+// SplitHandshake splits the handshake from the start of a message, if there's
+// no handshake then the codec is Gob.
+func SplitHandshake(message []byte) (Codec, []byte, bool, error) {
+	if len(message) == 0 || message[0] != HandshakeMagic {
+		return Gob, message, false, nil
+	}
+	if len(message) < HandshakeSize {
+		return nil, nil, true, ErrUnknownCodec
+	}
+	c, err := CodecFor(message[1])
+	return c, message[HandshakeSize:], true, err
+}
+
+type gobCodec struct{}
+
+func (gobCodec) ID() byte       { return 1 }
+func (gobCodec) String() string { return "gob" }
+
+func (gobCodec) NewEncoder(w io.Writer) MessageEncoder {
+	return gob.NewEncoder(w)
+}
+
+func (c gobCodec) NewDecoder(r io.Reader) MessageDecoder {
+	return c.newDecoderSize(r, DefaultMaxMessageSize)
+}
+
+// newDecoderSize limits each of the messages of the gob stream to the size.
+// Gob allocates the whole of a message from its length, so the messages are
+// read by a gobReader first.
+func (gobCodec) newDecoderSize(r io.Reader, size int) MessageDecoder {
+	br, ok := r.(byteReader)
+	if !ok {
+		br = bufio.NewReader(r)
+	}
+	return gob.NewDecoder(&gobReader{r: br, size: size})
+}
+
+// byteReader is a reader that can also read a byte at a time.
+type byteReader interface {
+	io.Reader
+	io.ByteReader
+}
+
+// gobReader reads a gob stream a message at a time, so that the length of each
+// message is checked against the size before any of the message is read and
+// the message is read as it arrives. A gob stream is made up of messages, each
+// of which is prefixed by its length.
+type gobReader struct {
+	r    byteReader
+	size int
+	buf  []byte
+}
+
+func (g *gobReader) Read(p []byte) (int, error) {
+	if len(g.buf) == 0 {
+		if err := g.next(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, g.buf)
+	g.buf = g.buf[n:]
+	return n, nil
+}
+
+func (g *gobReader) ReadByte() (byte, error) {
+	if len(g.buf) == 0 {
+		if err := g.next(); err != nil {
+			return 0, err
+		}
+	}
+	b := g.buf[0]
+	g.buf = g.buf[1:]
+	return b, nil
+}
+
+// next reads the next message of the stream, along with its length. Gob
+// encodes a length of less than 128 as a single byte, otherwise as the negated
+// count of bytes that follow, which hold the length big endian.
+func (g *gobReader) next() error {
+	b, err := g.r.ReadByte()
+	if err != nil {
+		return err
+	}
+	message, n := []byte{b}, uint64(b)
+	if b >= 0x80 {
+		count := int(-int8(b))
+		if count > 8 {
+			return ErrCodecMessageTooLarge
+		}
+		digits := make([]byte, count)
+		if _, err := io.ReadFull(g.r, digits); err != nil {
+			return unexpectedEOF(err)
+		}
+		n = 0
+		for _, d := range digits {
+			n = n<<8 | uint64(d)
+		}
+		message = append(message, digits...)
+	}
+	if n > uint64(g.size) {
+		return ErrCodecMessageTooLarge
+	}
+
+	body, err := readMessage(g.r, int64(n))
+	if err != nil {
+		return err
+	}
+	g.buf = append(message, body...)
+	return nil
+}
+
+type jsonCodec struct{}
+
+func (jsonCodec) ID() byte       { return 2 }
+func (jsonCodec) String() string { return "json" }
+
+func (jsonCodec) NewEncoder(w io.Writer) MessageEncoder {
+	return lengthPrefixedEncoder{w: w, marshal: json.Marshal}
+}
+
+func (c jsonCodec) NewDecoder(r io.Reader) MessageDecoder {
+	return c.newDecoderSize(r, DefaultMaxMessageSize)
+}
+
+func (jsonCodec) newDecoderSize(r io.Reader, size int) MessageDecoder {
+	return lengthPrefixedDecoder{r: r, size: size, unmarshal: json.Unmarshal}
+}
+
+type lengthPrefixedEncoder struct {
+	w       io.Writer
+	marshal func(interface{}) ([]byte, error)
+}
+
+func (e lengthPrefixedEncoder) Encode(v interface{}) error {
+	b, err := e.marshal(v)
+	if err != nil {
+		return err
+	}
+	var size [4]byte
+	binary.BigEndian.PutUint32(size[:], uint32(len(b)))
+	if _, err := e.w.Write(size[:]); err != nil {
+		return err
+	}
+	_, err = e.w.Write(b)
+	return err
+}
+
+type lengthPrefixedDecoder struct {
+	r         io.Reader
+	size      int
+	unmarshal func([]byte, interface{}) error
+}
+
+func (d lengthPrefixedDecoder) Decode(v interface{}) error {
+	var size [4]byte
+	if _, err := io.ReadFull(d.r, size[:]); err != nil {
+		return err
+	}
+	n := binary.BigEndian.Uint32(size[:])
+	if uint64(n) > uint64(d.size) {
+		return ErrCodecMessageTooLarge
+	}
+	b, err := readMessage(d.r, int64(n))
+	if err != nil {
+		return err
+	}
+	return d.unmarshal(b, v)
+}
+
+type msgpackCodec struct{}
+
+func (msgpackCodec) ID() byte       { return 3 }
+func (msgpackCodec) String() string { return "msgpack" }
+
+func (msgpackCodec) NewEncoder(w io.Writer) MessageEncoder {
+	enc := msgpack.NewEncoder(w)
+	enc.SetCustomStructTag("json")
+	return enc
+}
+
+func (c msgpackCodec) NewDecoder(r io.Reader) MessageDecoder {
+	return c.newDecoderSize(r, DefaultMaxMessageSize)
+}
+
+// newDecoderSize limits each of the messages to the size. MessagePack isn't
+// length prefixed, instead the decoder reads the values as they arrive, so the
+// bytes read for each message are limited instead.
+func (msgpackCodec) newDecoderSize(r io.Reader, size int) MessageDecoder {
+	s, ok := r.(byteScanner)
+	if !ok {
+		s = bufio.NewReader(r)
+	}
+	limiter := &messageLimiter{r: s, size: size}
+	dec := msgpack.NewDecoder(limiter)
+	dec.SetCustomStructTag("json")
+	return limitedDecoder{limiter: limiter, dec: dec}
+}
+
+// byteScanner is a reader that can also read and unread a byte at a time.
+type byteScanner interface {
+	io.Reader
+	io.ByteScanner
+}
+
+// messageLimiter limits the bytes that are read for each message, once the
+// size is used up every read fails with ErrCodecMessageTooLarge. It reads a
+// byte at a time as well, so that the decoder reads straight from it, without
+// reading ahead of the message.
+type messageLimiter struct {
+	r    byteScanner
+	size int
+	left int
+}
+
+func (l *messageLimiter) Read(p []byte) (int, error) {
+	if l.left <= 0 {
+		return 0, ErrCodecMessageTooLarge
+	}
+	if len(p) > l.left {
+		p = p[:l.left]
+	}
+	n, err := l.r.Read(p)
+	l.left -= n
+	return n, err
+}
+
+func (l *messageLimiter) ReadByte() (byte, error) {
+	if l.left <= 0 {
+		return 0, ErrCodecMessageTooLarge
+	}
+	b, err := l.r.ReadByte()
+	if err == nil {
+		l.left--
+	}
+	return b, err
+}
+
+func (l *messageLimiter) UnreadByte() error {
+	err := l.r.UnreadByte()
+	if err == nil {
+		l.left++
+	}
+	return err
+}
+
+// limitedDecoder resets the limit of the messageLimiter before each message.
+type limitedDecoder struct {
+	limiter *messageLimiter
+	dec     MessageDecoder
+}
+
+func (d limitedDecoder) Decode(v interface{}) error {
+	d.limiter.left = d.limiter.size
+	return d.dec.Decode(v)
+}
+
+type protobufCodec struct{}
+
+func (protobufCodec) ID() byte       { return 4 }
+func (protobufCodec) String() string { return "protobuf" }
+
+func (protobufCodec) NewEncoder(w io.Writer) MessageEncoder {
+	return protobufEncoder{w}
+}
+
+func (c protobufCodec) NewDecoder(r io.Reader) MessageDecoder {
+	return c.newDecoderSize(r, DefaultMaxMessageSize)
+}
+
+func (protobufCodec) newDecoderSize(r io.Reader, size int) MessageDecoder {
+	br, ok := r.(io.ByteReader)
+	if !ok {
+		buffered := bufio.NewReader(r)
+		r, br = buffered, buffered
+	}
+	return protobufDecoder{r: r, br: br, size: size}
+}
+
+type protobufEncoder struct {
+	w io.Writer
+}
+
+func (e protobufEncoder) Encode(v interface{}) error {
+	b, err := MarshalProto(v)
+	if err != nil {
+		return err
+	}
+	_, err = e.w.Write(append(binary.AppendUvarint(nil, uint64(len(b))), b...))
+	return err
+}
+
+type protobufDecoder struct {
+	r    io.Reader
+	br   io.ByteReader
+	size int
+}
+
+func (d protobufDecoder) Decode(v interface{}) error {
+	n, err := binary.ReadUvarint(d.br)
+	if err != nil {
+		return err
+	}
+	if n > uint64(d.size) {
+		return ErrCodecMessageTooLarge
+	}
+	b, err := readMessage(d.r, int64(n))
+	if err != nil {
+		return err
+	}
+	return UnmarshalProto(b, v)
+}
+
+// readMessage reads a message of n bytes. The length comes from the peer, so
+// the message is read as it arrives rather than allocated up front, a peer
+// that claims a large message without sending it can't use up the memory.
+func readMessage(r io.Reader, n int64) ([]byte, error) {
+	b, err := ioutil.ReadAll(io.LimitReader(r, n))
+	if err != nil {
+		return nil, unexpectedEOF(err)
+	}
+	if int64(len(b)) < n {
+		return nil, io.ErrUnexpectedEOF
+	}
+	return b, nil
+}
+
+// unexpectedEOF turns an EOF part way through a message into an unexpected
+// EOF, so that it isn't mistaken for the end of the stream.
+func unexpectedEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
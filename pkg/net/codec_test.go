@@ -0,0 +1,266 @@
+package net
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"reflect"
+	"testing"
+	"testing/quick"
+)
+
+func TestCodecs(t *testing.T) {
+	t.Parallel()
+
+	for _, codec := range Codecs() {
+		codec := codec
+		t.Run(codec.String(), func(t *testing.T) {
+			t.Parallel()
+
+			t.Run("queries", func(t *testing.T) {
+				fn := func(a, b Query) bool {
+					// The limit is an int32 in the protocol buffer.
+					a.Limit, b.Limit = int(int32(a.Limit)), int(int32(b.Limit))
+
+					var buf bytes.Buffer
+					enc := codec.NewEncoder(&buf)
+					if err := enc.Encode(a); err != nil {
+						t.Fatal(err)
+					}
+					if err := enc.Encode(&b); err != nil {
+						t.Fatal(err)
+					}
+
+					dec := codec.NewDecoder(&buf)
+					for _, expected := range []Query{a, b} {
+						var actual Query
+						if err := dec.Decode(&actual); err != nil {
+							t.Fatal(err)
+						}
+						if !reflect.DeepEqual(normalizeQuery(expected), normalizeQuery(actual)) {
+							t.Logf("expected: %v, actual: %v", expected, actual)
+							return false
+						}
+					}
+					return true
+				}
+				if err := quick.Check(fn, nil); err != nil {
+					t.Error(err)
+				}
+			})
+
+			t.Run("results", func(t *testing.T) {
+				fn := func(a Result) bool {
+					var buf bytes.Buffer
+					if err := NewCodecEncoder(codec, &buf).Encode(a); err != nil {
+						t.Fatal(err)
+					}
+
+					var actual Result
+					if err := codec.NewDecoder(&buf).Decode(&actual); err != nil {
+						t.Fatal(err)
+					}
+					if !reflect.DeepEqual(normalizeResult(a), normalizeResult(actual)) {
+						t.Logf("expected: %v, actual: %v", a, actual)
+						return false
+					}
+					return true
+				}
+				if err := quick.Check(fn, nil); err != nil {
+					t.Error(err)
+				}
+			})
+
+			t.Run("end of stream", func(t *testing.T) {
+				var actual Query
+				if err := codec.NewDecoder(bytes.NewReader(nil)).Decode(&actual); err != io.EOF {
+					t.Errorf("expected: %v, actual: %v", io.EOF, err)
+				}
+			})
+		})
+	}
+}
+
+func TestCodecMessageSize(t *testing.T) {
+	t.Parallel()
+
+	for _, codec := range Codecs() {
+		codec := codec
+		t.Run(codec.String(), func(t *testing.T) {
+			t.Parallel()
+
+			var buf bytes.Buffer
+			if err := codec.NewEncoder(&buf).Encode(Query{Key: "a", Value: make([]byte, 64)}); err != nil {
+				t.Fatal(err)
+			}
+			message := buf.Bytes()
+
+			t.Run("fits", func(t *testing.T) {
+				var actual Query
+				if err := NewDecoderSize(codec, bytes.NewReader(message), len(message)).Decode(&actual); err != nil {
+					t.Fatal(err)
+				}
+				if expected := "a"; expected != actual.Key {
+					t.Errorf("expected: %v, actual: %v", expected, actual.Key)
+				}
+			})
+
+			t.Run("too large", func(t *testing.T) {
+				var actual Query
+				if err := NewDecoderSize(codec, bytes.NewReader(message), 32).Decode(&actual); err != ErrCodecMessageTooLarge {
+					t.Errorf("expected: %v, actual: %v", ErrCodecMessageTooLarge, err)
+				}
+			})
+
+			t.Run("shorter than the length", func(t *testing.T) {
+				var actual Query
+				if err := NewDecoderSize(codec, bytes.NewReader(message[:len(message)-1]), len(message)).Decode(&actual); err != io.ErrUnexpectedEOF {
+					t.Errorf("expected: %v, actual: %v", io.ErrUnexpectedEOF, err)
+				}
+			})
+		})
+	}
+}
+
+func TestHandshake(t *testing.T) {
+	t.Parallel()
+
+	t.Run("read", func(t *testing.T) {
+		for _, codec := range Codecs() {
+			r := bufio.NewReader(bytes.NewReader(append(Handshake(codec), 'a')))
+			actual, ok, err := ReadHandshake(r)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if expected := codec; !ok || expected != actual {
+				t.Errorf("expected: %v, actual: %v", expected, actual)
+			}
+			if b, _ := r.ReadByte(); b != 'a' {
+				t.Errorf("expected: %v, actual: %v", 'a', b)
+			}
+		}
+	})
+
+	t.Run("gob without a handshake", func(t *testing.T) {
+		var buf bytes.Buffer
+		Gob.NewEncoder(&buf).Encode(Query{Key: "a"})
+
+		r := bufio.NewReader(&buf)
+		codec, ok, err := ReadHandshake(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if expected := Gob; ok || expected != codec {
+			t.Errorf("expected: %v, actual: %v", expected, codec)
+		}
+
+		// Nothing is read, so the whole query can still be decoded.
+		var actual Query
+		if err := codec.NewDecoder(r).Decode(&actual); err != nil {
+			t.Fatal(err)
+		}
+		if expected := "a"; expected != actual.Key {
+			t.Errorf("expected: %v, actual: %v", expected, actual.Key)
+		}
+	})
+
+	t.Run("unknown codec", func(t *testing.T) {
+		r := bufio.NewReader(bytes.NewReader([]byte{HandshakeMagic, 0}))
+		if _, ok, err := ReadHandshake(r); !ok || err != ErrUnknownCodec {
+			t.Errorf("expected: %v, actual: %v", ErrUnknownCodec, err)
+		}
+	})
+
+	t.Run("split", func(t *testing.T) {
+		codec, message, ok, err := SplitHandshake(append(Handshake(JSON), 'a'))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !ok || codec != JSON || string(message) != "a" {
+			t.Errorf("expected: %v, actual: %v %q", JSON, codec, message)
+		}
+
+		codec, message, ok, err = SplitHandshake([]byte("a"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ok || codec != Gob || string(message) != "a" {
+			t.Errorf("expected: %v, actual: %v %q", Gob, codec, message)
+		}
+	})
+}
+
+func TestProtoMalformed(t *testing.T) {
+	t.Parallel()
+
+	fn := func(b []byte) bool {
+		// Any bytes either parse or return an error, but never panic.
+		var q Query
+		UnmarshalProto(b, &q)
+		var r Result
+		UnmarshalProto(b, &r)
+		return true
+	}
+	if err := quick.Check(fn, nil); err != nil {
+		t.Error(err)
+	}
+
+	for _, b := range [][]byte{
+		{0x0a},       // a tag without a value
+		{0x1a, 0x05}, // bytes that are too short
+		{0x08, 0xff}, // a varint that doesn't end
+	} {
+		var q Query
+		if err := UnmarshalProto(b, &q); err != ErrProtoMalformed {
+			t.Errorf("expected: %v, actual: %v", ErrProtoMalformed, err)
+		}
+	}
+}
+
+// normalizeQuery sets empty slices to nil, as not every codec can tell them
+// apart.
+func normalizeQuery(q Query) Query {
+	q.Value = normalizeBytes(q.Value)
+	if len(q.Conditions) == 0 {
+		q.Conditions = nil
+	}
+	if len(q.Mutations) == 0 {
+		q.Mutations = nil
+	}
+	for k := range q.Mutations {
+		q.Mutations[k].Value = normalizeBytes(q.Mutations[k].Value)
+		q.Mutations[k].Metadata = normalizeMetadata(q.Mutations[k].Metadata)
+	}
+	q.Metadata = normalizeMetadata(q.Metadata)
+	return q
+}
+
+func normalizeResult(r Result) Result {
+	r.Value = normalizeBytes(r.Value)
+	if len(r.Entries) == 0 {
+		r.Entries = nil
+	}
+	for k := range r.Entries {
+		r.Entries[k].Value = normalizeBytes(r.Entries[k].Value)
+	}
+	if len(r.Versions) == 0 {
+		r.Versions = nil
+	}
+	r.Event.Value = normalizeBytes(r.Event.Value)
+	r.Metadata = normalizeMetadata(r.Metadata)
+	return r
+}
+
+func normalizeMetadata(m map[string]string) map[string]string {
+	if len(m) == 0 {
+		return nil
+	}
+	return m
+}
+
+func normalizeBytes(b []byte) []byte {
+	if len(b) == 0 {
+		return nil
+	}
+	return b
+}
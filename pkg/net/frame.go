@@ -0,0 +1,267 @@
+package net
+
+import (
+	"encoding/binary"
+	"errors"
+	"sync"
+	"time"
+)
+
+// Datagrams are limited in size, so queries and results sent over udp are
+// split into frames. Every frame has a header with the ID of the message, the
+// index of the frame and the total amount of frames for the message, followed
+// by a fragment of the encoded message. All numbers are big endian.
+//
+//	+---------+----------+-------------+-------------+----------+
+//	| version | id       | index       | total       | fragment |
+//	| 1 byte  | 8 bytes  | 2 bytes     | 2 bytes     | ...      |
+//	+---------+----------+-------------+-------------+----------+
+const (
+	// FrameVersion is the version of the frame header.
+	FrameVersion = 1
+	// FrameHeaderSize is the size of the frame header.
+	FrameHeaderSize = 13
+	// MaxFrameSize is the largest frame that fits in a datagram.
+	MaxFrameSize = 65507
+	// MaxFrames is the most frames a message can be split into.
+	MaxFrames = 1<<16 - 1
+
+	// DefaultFrameSize is the size of the frames that messages are split into,
+	// so that each datagram fits within the MTU of most networks.
+	DefaultFrameSize = 1400
+	// DefaultMaxMessageSize is the largest message that is reassembled.
+	DefaultMaxMessageSize = 1024 * 1024
+	// DefaultMaxReassemblyBytes is the most memory used for reassembling
+	// messages at any one time.
+	DefaultMaxReassemblyBytes = 64 * 1024 * 1024
+	// DefaultReassemblyTimeout is how long the frames of a message can take
+	// to arrive, before the message is given up on.
+	DefaultReassemblyTimeout = 5 * time.Second
+	// DefaultReadBufferSize is the size of the socket buffer asked for when
+	// reading frames, as the frames of a message arrive in a burst. The
+	// operating system can cap it to something smaller.
+	DefaultReadBufferSize = 4 * 1024 * 1024
+)
+
+var (
+	// ErrFrameMalformed is returned when a frame can't be parsed.
+	ErrFrameMalformed = errors.New("frame malformed")
+	// ErrMessageTooLarge is returned when a message is larger than the
+	// maximum message size.
+	ErrMessageTooLarge = errors.New("message too large")
+	// ErrReassemblyFull is returned when there isn't enough memory left to
+	// reassemble a message.
+	ErrReassemblyFull = errors.New("reassembly full")
+)
+
+// Frame represents part of a message sent over udp
+type Frame struct {
+	ID       uint64
+	Index    uint16
+	Total    uint16
+	Fragment []byte
+}
+
+// AppendTo appends the encoded frame to b.
+func (f Frame) AppendTo(b []byte) []byte {
+	var header [FrameHeaderSize]byte
+	header[0] = FrameVersion
+	binary.BigEndian.PutUint64(header[1:9], f.ID)
+	binary.BigEndian.PutUint16(header[9:11], f.Index)
+	binary.BigEndian.PutUint16(header[11:13], f.Total)
+	return append(append(b, header[:]...), f.Fragment...)
+}
+
+// ParseFrame parses an encoded frame, the fragment of the frame refers to b.
+func ParseFrame(b []byte) (Frame, error) {
+	if len(b) < FrameHeaderSize || b[0] != FrameVersion {
+		return Frame{}, ErrFrameMalformed
+	}
+	f := Frame{
+		ID:       binary.BigEndian.Uint64(b[1:9]),
+		Index:    binary.BigEndian.Uint16(b[9:11]),
+		Total:    binary.BigEndian.Uint16(b[11:13]),
+		Fragment: b[FrameHeaderSize:],
+	}
+	if f.Total == 0 || f.Index >= f.Total {
+		return Frame{}, ErrFrameMalformed
+	}
+	return f, nil
+}
+
+// Fragment splits the message into frames, where every encoded frame is at
+// most size bytes.
+func Fragment(id uint64, message []byte, size int) ([]Frame, error) {
+	if size > MaxFrameSize {
+		size = MaxFrameSize
+	}
+	chunk := size - FrameHeaderSize
+	if chunk <= 0 {
+		return nil, errors.New("frame size too small")
+	}
+
+	total := (len(message) + chunk - 1) / chunk
+	if total == 0 {
+		total = 1
+	}
+	if total > MaxFrames {
+		return nil, ErrMessageTooLarge
+	}
+
+	frames := make([]Frame, total)
+	for k := range frames {
+		end := (k + 1) * chunk
+		if end > len(message) {
+			end = len(message)
+		}
+		frames[k] = Frame{
+			ID:       id,
+			Index:    uint16(k),
+			Total:    uint16(total),
+			Fragment: message[k*chunk : end],
+		}
+	}
+	return frames, nil
+}
+
+// Reassembler puts the frames of messages back together. Frames can arrive in
+// any order and more than once, a message is complete once every frame of it
+// has arrived. Messages are identified by the source they're from along with
+// their ID, as the IDs of different sources can clash.
+type Reassembler struct {
+	maxMessageSize int
+	maxBytes       int
+	timeout        time.Duration
+
+	mutex    sync.Mutex
+	messages map[reassemblyKey]*reassembly
+	bytes    int
+	swept    time.Time
+}
+
+type reassemblyKey struct {
+	source string
+	id     uint64
+}
+
+type reassembly struct {
+	fragments [][]byte
+	received  int
+	bytes     int
+	started   time.Time
+	rejected  bool
+}
+
+// NewReassembler creates a Reassembler that gives up on messages after the
+// timeout and never uses more than maxBytes of memory.
+func NewReassembler(maxMessageSize, maxBytes int, timeout time.Duration) *Reassembler {
+	return &Reassembler{
+		maxMessageSize: maxMessageSize,
+		maxBytes:       maxBytes,
+		timeout:        timeout,
+		messages:       make(map[reassemblyKey]*reassembly),
+	}
+}
+
+// Add the frame from the source, returning the message once every frame of it
+// has arrived. The fragment of the frame is copied, so the frame can be reused
+// once Add returns. If the message is too large or there's no memory left to
+// reassemble it, then the message is rejected and an error is returned. The
+// error is only returned once, the rest of the frames of a rejected message
+// are ignored.
+func (r *Reassembler) Add(source string, f Frame) ([]byte, bool, error) {
+	// The vast majority of messages fit in a single frame, so there is
+	// nothing to put back together.
+	if f.Total == 1 {
+		if len(f.Fragment) > r.maxMessageSize {
+			return nil, false, ErrMessageTooLarge
+		}
+		return append([]byte(nil), f.Fragment...), true, nil
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	now := time.Now()
+	if now.Sub(r.swept) > r.timeout {
+		r.expire(now)
+	}
+
+	key := reassemblyKey{source, f.ID}
+	m, ok := r.messages[key]
+	if !ok {
+		m = &reassembly{
+			fragments: make([][]byte, f.Total),
+			started:   now,
+		}
+		r.messages[key] = m
+	}
+
+	switch {
+	case m.rejected:
+		// The message has already been rejected, so the error has already
+		// been returned.
+		return nil, false, nil
+	case len(m.fragments) != int(f.Total):
+		return nil, false, r.reject(m, ErrFrameMalformed)
+	case m.fragments[f.Index] != nil:
+		// The frame has been seen before.
+		return nil, false, nil
+	case f.Index < f.Total-1 && (int(f.Total)-1)*len(f.Fragment) > r.maxMessageSize:
+		// Every frame apart from the last is the same size, so messages that
+		// are too large can be rejected from the very first frame.
+		return nil, false, r.reject(m, ErrMessageTooLarge)
+	case m.bytes+len(f.Fragment) > r.maxMessageSize:
+		return nil, false, r.reject(m, ErrMessageTooLarge)
+	case r.bytes+len(f.Fragment) > r.maxBytes:
+		return nil, false, r.reject(m, ErrReassemblyFull)
+	}
+
+	// An empty fragment still has to be marked as received.
+	m.fragments[f.Index] = append(make([]byte, 0, len(f.Fragment)), f.Fragment...)
+	m.received++
+	m.bytes += len(f.Fragment)
+	r.bytes += len(f.Fragment)
+	if m.received < len(m.fragments) {
+		return nil, false, nil
+	}
+
+	message := make([]byte, 0, m.bytes)
+	for _, fragment := range m.fragments {
+		message = append(message, fragment...)
+	}
+	r.drop(key, m)
+	return message, true, nil
+}
+
+// Len returns the amount of messages that are partially reassembled, including
+// rejected messages that have yet to expire.
+func (r *Reassembler) Len() int {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return len(r.messages)
+}
+
+func (r *Reassembler) expire(now time.Time) {
+	for key, m := range r.messages {
+		if now.Sub(m.started) > r.timeout {
+			r.drop(key, m)
+		}
+	}
+	r.swept = now
+}
+
+// reject frees the fragments of the message, but remembers the message until
+// it expires so that the rest of its frames are ignored.
+func (r *Reassembler) reject(m *reassembly, err error) error {
+	r.bytes -= m.bytes
+	m.fragments = nil
+	m.bytes = 0
+	m.rejected = true
+	return err
+}
+
+func (r *Reassembler) drop(key reassemblyKey, m *reassembly) {
+	r.bytes -= m.bytes
+	delete(r.messages, key)
+}
@@ -0,0 +1,210 @@
+package net
+
+import (
+	"bytes"
+	"math/rand"
+	"testing"
+	"testing/quick"
+	"time"
+)
+
+func TestFrame(t *testing.T) {
+	t.Parallel()
+
+	t.Run("encode and parse", func(t *testing.T) {
+		fn := func(id uint64, index, total uint16, fragment []byte) bool {
+			if total == 0 {
+				total = 1
+			}
+			index %= total
+
+			f := Frame{ID: id, Index: index, Total: total, Fragment: fragment}
+			actual, err := ParseFrame(f.AppendTo(nil))
+			if err != nil {
+				t.Log(err)
+				return false
+			}
+			return actual.ID == id &&
+				actual.Index == index &&
+				actual.Total == total &&
+				bytes.Equal(actual.Fragment, fragment)
+		}
+		if err := quick.Check(fn, nil); err != nil {
+			t.Error(err)
+		}
+	})
+
+	t.Run("malformed", func(t *testing.T) {
+		for _, b := range [][]byte{
+			nil,
+			[]byte("short"),
+			Frame{ID: 1, Index: 0, Total: 0}.AppendTo(nil),
+			Frame{ID: 1, Index: 2, Total: 2}.AppendTo(nil),
+			append([]byte{FrameVersion + 1}, Frame{ID: 1, Total: 1}.AppendTo(nil)[1:]...),
+		} {
+			if _, err := ParseFrame(b); err != ErrFrameMalformed {
+				t.Errorf("expected: %v, actual: %v", ErrFrameMalformed, err)
+			}
+		}
+	})
+
+	t.Run("fragment sizes", func(t *testing.T) {
+		fn := func(message []byte, size uint8) bool {
+			frameSize := FrameHeaderSize + 1 + int(size)
+			frames, err := Fragment(1, message, frameSize)
+			if err != nil {
+				t.Log(err)
+				return false
+			}
+			var actual []byte
+			for _, f := range frames {
+				if len(f.AppendTo(nil)) > frameSize {
+					return false
+				}
+				actual = append(actual, f.Fragment...)
+			}
+			return bytes.Equal(actual, message)
+		}
+		if err := quick.Check(fn, nil); err != nil {
+			t.Error(err)
+		}
+	})
+
+	t.Run("fragment too small", func(t *testing.T) {
+		if _, err := Fragment(1, []byte("abc"), FrameHeaderSize); err == nil {
+			t.Errorf("expected: error, actual: %v", err)
+		}
+	})
+}
+
+func TestReassembler(t *testing.T) {
+	t.Parallel()
+
+	t.Run("out of order and duplicated", func(t *testing.T) {
+		fn := func(message []byte, seed int64) bool {
+			frames, err := Fragment(1, message, FrameHeaderSize+8)
+			if err != nil {
+				t.Log(err)
+				return false
+			}
+
+			// Shuffle the frames and send some of them twice.
+			r := rand.New(rand.NewSource(seed))
+			r.Shuffle(len(frames), func(i, j int) {
+				frames[i], frames[j] = frames[j], frames[i]
+			})
+			frames = append(frames[:len(frames):len(frames)], frames[:len(frames)/2]...)
+
+			reassembler := NewReassembler(DefaultMaxMessageSize, DefaultMaxReassemblyBytes, time.Minute)
+			var completed int
+			for _, f := range frames {
+				actual, ok, err := reassembler.Add("source", f)
+				if err != nil {
+					t.Log(err)
+					return false
+				}
+				if ok {
+					completed++
+					if !bytes.Equal(actual, message) {
+						return false
+					}
+				}
+			}
+			return completed >= 1
+		}
+		if err := quick.Check(fn, nil); err != nil {
+			t.Error(err)
+		}
+	})
+
+	t.Run("sources are separate", func(t *testing.T) {
+		reassembler := NewReassembler(DefaultMaxMessageSize, DefaultMaxReassemblyBytes, time.Minute)
+
+		a, _ := Fragment(1, []byte("abcdef"), FrameHeaderSize+3)
+		b, _ := Fragment(1, []byte("ghijkl"), FrameHeaderSize+3)
+
+		reassembler.Add("a", a[0])
+		reassembler.Add("b", b[0])
+		actual, ok, err := reassembler.Add("b", b[1])
+		if err != nil || !ok {
+			t.Fatalf("expected: complete, actual: %v, %v", ok, err)
+		}
+		if expected := []byte("ghijkl"); !bytes.Equal(expected, actual) {
+			t.Errorf("expected: %s, actual: %s", expected, actual)
+		}
+		if expected, actual := 1, reassembler.Len(); expected != actual {
+			t.Errorf("expected: %v, actual: %v", expected, actual)
+		}
+	})
+
+	t.Run("fragments are copied", func(t *testing.T) {
+		reassembler := NewReassembler(DefaultMaxMessageSize, DefaultMaxReassemblyBytes, time.Minute)
+
+		buf := []byte("abc")
+		reassembler.Add("source", Frame{ID: 1, Index: 0, Total: 2, Fragment: buf})
+		copy(buf, "xyz")
+		actual, _, _ := reassembler.Add("source", Frame{ID: 1, Index: 1, Total: 2, Fragment: buf})
+		if expected := []byte("abcxyz"); !bytes.Equal(expected, actual) {
+			t.Errorf("expected: %s, actual: %s", expected, actual)
+		}
+	})
+
+	t.Run("too large", func(t *testing.T) {
+		reassembler := NewReassembler(16, DefaultMaxReassemblyBytes, time.Minute)
+
+		if _, _, err := reassembler.Add("source", Frame{ID: 1, Total: 1, Fragment: make([]byte, 17)}); err != ErrMessageTooLarge {
+			t.Errorf("expected: %v, actual: %v", ErrMessageTooLarge, err)
+		}
+
+		// The first frame is enough to know that the message is too large and
+		// the error is only returned once.
+		frames, _ := Fragment(2, make([]byte, 32), FrameHeaderSize+8)
+		if _, _, err := reassembler.Add("source", frames[0]); err != ErrMessageTooLarge {
+			t.Errorf("expected: %v, actual: %v", ErrMessageTooLarge, err)
+		}
+		for _, f := range frames[1:] {
+			if _, ok, err := reassembler.Add("source", f); ok || err != nil {
+				t.Errorf("expected: ignored, actual: %v, %v", ok, err)
+			}
+		}
+	})
+
+	t.Run("full", func(t *testing.T) {
+		reassembler := NewReassembler(DefaultMaxMessageSize, 8, time.Minute)
+
+		a, _ := Fragment(1, make([]byte, 8), FrameHeaderSize+4)
+		b, _ := Fragment(2, make([]byte, 12), FrameHeaderSize+6)
+
+		if _, _, err := reassembler.Add("source", a[0]); err != nil {
+			t.Fatal(err)
+		}
+		if _, _, err := reassembler.Add("source", b[0]); err != ErrReassemblyFull {
+			t.Errorf("expected: %v, actual: %v", ErrReassemblyFull, err)
+		}
+
+		// Completing a message frees up the memory it used.
+		if _, ok, err := reassembler.Add("source", a[1]); !ok || err != nil {
+			t.Fatalf("expected: complete, actual: %v, %v", ok, err)
+		}
+		c, _ := Fragment(3, make([]byte, 12), FrameHeaderSize+6)
+		if _, _, err := reassembler.Add("source", c[0]); err != nil {
+			t.Errorf("expected: %v, actual: %v", nil, err)
+		}
+	})
+
+	t.Run("expires", func(t *testing.T) {
+		reassembler := NewReassembler(DefaultMaxMessageSize, DefaultMaxReassemblyBytes, time.Millisecond)
+
+		a, _ := Fragment(1, []byte("abcdef"), FrameHeaderSize+3)
+		reassembler.Add("source", a[0])
+		time.Sleep(time.Millisecond * 5)
+
+		// The expired message has to start again from the beginning.
+		if _, ok, err := reassembler.Add("source", a[1]); ok || err != nil {
+			t.Errorf("expected: incomplete, actual: %v, %v", ok, err)
+		}
+		if expected, actual := 1, reassembler.Len(); expected != actual {
+			t.Errorf("expected: %v, actual: %v", expected, actual)
+		}
+	})
+}
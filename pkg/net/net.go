@@ -1,5 +1,7 @@
 package net
 
+import "time"
+
 // Status represents the different codes that can return from the handlers
 type Status int
 
@@ -14,6 +16,11 @@ const (
 	NotFound
 	// ServerError code
 	ServerError
+	// Conflict code, the version expected didn't match the current version
+	Conflict
+	// Overflow code, the watch couldn't keep up with the events, so events
+	// were dropped
+	Overflow
 )
 
 // Method represents the different methods that the server can handle
@@ -23,18 +30,122 @@ const (
 	Select Method = iota
 	Insert
 	Delete
+	CompareAndSwap
+	CompareAndDelete
+	Scan
+	Txn
+	Watch
 )
 
 // Query represents an encoding type for the tcp handler
+// ID is echoed back in the Result, so that many queries can be sent over the
+// same connection before reading the results.
 type Query struct {
-	Method Method
-	Key    string
-	Value  []byte
+	ID      uint64        `json:"id,omitempty"`
+	Method  Method        `json:"method"`
+	Key     string        `json:"key,omitempty"`
+	Value   []byte        `json:"value,omitempty"`
+	TTL     time.Duration `json:"ttl,omitempty"`
+	Version uint64        `json:"version,omitempty"`
+
+	// Metadata of the value, stored along with the value
+	ContentType     string            `json:"content_type,omitempty"`
+	ContentEncoding string            `json:"content_encoding,omitempty"`
+	Metadata        map[string]string `json:"metadata,omitempty"`
+
+	// Scan specific fields
+	Prefix string `json:"prefix,omitempty"`
+	Start  string `json:"start,omitempty"`
+	End    string `json:"end,omitempty"`
+	Limit  int    `json:"limit,omitempty"`
+	Cursor string `json:"cursor,omitempty"`
+
+	// Txn specific fields
+	Conditions []Condition `json:"conditions,omitempty"`
+	Mutations  []Mutation  `json:"mutations,omitempty"`
 }
 
 // Result represents the final result of the tcp handler
 type Result struct {
-	Status   Status
-	Value    []byte
-	Duration string
+	ID       uint64   `json:"id,omitempty"`
+	Status   Status   `json:"status"`
+	Value    []byte   `json:"value,omitempty"`
+	Version  uint64   `json:"version,omitempty"`
+	Entries  []Entry  `json:"entries,omitempty"`
+	Cursor   string   `json:"cursor,omitempty"`
+	Versions []uint64 `json:"versions,omitempty"`
+	Event    Event    `json:"event,omitempty"`
+	Duration string   `json:"duration,omitempty"`
+
+	// Metadata of the value, as it was stored along with the value
+	ContentType     string            `json:"content_type,omitempty"`
+	ContentEncoding string            `json:"content_encoding,omitempty"`
+	Metadata        map[string]string `json:"metadata,omitempty"`
+}
+
+// Entry represents a value along with the key and version, as part of a scan
+type Entry struct {
+	Key     string `json:"key,omitempty"`
+	Value   []byte `json:"value,omitempty"`
+	Version uint64 `json:"version,omitempty"`
+}
+
+// Check represents what a condition of a transaction checks about a key
+type Check int
+
+const (
+	// CheckExists checks that the key exists
+	CheckExists Check = iota
+	// CheckMissing checks that the key doesn't exist
+	CheckMissing
+	// CheckVersion checks that the current version of the key matches
+	CheckVersion
+)
+
+// Condition represents a check that has to hold for a transaction to apply
+type Condition struct {
+	Key     string `json:"key,omitempty"`
+	Check   Check  `json:"check,omitempty"`
+	Version uint64 `json:"version,omitempty"`
+}
+
+// Operation represents how a mutation of a transaction changes a key
+type Operation int
+
+const (
+	// OperationSet stores the value for the key
+	OperationSet Operation = iota
+	// OperationDelete removes the value for the key
+	OperationDelete
+)
+
+// Mutation represents a change to a key as part of a transaction
+type Mutation struct {
+	Key       string        `json:"key,omitempty"`
+	Operation Operation     `json:"operation,omitempty"`
+	Value     []byte        `json:"value,omitempty"`
+	TTL       time.Duration `json:"ttl,omitempty"`
+
+	// Metadata of the value, stored along with the value
+	ContentType     string            `json:"content_type,omitempty"`
+	ContentEncoding string            `json:"content_encoding,omitempty"`
+	Metadata        map[string]string `json:"metadata,omitempty"`
+}
+
+// EventType represents what change happened to a key
+type EventType int
+
+const (
+	// EventPut is sent when a value is stored for a key
+	EventPut EventType = iota
+	// EventDelete is sent when a value is removed from a key
+	EventDelete
+)
+
+// Event represents a change to a key, as part of a watch
+type Event struct {
+	Type    EventType `json:"type,omitempty"`
+	Key     string    `json:"key,omitempty"`
+	Value   []byte    `json:"value,omitempty"`
+	Version uint64    `json:"version,omitempty"`
 }
@@ -0,0 +1,439 @@
+package net
+
+import (
+	"sort"
+	"time"
+
+	"github.com/pkg/errors"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// The field numbers of the messages in keyval.proto, any change to them has
+// to be made in both places.
+const (
+	protoQueryID              protowire.Number = 1
+	protoQueryMethod          protowire.Number = 2
+	protoQueryKey             protowire.Number = 3
+	protoQueryValue           protowire.Number = 4
+	protoQueryTTL             protowire.Number = 5
+	protoQueryVersion         protowire.Number = 6
+	protoQueryPrefix          protowire.Number = 7
+	protoQueryStart           protowire.Number = 8
+	protoQueryEnd             protowire.Number = 9
+	protoQueryLimit           protowire.Number = 10
+	protoQueryCursor          protowire.Number = 11
+	protoQueryConditions      protowire.Number = 12
+	protoQueryMutations       protowire.Number = 13
+	protoQueryContentType     protowire.Number = 14
+	protoQueryContentEncoding protowire.Number = 15
+	protoQueryMetadata        protowire.Number = 16
+
+	protoConditionKey     protowire.Number = 1
+	protoConditionCheck   protowire.Number = 2
+	protoConditionVersion protowire.Number = 3
+
+	protoMutationKey             protowire.Number = 1
+	protoMutationOperation       protowire.Number = 2
+	protoMutationValue           protowire.Number = 3
+	protoMutationTTL             protowire.Number = 4
+	protoMutationContentType     protowire.Number = 5
+	protoMutationContentEncoding protowire.Number = 6
+	protoMutationMetadata        protowire.Number = 7
+
+	protoResultID              protowire.Number = 1
+	protoResultStatus          protowire.Number = 2
+	protoResultValue           protowire.Number = 3
+	protoResultVersion         protowire.Number = 4
+	protoResultEntries         protowire.Number = 5
+	protoResultCursor          protowire.Number = 6
+	protoResultVersions        protowire.Number = 7
+	protoResultEvent           protowire.Number = 8
+	protoResultDuration        protowire.Number = 9
+	protoResultContentType     protowire.Number = 10
+	protoResultContentEncoding protowire.Number = 11
+	protoResultMetadata        protowire.Number = 12
+
+	// Every map is encoded as a repeated message of the key and the value.
+	protoMapKey   protowire.Number = 1
+	protoMapValue protowire.Number = 2
+
+	protoEntryKey     protowire.Number = 1
+	protoEntryValue   protowire.Number = 2
+	protoEntryVersion protowire.Number = 3
+
+	protoEventType    protowire.Number = 1
+	protoEventKey     protowire.Number = 2
+	protoEventValue   protowire.Number = 3
+	protoEventVersion protowire.Number = 4
+)
+
+// ErrProtoMalformed is returned when a protocol buffer can't be parsed.
+var ErrProtoMalformed = errors.New("protocol buffer malformed")
+
+// MarshalProto encodes a Query or a Result as a protocol buffer, see
+// keyval.proto for the schema.
+func MarshalProto(v interface{}) ([]byte, error) {
+	switch v := v.(type) {
+	case Query:
+		return appendProtoQuery(nil, &v), nil
+	case *Query:
+		return appendProtoQuery(nil, v), nil
+	case Result:
+		return appendProtoResult(nil, &v), nil
+	case *Result:
+		return appendProtoResult(nil, v), nil
+	default:
+		return nil, errors.Errorf("%T: unsupported type", v)
+	}
+}
+
+// UnmarshalProto decodes a protocol buffer into a *Query or a *Result.
+func UnmarshalProto(b []byte, v interface{}) error {
+	switch v := v.(type) {
+	case *Query:
+		*v = Query{}
+		return parseProto(b, v.field)
+	case *Result:
+		*v = Result{}
+		return parseProto(b, v.field)
+	default:
+		return errors.Errorf("%T: unsupported type", v)
+	}
+}
+
+func appendProtoQuery(b []byte, q *Query) []byte {
+	b = appendProtoVarint(b, protoQueryID, q.ID)
+	b = appendProtoVarint(b, protoQueryMethod, uint64(q.Method))
+	b = appendProtoBytes(b, protoQueryKey, []byte(q.Key))
+	b = appendProtoBytes(b, protoQueryValue, q.Value)
+	b = appendProtoVarint(b, protoQueryTTL, uint64(q.TTL))
+	b = appendProtoVarint(b, protoQueryVersion, q.Version)
+	b = appendProtoBytes(b, protoQueryPrefix, []byte(q.Prefix))
+	b = appendProtoBytes(b, protoQueryStart, []byte(q.Start))
+	b = appendProtoBytes(b, protoQueryEnd, []byte(q.End))
+	b = appendProtoVarint(b, protoQueryLimit, uint64(q.Limit))
+	b = appendProtoBytes(b, protoQueryCursor, []byte(q.Cursor))
+	for _, c := range q.Conditions {
+		var m []byte
+		m = appendProtoBytes(m, protoConditionKey, []byte(c.Key))
+		m = appendProtoVarint(m, protoConditionCheck, uint64(c.Check))
+		m = appendProtoVarint(m, protoConditionVersion, c.Version)
+		b = appendProtoMessage(b, protoQueryConditions, m)
+	}
+	for _, mu := range q.Mutations {
+		var m []byte
+		m = appendProtoBytes(m, protoMutationKey, []byte(mu.Key))
+		m = appendProtoVarint(m, protoMutationOperation, uint64(mu.Operation))
+		m = appendProtoBytes(m, protoMutationValue, mu.Value)
+		m = appendProtoVarint(m, protoMutationTTL, uint64(mu.TTL))
+		m = appendProtoBytes(m, protoMutationContentType, []byte(mu.ContentType))
+		m = appendProtoBytes(m, protoMutationContentEncoding, []byte(mu.ContentEncoding))
+		m = appendProtoMap(m, protoMutationMetadata, mu.Metadata)
+		b = appendProtoMessage(b, protoQueryMutations, m)
+	}
+	b = appendProtoBytes(b, protoQueryContentType, []byte(q.ContentType))
+	b = appendProtoBytes(b, protoQueryContentEncoding, []byte(q.ContentEncoding))
+	b = appendProtoMap(b, protoQueryMetadata, q.Metadata)
+	return b
+}
+
+func appendProtoResult(b []byte, r *Result) []byte {
+	b = appendProtoVarint(b, protoResultID, r.ID)
+	b = appendProtoVarint(b, protoResultStatus, uint64(r.Status))
+	b = appendProtoBytes(b, protoResultValue, r.Value)
+	b = appendProtoVarint(b, protoResultVersion, r.Version)
+	for _, e := range r.Entries {
+		var m []byte
+		m = appendProtoBytes(m, protoEntryKey, []byte(e.Key))
+		m = appendProtoBytes(m, protoEntryValue, e.Value)
+		m = appendProtoVarint(m, protoEntryVersion, e.Version)
+		b = appendProtoMessage(b, protoResultEntries, m)
+	}
+	b = appendProtoBytes(b, protoResultCursor, []byte(r.Cursor))
+	if len(r.Versions) > 0 {
+		// Repeated numbers are packed, as is the default for proto3.
+		var m []byte
+		for _, v := range r.Versions {
+			m = protowire.AppendVarint(m, v)
+		}
+		b = appendProtoMessage(b, protoResultVersions, m)
+	}
+	if e := r.Event; e.Type != 0 || e.Key != "" || len(e.Value) > 0 || e.Version != 0 {
+		var m []byte
+		m = appendProtoVarint(m, protoEventType, uint64(e.Type))
+		m = appendProtoBytes(m, protoEventKey, []byte(e.Key))
+		m = appendProtoBytes(m, protoEventValue, e.Value)
+		m = appendProtoVarint(m, protoEventVersion, e.Version)
+		b = appendProtoMessage(b, protoResultEvent, m)
+	}
+	b = appendProtoBytes(b, protoResultDuration, []byte(r.Duration))
+	b = appendProtoBytes(b, protoResultContentType, []byte(r.ContentType))
+	b = appendProtoBytes(b, protoResultContentEncoding, []byte(r.ContentEncoding))
+	b = appendProtoMap(b, protoResultMetadata, r.Metadata)
+	return b
+}
+
+// appendProtoMap appends every entry of the map in key order, so that the
+// same map is always encoded the same way.
+func appendProtoMap(b []byte, num protowire.Number, v map[string]string) []byte {
+	keys := make([]string, 0, len(v))
+	for k := range v {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		var m []byte
+		m = appendProtoBytes(m, protoMapKey, []byte(k))
+		m = appendProtoBytes(m, protoMapValue, []byte(v[k]))
+		b = appendProtoMessage(b, num, m)
+	}
+	return b
+}
+
+// appendProtoVarint appends the field, unless it's zero as proto3 doesn't
+// encode default values.
+func appendProtoVarint(b []byte, num protowire.Number, v uint64) []byte {
+	if v == 0 {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.VarintType)
+	return protowire.AppendVarint(b, v)
+}
+
+func appendProtoBytes(b []byte, num protowire.Number, v []byte) []byte {
+	if len(v) == 0 {
+		return b
+	}
+	return appendProtoMessage(b, num, v)
+}
+
+func appendProtoMessage(b []byte, num protowire.Number, v []byte) []byte {
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendBytes(b, v)
+}
+
+// protoField is called for every field of a message, with either the varint
+// or the bytes of the field depending on its type.
+type protoField func(num protowire.Number, typ protowire.Type, v uint64, b []byte) error
+
+// parseProto calls fn for every field of the message. Fields that aren't
+// varints or bytes are skipped, as none of the messages have any.
+func parseProto(b []byte, fn protoField) error {
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return ErrProtoMalformed
+		}
+		b = b[n:]
+
+		var (
+			v     uint64
+			bytes []byte
+		)
+		switch typ {
+		case protowire.VarintType:
+			v, n = protowire.ConsumeVarint(b)
+		case protowire.BytesType:
+			bytes, n = protowire.ConsumeBytes(b)
+		default:
+			n = protowire.ConsumeFieldValue(num, typ, b)
+			if n < 0 {
+				return ErrProtoMalformed
+			}
+			b = b[n:]
+			continue
+		}
+		if n < 0 {
+			return ErrProtoMalformed
+		}
+		b = b[n:]
+
+		if err := fn(num, typ, v, bytes); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (q *Query) field(num protowire.Number, typ protowire.Type, v uint64, b []byte) error {
+	switch num {
+	case protoQueryID:
+		q.ID = v
+	case protoQueryMethod:
+		q.Method = Method(v)
+	case protoQueryKey:
+		q.Key = string(b)
+	case protoQueryValue:
+		q.Value = copyBytes(b)
+	case protoQueryTTL:
+		q.TTL = time.Duration(v)
+	case protoQueryVersion:
+		q.Version = v
+	case protoQueryPrefix:
+		q.Prefix = string(b)
+	case protoQueryStart:
+		q.Start = string(b)
+	case protoQueryEnd:
+		q.End = string(b)
+	case protoQueryLimit:
+		q.Limit = int(int32(v))
+	case protoQueryCursor:
+		q.Cursor = string(b)
+	case protoQueryConditions:
+		var c Condition
+		if err := parseProto(b, c.field); err != nil {
+			return err
+		}
+		q.Conditions = append(q.Conditions, c)
+	case protoQueryMutations:
+		var m Mutation
+		if err := parseProto(b, m.field); err != nil {
+			return err
+		}
+		q.Mutations = append(q.Mutations, m)
+	case protoQueryContentType:
+		q.ContentType = string(b)
+	case protoQueryContentEncoding:
+		q.ContentEncoding = string(b)
+	case protoQueryMetadata:
+		return parseProtoMap(b, &q.Metadata)
+	}
+	return nil
+}
+
+func (c *Condition) field(num protowire.Number, typ protowire.Type, v uint64, b []byte) error {
+	switch num {
+	case protoConditionKey:
+		c.Key = string(b)
+	case protoConditionCheck:
+		c.Check = Check(v)
+	case protoConditionVersion:
+		c.Version = v
+	}
+	return nil
+}
+
+func (m *Mutation) field(num protowire.Number, typ protowire.Type, v uint64, b []byte) error {
+	switch num {
+	case protoMutationKey:
+		m.Key = string(b)
+	case protoMutationOperation:
+		m.Operation = Operation(v)
+	case protoMutationValue:
+		m.Value = copyBytes(b)
+	case protoMutationTTL:
+		m.TTL = time.Duration(v)
+	case protoMutationContentType:
+		m.ContentType = string(b)
+	case protoMutationContentEncoding:
+		m.ContentEncoding = string(b)
+	case protoMutationMetadata:
+		return parseProtoMap(b, &m.Metadata)
+	}
+	return nil
+}
+
+func (r *Result) field(num protowire.Number, typ protowire.Type, v uint64, b []byte) error {
+	switch num {
+	case protoResultID:
+		r.ID = v
+	case protoResultStatus:
+		r.Status = Status(v)
+	case protoResultValue:
+		r.Value = copyBytes(b)
+	case protoResultVersion:
+		r.Version = v
+	case protoResultEntries:
+		var e Entry
+		if err := parseProto(b, e.field); err != nil {
+			return err
+		}
+		r.Entries = append(r.Entries, e)
+	case protoResultCursor:
+		r.Cursor = string(b)
+	case protoResultVersions:
+		// Both packed and unpacked numbers have to be accepted.
+		if typ == protowire.VarintType {
+			r.Versions = append(r.Versions, v)
+			break
+		}
+		for len(b) > 0 {
+			version, n := protowire.ConsumeVarint(b)
+			if n < 0 {
+				return ErrProtoMalformed
+			}
+			r.Versions = append(r.Versions, version)
+			b = b[n:]
+		}
+	case protoResultEvent:
+		return parseProto(b, r.Event.field)
+	case protoResultDuration:
+		r.Duration = string(b)
+	case protoResultContentType:
+		r.ContentType = string(b)
+	case protoResultContentEncoding:
+		r.ContentEncoding = string(b)
+	case protoResultMetadata:
+		return parseProtoMap(b, &r.Metadata)
+	}
+	return nil
+}
+
+func (e *Entry) field(num protowire.Number, typ protowire.Type, v uint64, b []byte) error {
+	switch num {
+	case protoEntryKey:
+		e.Key = string(b)
+	case protoEntryValue:
+		e.Value = copyBytes(b)
+	case protoEntryVersion:
+		e.Version = v
+	}
+	return nil
+}
+
+func (e *Event) field(num protowire.Number, typ protowire.Type, v uint64, b []byte) error {
+	switch num {
+	case protoEventType:
+		e.Type = EventType(v)
+	case protoEventKey:
+		e.Key = string(b)
+	case protoEventValue:
+		e.Value = copyBytes(b)
+	case protoEventVersion:
+		e.Version = v
+	}
+	return nil
+}
+
+// parseProtoMap parses an entry of a map into the map, creating the map if
+// it's the first entry.
+func parseProtoMap(b []byte, v *map[string]string) error {
+	var key, value string
+	if err := parseProto(b, func(num protowire.Number, typ protowire.Type, _ uint64, b []byte) error {
+		switch num {
+		case protoMapKey:
+			key = string(b)
+		case protoMapValue:
+			value = string(b)
+		}
+		return nil
+	}); err != nil {
+		return err
+	}
+
+	if *v == nil {
+		*v = make(map[string]string)
+	}
+	(*v)[key] = value
+	return nil
+}
+
+// copyBytes copies the bytes, so that the message doesn't hold on to the
+// buffer it was parsed from.
+func copyBytes(b []byte) []byte {
+	if len(b) == 0 {
+		return nil
+	}
+	return append([]byte(nil), b...)
+}
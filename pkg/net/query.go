@@ -1,14 +1,44 @@
 package net
 
 import (
-	"encoding/gob"
 	"errors"
 	"io"
+	"time"
 )
 
+// Encoder represents a stream of results, a single Encoder is expected to be
+// used for every result of the stream.
+type Encoder interface {
+	Encode(Result) error
+}
+
+// NewEncoder creates an Encoder that encodes the results as gob to the writer.
+func NewEncoder(w io.Writer) Encoder {
+	return NewCodecEncoder(Gob, w)
+}
+
+// NewCodecEncoder creates an Encoder that encodes the results with the codec
+// to the writer.
+func NewCodecEncoder(codec Codec, w io.Writer) Encoder {
+	return resultEncoder{codec.NewEncoder(w)}
+}
+
+type resultEncoder struct {
+	enc MessageEncoder
+}
+
+func (e resultEncoder) Encode(result Result) error {
+	return e.enc.Encode(result)
+}
+
 // QueryParams defines all the dimensions of a query.
 type QueryParams struct {
-	Key string
+	Key             string
+	TTL             time.Duration
+	Version         uint64
+	ContentType     string
+	ContentEncoding string
+	Metadata        map[string]string
 }
 
 // DecodeFrom populates a QueryParams from a URL.
@@ -16,23 +46,186 @@ func (qp *QueryParams) DecodeFrom(q Query) error {
 	if qp.Key = q.Key; qp.Key == "" {
 		return errors.New("error reading 'key' (required) query")
 	}
+	if qp.TTL = q.TTL; qp.TTL < 0 {
+		return errors.New("error reading 'ttl' (optional) query")
+	}
+	qp.Version = q.Version
+	if err := validMetadata(q.ContentType, q.ContentEncoding, q.Metadata); err != nil {
+		return err
+	}
+	qp.ContentType = q.ContentType
+	qp.ContentEncoding = q.ContentEncoding
+	qp.Metadata = q.Metadata
+	return nil
+}
+
+// MaxMetadataSize is the maximum size of the content type, content encoding
+// and metadata of a value.
+const MaxMetadataSize = 8 << 10
+
+// validMetadata checks that the metadata is small and can be sent as the
+// headers of the http API, so the metadata can be returned by every API.
+func validMetadata(contentType, contentEncoding string, metadata map[string]string) error {
+	if !validHeaderValue(contentType) {
+		return errors.New("error reading 'content_type' (optional) query")
+	}
+	if !validHeaderValue(contentEncoding) {
+		return errors.New("error reading 'content_encoding' (optional) query")
+	}
+
+	size := len(contentType) + len(contentEncoding)
+	for k, v := range metadata {
+		if !validHeaderName(k) || !validHeaderValue(v) {
+			return errors.New("error reading 'metadata' (optional) query")
+		}
+		size += len(k) + len(v)
+	}
+	if size > MaxMetadataSize {
+		return errors.New("error reading 'metadata' (optional) query, too large")
+	}
+	return nil
+}
+
+// validHeaderName returns true if the name is made up of letters, digits,
+// '-' and '_', which is a subset of the names that http allows.
+func validHeaderName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for _, c := range name {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9', c == '-', c == '_':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// validHeaderValue returns true if the value has no control characters, other
+// than tabs.
+func validHeaderValue(value string) bool {
+	for _, c := range value {
+		if (c < ' ' && c != '\t') || c == 0x7f {
+			return false
+		}
+	}
+	return true
+}
+
+const (
+	// DefaultScanLimit is the limit used for a scan when no limit is supplied.
+	DefaultScanLimit = 100
+	// MaxScanLimit is the maximum limit that can be used for a scan.
+	MaxScanLimit = 1000
+)
+
+// ScanQueryParams defines all the dimensions of a scan query.
+type ScanQueryParams struct {
+	Prefix string
+	Start  string
+	End    string
+	Limit  int
+	Cursor string
+}
+
+// DecodeFrom populates a ScanQueryParams from a Query.
+func (qp *ScanQueryParams) DecodeFrom(q Query) error {
+	qp.Prefix = q.Prefix
+	qp.Start = q.Start
+	qp.End = q.End
+	qp.Cursor = q.Cursor
+
+	switch qp.Limit = q.Limit; {
+	case qp.Limit == 0:
+		qp.Limit = DefaultScanLimit
+	case qp.Limit < 0 || qp.Limit > MaxScanLimit:
+		return errors.New("error reading 'limit' (optional) query")
+	}
+	return nil
+}
+
+// MaxTxnSize is the maximum amount of conditions and mutations that can be
+// used for a transaction.
+const MaxTxnSize = 1000
+
+// TxnQueryParams defines all the dimensions of a transaction query.
+type TxnQueryParams struct {
+	Conditions []Condition
+	Mutations  []Mutation
+}
+
+// DecodeFrom populates a TxnQueryParams from a Query.
+func (qp *TxnQueryParams) DecodeFrom(q Query) error {
+	if len(q.Conditions)+len(q.Mutations) > MaxTxnSize {
+		return errors.New("error reading 'txn' (required) query, too large")
+	}
+	for _, c := range q.Conditions {
+		if c.Key == "" {
+			return errors.New("error reading 'key' (required) condition")
+		}
+		if c.Check < CheckExists || c.Check > CheckVersion {
+			return errors.New("error reading 'check' (required) condition")
+		}
+	}
+	for _, m := range q.Mutations {
+		if m.Key == "" {
+			return errors.New("error reading 'key' (required) mutation")
+		}
+		if m.Operation < OperationSet || m.Operation > OperationDelete {
+			return errors.New("error reading 'operation' (required) mutation")
+		}
+		if m.TTL < 0 {
+			return errors.New("error reading 'ttl' (optional) mutation")
+		}
+		if err := validMetadata(m.ContentType, m.ContentEncoding, m.Metadata); err != nil {
+			return err
+		}
+	}
+	qp.Conditions = q.Conditions
+	qp.Mutations = q.Mutations
+	return nil
+}
+
+// WatchQueryParams defines all the dimensions of a watch query.
+type WatchQueryParams struct {
+	Key    string
+	Prefix string
+}
+
+// DecodeFrom populates a WatchQueryParams from a Query.
+func (qp *WatchQueryParams) DecodeFrom(q Query) error {
+	qp.Key = q.Key
+	qp.Prefix = q.Prefix
+
+	// Either the key or the prefix can be watched, but not both.
+	if qp.Key != "" && qp.Prefix != "" {
+		return errors.New("error reading 'key' and 'prefix' (optional) query")
+	}
 	return nil
 }
 
 // SelectQueryResult contains statistics about the query.
 type SelectQueryResult struct {
-	Params   QueryParams
-	Duration string
-	Value    []byte
+	Params          QueryParams
+	Duration        string
+	Value           []byte
+	Version         uint64
+	ContentType     string
+	ContentEncoding string
+	Metadata        map[string]string
 }
 
-// EncodeTo encodes the SelectQueryResult to the HTTP response writer.
-func (qr *SelectQueryResult) EncodeTo(w io.Writer) {
-	enc := gob.NewEncoder(w)
+// EncodeTo encodes the SelectQueryResult to the Encoder.
+func (qr *SelectQueryResult) EncodeTo(enc Encoder) {
 	enc.Encode(Result{
-		Status:   OK,
-		Value:    qr.Value,
-		Duration: qr.Duration,
+		Status:          OK,
+		Value:           qr.Value,
+		Version:         qr.Version,
+		Duration:        qr.Duration,
+		ContentType:     qr.ContentType,
+		ContentEncoding: qr.ContentEncoding,
+		Metadata:        qr.Metadata,
 	})
 }
 
@@ -41,19 +234,20 @@ type InsertQueryResult struct {
 	Params   QueryParams
 	Duration string
 	Created  bool
+	Version  uint64
 }
 
-// EncodeTo encodes the InsertQueryResult to the HTTP response writer.
-func (qr *InsertQueryResult) EncodeTo(w io.Writer) {
+// EncodeTo encodes the InsertQueryResult to the Encoder.
+func (qr *InsertQueryResult) EncodeTo(enc Encoder) {
 	status := OK
 	if qr.Created {
 		status = Created
 	}
 
-	enc := gob.NewEncoder(w)
 	enc.Encode(Result{
 		Status:   status,
 		Value:    []byte{},
+		Version:  qr.Version,
 		Duration: qr.Duration,
 	})
 }
@@ -64,12 +258,53 @@ type DeleteQueryResult struct {
 	Duration string
 }
 
-// EncodeTo encodes the DeleteQueryResult to the HTTP response writer.
-func (qr *DeleteQueryResult) EncodeTo(w io.Writer) {
-	enc := gob.NewEncoder(w)
+// EncodeTo encodes the DeleteQueryResult to the Encoder.
+func (qr *DeleteQueryResult) EncodeTo(enc Encoder) {
+	enc.Encode(Result{
+		Status:   OK,
+		Value:    []byte{},
+		Duration: qr.Duration,
+	})
+}
+
+// ScanQueryResult contains statistics about the query.
+type ScanQueryResult struct {
+	Params   ScanQueryParams
+	Duration string
+	Entries  []Entry
+	Cursor   string
+}
+
+// EncodeTo encodes the ScanQueryResult to the Encoder.
+func (qr *ScanQueryResult) EncodeTo(enc Encoder) {
 	enc.Encode(Result{
 		Status:   OK,
 		Value:    []byte{},
+		Entries:  qr.Entries,
+		Cursor:   qr.Cursor,
+		Duration: qr.Duration,
+	})
+}
+
+// TxnQueryResult contains statistics about the query.
+type TxnQueryResult struct {
+	Params   TxnQueryParams
+	Duration string
+	Applied  bool
+	Versions []uint64
+}
+
+// EncodeTo encodes the TxnQueryResult to the Encoder.
+func (qr *TxnQueryResult) EncodeTo(enc Encoder) {
+	status := OK
+	if !qr.Applied {
+		status = Conflict
+	}
+
+	enc.Encode(Result{
+		Status:   status,
+		Value:    []byte{},
+		Versions: qr.Versions,
 		Duration: qr.Duration,
 	})
 }
@@ -0,0 +1,129 @@
+package resp
+
+// globPrefix returns the literal prefix of the pattern, before any of the
+// special characters. Every key matching the pattern starts with the prefix, so
+// it can be used to limit a scan of the store.
+func globPrefix(pattern string) string {
+	for k := 0; k < len(pattern); k++ {
+		switch pattern[k] {
+		case '*', '?', '[', '\\':
+			return pattern[:k]
+		}
+	}
+	return pattern
+}
+
+// globMatch reports whether the key matches the glob style pattern, following
+// the same rules as redis:
+//   - `*` matches any sequence of characters
+//   - `?` matches any single character
+//   - `[abc]`, `[a-z]` and `[^a]` match a set of characters
+//   - `\` escapes the next character
+func globMatch(pattern, key string) bool {
+	// Backtracking only ever needs to resume from the last star, as any
+	// earlier star can be expanded by the last star instead.
+	var (
+		p, k         int
+		star, starAt = -1, 0
+	)
+	for k < len(key) {
+		if p < len(pattern) {
+			switch c := pattern[p]; c {
+			case '*':
+				star, starAt = p, k
+				p++
+				continue
+			case '?':
+				p++
+				k++
+				continue
+			case '[':
+				if next, ok := matchClass(pattern, p, key[k]); next > 0 {
+					if ok {
+						p = next
+						k++
+						continue
+					}
+					break
+				}
+				// An unterminated class is treated as a literal.
+				if key[k] == '[' {
+					p++
+					k++
+					continue
+				}
+			case '\\':
+				if p+1 < len(pattern) {
+					if pattern[p+1] == key[k] {
+						p += 2
+						k++
+						continue
+					}
+					break
+				}
+				if key[k] == '\\' {
+					p++
+					k++
+					continue
+				}
+			default:
+				if c == key[k] {
+					p++
+					k++
+					continue
+				}
+			}
+		}
+		if star < 0 {
+			return false
+		}
+		starAt++
+		p, k = star+1, starAt
+	}
+	for p < len(pattern) && pattern[p] == '*' {
+		p++
+	}
+	return p == len(pattern)
+}
+
+// matchClass matches the character against the class starting at p, returning
+// the position after the class. A position of zero means the class isn't
+// terminated.
+func matchClass(pattern string, p int, c byte) (int, bool) {
+	p++
+	negate := p < len(pattern) && pattern[p] == '^'
+	if negate {
+		p++
+	}
+
+	var matched bool
+	for first := true; p < len(pattern); first = false {
+		if pattern[p] == ']' && !first {
+			return p + 1, matched != negate
+		}
+
+		lo := pattern[p]
+		if lo == '\\' && p+1 < len(pattern) {
+			p++
+			lo = pattern[p]
+		}
+		p++
+
+		hi := lo
+		if p+1 < len(pattern) && pattern[p] == '-' && pattern[p+1] != ']' {
+			hi = pattern[p+1]
+			if hi == '\\' && p+2 < len(pattern) {
+				p++
+				hi = pattern[p+1]
+			}
+			p += 2
+			if lo > hi {
+				lo, hi = hi, lo
+			}
+		}
+		if lo <= c && c <= hi {
+			matched = true
+		}
+	}
+	return 0, false
+}
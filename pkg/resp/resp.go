@@ -0,0 +1,222 @@
+package resp
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"io/ioutil"
+	"strconv"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// DefaultMaxBulkLength is the default largest bulk string that can be
+	// read, the same as the default largest value sent over http.
+	DefaultMaxBulkLength = 32 << 20
+
+	// maxArrayLength is the most arguments that a command can have.
+	maxArrayLength = 1024 * 1024
+	// initialArrayLength is the most arguments that are allocated up front,
+	// so that the length of an array can't allocate more than the arguments
+	// that are actually sent.
+	initialArrayLength = 64
+	// maxInlineLength is the longest inline command that can be read.
+	maxInlineLength = 64 * 1024
+)
+
+// errProtocol is returned when the client doesn't follow the protocol, the
+// connection can't be recovered once this happens.
+var errProtocol = errors.New("Protocol error")
+
+// reader reads the commands sent by a client, commands are either an array of
+// bulk strings or an inline command separated by spaces. maxBulk is the largest
+// bulk string that can be read.
+type reader struct {
+	r       *bufio.Reader
+	maxBulk int
+}
+
+func newReader(r io.Reader, maxBulk int) *reader {
+	return &reader{
+		r:       bufio.NewReader(r),
+		maxBulk: maxBulk,
+	}
+}
+
+// buffered returns the amount of bytes that have already been read from the
+// connection, but not yet read as a command.
+func (r *reader) buffered() int {
+	return r.r.Buffered()
+}
+
+// readCommand returns the arguments of the next command, the first argument is
+// the name of the command. Empty commands are skipped.
+func (r *reader) readCommand() ([][]byte, error) {
+	for {
+		line, err := r.readLine()
+		if err != nil {
+			return nil, err
+		}
+		if len(line) == 0 {
+			continue
+		}
+		if line[0] != '*' {
+			if args := bytes.Fields(line); len(args) > 0 {
+				return args, nil
+			}
+			continue
+		}
+
+		n, err := parseLength(line[1:], maxArrayLength)
+		if err != nil {
+			return nil, err
+		}
+		if n <= 0 {
+			continue
+		}
+
+		size := n
+		if size > initialArrayLength {
+			size = initialArrayLength
+		}
+		args := make([][]byte, 0, size)
+		for k := 0; k < n; k++ {
+			arg, err := r.readBulk()
+			if err != nil {
+				return nil, err
+			}
+			args = append(args, arg)
+		}
+		return args, nil
+	}
+}
+
+func (r *reader) readBulk() ([]byte, error) {
+	line, err := r.readLine()
+	if err != nil {
+		return nil, err
+	}
+	if len(line) == 0 || line[0] != '$' {
+		return nil, errProtocol
+	}
+	n, err := parseLength(line[1:], r.maxBulk)
+	if err != nil || n < 0 {
+		return nil, errProtocol
+	}
+
+	// Read the trailing \r\n along with the bulk string. The bulk string is
+	// read as it arrives, rather than allocating all of it up front from the
+	// length, which the client can send without ever sending the bulk string.
+	buf, err := ioutil.ReadAll(io.LimitReader(r.r, int64(n)+2))
+	if err != nil {
+		return nil, err
+	}
+	if len(buf) < n+2 {
+		return nil, io.ErrUnexpectedEOF
+	}
+	if buf[n] != '\r' || buf[n+1] != '\n' {
+		return nil, errProtocol
+	}
+	return buf[:n], nil
+}
+
+// readLine reads up to the next \r\n, returning the line without it.
+func (r *reader) readLine() ([]byte, error) {
+	var line []byte
+	for {
+		chunk, isPrefix, err := r.r.ReadLine()
+		if err != nil {
+			return nil, err
+		}
+		line = append(line, chunk...)
+		if len(line) > maxInlineLength {
+			return nil, errProtocol
+		}
+		if !isPrefix {
+			return line, nil
+		}
+	}
+}
+
+func parseLength(b []byte, max int) (int, error) {
+	n, err := strconv.Atoi(string(b))
+	if err != nil || n > max {
+		return 0, errProtocol
+	}
+	return n, nil
+}
+
+// writer writes the replies to a client, in either RESP2 or RESP3. The replies
+// are buffered until they're flushed.
+type writer struct {
+	w     *bufio.Writer
+	proto int
+}
+
+func newWriter(w io.Writer) *writer {
+	return &writer{
+		w:     bufio.NewWriter(w),
+		proto: 2,
+	}
+}
+
+func (w *writer) flush() error {
+	return w.w.Flush()
+}
+
+func (w *writer) writeSimple(s string) {
+	w.w.WriteByte('+')
+	w.w.WriteString(s)
+	w.w.WriteString("\r\n")
+}
+
+func (w *writer) writeError(s string) {
+	w.w.WriteByte('-')
+	w.w.WriteString(s)
+	w.w.WriteString("\r\n")
+}
+
+func (w *writer) writeInteger(n int64) {
+	w.writeHeader(':', n)
+}
+
+func (w *writer) writeBulk(b []byte) {
+	w.writeHeader('$', int64(len(b)))
+	w.w.Write(b)
+	w.w.WriteString("\r\n")
+}
+
+func (w *writer) writeBulkString(s string) {
+	w.writeHeader('$', int64(len(s)))
+	w.w.WriteString(s)
+	w.w.WriteString("\r\n")
+}
+
+func (w *writer) writeNull() {
+	if w.proto >= 3 {
+		w.w.WriteString("_\r\n")
+		return
+	}
+	w.w.WriteString("$-1\r\n")
+}
+
+func (w *writer) writeArray(n int) {
+	w.writeHeader('*', int64(n))
+}
+
+// writeMap writes the header of a map of n pairs, RESP2 has no maps, so a
+// flattened array of the pairs is used instead.
+func (w *writer) writeMap(n int) {
+	if w.proto >= 3 {
+		w.writeHeader('%', int64(n))
+		return
+	}
+	w.writeHeader('*', int64(n*2))
+}
+
+func (w *writer) writeHeader(prefix byte, n int64) {
+	w.w.WriteByte(prefix)
+	w.w.WriteString(strconv.FormatInt(n, 10))
+	w.w.WriteString("\r\n")
+}
@@ -0,0 +1,489 @@
+package resp
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/SimonRichardson/keyval/pkg/store"
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+)
+
+const (
+	// defaultScanCount is the amount of keys a scan returns when no count is
+	// supplied, this matches redis.
+	defaultScanCount = 10
+	// maxScanCursors is the amount of scan cursors a connection holds on to,
+	// once there are too many the oldest cursors are forgotten.
+	maxScanCursors = 1024
+)
+
+// Server represents a way to interact with the underlying key/val store using
+// the redis serialization protocol (RESP), so that existing redis clients can
+// be used. Both RESP2 and RESP3 (via HELLO) are supported.
+type Server struct {
+	store  store.Store
+	logger log.Logger
+
+	// MaxBulkLength is the largest bulk string that can be sent in bytes, a
+	// larger bulk string is a protocol error.
+	MaxBulkLength int
+}
+
+// NewServer creates a Server with the correct dependencies
+func NewServer(store store.Store, logger log.Logger) *Server {
+	return &Server{
+		store:         store,
+		logger:        logger,
+		MaxBulkLength: DefaultMaxBulkLength,
+	}
+}
+
+// Serve the listener for the server
+func (s *Server) Serve(listener net.Listener) error {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return err
+		}
+		go s.handleConn(conn)
+	}
+}
+
+// session holds the state of a connection.
+// Redis scan cursors are numbers, but the cursors of the store are keys, so
+// each cursor is handed out as a number and the key is held by the session.
+type session struct {
+	w       *writer
+	cursors map[uint64]string
+	next    uint64
+	quit    bool
+}
+
+// cursor stores the cursor of the store, returning the number for the cursor.
+func (s *session) cursor(cursor string) uint64 {
+	s.next++
+	s.cursors[s.next] = cursor
+	delete(s.cursors, s.next-maxScanCursors)
+	return s.next
+}
+
+func (s *Server) handleConn(conn net.Conn) {
+	defer conn.Close()
+
+	var (
+		r    = newReader(conn, s.MaxBulkLength)
+		sess = &session{
+			w:       newWriter(conn),
+			cursors: make(map[uint64]string),
+		}
+	)
+	for !sess.quit {
+		args, err := r.readCommand()
+		if err != nil {
+			if err == errProtocol {
+				sess.w.writeError("ERR " + err.Error())
+				sess.w.flush()
+			} else if err != io.EOF {
+				level.Debug(s.logger).Log("err", err)
+			}
+			return
+		}
+
+		s.handleCommand(sess, args)
+
+		// Only flush once there are no more pipelined commands waiting, so
+		// the replies of pipelined commands are written together.
+		if r.buffered() > 0 {
+			continue
+		}
+		if err := sess.w.flush(); err != nil {
+			return
+		}
+	}
+	sess.w.flush()
+}
+
+func (s *Server) handleCommand(sess *session, args [][]byte) {
+	w := sess.w
+
+	name := strings.ToUpper(string(args[0]))
+	switch name {
+	case "PING":
+		s.handlePing(w, args)
+	case "ECHO":
+		s.handleEcho(w, args)
+	case "HELLO":
+		s.handleHello(w, args)
+	case "COMMAND":
+		// Clients ask about the commands on start up, there are no details
+		// of the commands to give.
+		w.writeArray(0)
+	case "QUIT":
+		w.writeSimple("OK")
+		sess.quit = true
+	case "GET":
+		s.handleGet(w, args)
+	case "SET":
+		s.handleSet(w, args)
+	case "DEL":
+		s.handleDel(w, args)
+	case "EXISTS":
+		s.handleExists(w, args)
+	case "MGET":
+		s.handleMGet(w, args)
+	case "MSET":
+		s.handleMSet(w, args)
+	case "KEYS":
+		s.handleKeys(w, args)
+	case "SCAN":
+		s.handleScan(sess, args)
+	default:
+		w.writeError(fmt.Sprintf("ERR unknown command '%s'", args[0]))
+	}
+}
+
+func (s *Server) handlePing(w *writer, args [][]byte) {
+	switch len(args) {
+	case 1:
+		w.writeSimple("PONG")
+	case 2:
+		w.writeBulk(args[1])
+	default:
+		writeArity(w, args[0])
+	}
+}
+
+func (s *Server) handleEcho(w *writer, args [][]byte) {
+	if len(args) != 2 {
+		writeArity(w, args[0])
+		return
+	}
+	w.writeBulk(args[1])
+}
+
+// handleHello switches the protocol version of the connection and replies with
+// the details of the server.
+func (s *Server) handleHello(w *writer, args [][]byte) {
+	if len(args) > 1 {
+		proto, err := strconv.Atoi(string(args[1]))
+		if err != nil {
+			w.writeError("ERR Protocol version is not an integer or out of range")
+			return
+		}
+		if proto != 2 && proto != 3 {
+			w.writeError("NOPROTO unsupported protocol version")
+			return
+		}
+		w.proto = proto
+	}
+
+	w.writeMap(3)
+	w.writeBulkString("server")
+	w.writeBulkString("keyval")
+	w.writeBulkString("proto")
+	w.writeInteger(int64(w.proto))
+	w.writeBulkString("mode")
+	w.writeBulkString("standalone")
+}
+
+func (s *Server) handleGet(w *writer, args [][]byte) {
+	if len(args) != 2 {
+		writeArity(w, args[0])
+		return
+	}
+
+	value, ok := s.store.Get(string(args[1]))
+	if !ok {
+		w.writeNull()
+		return
+	}
+	w.writeBulk(value)
+}
+
+// handleSet supports the EX, PX, NX and XX options of SET. NX and XX are
+// checked and applied atomically via a transaction.
+func (s *Server) handleSet(w *writer, args [][]byte) {
+	if len(args) < 3 {
+		writeArity(w, args[0])
+		return
+	}
+
+	var (
+		key, value = string(args[1]), args[2]
+		ttl        time.Duration
+		conditions []store.Condition
+	)
+	for k := 3; k < len(args); k++ {
+		switch option := strings.ToUpper(string(args[k])); option {
+		case "EX", "PX":
+			if ttl > 0 || k+1 >= len(args) {
+				w.writeError("ERR syntax error")
+				return
+			}
+			k++
+			n, err := strconv.ParseInt(string(args[k]), 10, 64)
+			if err != nil {
+				w.writeError("ERR value is not an integer or out of range")
+				return
+			}
+			unit := time.Second
+			if option == "PX" {
+				unit = time.Millisecond
+			}
+			// The expire time can't be more than a duration can hold.
+			if n <= 0 || n > math.MaxInt64/int64(unit) {
+				w.writeError("ERR invalid expire time in 'set' command")
+				return
+			}
+			ttl = time.Duration(n) * unit
+		case "NX", "XX":
+			if len(conditions) > 0 {
+				w.writeError("ERR syntax error")
+				return
+			}
+			check := store.CheckMissing
+			if option == "XX" {
+				check = store.CheckExists
+			}
+			conditions = append(conditions, store.Condition{
+				Key:   key,
+				Check: check,
+			})
+		default:
+			w.writeError("ERR syntax error")
+			return
+		}
+	}
+
+	// A value that can never fit with in a bounded store is refused.
+	if !store.Fits(s.store, key, value, store.Metadata{}) {
+		w.writeError("OOM command not allowed when used memory > 'maxmemory'.")
+		return
+	}
+
+	ok := true
+	if len(conditions) > 0 {
+		_, ok = s.store.Txn(conditions, []store.Mutation{{
+			Key:       key,
+			Operation: store.OperationSet,
+			Value:     value,
+			TTL:       ttl,
+		}})
+	} else if ttl > 0 {
+		s.store.SetWithTTL(key, value, ttl)
+	} else {
+		s.store.Set(key, value)
+	}
+	if err := store.Err(s.store); err != nil {
+		s.failed(w, err)
+		return
+	} else if !ok {
+		w.writeNull()
+		return
+	}
+	w.writeSimple("OK")
+}
+
+func (s *Server) handleDel(w *writer, args [][]byte) {
+	if len(args) < 2 {
+		writeArity(w, args[0])
+		return
+	}
+
+	var deleted int64
+	for _, key := range args[1:] {
+		if s.store.Delete(string(key)) {
+			deleted++
+		}
+	}
+	if err := store.Err(s.store); err != nil {
+		s.failed(w, err)
+		return
+	}
+	w.writeInteger(deleted)
+}
+
+func (s *Server) handleExists(w *writer, args [][]byte) {
+	if len(args) < 2 {
+		writeArity(w, args[0])
+		return
+	}
+
+	// Keys that are repeated are counted each time, the same as redis.
+	var found int64
+	for _, key := range args[1:] {
+		if _, ok := s.store.Get(string(key)); ok {
+			found++
+		}
+	}
+	w.writeInteger(found)
+}
+
+func (s *Server) handleMGet(w *writer, args [][]byte) {
+	if len(args) < 2 {
+		writeArity(w, args[0])
+		return
+	}
+
+	w.writeArray(len(args) - 1)
+	for _, key := range args[1:] {
+		if value, ok := s.store.Get(string(key)); ok {
+			w.writeBulk(value)
+		} else {
+			w.writeNull()
+		}
+	}
+}
+
+// handleMSet sets all the keys atomically via a transaction.
+func (s *Server) handleMSet(w *writer, args [][]byte) {
+	if len(args) < 3 || len(args)%2 != 1 {
+		writeArity(w, args[0])
+		return
+	}
+
+	mutations := make([]store.Mutation, 0, len(args)/2)
+	for k := 1; k < len(args); k += 2 {
+		mutations = append(mutations, store.Mutation{
+			Key:       string(args[k]),
+			Operation: store.OperationSet,
+			Value:     args[k+1],
+		})
+	}
+	_, ok := s.store.Txn(nil, mutations)
+	if err := store.Err(s.store); err != nil {
+		s.failed(w, err)
+		return
+	} else if !ok {
+		// Without any conditions the keys are only ever not set when they
+		// can't fit with in a bounded store.
+		w.writeError("OOM command not allowed when used memory > 'maxmemory'.")
+		return
+	}
+	w.writeSimple("OK")
+}
+
+func (s *Server) handleKeys(w *writer, args [][]byte) {
+	if len(args) != 2 {
+		writeArity(w, args[0])
+		return
+	}
+
+	pattern := string(args[1])
+	options := store.ScanOptions{
+		Prefix: globPrefix(pattern),
+	}
+
+	var keys []string
+	for {
+		entries, cursor := s.store.Scan(options)
+		for _, entry := range entries {
+			if globMatch(pattern, entry.Key) {
+				keys = append(keys, entry.Key)
+			}
+		}
+		if cursor == "" {
+			break
+		}
+		options.Cursor = cursor
+	}
+
+	w.writeArray(len(keys))
+	for _, key := range keys {
+		w.writeBulkString(key)
+	}
+}
+
+// handleScan supports the MATCH and COUNT options of SCAN, like redis the count
+// is only a hint, so a scan can return less keys than the count even when
+// there are more keys to come.
+func (s *Server) handleScan(sess *session, args [][]byte) {
+	w := sess.w
+	if len(args) < 2 {
+		writeArity(w, args[0])
+		return
+	}
+
+	id, err := strconv.ParseUint(string(args[1]), 10, 64)
+	if err != nil {
+		w.writeError("ERR invalid cursor")
+		return
+	}
+
+	var (
+		pattern = "*"
+		count   = defaultScanCount
+	)
+	for k := 2; k < len(args); k += 2 {
+		if k+1 >= len(args) {
+			w.writeError("ERR syntax error")
+			return
+		}
+		switch strings.ToUpper(string(args[k])) {
+		case "MATCH":
+			pattern = string(args[k+1])
+		case "COUNT":
+			if count, err = strconv.Atoi(string(args[k+1])); err != nil || count < 1 {
+				w.writeError("ERR value is not an integer or out of range")
+				return
+			}
+		default:
+			w.writeError("ERR syntax error")
+			return
+		}
+	}
+
+	options := store.ScanOptions{
+		Prefix: globPrefix(pattern),
+		Limit:  count,
+	}
+	if id > 0 {
+		cursor, ok := sess.cursors[id]
+		if !ok {
+			w.writeError("ERR invalid cursor")
+			return
+		}
+		delete(sess.cursors, id)
+		options.Cursor = cursor
+	}
+
+	entries, cursor := s.store.Scan(options)
+
+	var keys [][]byte
+	for _, entry := range entries {
+		if globMatch(pattern, entry.Key) {
+			keys = append(keys, []byte(entry.Key))
+		}
+	}
+
+	// A cursor of zero means that the scan is complete.
+	var next uint64
+	if cursor != "" {
+		next = sess.cursor(cursor)
+	}
+
+	w.writeArray(2)
+	w.writeBulkString(strconv.FormatUint(next, 10))
+	w.writeArray(len(keys))
+	for _, key := range keys {
+		w.writeBulk(key)
+	}
+}
+
+// failed replies to a write that the store couldn't apply, even though the
+// write itself is valid.
+func (s *Server) failed(w *writer, err error) {
+	level.Error(s.logger).Log("err", err)
+	w.writeError("ERR unable to write to the store")
+}
+
+func writeArity(w *writer, name []byte) {
+	w.writeError(fmt.Sprintf("ERR wrong number of arguments for '%s' command", bytes.ToLower(name)))
+}
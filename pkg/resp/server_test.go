@@ -0,0 +1,368 @@
+package resp
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"testing/quick"
+	"time"
+
+	keyvalStore "github.com/SimonRichardson/keyval/pkg/store"
+	"github.com/go-kit/kit/log"
+)
+
+func TestServer(t *testing.T) {
+	t.Parallel()
+
+	port := 9030
+
+	server := NewServer(keyvalStore.New(), log.NewNopLogger())
+	listener := setupServer(server, port)
+	defer listener.Close()
+
+	for _, testcase := range []struct {
+		name     string
+		commands [][]string
+		expected []string
+	}{
+		{
+			name:     "ping",
+			commands: [][]string{{"PING"}, {"ping", "hello"}},
+			expected: []string{"+PONG\r\n", "$5\r\nhello\r\n"},
+		},
+		{
+			name:     "set then get",
+			commands: [][]string{{"SET", "a", "b"}, {"GET", "a"}, {"GET", "missing"}},
+			expected: []string{"+OK\r\n", "$1\r\nb\r\n", "$-1\r\n"},
+		},
+		{
+			name: "set nx and xx",
+			commands: [][]string{
+				{"SET", "nx", "a", "XX"},
+				{"SET", "nx", "a", "NX"},
+				{"SET", "nx", "b", "NX"},
+				{"SET", "nx", "c", "XX"},
+				{"GET", "nx"},
+			},
+			expected: []string{"$-1\r\n", "+OK\r\n", "$-1\r\n", "+OK\r\n", "$1\r\nc\r\n"},
+		},
+		{
+			name: "set with expiry",
+			commands: [][]string{
+				{"SET", "px", "a", "PX", "1"},
+				{"SET", "ex", "a", "EX", "100"},
+				{"SET", "ex", "a", "EX", "0"},
+				{"SET", "ex", "a", "EX", "9223372037"},
+				{"SET", "px", "a", "PX", "9223372036855"},
+				{"SET", "ex", "a", "EX", "abc"},
+				{"SET", "ex", "a", "EX"},
+			},
+			expected: []string{
+				"+OK\r\n",
+				"+OK\r\n",
+				"-ERR invalid expire time in 'set' command\r\n",
+				"-ERR invalid expire time in 'set' command\r\n",
+				"-ERR invalid expire time in 'set' command\r\n",
+				"-ERR value is not an integer or out of range\r\n",
+				"-ERR syntax error\r\n",
+			},
+		},
+		{
+			name: "del and exists",
+			commands: [][]string{
+				{"MSET", "d1", "a", "d2", "b"},
+				{"EXISTS", "d1", "d2", "d3", "d1"},
+				{"DEL", "d1", "d2", "d3"},
+				{"EXISTS", "d1"},
+			},
+			expected: []string{"+OK\r\n", ":3\r\n", ":2\r\n", ":0\r\n"},
+		},
+		{
+			name: "mset and mget",
+			commands: [][]string{
+				{"MSET", "m1", "a", "m2", "b"},
+				{"MGET", "m1", "missing", "m2"},
+				{"MSET", "m1"},
+			},
+			expected: []string{
+				"+OK\r\n",
+				"*3\r\n$1\r\na\r\n$-1\r\n$1\r\nb\r\n",
+				"-ERR wrong number of arguments for 'mset' command\r\n",
+			},
+		},
+		{
+			name: "keys",
+			commands: [][]string{
+				{"MSET", "user:1", "a", "user:2", "b", "user:10", "c", "other", "d"},
+				{"KEYS", "user:?"},
+			},
+			expected: []string{"+OK\r\n", "*2\r\n$6\r\nuser:1\r\n$6\r\nuser:2\r\n"},
+		},
+		{
+			name: "hello",
+			commands: [][]string{
+				{"HELLO", "3"},
+				{"GET", "missing"},
+				{"HELLO", "2"},
+				{"HELLO", "4"},
+			},
+			expected: []string{
+				"%3\r\n$6\r\nserver\r\n$6\r\nkeyval\r\n$5\r\nproto\r\n:3\r\n$4\r\nmode\r\n$10\r\nstandalone\r\n",
+				"_\r\n",
+				"*6\r\n$6\r\nserver\r\n$6\r\nkeyval\r\n$5\r\nproto\r\n:2\r\n$4\r\nmode\r\n$10\r\nstandalone\r\n",
+				"-NOPROTO unsupported protocol version\r\n",
+			},
+		},
+		{
+			name:     "unknown command",
+			commands: [][]string{{"FLUSHALL"}},
+			expected: []string{"-ERR unknown command 'FLUSHALL'\r\n"},
+		},
+	} {
+		t.Run(testcase.name, func(t *testing.T) {
+			conn := dial(t, port)
+			defer conn.Close()
+
+			for k, command := range testcase.commands {
+				if expected, actual := testcase.expected[k], conn.do(t, command...); expected != actual {
+					t.Errorf("%v expected: %q, actual: %q", command, expected, actual)
+				}
+			}
+		})
+	}
+}
+
+func TestServerPipelined(t *testing.T) {
+	t.Parallel()
+
+	port := 9031
+
+	server := NewServer(keyvalStore.New(), log.NewNopLogger())
+	listener := setupServer(server, port)
+	defer listener.Close()
+
+	conn := dial(t, port)
+	defer conn.Close()
+
+	// Send both an array and an inline command, before reading the replies.
+	fmt.Fprint(conn, "*3\r\n$3\r\nSET\r\n$1\r\na\r\n$1\r\nb\r\nGET a\r\n")
+
+	for _, expected := range []string{"+OK\r\n", "$1\r\nb\r\n"} {
+		if actual := conn.reply(t); expected != actual {
+			t.Errorf("expected: %q, actual: %q", expected, actual)
+		}
+	}
+}
+
+func TestServerScan(t *testing.T) {
+	t.Parallel()
+
+	port := 9032
+
+	store := keyvalStore.NewBucket(4)
+	for i := 0; i < 100; i++ {
+		store.Set(fmt.Sprintf("key:%03d", i), []byte("a"))
+		store.Set(fmt.Sprintf("other:%03d", i), []byte("a"))
+	}
+
+	server := NewServer(store, log.NewNopLogger())
+	listener := setupServer(server, port)
+	defer listener.Close()
+
+	conn := dial(t, port)
+	defer conn.Close()
+
+	var (
+		keys   = make(map[string]bool)
+		cursor = "0"
+	)
+	for i := 0; i < 100; i++ {
+		fmt.Fprint(conn, encode("SCAN", cursor, "MATCH", "key:*", "COUNT", "7"))
+
+		// *2 $n cursor *n keys...
+		conn.line(t)
+		conn.line(t)
+		cursor = conn.line(t)
+		n, _ := strconv.Atoi(strings.TrimPrefix(conn.line(t), "*"))
+		for j := 0; j < n; j++ {
+			conn.line(t)
+			keys[conn.line(t)] = true
+		}
+		if cursor == "0" {
+			break
+		}
+	}
+
+	if expected, actual := "0", cursor; expected != actual {
+		t.Errorf("expected: %v, actual: %v", expected, actual)
+	}
+	if expected, actual := 100, len(keys); expected != actual {
+		t.Errorf("expected: %v, actual: %v", expected, actual)
+	}
+}
+
+func TestServerBounded(t *testing.T) {
+	t.Parallel()
+
+	port := 9033
+
+	// Each value has to fit with in 16 bytes, along with its key.
+	server := NewServer(keyvalStore.NewBounded(keyvalStore.Limits{MaxBytes: 16}), log.NewNopLogger())
+	listener := setupServer(server, port)
+	defer listener.Close()
+
+	conn := dial(t, port)
+	defer conn.Close()
+
+	large := strings.Repeat("a", 32)
+	for _, testcase := range []struct {
+		args     []string
+		expected string
+	}{
+		{[]string{"SET", "a", "b"}, "+OK\r\n"},
+		{[]string{"SET", "a", large}, "-OOM command not allowed when used memory > 'maxmemory'.\r\n"},
+		{[]string{"SET", "a", large, "XX"}, "-OOM command not allowed when used memory > 'maxmemory'.\r\n"},
+		{[]string{"GET", "a"}, "$1\r\nb\r\n"},
+	} {
+		if actual := conn.do(t, testcase.args...); testcase.expected != actual {
+			t.Errorf("%q expected: %q, actual: %q", testcase.args, testcase.expected, actual)
+		}
+	}
+}
+
+func TestServerBulkLength(t *testing.T) {
+	t.Parallel()
+
+	port := 9034
+
+	server := NewServer(keyvalStore.New(), log.NewNopLogger())
+	server.MaxBulkLength = 4
+	listener := setupServer(server, port)
+	defer listener.Close()
+
+	conn := dial(t, port)
+	defer conn.Close()
+
+	if expected, actual := "+OK\r\n", conn.do(t, "SET", "a", "abcd"); expected != actual {
+		t.Errorf("expected: %q, actual: %q", expected, actual)
+	}
+	if expected, actual := "-ERR Protocol error\r\n", conn.do(t, "SET", "a", "abcde"); expected != actual {
+		t.Errorf("expected: %q, actual: %q", expected, actual)
+	}
+}
+
+func TestGlobMatch(t *testing.T) {
+	t.Parallel()
+
+	for _, testcase := range []struct {
+		pattern, key string
+		expected     bool
+	}{
+		{"*", "", true},
+		{"*", "abc", true},
+		{"a*", "abc", true},
+		{"a*", "bc", false},
+		{"*c", "abc", true},
+		{"a*b*c", "aXbYc", true},
+		{"a*b*c", "aXbY", false},
+		{"h?llo", "hello", true},
+		{"h?llo", "hllo", false},
+		{"h[ae]llo", "hallo", true},
+		{"h[ae]llo", "hillo", false},
+		{"h[^e]llo", "hallo", true},
+		{"h[^e]llo", "hello", false},
+		{"h[a-c]llo", "hbllo", true},
+		{"h[a-c]llo", "hdllo", false},
+		{"h\\*llo", "h*llo", true},
+		{"h\\*llo", "hello", false},
+		{"[abc", "[abc", true},
+	} {
+		if actual := globMatch(testcase.pattern, testcase.key); testcase.expected != actual {
+			t.Errorf("(%q, %q) expected: %v, actual: %v", testcase.pattern, testcase.key, testcase.expected, actual)
+		}
+	}
+
+	t.Run("literal patterns match themselves", func(t *testing.T) {
+		fn := func(a string) bool {
+			key := strings.NewReplacer("*", "", "?", "", "[", "", "\\", "").Replace(a)
+			return globMatch(key, key) && globPrefix(key) == key
+		}
+		if err := quick.Check(fn, nil); err != nil {
+			t.Error(err)
+		}
+	})
+}
+
+func setupServer(server *Server, port int) net.Listener {
+	apiListener, err := net.Listen("tcp", fmt.Sprintf("0.0.0.0:%d", port))
+	if err != nil {
+		panic(err)
+	}
+	go server.Serve(apiListener)
+	return apiListener
+}
+
+type conn struct {
+	net.Conn
+	reader *bufio.Reader
+}
+
+func dial(t *testing.T, port int) *conn {
+	c, err := net.Dial("tcp", fmt.Sprintf("0.0.0.0:%d", port))
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.SetDeadline(time.Now().Add(time.Second * 5))
+	return &conn{
+		Conn:   c,
+		reader: bufio.NewReader(c),
+	}
+}
+
+// do sends the command and returns the raw reply.
+func (c *conn) do(t *testing.T, args ...string) string {
+	if _, err := fmt.Fprint(c, encode(args...)); err != nil {
+		t.Fatal(err)
+	}
+	return c.reply(t)
+}
+
+// reply reads a single reply, including any nested replies.
+func (c *conn) reply(t *testing.T) string {
+	line := c.line(t)
+	reply := line + "\r\n"
+	switch line[0] {
+	case '$':
+		if n, _ := strconv.Atoi(line[1:]); n >= 0 {
+			reply += c.line(t) + "\r\n"
+		}
+	case '*', '%':
+		n, _ := strconv.Atoi(line[1:])
+		if line[0] == '%' {
+			n *= 2
+		}
+		for k := 0; k < n; k++ {
+			reply += c.reply(t)
+		}
+	}
+	return reply
+}
+
+func (c *conn) line(t *testing.T) string {
+	line, err := c.reader.ReadString('\n')
+	if err != nil {
+		t.Fatal(err)
+	}
+	return strings.TrimSuffix(line, "\r\n")
+}
+
+func encode(args ...string) string {
+	s := fmt.Sprintf("*%d\r\n", len(args))
+	for _, arg := range args {
+		s += fmt.Sprintf("$%d\r\n%s\r\n", len(arg), arg)
+	}
+	return s
+}
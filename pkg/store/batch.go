@@ -0,0 +1,187 @@
+package store
+
+import "time"
+
+// Batcher defines a store that can apply many operations at once, which is
+// cheaper than applying each of the operations on its own.
+type Batcher interface {
+
+	// Batch applies each of the operations in order. Unlike a transaction the
+	// operations are independent of each other, so readers can observe some
+	// of the operations without the others.
+	// Returns the result of each of the operations, in the same order as the
+	// operations.
+	Batch(ops []BatchOp) []BatchResult
+}
+
+// BatchOpType defines what an operation of a batch does.
+type BatchOpType int
+
+const (
+	// BatchGet selects the entry of the key.
+	BatchGet BatchOpType = iota
+	// BatchSet stores the value for the key.
+	BatchSet
+	// BatchDelete removes the value for the key.
+	BatchDelete
+)
+
+// BatchOp is a single operation of a batch. The value, ttl and metadata are
+// only used by a set.
+type BatchOp struct {
+	Type     BatchOpType
+	Key      string
+	Value    []byte
+	TTL      time.Duration
+	Metadata Metadata
+}
+
+// BatchResult is the result of a single operation of a batch. OK is true if
+// the key existed before the operation was applied. Entry is the entry of a
+// get, for a set only the version of the entry is known, which is zero if the
+// store couldn't tell or the value was refused, because it can never fit with
+// in a bounded store.
+type BatchResult struct {
+	Entry Entry
+	OK    bool
+}
+
+// Batch applies the operations to the store, if the store is a Batcher then
+// the operations are applied by the store, otherwise each operation is applied
+// on its own.
+func Batch(store Store, ops []BatchOp) []BatchResult {
+	if b, ok := store.(Batcher); ok {
+		return b.Batch(ops)
+	}
+
+	results := make([]BatchResult, len(ops))
+	for k, op := range ops {
+		switch op.Type {
+		case BatchGet:
+			results[k].Entry, results[k].OK = store.Select(op.Key)
+		case BatchSet:
+			results[k].OK = store.SetWithMetadata(op.Key, op.Value, op.TTL, op.Metadata)
+		case BatchDelete:
+			results[k].OK = store.Delete(op.Key)
+		}
+	}
+	return results
+}
+
+// Batch groups the operations by bucket, so that the lock of each bucket is
+// only taken once. Every operation on a key is in the same bucket, so the
+// operations on a key are still applied in order.
+func (m *memory) Batch(ops []BatchOp) []BatchResult {
+	groups := make([][]int, m.size)
+	for k, op := range ops {
+		index := m.index(op.Key)
+		groups[index] = append(groups[index], k)
+	}
+
+	results := make([]BatchResult, len(ops))
+	for index, group := range groups {
+		if len(group) > 0 {
+			m.buckets[index].batch(ops, group, results)
+		}
+	}
+	return results
+}
+
+func (b *bucket) Batch(ops []BatchOp) []BatchResult {
+	group := make([]int, len(ops))
+	for k := range group {
+		group[k] = k
+	}
+
+	results := make([]BatchResult, len(ops))
+	b.batch(ops, group, results)
+	return results
+}
+
+// batch applies the operations of the group in order, writing the result of
+// each operation into the results. Only the read lock is held if the group
+// only has gets.
+func (b *bucket) batch(ops []BatchOp, group []int, results []BatchResult) {
+	now := time.Now()
+
+	if writes(ops, group) {
+		b.mutex.Lock()
+		defer b.mutex.Unlock()
+	} else {
+		b.mutex.RLock()
+		defer b.mutex.RUnlock()
+	}
+
+	for _, k := range group {
+		op := ops[k]
+
+		e, ok := b.lookup(op.Key, now.UnixNano())
+		results[k].OK = ok
+
+		switch op.Type {
+		case BatchGet:
+			if !ok {
+				continue
+			}
+			results[k].Entry = newEntry(op.Key, e)
+			if b.evictor != nil {
+				b.evictor.access(op.Key)
+			}
+		case BatchSet:
+			var expires int64
+			if op.TTL > 0 {
+				expires = now.Add(op.TTL).UnixNano()
+			}
+			results[k].Entry = Entry{
+				Key:     op.Key,
+				Version: b.insert(op.Key, op.Value, op.Metadata, expires, now.UnixNano()),
+			}
+		case BatchDelete:
+			b.remove(op.Key)
+		}
+	}
+}
+
+// writes returns true if any of the operations of the group write to the
+// store.
+func writes(ops []BatchOp, group []int) bool {
+	for _, k := range group {
+		if ops[k].Type != BatchGet {
+			return true
+		}
+	}
+	return false
+}
+
+// Batch appends the writes of the batch to the log as a single record. A batch
+// of only gets goes straight to the store, like any other read. If the writes
+// can't be appended to the log, then none of the writes are applied.
+func (d *durable) Batch(ops []BatchOp) []BatchResult {
+	var keys []string
+	for _, op := range ops {
+		if op.Type != BatchGet {
+			keys = append(keys, op.Key)
+		}
+	}
+	if len(keys) == 0 {
+		return Batch(d.store, ops)
+	}
+
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	var results []BatchResult
+	if err := d.write(keys, func() bool {
+		results = Batch(d.store, ops)
+		return true
+	}); results == nil {
+		// The store has already failed, so none of the operations are
+		// applied, not even the gets.
+		return make([]BatchResult, len(ops))
+	} else if err != nil {
+		for k := range results {
+			results[k].OK = false
+		}
+	}
+	return results
+}
@@ -0,0 +1,537 @@
+package store
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/SimonRichardson/keyval/pkg/wal"
+	"github.com/pkg/errors"
+)
+
+// Failer defines a store that can fail to apply a write, even though the write
+// itself is valid.
+type Failer interface {
+
+	// Err returns the error that caused the writes to the store to fail, or
+	// nil if the writes haven't failed.
+	Err() error
+}
+
+// Err returns the error that caused the writes to the store to fail, if the
+// store isn't a Failer then the writes never fail.
+func Err(store Store) error {
+	if f, ok := store.(Failer); ok {
+		return f.Err()
+	}
+	return nil
+}
+
+// restorer defines a store that can have its entries read and written
+// directly, so that the entries can be persisted and later restored.
+type restorer interface {
+	// peek returns the entry for the key if it hasn't expired.
+	peek(key string) (entry, bool)
+	// restore stores the entry as is, including the version.
+	restore(key string, e entry)
+	// forget removes the entry for the key.
+	forget(key string)
+	// advance makes sure that any version handed out is after the version.
+	advance(version uint64)
+}
+
+// durable wraps a store, so that every write to the store is appended to a
+// write-ahead log before the write is acknowledged. The log records the entry
+// that is the result of the write, rather than the write itself, which means
+// that replaying the log restores the versions as well as the values.
+// Writes are serialised, so that the order of the log matches the order that
+// the writes are applied to the store. Reads go straight to the store.
+// If the log can't be appended to, then the write is rolled back, rather than
+// acknowledging a write that isn't durable, and every write after it fails.
+// Readers and watchers can briefly see the write before it's rolled back.
+// The durable store implements Failer, so callers can tell that a write failed.
+// The durable store also implements Snapshotter, taking a snapshot allows the
+// log to remove the segments that are before the snapshot.
+type durable struct {
+	mutex        sync.Mutex
+	snapshotting sync.Mutex
+	store        Store
+	restorer     restorer
+	snapshotter  snapshotter
+	watcher      Watcher
+	log          *wal.Log
+	err          error
+}
+
+// NewDurable creates a Store that persists every write to the log, the latest
+// snapshot and then the log are replayed into the store before the Store is
+// returned.
+// The store is expected to be a store created from this package.
+func NewDurable(store Store, log *wal.Log) (Store, error) {
+	r, ok := store.(restorer)
+	if !ok {
+		return nil, errors.New("store doesn't support being restored")
+	}
+	s, ok := store.(snapshotter)
+	if !ok {
+		return nil, errors.New("store doesn't support snapshots")
+	}
+	w, ok := store.(Watcher)
+	if !ok {
+		return nil, errors.New("store doesn't support watching")
+	}
+
+	d := &durable{
+		store:       store,
+		restorer:    r,
+		snapshotter: s,
+		watcher:     w,
+		log:         log,
+	}
+
+	seq, err := log.Restore(func(record []byte) error {
+		return d.replay(0, record)
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to restore snapshot")
+	}
+	if err := log.Replay(seq, d.replay); err != nil {
+		return nil, errors.Wrap(err, "unable to replay log")
+	}
+	return d, nil
+}
+
+func (d *durable) Set(key string, value []byte) bool {
+	// A value that can never fit is refused, so there is nothing to log.
+	if !Fits(d.store, key, value, Metadata{}) {
+		return false
+	}
+
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	var ok bool
+	err := d.write([]string{key}, func() bool {
+		ok = d.store.Set(key, value)
+		return true
+	})
+	return ok && err == nil
+}
+
+func (d *durable) SetWithTTL(key string, value []byte, ttl time.Duration) bool {
+	// A value that can never fit is refused, so there is nothing to log.
+	if !Fits(d.store, key, value, Metadata{}) {
+		return false
+	}
+
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	var ok bool
+	err := d.write([]string{key}, func() bool {
+		ok = d.store.SetWithTTL(key, value, ttl)
+		return true
+	})
+	return ok && err == nil
+}
+
+func (d *durable) SetWithMetadata(key string, value []byte, ttl time.Duration, metadata Metadata) bool {
+	// A value that can never fit is refused, so there is nothing to log.
+	if !Fits(d.store, key, value, metadata) {
+		return false
+	}
+
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	var ok bool
+	err := d.write([]string{key}, func() bool {
+		ok = d.store.SetWithMetadata(key, value, ttl, metadata)
+		return true
+	})
+	return ok && err == nil
+}
+
+func (d *durable) Get(key string) ([]byte, bool) {
+	return d.store.Get(key)
+}
+
+func (d *durable) Delete(key string) bool {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	var ok bool
+	err := d.write([]string{key}, func() bool {
+		ok = d.store.Delete(key)
+		return ok
+	})
+	return ok && err == nil
+}
+
+func (d *durable) Select(key string) (Entry, bool) {
+	return d.store.Select(key)
+}
+
+func (d *durable) CompareAndSwap(key string, version uint64, value []byte) (uint64, bool) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	var ok bool
+	err := d.write([]string{key}, func() bool {
+		version, ok = d.store.CompareAndSwap(key, version, value)
+		return ok
+	})
+	return version, ok && err == nil
+}
+
+func (d *durable) CompareAndDelete(key string, version uint64) bool {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	var ok bool
+	err := d.write([]string{key}, func() bool {
+		ok = d.store.CompareAndDelete(key, version)
+		return ok
+	})
+	return ok && err == nil
+}
+
+func (d *durable) Fits(key string, value []byte, metadata Metadata) bool {
+	return Fits(d.store, key, value, metadata)
+}
+
+func (d *durable) Scan(options ScanOptions) ([]Entry, string) {
+	return d.store.Scan(options)
+}
+
+// Txn appends all the mutations of the transaction to the log as a single
+// record, so that the transaction is also atomic when the log is replayed.
+func (d *durable) Txn(conditions []Condition, mutations []Mutation) ([]uint64, bool) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	keys := make([]string, len(mutations))
+	for k, mutation := range mutations {
+		keys[k] = mutation.Key
+	}
+
+	var (
+		versions []uint64
+		ok       bool
+	)
+	if err := d.write(keys, func() bool {
+		versions, ok = d.store.Txn(conditions, mutations)
+		return ok && len(mutations) > 0
+	}); err != nil {
+		return make([]uint64, len(conditions)), false
+	}
+	return versions, ok
+}
+
+// Stats returns the statistics of the underlying store, evictions aren't
+// written to the log, as the limits are enforced again when the log is
+// replayed.
+func (d *durable) Stats() Stats {
+	if r, ok := d.store.(Reporter); ok {
+		return r.Stats()
+	}
+	return Stats{}
+}
+
+func (d *durable) sweep(now time.Time) int {
+	// Expired entries are also expired when they're replayed, so there is no
+	// need to log the removal of them.
+	if s, ok := d.store.(sweeper); ok {
+		return s.sweep(now)
+	}
+	return 0
+}
+
+// Err returns the error of the write that couldn't be appended to the log, once
+// there is an error every write fails.
+func (d *durable) Err() error {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	return d.err
+}
+
+// write calls fn to write to the keys of the store, if fn returns true then the
+// write changed the keys, so the entries of the keys are appended to the log.
+// If the log can't be appended to, then the keys are rolled back to how they
+// were before the write, so that the store never holds a write that isn't
+// durable. The log is then in an unknown state, so every write after that
+// fails without being applied.
+// write expects the caller to hold the lock.
+func (d *durable) write(keys []string, fn func() bool) error {
+	if d.err != nil {
+		return d.err
+	}
+
+	rollback := make([]snapshotEntry, len(keys))
+	for k, key := range keys {
+		rollback[k].entry, rollback[k].existed = d.restorer.peek(key)
+	}
+
+	if !fn() {
+		return nil
+	}
+	if err := d.commit(keys...); err != nil {
+		for k, key := range keys {
+			if rollback[k].existed {
+				d.restorer.restore(key, rollback[k].entry)
+			} else {
+				d.restorer.forget(key)
+			}
+		}
+		d.err = err
+		return err
+	}
+	return nil
+}
+
+// commit appends the current entries of the keys to the log.
+// commit expects the caller to hold the lock.
+func (d *durable) commit(keys ...string) error {
+	mutations := make([]mutation, len(keys))
+	for k, key := range keys {
+		mutations[k].key = key
+		if e, ok := d.restorer.peek(key); ok {
+			mutations[k].op = setOp(e)
+			mutations[k].entry = e
+		} else {
+			mutations[k].op = opDelete
+		}
+	}
+
+	if _, err := d.log.Append(encodeMutations(mutations)); err != nil {
+		return errors.Wrap(err, "unable to append to log")
+	}
+	return nil
+}
+
+func (d *durable) replay(seq uint64, record []byte) error {
+	mutations, err := decodeMutations(record)
+	if err != nil {
+		return errors.Wrapf(err, "unable to decode record %d", seq)
+	}
+
+	now := time.Now().UnixNano()
+	for _, m := range mutations {
+		switch m.op {
+		case opSet, opSetModified, opSetMetadata:
+			// Still restore expired entries, so that the versions handed out
+			// continue on from the expired entry.
+			d.restorer.restore(m.key, m.entry)
+			if m.entry.expired(now) {
+				d.restorer.forget(m.key)
+			}
+		case opDelete:
+			d.restorer.forget(m.key)
+		case opClock:
+			d.restorer.advance(m.entry.version)
+		}
+	}
+	return nil
+}
+
+const (
+	opSet byte = iota + 1
+	opDelete
+	opClock
+	// opSetModified is opSet along with when the entry was modified, entries
+	// are always written with opSetModified, but opSet is still read from
+	// older logs.
+	opSetModified
+	// opSetMetadata is opSetModified along with the metadata of the entry,
+	// it's only written when the entry has metadata.
+	opSetMetadata
+)
+
+// setOp returns the op that records the entry.
+func setOp(e entry) byte {
+	if e.metadata.IsZero() {
+		return opSetModified
+	}
+	return opSetMetadata
+}
+
+// mutation is the result of a write to a key, as recorded in the log.
+type mutation struct {
+	op    byte
+	key   string
+	entry entry
+}
+
+// encodeMutations encodes the mutations as a single record, so that the
+// mutations are all replayed or none of them are.
+func encodeMutations(mutations []mutation) []byte {
+	var (
+		buf     bytes.Buffer
+		scratch [binary.MaxVarintLen64]byte
+	)
+
+	putUvarint := func(v uint64) {
+		buf.Write(scratch[:binary.PutUvarint(scratch[:], v)])
+	}
+	putBytes := func(b []byte) {
+		putUvarint(uint64(len(b)))
+		buf.Write(b)
+	}
+
+	putUvarint(uint64(len(mutations)))
+	for _, m := range mutations {
+		buf.WriteByte(m.op)
+		putBytes([]byte(m.key))
+		switch m.op {
+		case opSet, opSetModified, opSetMetadata:
+			putUvarint(m.entry.version)
+			buf.Write(scratch[:binary.PutVarint(scratch[:], m.entry.expires)])
+			putBytes(m.entry.value)
+			if m.op == opSet {
+				break
+			}
+			buf.Write(scratch[:binary.PutVarint(scratch[:], m.entry.modified)])
+			if m.op == opSetModified {
+				break
+			}
+
+			metadata := m.entry.metadata
+			putBytes([]byte(metadata.ContentType))
+			putBytes([]byte(metadata.ContentEncoding))
+
+			// Sort the user metadata, so the same entry is always encoded
+			// the same way.
+			keys := make([]string, 0, len(metadata.User))
+			for k := range metadata.User {
+				keys = append(keys, k)
+			}
+			sort.Strings(keys)
+
+			putUvarint(uint64(len(keys)))
+			for _, k := range keys {
+				putBytes([]byte(k))
+				putBytes([]byte(metadata.User[k]))
+			}
+		case opClock:
+			putUvarint(m.entry.version)
+		}
+	}
+	return buf.Bytes()
+}
+
+func decodeMutations(record []byte) ([]mutation, error) {
+	r := bytes.NewReader(record)
+
+	getBytes := func() ([]byte, error) {
+		n, err := binary.ReadUvarint(r)
+		if err != nil {
+			return nil, err
+		}
+		if n > uint64(r.Len()) {
+			return nil, errors.New("invalid length")
+		}
+		b := make([]byte, n)
+		_, err = io.ReadFull(r, b)
+		return b, err
+	}
+
+	n, err := binary.ReadUvarint(r)
+	if err != nil {
+		return nil, err
+	}
+	if n > uint64(r.Len()) {
+		return nil, errors.New("invalid number of mutations")
+	}
+
+	mutations := make([]mutation, n)
+	for k := range mutations {
+		m := &mutations[k]
+		if m.op, err = r.ReadByte(); err != nil {
+			return nil, err
+		}
+
+		key, err := getBytes()
+		if err != nil {
+			return nil, err
+		}
+		m.key = string(key)
+
+		switch m.op {
+		case opSet, opSetModified, opSetMetadata:
+			if m.entry.version, err = binary.ReadUvarint(r); err != nil {
+				return nil, err
+			}
+			if m.entry.expires, err = binary.ReadVarint(r); err != nil {
+				return nil, err
+			}
+			if m.entry.value, err = getBytes(); err != nil {
+				return nil, err
+			}
+			if m.op == opSet {
+				break
+			}
+			if m.entry.modified, err = binary.ReadVarint(r); err != nil {
+				return nil, err
+			}
+			if m.op == opSetModified {
+				break
+			}
+			if m.entry.metadata, err = decodeMetadata(r, getBytes); err != nil {
+				return nil, err
+			}
+		case opDelete:
+		case opClock:
+			if m.entry.version, err = binary.ReadUvarint(r); err != nil {
+				return nil, err
+			}
+		default:
+			return nil, errors.Errorf("unknown op %d", m.op)
+		}
+	}
+	return mutations, nil
+}
+
+func decodeMetadata(r *bytes.Reader, getBytes func() ([]byte, error)) (Metadata, error) {
+	var metadata Metadata
+
+	contentType, err := getBytes()
+	if err != nil {
+		return metadata, err
+	}
+	metadata.ContentType = string(contentType)
+
+	contentEncoding, err := getBytes()
+	if err != nil {
+		return metadata, err
+	}
+	metadata.ContentEncoding = string(contentEncoding)
+
+	n, err := binary.ReadUvarint(r)
+	if err != nil {
+		return metadata, err
+	}
+	if n > uint64(r.Len()) {
+		return metadata, errors.New("invalid number of metadata")
+	}
+	if n == 0 {
+		return metadata, nil
+	}
+
+	metadata.User = make(map[string]string, n)
+	for ; n > 0; n-- {
+		k, err := getBytes()
+		if err != nil {
+			return metadata, err
+		}
+		v, err := getBytes()
+		if err != nil {
+			return metadata, err
+		}
+		metadata.User[string(k)] = string(v)
+	}
+	return metadata, nil
+}
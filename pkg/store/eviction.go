@@ -0,0 +1,583 @@
+package store
+
+import (
+	"container/heap"
+	"container/list"
+	"sync"
+)
+
+// Eviction defines which values are evicted first, when a store is over its
+// limits.
+type Eviction int
+
+const (
+	// EvictLRU evicts the least recently used values first.
+	EvictLRU Eviction = iota
+	// EvictLFU evicts the least frequently used values first, values that are
+	// used equally as frequent are evicted least recently used first.
+	EvictLFU
+	// EvictTTL evicts the values that are closest to expiring first, values
+	// without a ttl are only evicted once there are no values with a ttl and
+	// are evicted least recently used first.
+	EvictTTL
+)
+
+func (e Eviction) String() string {
+	switch e {
+	case EvictLRU:
+		return "lru"
+	case EvictLFU:
+		return "lfu"
+	case EvictTTL:
+		return "ttl"
+	default:
+		return "unknown"
+	}
+}
+
+// Limits defines how large a store can grow before values are evicted from
+// the store. A limit of zero means there is no limit.
+type Limits struct {
+	// MaxBytes is the maximum size of all the keys and values.
+	MaxBytes uint64
+	// MaxItems is the maximum number of values.
+	MaxItems uint64
+	// Eviction is the policy used to select which values are evicted.
+	Eviction Eviction
+}
+
+// bounded returns true if any of the limits are set.
+func (l Limits) bounded() bool {
+	return l.MaxBytes > 0 || l.MaxItems > 0
+}
+
+// shard returns the limits for each of the shards, when the limits are spread
+// across the amount of shards.
+func (l Limits) shard(shards uint) Limits {
+	n := uint64(shards)
+	if n == 0 {
+		return l
+	}
+	if l.MaxBytes > 0 {
+		l.MaxBytes = (l.MaxBytes + n - 1) / n
+	}
+	if l.MaxItems > 0 {
+		l.MaxItems = (l.MaxItems + n - 1) / n
+	}
+	return l
+}
+
+// Stats holds the statistics of a store.
+type Stats struct {
+	// Items is the number of values with in the store, including values that
+	// have expired, but haven't been removed yet.
+	Items uint64
+	// Bytes is the size of all the keys and values with in the store.
+	Bytes uint64
+	// Evictions is the number of values that have been evicted from the store,
+	// because the store was over its limits.
+	Evictions uint64
+}
+
+// Reporter defines a store that can report statistics about the store.
+type Reporter interface {
+
+	// Stats returns the current statistics of the store.
+	Stats() Stats
+}
+
+// Bounder defines a store that is bounded by limits, so a value can be too
+// large to ever fit with in the store. A bounded store doesn't store a value
+// that can never fit, the write is refused rather than evicting the value
+// straight away.
+type Bounder interface {
+
+	// Fits returns true if the value can fit with in the limits of the store,
+	// along with the key and the metadata of the value. A value that fits can
+	// still cause other values to be evicted.
+	Fits(key string, value []byte, metadata Metadata) bool
+}
+
+// Fits returns true if the value can fit with in the limits of the store, if
+// the store isn't a Bounder then every value fits.
+func Fits(store Store, key string, value []byte, metadata Metadata) bool {
+	if b, ok := store.(Bounder); ok {
+		return b.Fits(key, value, metadata)
+	}
+	return true
+}
+
+// NewBoundedBucket creates a new in-memory Store according to the size
+// required by the value requested, which evicts values once the limits are
+// reached. The limits are spread evenly across the buckets and each bucket
+// evicts its own values, so eviction never requires more than the lock of the
+// bucket. This also means that a value can be no larger than the limit of a
+// single bucket.
+func NewBoundedBucket(size uint, limits Limits) Store {
+	var (
+		hub     = newHub()
+		buckets = make([]*bucket, size)
+	)
+	for k := range buckets {
+		buckets[k] = newBoundedBucket(limits.shard(size))
+		buckets[k].hub = hub
+	}
+
+	return &memory{
+		size:    size,
+		buckets: buckets,
+	}
+}
+
+// NewBounded creates a store from a singular bucket, which evicts values once
+// the limits are reached.
+func NewBounded(limits Limits) Store {
+	return newBoundedBucket(limits)
+}
+
+func newBoundedBucket(limits Limits) *bucket {
+	b := newBucket()
+	if limits.bounded() {
+		b.limits = limits
+		b.evictor = newEvictor(limits.Eviction)
+	}
+	return b
+}
+
+func (m *memory) Stats() Stats {
+	var stats Stats
+	for _, b := range m.buckets {
+		s := b.Stats()
+		stats.Items += s.Items
+		stats.Bytes += s.Bytes
+		stats.Evictions += s.Evictions
+	}
+	return stats
+}
+
+func (m *memory) Fits(key string, value []byte, metadata Metadata) bool {
+	return m.bucket(key).Fits(key, value, metadata)
+}
+
+// Fits only depends on the limits, which never change, so the lock isn't
+// required.
+func (b *bucket) Fits(key string, value []byte, metadata Metadata) bool {
+	return b.evictor == nil || b.limits.MaxBytes == 0 ||
+		usage(key, entry{value: value, metadata: metadata}) <= b.limits.MaxBytes
+}
+
+func (b *bucket) Stats() Stats {
+	b.mutex.RLock()
+	defer b.mutex.RUnlock()
+
+	return Stats{
+		Items:     uint64(len(b.values)),
+		Bytes:     b.bytes,
+		Evictions: b.evictions,
+	}
+}
+
+// usage returns the amount of bytes used by a key and the value and metadata
+// of the entry.
+func usage(key string, e entry) uint64 {
+	return uint64(len(key) + len(e.value) + e.metadata.Size())
+}
+
+// reserve evicts values until the entry for the key fits with in the limits of
+// the bucket, the key itself is never evicted to make room for the entry.
+// Returns false if the entry can never fit with in the limits.
+// reserve expects the caller to hold the write lock.
+func (b *bucket) reserve(key string, e entry) bool {
+	size := usage(key, e)
+	if b.limits.MaxBytes > 0 && size > b.limits.MaxBytes {
+		return false
+	}
+
+	for {
+		bytes, items := b.bytes+size, uint64(len(b.values))+1
+		if old, ok := b.values[key]; ok {
+			bytes -= usage(key, old)
+			items--
+		}
+		if (b.limits.MaxBytes == 0 || bytes <= b.limits.MaxBytes) &&
+			(b.limits.MaxItems == 0 || items <= b.limits.MaxItems) {
+			return true
+		}
+
+		victim, ok := b.evictor.victim(func(k string) bool { return k == key })
+		if !ok {
+			return false
+		}
+		b.remove(victim)
+		b.evictions++
+	}
+}
+
+// footprint is the space that the keys of a transaction take up with in a
+// bucket, once all of the mutations of the transaction are applied.
+type footprint struct {
+	// keys holds every key of the transaction with in the bucket.
+	keys map[string]struct{}
+	// sizes holds the size of every key that is set, once the transaction is
+	// applied.
+	sizes map[string]uint64
+}
+
+func newFootprint() footprint {
+	return footprint{
+		keys:  make(map[string]struct{}),
+		sizes: make(map[string]uint64),
+	}
+}
+
+// fits returns true if the keys of the footprint fit with in the limits of the
+// bucket, when every other value is evicted.
+// fits expects the caller to hold the lock.
+func (b *bucket) fits(f footprint) bool {
+	if b.evictor == nil {
+		return true
+	}
+
+	var bytes uint64
+	for _, size := range f.sizes {
+		bytes += size
+	}
+	return (b.limits.MaxBytes == 0 || bytes <= b.limits.MaxBytes) &&
+		(b.limits.MaxItems == 0 || uint64(len(f.sizes)) <= b.limits.MaxItems)
+}
+
+// reserveAll evicts values until the keys of the footprint fit with in the
+// limits of the bucket, the keys of the footprint are never evicted to make
+// room for each other. The footprint is expected to fit, see fits.
+// reserveAll expects the caller to hold the write lock.
+func (b *bucket) reserveAll(f footprint) {
+	if b.evictor == nil {
+		return
+	}
+
+	// The keys of the footprint are never evicted, so the difference they make
+	// to the usage of the bucket stays the same whilst evicting.
+	var (
+		add, items uint64
+		sub, gone  uint64
+	)
+	for _, size := range f.sizes {
+		add += size
+		items++
+	}
+	for key := range f.keys {
+		if old, ok := b.values[key]; ok {
+			sub += usage(key, old)
+			gone++
+		}
+	}
+
+	skip := func(key string) bool {
+		_, ok := f.keys[key]
+		return ok
+	}
+	for {
+		bytes, n := b.bytes+add-sub, uint64(len(b.values))+items-gone
+		if (b.limits.MaxBytes == 0 || bytes <= b.limits.MaxBytes) &&
+			(b.limits.MaxItems == 0 || n <= b.limits.MaxItems) {
+			return
+		}
+
+		victim, ok := b.evictor.victim(skip)
+		if !ok {
+			return
+		}
+		b.remove(victim)
+		b.evictions++
+	}
+}
+
+// policy tracks how the values of a bucket are used, so that it can select
+// which value should be evicted next.
+type policy interface {
+	// insert is called every time the entry for the key is written.
+	insert(key string, e entry)
+	// access is called every time the key is read.
+	access(key string)
+	// remove is called when the key is removed.
+	remove(key string)
+	// victim returns the key that should be evicted next, other than the keys
+	// that are to be skipped.
+	victim(skip func(string) bool) (string, bool)
+}
+
+// evictor guards the policy, reads only hold the read lock of a bucket, yet the
+// policy still needs to be updated on every read.
+type evictor struct {
+	mutex  sync.Mutex
+	policy policy
+}
+
+func newEvictor(eviction Eviction) *evictor {
+	var p policy
+	switch eviction {
+	case EvictLFU:
+		p = newLFU()
+	case EvictTTL:
+		p = newTTLFirst()
+	default:
+		p = newLRU()
+	}
+	return &evictor{
+		policy: p,
+	}
+}
+
+func (e *evictor) insert(key string, v entry) {
+	e.mutex.Lock()
+	e.policy.insert(key, v)
+	e.mutex.Unlock()
+}
+
+func (e *evictor) access(key string) {
+	e.mutex.Lock()
+	e.policy.access(key)
+	e.mutex.Unlock()
+}
+
+func (e *evictor) remove(key string) {
+	e.mutex.Lock()
+	e.policy.remove(key)
+	e.mutex.Unlock()
+}
+
+func (e *evictor) victim(skip func(string) bool) (string, bool) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	return e.policy.victim(skip)
+}
+
+// lru keeps the keys ordered from the most recently used to the least recently
+// used.
+type lru struct {
+	order *list.List
+	keys  map[string]*list.Element
+}
+
+func newLRU() *lru {
+	return &lru{
+		order: list.New(),
+		keys:  make(map[string]*list.Element),
+	}
+}
+
+func (l *lru) insert(key string, e entry) {
+	if elem, ok := l.keys[key]; ok {
+		l.order.MoveToFront(elem)
+		return
+	}
+	l.keys[key] = l.order.PushFront(key)
+}
+
+func (l *lru) access(key string) {
+	if elem, ok := l.keys[key]; ok {
+		l.order.MoveToFront(elem)
+	}
+}
+
+func (l *lru) remove(key string) {
+	if elem, ok := l.keys[key]; ok {
+		l.order.Remove(elem)
+		delete(l.keys, key)
+	}
+}
+
+func (l *lru) victim(skip func(string) bool) (string, bool) {
+	for elem := l.order.Back(); elem != nil; elem = elem.Prev() {
+		if key := elem.Value.(string); !skip(key) {
+			return key, true
+		}
+	}
+	return "", false
+}
+
+// lfu keeps the keys in a heap ordered by how frequently they're used.
+type lfu struct {
+	clock uint64
+	heap  priorityHeap
+	keys  map[string]*priorityItem
+}
+
+func newLFU() *lfu {
+	return &lfu{
+		keys: make(map[string]*priorityItem),
+	}
+}
+
+func (l *lfu) insert(key string, e entry) {
+	if _, ok := l.keys[key]; ok {
+		l.access(key)
+		return
+	}
+	l.clock++
+	item := &priorityItem{
+		key:      key,
+		priority: 1,
+		tick:     l.clock,
+	}
+	l.keys[key] = item
+	heap.Push(&l.heap, item)
+}
+
+func (l *lfu) access(key string) {
+	if item, ok := l.keys[key]; ok {
+		l.clock++
+		item.priority++
+		item.tick = l.clock
+		heap.Fix(&l.heap, item.index)
+	}
+}
+
+func (l *lfu) remove(key string) {
+	if item, ok := l.keys[key]; ok {
+		heap.Remove(&l.heap, item.index)
+		delete(l.keys, key)
+	}
+}
+
+func (l *lfu) victim(skip func(string) bool) (string, bool) {
+	return l.heap.min(skip)
+}
+
+// ttlFirst keeps the keys with a ttl in a heap ordered by when they expire and
+// the keys without a ttl in least recently used order.
+type ttlFirst struct {
+	clock uint64
+	heap  priorityHeap
+	keys  map[string]*priorityItem
+	lru   *lru
+}
+
+func newTTLFirst() *ttlFirst {
+	return &ttlFirst{
+		keys: make(map[string]*priorityItem),
+		lru:  newLRU(),
+	}
+}
+
+func (t *ttlFirst) insert(key string, e entry) {
+	if e.expires == 0 {
+		if item, ok := t.keys[key]; ok {
+			heap.Remove(&t.heap, item.index)
+			delete(t.keys, key)
+		}
+		t.lru.insert(key, e)
+		return
+	}
+
+	t.lru.remove(key)
+
+	t.clock++
+	if item, ok := t.keys[key]; ok {
+		item.priority = e.expires
+		item.tick = t.clock
+		heap.Fix(&t.heap, item.index)
+		return
+	}
+	item := &priorityItem{
+		key:      key,
+		priority: e.expires,
+		tick:     t.clock,
+	}
+	t.keys[key] = item
+	heap.Push(&t.heap, item)
+}
+
+func (t *ttlFirst) access(key string) {
+	t.lru.access(key)
+}
+
+func (t *ttlFirst) remove(key string) {
+	if item, ok := t.keys[key]; ok {
+		heap.Remove(&t.heap, item.index)
+		delete(t.keys, key)
+	}
+	t.lru.remove(key)
+}
+
+func (t *ttlFirst) victim(skip func(string) bool) (string, bool) {
+	if key, ok := t.heap.min(skip); ok {
+		return key, true
+	}
+	return t.lru.victim(skip)
+}
+
+// priorityItem is a key with in a priorityHeap, the lowest priority is at the
+// top of the heap and ties are broken by the lowest tick.
+type priorityItem struct {
+	key      string
+	priority int64
+	tick     uint64
+	index    int
+}
+
+// priorityHeap implements heap.Interface for the priority items.
+type priorityHeap []*priorityItem
+
+func (h priorityHeap) Len() int { return len(h) }
+
+func (h priorityHeap) Less(i, j int) bool {
+	if h[i].priority != h[j].priority {
+		return h[i].priority < h[j].priority
+	}
+	return h[i].tick < h[j].tick
+}
+
+func (h priorityHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+
+func (h *priorityHeap) Push(x interface{}) {
+	item := x.(*priorityItem)
+	item.index = len(*h)
+	*h = append(*h, item)
+}
+
+func (h *priorityHeap) Pop() interface{} {
+	old := *h
+	n := len(old)
+	item := old[n-1]
+	old[n-1] = nil
+	*h = old[:n-1]
+	return item
+}
+
+// min returns the smallest key with in the heap, other than the keys that are
+// to be skipped. The next smallest key is always one of the children of a key
+// that is skipped, so only the keys that are skipped and their children are
+// visited, starting from the top of the heap.
+func (h priorityHeap) min(skip func(string) bool) (string, bool) {
+	if len(h) == 0 {
+		return "", false
+	}
+
+	// candidates holds the index of every key that could be the smallest.
+	candidates := []int{0}
+	for len(candidates) > 0 {
+		m := 0
+		for k := 1; k < len(candidates); k++ {
+			if h.Less(candidates[k], candidates[m]) {
+				m = k
+			}
+		}
+
+		i := candidates[m]
+		if !skip(h[i].key) {
+			return h[i].key, true
+		}
+		candidates = append(candidates[:m], candidates[m+1:]...)
+		for _, child := range []int{2*i + 1, 2*i + 2} {
+			if child < len(h) {
+				candidates = append(candidates, child)
+			}
+		}
+	}
+	return "", false
+}
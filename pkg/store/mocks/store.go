@@ -5,8 +5,10 @@
 package mocks
 
 import (
+	store "github.com/SimonRichardson/keyval/pkg/store"
 	gomock "github.com/golang/mock/gomock"
 	reflect "reflect"
+	time "time"
 )
 
 // MockStore is a mock of Store interface
@@ -32,6 +34,31 @@ func (m *MockStore) EXPECT() *MockStoreMockRecorder {
 	return m.recorder
 }
 
+// CompareAndDelete mocks base method
+func (m *MockStore) CompareAndDelete(arg0 string, arg1 uint64) bool {
+	ret := m.ctrl.Call(m, "CompareAndDelete", arg0, arg1)
+	ret0, _ := ret[0].(bool)
+	return ret0
+}
+
+// CompareAndDelete indicates an expected call of CompareAndDelete
+func (mr *MockStoreMockRecorder) CompareAndDelete(arg0, arg1 interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CompareAndDelete", reflect.TypeOf((*MockStore)(nil).CompareAndDelete), arg0, arg1)
+}
+
+// CompareAndSwap mocks base method
+func (m *MockStore) CompareAndSwap(arg0 string, arg1 uint64, arg2 []byte) (uint64, bool) {
+	ret := m.ctrl.Call(m, "CompareAndSwap", arg0, arg1, arg2)
+	ret0, _ := ret[0].(uint64)
+	ret1, _ := ret[1].(bool)
+	return ret0, ret1
+}
+
+// CompareAndSwap indicates an expected call of CompareAndSwap
+func (mr *MockStoreMockRecorder) CompareAndSwap(arg0, arg1, arg2 interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CompareAndSwap", reflect.TypeOf((*MockStore)(nil).CompareAndSwap), arg0, arg1, arg2)
+}
+
 // Delete mocks base method
 func (m *MockStore) Delete(arg0 string) bool {
 	ret := m.ctrl.Call(m, "Delete", arg0)
@@ -57,6 +84,32 @@ func (mr *MockStoreMockRecorder) Get(arg0 interface{}) *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Get", reflect.TypeOf((*MockStore)(nil).Get), arg0)
 }
 
+// Scan mocks base method
+func (m *MockStore) Scan(arg0 store.ScanOptions) ([]store.Entry, string) {
+	ret := m.ctrl.Call(m, "Scan", arg0)
+	ret0, _ := ret[0].([]store.Entry)
+	ret1, _ := ret[1].(string)
+	return ret0, ret1
+}
+
+// Scan indicates an expected call of Scan
+func (mr *MockStoreMockRecorder) Scan(arg0 interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Scan", reflect.TypeOf((*MockStore)(nil).Scan), arg0)
+}
+
+// Select mocks base method
+func (m *MockStore) Select(arg0 string) (store.Entry, bool) {
+	ret := m.ctrl.Call(m, "Select", arg0)
+	ret0, _ := ret[0].(store.Entry)
+	ret1, _ := ret[1].(bool)
+	return ret0, ret1
+}
+
+// Select indicates an expected call of Select
+func (mr *MockStoreMockRecorder) Select(arg0 interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Select", reflect.TypeOf((*MockStore)(nil).Select), arg0)
+}
+
 // Set mocks base method
 func (m *MockStore) Set(arg0 string, arg1 []byte) bool {
 	ret := m.ctrl.Call(m, "Set", arg0, arg1)
@@ -68,3 +121,40 @@ func (m *MockStore) Set(arg0 string, arg1 []byte) bool {
 func (mr *MockStoreMockRecorder) Set(arg0, arg1 interface{}) *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Set", reflect.TypeOf((*MockStore)(nil).Set), arg0, arg1)
 }
+
+// SetWithMetadata mocks base method
+func (m *MockStore) SetWithMetadata(arg0 string, arg1 []byte, arg2 time.Duration, arg3 store.Metadata) bool {
+	ret := m.ctrl.Call(m, "SetWithMetadata", arg0, arg1, arg2, arg3)
+	ret0, _ := ret[0].(bool)
+	return ret0
+}
+
+// SetWithMetadata indicates an expected call of SetWithMetadata
+func (mr *MockStoreMockRecorder) SetWithMetadata(arg0, arg1, arg2, arg3 interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SetWithMetadata", reflect.TypeOf((*MockStore)(nil).SetWithMetadata), arg0, arg1, arg2, arg3)
+}
+
+// SetWithTTL mocks base method
+func (m *MockStore) SetWithTTL(arg0 string, arg1 []byte, arg2 time.Duration) bool {
+	ret := m.ctrl.Call(m, "SetWithTTL", arg0, arg1, arg2)
+	ret0, _ := ret[0].(bool)
+	return ret0
+}
+
+// SetWithTTL indicates an expected call of SetWithTTL
+func (mr *MockStoreMockRecorder) SetWithTTL(arg0, arg1, arg2 interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SetWithTTL", reflect.TypeOf((*MockStore)(nil).SetWithTTL), arg0, arg1, arg2)
+}
+
+// Txn mocks base method
+func (m *MockStore) Txn(arg0 []store.Condition, arg1 []store.Mutation) ([]uint64, bool) {
+	ret := m.ctrl.Call(m, "Txn", arg0, arg1)
+	ret0, _ := ret[0].([]uint64)
+	ret1, _ := ret[1].(bool)
+	return ret0, ret1
+}
+
+// Txn indicates an expected call of Txn
+func (mr *MockStoreMockRecorder) Txn(arg0, arg1 interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Txn", reflect.TypeOf((*MockStore)(nil).Txn), arg0, arg1)
+}
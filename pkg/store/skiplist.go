@@ -0,0 +1,113 @@
+package store
+
+import (
+	"math/rand"
+	"time"
+)
+
+const (
+	// skiplistMaxLevel is enough levels to efficiently hold 4^16 keys.
+	skiplistMaxLevel = 16
+	// skiplistP is the probability (1/skiplistP) of a node being promoted to
+	// the next level.
+	skiplistP = 4
+)
+
+type skiplistNode struct {
+	key  string
+	next []*skiplistNode
+}
+
+// skiplist holds the keys of a bucket in order, so that the keys can be
+// iterated over in order without having to sort the whole of the keyspace for
+// each scan.
+// skiplist isn't safe for concurrent use, the caller is expected to hold the
+// lock of the bucket.
+type skiplist struct {
+	head   *skiplistNode
+	level  int
+	random *rand.Rand
+}
+
+func newSkiplist() *skiplist {
+	return &skiplist{
+		head:   &skiplistNode{next: make([]*skiplistNode, skiplistMaxLevel)},
+		level:  1,
+		random: rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+}
+
+// insert adds the key to the skiplist, it's expected that the key doesn't
+// already exist.
+func (s *skiplist) insert(key string) {
+	var update [skiplistMaxLevel]*skiplistNode
+	node := s.head
+	for i := s.level - 1; i >= 0; i-- {
+		for node.next[i] != nil && node.next[i].key < key {
+			node = node.next[i]
+		}
+		update[i] = node
+	}
+
+	level := s.randomLevel()
+	if level > s.level {
+		for i := s.level; i < level; i++ {
+			update[i] = s.head
+		}
+		s.level = level
+	}
+
+	n := &skiplistNode{
+		key:  key,
+		next: make([]*skiplistNode, level),
+	}
+	for i := 0; i < level; i++ {
+		n.next[i] = update[i].next[i]
+		update[i].next[i] = n
+	}
+}
+
+// remove removes the key from the skiplist if it exists.
+func (s *skiplist) remove(key string) {
+	var update [skiplistMaxLevel]*skiplistNode
+	node := s.head
+	for i := s.level - 1; i >= 0; i-- {
+		for node.next[i] != nil && node.next[i].key < key {
+			node = node.next[i]
+		}
+		update[i] = node
+	}
+
+	node = node.next[0]
+	if node == nil || node.key != key {
+		return
+	}
+
+	for i := 0; i < len(node.next); i++ {
+		update[i].next[i] = node.next[i]
+	}
+	for s.level > 1 && s.head.next[s.level-1] == nil {
+		s.level--
+	}
+}
+
+// seek returns the first node that has a key greater than or equal to the key
+// supplied, or nil if there isn't one. The following keys can be iterated over
+// using next[0] of the node.
+func (s *skiplist) seek(key string) *skiplistNode {
+	node := s.head
+	for i := s.level - 1; i >= 0; i-- {
+		for node.next[i] != nil && node.next[i].key < key {
+			node = node.next[i]
+		}
+	}
+	return node.next[0]
+}
+
+func (s *skiplist) randomLevel() int {
+	level := 1
+	for level < skiplistMaxLevel && s.random.Intn(skiplistP) == 0 {
+		level++
+	}
+	return level
+}
@@ -0,0 +1,243 @@
+package store
+
+import "time"
+
+// Snapshotter defines a store that can take a point-in-time snapshot of the
+// whole of the store.
+type Snapshotter interface {
+
+	// Snapshot takes a snapshot of the store.
+	Snapshot() error
+}
+
+// snapshotter defines a store that can be read at a point-in-time, whilst
+// still allowing writes to the store.
+type snapshotter interface {
+	// beginSnapshot marks the point-in-time that the snapshot represents,
+	// returning the highest version handed out at that point-in-time.
+	beginSnapshot() uint64
+	// readSnapshot calls the function for every entry with in the store as it
+	// was at the point-in-time of the snapshot.
+	readSnapshot(fn func(key string, e entry) error) error
+	// endSnapshot releases any resources held by the snapshot.
+	endSnapshot()
+}
+
+// snapshotChunk is the number of keys read from a bucket whilst holding the
+// lock of the bucket.
+const snapshotChunk = 1024
+
+// snapshotEntry is the state of a key at the point-in-time of the snapshot.
+type snapshotEntry struct {
+	entry   entry
+	existed bool
+}
+
+// bucketSnapshot is a copy-on-write snapshot of a bucket. Keys are read in
+// order, in chunks, so the lock of the bucket isn't held for long. Any key that
+// is written to before it's read has its original state kept in the overlay,
+// so that the snapshot still reads the original state. Keys that have already
+// been read don't need to be kept.
+type bucketSnapshot struct {
+	overlay map[string]snapshotEntry
+	read    bool
+	cursor  string
+}
+
+// passed returns true if the key has already been read by the snapshot.
+func (s *bucketSnapshot) passed(key string) bool {
+	return s.read && key <= s.cursor
+}
+
+func (m *memory) beginSnapshot() uint64 {
+	// Lock all the buckets, so that the snapshot is the same point-in-time
+	// for every bucket. Buckets are always locked in order.
+	for _, b := range m.buckets {
+		b.mutex.Lock()
+	}
+	var clock uint64
+	for _, b := range m.buckets {
+		b.snapshot = &bucketSnapshot{
+			overlay: make(map[string]snapshotEntry),
+		}
+		if b.clock > clock {
+			clock = b.clock
+		}
+	}
+	for _, b := range m.buckets {
+		b.mutex.Unlock()
+	}
+	return clock
+}
+
+func (m *memory) readSnapshot(fn func(key string, e entry) error) error {
+	for _, b := range m.buckets {
+		if err := b.readSnapshot(fn); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *memory) endSnapshot() {
+	for _, b := range m.buckets {
+		b.endSnapshot()
+	}
+}
+
+func (b *bucket) beginSnapshot() uint64 {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	b.snapshot = &bucketSnapshot{
+		overlay: make(map[string]snapshotEntry),
+	}
+	return b.clock
+}
+
+func (b *bucket) readSnapshot(fn func(key string, e entry) error) error {
+	type keyEntry struct {
+		key   string
+		entry entry
+	}
+
+	chunk := make([]keyEntry, 0, snapshotChunk)
+	for {
+		chunk = chunk[:0]
+
+		// The cursor is only ever moved by the snapshot and only read by
+		// writers whilst holding the write lock, so the read lock is enough.
+		b.mutex.RLock()
+		snapshot := b.snapshot
+		from := ""
+		if snapshot.read {
+			from = cursor(snapshot.cursor)
+		}
+		for node := b.index.seek(from); node != nil && len(chunk) < snapshotChunk; node = node.next[0] {
+			if s, ok := snapshot.overlay[node.key]; ok {
+				if s.existed {
+					chunk = append(chunk, keyEntry{node.key, s.entry})
+				}
+			} else {
+				chunk = append(chunk, keyEntry{node.key, b.values[node.key]})
+			}
+			snapshot.read = true
+			snapshot.cursor = node.key
+		}
+		done := len(chunk) < snapshotChunk
+		b.mutex.RUnlock()
+
+		for _, v := range chunk {
+			if err := fn(v.key, v.entry); err != nil {
+				return err
+			}
+		}
+
+		if done {
+			break
+		}
+	}
+
+	// Keys that existed at the point-in-time, but have since been removed won't
+	// have been found when walking the keys.
+	b.mutex.RLock()
+	chunk = chunk[:0]
+	for key, s := range b.snapshot.overlay {
+		if _, ok := b.values[key]; !ok && s.existed {
+			chunk = append(chunk, keyEntry{key, s.entry})
+		}
+	}
+	b.mutex.RUnlock()
+
+	for _, v := range chunk {
+		if err := fn(v.key, v.entry); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (b *bucket) endSnapshot() {
+	b.mutex.Lock()
+	b.snapshot = nil
+	b.mutex.Unlock()
+}
+
+// preserve keeps the current state of the key if a snapshot is in progress and
+// the snapshot hasn't read the key yet.
+// preserve expects the caller to hold the write lock.
+func (b *bucket) preserve(key string) {
+	if b.snapshot == nil || b.snapshot.passed(key) {
+		return
+	}
+	if _, ok := b.snapshot.overlay[key]; ok {
+		return
+	}
+
+	e, ok := b.values[key]
+	b.snapshot.overlay[key] = snapshotEntry{
+		entry:   e,
+		existed: ok,
+	}
+}
+
+// snapshotBatch is the number of entries that are written to a single record
+// of a snapshot.
+const snapshotBatch = 128
+
+// Snapshot takes a point-in-time snapshot of the store, once the snapshot is
+// written the log no longer needs to hold the writes before the snapshot.
+// Writes are only blocked whilst the point-in-time is marked, not whilst the
+// snapshot is written.
+func (d *durable) Snapshot() error {
+	d.snapshotting.Lock()
+	defer d.snapshotting.Unlock()
+
+	d.mutex.Lock()
+	seq := d.log.Sequence()
+	clock := d.snapshotter.beginSnapshot()
+	d.mutex.Unlock()
+
+	defer d.snapshotter.endSnapshot()
+
+	return d.log.Snapshot(seq, func(write func([]byte) error) error {
+		// Record the clock, so that versions of keys that are no longer in the
+		// store are never handed out again.
+		if err := write(encodeMutations([]mutation{{
+			op:    opClock,
+			entry: entry{version: clock},
+		}})); err != nil {
+			return err
+		}
+
+		var (
+			now       = time.Now().UnixNano()
+			mutations = make([]mutation, 0, snapshotBatch)
+		)
+		if err := d.snapshotter.readSnapshot(func(key string, e entry) error {
+			if e.expired(now) {
+				return nil
+			}
+
+			mutations = append(mutations, mutation{
+				op:    setOp(e),
+				key:   key,
+				entry: e,
+			})
+			if len(mutations) < snapshotBatch {
+				return nil
+			}
+
+			err := write(encodeMutations(mutations))
+			mutations = mutations[:0]
+			return err
+		}); err != nil {
+			return err
+		}
+
+		if len(mutations) > 0 {
+			return write(encodeMutations(mutations))
+		}
+		return nil
+	})
+}
@@ -1,7 +1,9 @@
 package store
 
 import (
+	"strings"
 	"sync"
+	"time"
 
 	"github.com/spaolacci/murmur3"
 )
@@ -9,10 +11,26 @@ import (
 // Store represents a in-memory Key/Value implementation
 type Store interface {
 
-	// Set takes a key and value and stores with in the underlying store.
+	// Set takes a key and value and stores with in the underlying store. A
+	// bounded store refuses a value that can never fit, see Bounder, which
+	// leaves any existing value as it is.
 	// Returns true if it's over writting an existing value.
 	Set(key string, value []byte) bool
 
+	// SetWithTTL takes a key and value and stores with in the underlying store,
+	// the value will expire once the ttl has elapsed. A ttl of zero or less
+	// means that the value will never expire.
+	// Returns true if it's over writting an existing value.
+	SetWithTTL(key string, value []byte, ttl time.Duration) bool
+
+	// SetWithMetadata takes a key and value and stores with in the underlying
+	// store along with the metadata that describes the value. The metadata is
+	// replaced by every write to the key, so a write without metadata clears
+	// the metadata. A ttl of zero or less means that the value will never
+	// expire.
+	// Returns true if it's over writting an existing value.
+	SetWithMetadata(key string, value []byte, ttl time.Duration, metadata Metadata) bool
+
 	// Get returns the value associated for the key with in the underlying store.
 	// Returns true if the value is found along with the value.
 	Get(key string) ([]byte, bool)
@@ -20,19 +38,136 @@ type Store interface {
 	// Delete removes a value associated with the key.
 	// Returns true if the value is found when deleting.
 	Delete(key string) bool
+
+	// Select returns the entry associated for the key with in the underlying
+	// store, the entry holds the value along with the current version.
+	// Returns true if the entry is found along with the entry.
+	Select(key string) (Entry, bool)
+
+	// CompareAndSwap stores the value only if the current version of the key
+	// matches the version expected. A version of zero expects that the key
+	// doesn't exist. The ttl and metadata of the existing value are kept. A
+	// bounded store refuses a value that can never fit, see Bounder.
+	// Returns true if the value was swapped along with the new version,
+	// otherwise the current version is returned.
+	CompareAndSwap(key string, version uint64, value []byte) (uint64, bool)
+
+	// CompareAndDelete removes a value associated with the key only if the
+	// current version of the key matches the version expected.
+	// Returns true if the value was deleted.
+	CompareAndDelete(key string, version uint64) bool
+
+	// Scan returns the entries with in the underlying store that match the
+	// options, ordered by key.
+	// Returns the cursor to pass to the next scan, if there are more entries
+	// to be scanned, otherwise the cursor is empty.
+	Scan(options ScanOptions) ([]Entry, string)
+
+	// Txn applies all of the mutations only if all of the conditions hold,
+	// otherwise none of the mutations are applied. Readers never observe some
+	// of the mutations without the others, apart from a Scan, which can read
+	// one part of the store before the mutations and another part after.
+	// A bounded store also doesn't apply any of the mutations if the values
+	// that are set can't all fit with in the limits of the store.
+	// Returns true if the mutations were applied along with the new version of
+	// each mutation, otherwise the current version of the key of each condition
+	// is returned.
+	Txn(conditions []Condition, mutations []Mutation) ([]uint64, bool)
+}
+
+// ScanOptions defines which entries are returned from a scan. All the options
+// are optional, so the zero value scans the whole of the store.
+type ScanOptions struct {
+	// Prefix that all the keys must start with.
+	Prefix string
+	// Start of the range of keys (inclusive).
+	Start string
+	// End of the range of keys (exclusive), empty means there is no end.
+	End string
+	// Limit of the number of entries returned, zero means no limit.
+	Limit int
+	// Cursor returned from a previous scan, to continue on from that scan.
+	Cursor string
+}
+
+// from returns the first key that can match the options.
+func (o ScanOptions) from() string {
+	from := o.Start
+	if o.Prefix > from {
+		from = o.Prefix
+	}
+	if o.Cursor > from {
+		from = o.Cursor
+	}
+	return from
+}
+
+// done returns true if the key is beyond the range of the options.
+func (o ScanOptions) done(key string) bool {
+	return (o.End != "" && key >= o.End) ||
+		!strings.HasPrefix(key, o.Prefix)
+}
+
+// cursor returns the cursor that continues a scan after the key.
+// Because the cursor is the smallest possible key after the key supplied, new
+// keys that are added in between scans are still found and the cursor is never
+// empty.
+func cursor(key string) string {
+	return key + "\x00"
+}
+
+// Entry represents a value with in the store along with the version of the
+// value. Every write to a key increases the version of the key, so the version
+// can be used to detect if a value has changed since it was last read.
+// Expires is when the value expires, it's the zero time if the value never
+// expires.
+type Entry struct {
+	Key      string
+	Value    []byte
+	Version  uint64
+	Expires  time.Time
+	Modified time.Time
+	Metadata Metadata
+}
+
+// Metadata describes a value, so that the value can be returned the same way
+// that it was stored. User holds any other attributes of the value, the store
+// doesn't interpret any of the metadata.
+type Metadata struct {
+	ContentType     string
+	ContentEncoding string
+	User            map[string]string
+}
+
+// IsZero returns true if there is no metadata.
+func (m Metadata) IsZero() bool {
+	return m.ContentType == "" && m.ContentEncoding == "" && len(m.User) == 0
+}
+
+// Size returns the amount of bytes used by the metadata.
+func (m Metadata) Size() int {
+	size := len(m.ContentType) + len(m.ContentEncoding)
+	for k, v := range m.User {
+		size += len(k) + len(v)
+	}
+	return size
 }
 
 type memory struct {
 	size    uint
-	buckets []Store
+	buckets []*bucket
 }
 
 // NewBucket creates a new in-memory Store according to the size required by
 // the value requested.
 func NewBucket(size uint) Store {
-	buckets := make([]Store, size)
+	var (
+		hub     = newHub()
+		buckets = make([]*bucket, size)
+	)
 	for k := range buckets {
-		buckets[k] = New()
+		buckets[k] = newBucket()
+		buckets[k].hub = hub
 	}
 
 	return &memory{
@@ -42,18 +177,152 @@ func NewBucket(size uint) Store {
 }
 
 func (m *memory) Set(key string, value []byte) bool {
-	index := uint(murmur3.Sum32([]byte(key))) % m.size
-	return m.buckets[index].Set(key, value)
+	return m.bucket(key).Set(key, value)
+}
+
+func (m *memory) SetWithTTL(key string, value []byte, ttl time.Duration) bool {
+	return m.bucket(key).SetWithTTL(key, value, ttl)
+}
+
+func (m *memory) SetWithMetadata(key string, value []byte, ttl time.Duration, metadata Metadata) bool {
+	return m.bucket(key).SetWithMetadata(key, value, ttl, metadata)
 }
 
 func (m *memory) Get(key string) ([]byte, bool) {
-	index := uint(murmur3.Sum32([]byte(key))) % m.size
-	return m.buckets[index].Get(key)
+	return m.bucket(key).Get(key)
 }
 
 func (m *memory) Delete(key string) bool {
-	index := uint(murmur3.Sum32([]byte(key))) % m.size
-	return m.buckets[index].Delete(key)
+	return m.bucket(key).Delete(key)
+}
+
+func (m *memory) Select(key string) (Entry, bool) {
+	return m.bucket(key).Select(key)
+}
+
+func (m *memory) CompareAndSwap(key string, version uint64, value []byte) (uint64, bool) {
+	return m.bucket(key).CompareAndSwap(key, version, value)
+}
+
+func (m *memory) CompareAndDelete(key string, version uint64) bool {
+	return m.bucket(key).CompareAndDelete(key, version)
+}
+
+// Scan scans each bucket for the entries and then merges the entries from each
+// bucket, so that the entries are still ordered by key. Each bucket is scanned
+// on its own, only holding the lock of that bucket, so the scan isn't a
+// point-in-time view of the store. A transaction that spans buckets can be
+// applied part way through the scan, in which case the scan only includes the
+// mutations to the buckets that are scanned after it.
+func (m *memory) Scan(options ScanOptions) ([]Entry, string) {
+	var (
+		more    bool
+		results = make([][]Entry, 0, len(m.buckets))
+	)
+	for _, b := range m.buckets {
+		entries, next := b.Scan(options)
+		if next != "" {
+			more = true
+		}
+		if len(entries) > 0 {
+			results = append(results, entries)
+		}
+	}
+
+	var entries []Entry
+	for len(results) > 0 {
+		if options.Limit > 0 && len(entries) == options.Limit {
+			more = true
+			break
+		}
+
+		// Find the smallest key at the head of each of the results. The amount
+		// of buckets is expected to be small, so a linear search is fine.
+		min := 0
+		for k := 1; k < len(results); k++ {
+			if results[k][0].Key < results[min][0].Key {
+				min = k
+			}
+		}
+
+		entries = append(entries, results[min][0])
+		if results[min] = results[min][1:]; len(results[min]) == 0 {
+			results = append(results[:min], results[min+1:]...)
+		}
+	}
+
+	if more && len(entries) > 0 {
+		return entries, cursor(entries[len(entries)-1].Key)
+	}
+	return entries, ""
+}
+
+func (m *memory) sweep(now time.Time) int {
+	var removed int
+	for _, b := range m.buckets {
+		removed += b.sweep(now)
+	}
+	return removed
+}
+
+func (m *memory) peek(key string) (entry, bool) {
+	return m.bucket(key).peek(key)
+}
+
+func (m *memory) restore(key string, e entry) {
+	m.bucket(key).restore(key, e)
+}
+
+func (m *memory) forget(key string) {
+	m.bucket(key).forget(key)
+}
+
+func (m *memory) advance(version uint64) {
+	for _, b := range m.buckets {
+		b.advance(version)
+	}
+}
+
+// bucket returns the bucket the key belongs to.
+func (m *memory) bucket(key string) *bucket {
+	return m.buckets[m.index(key)]
+}
+
+// index returns the index of the bucket the key belongs to.
+func (m *memory) index(key string) uint {
+	return uint(murmur3.Sum32([]byte(key))) % m.size
+}
+
+// entry holds the value along with the version of the value, when the value
+// expires, when the value was last modified and the metadata of the value. An
+// expires of zero means that the value never expires, a modified of zero means
+// that it's not known when the value was modified.
+type entry struct {
+	value    []byte
+	version  uint64
+	expires  int64
+	modified int64
+	metadata Metadata
+}
+
+func (e entry) expired(now int64) bool {
+	return e.expires > 0 && e.expires <= now
+}
+
+func newEntry(key string, e entry) Entry {
+	entry := Entry{
+		Key:      key,
+		Value:    e.value,
+		Version:  e.version,
+		Metadata: e.metadata,
+	}
+	if e.expires > 0 {
+		entry.Expires = time.Unix(0, e.expires)
+	}
+	if e.modified > 0 {
+		entry.Modified = time.Unix(0, e.modified)
+	}
+	return entry
 }
 
 // bucket conforms to the Key/Val store interface and provides locking mechanism
@@ -61,37 +330,363 @@ func (m *memory) Delete(key string) bool {
 // values are stored in a simple map, it is entirely possible to replace this
 // map with the newer https://golang.org/pkg/sync/#Map, but we will loose some
 // portability.
+// expiring holds all the keys that have a ttl associated with them, so that
+// the sweeper only has to sample those keys.
+// clock is incremented for every write to the bucket and is used as the
+// version of the value, so versions for a key only ever increase even if the
+// key is deleted and then set again.
+// index holds all the keys of the values in order, for scanning.
+// snapshot is only set whilst a snapshot of the bucket is in progress.
+// bytes is the size of all the keys and values, so that the bucket can be
+// bounded by the limits. evictor is only set if the bucket is bounded.
+// hub is where the changes to the bucket are published, buckets of the same
+// store share the same hub.
 type bucket struct {
-	mutex  sync.RWMutex
-	values map[string][]byte
+	mutex     sync.RWMutex
+	clock     uint64
+	values    map[string]entry
+	expiring  map[string]struct{}
+	index     *skiplist
+	snapshot  *bucketSnapshot
+	bytes     uint64
+	evictions uint64
+	limits    Limits
+	evictor   *evictor
+	hub       *hub
 }
 
 // New creates a store from a singular bucket
 func New() Store {
+	return newBucket()
+}
+
+func newBucket() *bucket {
 	return &bucket{
-		values: make(map[string][]byte),
+		values:   make(map[string]entry),
+		expiring: make(map[string]struct{}),
+		index:    newSkiplist(),
+		hub:      newHub(),
 	}
 }
 
 func (b *bucket) Set(key string, value []byte) bool {
+	return b.SetWithTTL(key, value, 0)
+}
+
+func (b *bucket) SetWithTTL(key string, value []byte, ttl time.Duration) bool {
+	return b.SetWithMetadata(key, value, ttl, Metadata{})
+}
+
+func (b *bucket) SetWithMetadata(key string, value []byte, ttl time.Duration, metadata Metadata) bool {
+	now := time.Now()
+
+	var expires int64
+	if ttl > 0 {
+		expires = now.Add(ttl).UnixNano()
+	}
+
 	b.mutex.Lock()
-	_, ok := b.values[key]
-	b.values[key] = value
-	b.mutex.Unlock()
+	defer b.mutex.Unlock()
+
+	_, ok := b.lookup(key, now.UnixNano())
+	if b.insert(key, value, metadata, expires, now.UnixNano()) == 0 {
+		return false
+	}
 	return ok
 }
 
 func (b *bucket) Get(key string) ([]byte, bool) {
-	b.mutex.RLock()
-	value, ok := b.values[key]
-	b.mutex.RUnlock()
-	return value, ok
+	e, ok := b.get(key)
+	return e.value, ok
 }
 
 func (b *bucket) Delete(key string) bool {
+	now := time.Now().UnixNano()
+
 	b.mutex.Lock()
-	_, ok := b.values[key]
-	delete(b.values, key)
+	_, ok := b.lookup(key, now)
+	b.remove(key)
 	b.mutex.Unlock()
 	return ok
 }
+
+func (b *bucket) Select(key string) (Entry, bool) {
+	e, ok := b.get(key)
+	if !ok {
+		return Entry{}, false
+	}
+	return newEntry(key, e), true
+}
+
+func (b *bucket) CompareAndSwap(key string, version uint64, value []byte) (uint64, bool) {
+	now := time.Now().UnixNano()
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	// A missing value has a version of zero.
+	e, _ := b.lookup(key, now)
+	if e.version != version {
+		return e.version, false
+	}
+	if v := b.insert(key, value, e.metadata, e.expires, now); v > 0 {
+		return v, true
+	}
+	return e.version, false
+}
+
+func (b *bucket) CompareAndDelete(key string, version uint64) bool {
+	now := time.Now().UnixNano()
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	e, ok := b.lookup(key, now)
+	if !ok || e.version != version {
+		return false
+	}
+	b.remove(key)
+	return true
+}
+
+func (b *bucket) Scan(options ScanOptions) ([]Entry, string) {
+	now := time.Now().UnixNano()
+
+	b.mutex.RLock()
+	defer b.mutex.RUnlock()
+
+	var entries []Entry
+	for node := b.index.seek(options.from()); node != nil; node = node.next[0] {
+		if options.done(node.key) {
+			break
+		}
+
+		e := b.values[node.key]
+		if e.expired(now) {
+			continue
+		}
+
+		if options.Limit > 0 && len(entries) == options.Limit {
+			return entries, cursor(entries[len(entries)-1].Key)
+		}
+		entries = append(entries, newEntry(node.key, e))
+	}
+	return entries, ""
+}
+
+// get returns the entry for the key, removing the entry if it has expired.
+func (b *bucket) get(key string) (entry, bool) {
+	now := time.Now().UnixNano()
+
+	b.mutex.RLock()
+	e, ok := b.values[key]
+	b.mutex.RUnlock()
+
+	if ok && e.expired(now) {
+		// Lazily remove the expired value, making sure that it wasn't replaced
+		// whilst we didn't hold the lock.
+		b.mutex.Lock()
+		if e, ok := b.values[key]; ok && e.expired(now) {
+			b.remove(key)
+		}
+		b.mutex.Unlock()
+		return entry{}, false
+	}
+	if ok && b.evictor != nil {
+		b.evictor.access(key)
+	}
+	return e, ok
+}
+
+// lookup returns the entry for the key if it hasn't expired.
+// lookup expects the caller to hold the lock.
+func (b *bucket) lookup(key string, now int64) (entry, bool) {
+	e, ok := b.values[key]
+	if !ok || e.expired(now) {
+		return entry{}, false
+	}
+	return e, true
+}
+
+// insert stores the value with a new version, returning the new version. If
+// the value can never fit with in the bucket, then nothing is stored and a
+// version of zero is returned.
+// insert expects the caller to hold the write lock.
+func (b *bucket) insert(key string, value []byte, metadata Metadata, expires, now int64) uint64 {
+	e := entry{
+		value:    value,
+		version:  b.clock + 1,
+		expires:  expires,
+		modified: now,
+		metadata: metadata,
+	}
+	if !b.put(key, e) {
+		return 0
+	}
+	b.clock++
+	return b.clock
+}
+
+// put stores the entry, if the bucket is bounded then other values are
+// evicted to make room for the entry. If the entry can never fit, then nothing
+// is stored and false is returned.
+// put expects the caller to hold the write lock.
+func (b *bucket) put(key string, e entry) bool {
+	if b.evictor != nil && !b.reserve(key, e) {
+		return false
+	}
+	b.place(key, e)
+	return true
+}
+
+// place stores the entry, without making room for the entry first.
+// place expects the caller to hold the write lock.
+func (b *bucket) place(key string, e entry) {
+	b.preserve(key)
+
+	if old, ok := b.values[key]; ok {
+		b.bytes -= usage(key, old)
+	} else {
+		b.index.insert(key)
+	}
+	b.bytes += usage(key, e)
+
+	b.values[key] = e
+	if e.expires > 0 {
+		b.expiring[key] = struct{}{}
+	} else {
+		delete(b.expiring, key)
+	}
+
+	if b.evictor != nil {
+		b.evictor.insert(key, e)
+	}
+
+	b.hub.publish(Event{
+		Type:    EventPut,
+		Key:     key,
+		Value:   e.value,
+		Version: e.version,
+	})
+}
+
+// remove expects the caller to hold the write lock.
+func (b *bucket) remove(key string) {
+	b.preserve(key)
+
+	old, ok := b.values[key]
+	if !ok {
+		return
+	}
+
+	b.index.remove(key)
+	b.bytes -= usage(key, old)
+	delete(b.values, key)
+	delete(b.expiring, key)
+
+	if b.evictor != nil {
+		b.evictor.remove(key)
+	}
+
+	b.hub.publish(Event{
+		Type:    EventDelete,
+		Key:     key,
+		Version: old.version,
+	})
+}
+
+func (b *bucket) peek(key string) (entry, bool) {
+	now := time.Now().UnixNano()
+
+	b.mutex.RLock()
+	defer b.mutex.RUnlock()
+
+	return b.lookup(key, now)
+}
+
+func (b *bucket) restore(key string, e entry) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	b.put(key, e)
+
+	// Make sure that the versions handed out after a restore continue on from
+	// the versions that have been restored.
+	if e.version > b.clock {
+		b.clock = e.version
+	}
+}
+
+func (b *bucket) forget(key string) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	b.remove(key)
+}
+
+func (b *bucket) advance(version uint64) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if version > b.clock {
+		b.clock = version
+	}
+}
+
+const (
+	// sweepSample is the amount of expiring keys that are inspected for each
+	// pass of the sweep.
+	sweepSample = 20
+	// sweepThreshold defines how many keys of the sample have to be expired
+	// before another pass of the sweep is performed.
+	sweepThreshold = sweepSample / 4
+)
+
+// sweep samples the keys that have a ttl, removing the ones that have expired.
+// The read lock is held whilst sampling and the write lock whilst removing,
+// but never for more than a sample at a time, so that we don't starve other
+// readers and writers of the bucket. If a large amount of the sample was
+// expired, another pass is performed.
+func (b *bucket) sweep(now time.Time) int {
+	var (
+		removed int
+		nano    = now.UnixNano()
+		keys    = make([]string, 0, sweepSample)
+	)
+	for {
+		keys = keys[:0]
+
+		// Map iteration is randomised, so ranging over the map gives us a
+		// random sample to work with.
+		b.mutex.RLock()
+		var sampled int
+		for key := range b.expiring {
+			if sampled >= sweepSample {
+				break
+			}
+			sampled++
+
+			if b.values[key].expired(nano) {
+				keys = append(keys, key)
+			}
+		}
+		b.mutex.RUnlock()
+
+		if len(keys) == 0 {
+			return removed
+		}
+
+		b.mutex.Lock()
+		for _, key := range keys {
+			if e, ok := b.values[key]; ok && e.expired(nano) {
+				b.remove(key)
+				removed++
+			}
+		}
+		b.mutex.Unlock()
+
+		if len(keys) <= sweepThreshold {
+			return removed
+		}
+	}
+}
@@ -1,11 +1,18 @@
 package store_test
 
 import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
 	"reflect"
+	"sync"
 	"testing"
 	"testing/quick"
+	"time"
 
 	"github.com/SimonRichardson/keyval/pkg/store"
+	"github.com/SimonRichardson/keyval/pkg/wal"
 )
 
 func testStore(t *testing.T, store func() store.Store) {
@@ -85,6 +92,155 @@ func testStore(t *testing.T, store func() store.Store) {
 			t.Error(err)
 		}
 	})
+
+	t.Run("setting store value increases the version", func(t *testing.T) {
+		fn := func(key string, a, b []byte) bool {
+			s := store()
+			s.Set(key, a)
+			first, ok := s.Select(key)
+			if !ok {
+				return false
+			}
+			s.Set(key, b)
+			second, ok := s.Select(key)
+			return ok && second.Version > first.Version &&
+				reflect.DeepEqual(b, second.Value)
+		}
+		if err := quick.Check(fn, nil); err != nil {
+			t.Error(err)
+		}
+	})
+
+	t.Run("setting store value records when it was modified", func(t *testing.T) {
+		s := store()
+
+		before := time.Now()
+		s.Set("a", []byte("a"))
+		first, _ := s.Select("a")
+		s.CompareAndSwap("a", first.Version, []byte("b"))
+		second, _ := s.Select("a")
+		after := time.Now()
+
+		if first.Modified.Before(before) || first.Modified.After(second.Modified) || second.Modified.After(after) {
+			t.Errorf("expected: %v <= %v <= %v <= %v", before, first.Modified, second.Modified, after)
+		}
+	})
+
+	t.Run("compare and swap with no existing value", func(t *testing.T) {
+		fn := func(key string, value []byte) bool {
+			s := store()
+			version, ok := s.CompareAndSwap(key, 0, value)
+			if !ok {
+				return false
+			}
+			entry, ok := s.Select(key)
+			return ok && entry.Version == version
+		}
+		if err := quick.Check(fn, nil); err != nil {
+			t.Error(err)
+		}
+	})
+
+	t.Run("compare and swap with matching version", func(t *testing.T) {
+		fn := func(key string, a, b []byte) bool {
+			s := store()
+			s.Set(key, a)
+			entry, _ := s.Select(key)
+			version, ok := s.CompareAndSwap(key, entry.Version, b)
+			if !ok || version <= entry.Version {
+				return false
+			}
+			result, _ := s.Get(key)
+			return reflect.DeepEqual(b, result)
+		}
+		if err := quick.Check(fn, nil); err != nil {
+			t.Error(err)
+		}
+	})
+
+	t.Run("compare and swap with stale version", func(t *testing.T) {
+		fn := func(key string, a, b []byte) bool {
+			s := store()
+			s.Set(key, a)
+			entry, _ := s.Select(key)
+			s.Set(key, a)
+			version, ok := s.CompareAndSwap(key, entry.Version, b)
+			return !ok && version > entry.Version
+		}
+		if err := quick.Check(fn, nil); err != nil {
+			t.Error(err)
+		}
+	})
+
+	t.Run("compare and delete with matching version", func(t *testing.T) {
+		fn := func(key string, value []byte) bool {
+			s := store()
+			s.Set(key, value)
+			entry, _ := s.Select(key)
+			if !s.CompareAndDelete(key, entry.Version) {
+				return false
+			}
+			_, ok := s.Get(key)
+			return !ok
+		}
+		if err := quick.Check(fn, nil); err != nil {
+			t.Error(err)
+		}
+	})
+
+	t.Run("compare and delete with stale version", func(t *testing.T) {
+		fn := func(key string, value []byte) bool {
+			s := store()
+			s.Set(key, value)
+			entry, _ := s.Select(key)
+			s.Set(key, value)
+			return !s.CompareAndDelete(key, entry.Version)
+		}
+		if err := quick.Check(fn, nil); err != nil {
+			t.Error(err)
+		}
+	})
+
+	t.Run("setting then getting store value with ttl returns value", func(t *testing.T) {
+		fn := func(key string, value []byte) bool {
+			s := store()
+			s.SetWithTTL(key, value, time.Minute)
+			result, ok := s.Get(key)
+			return ok && reflect.DeepEqual(value, result)
+		}
+		if err := quick.Check(fn, nil); err != nil {
+			t.Error(err)
+		}
+	})
+
+	t.Run("getting expired store value returns false", func(t *testing.T) {
+		s := store()
+		s.SetWithTTL("a", []byte("b"), time.Millisecond)
+
+		time.Sleep(time.Millisecond * 5)
+
+		if _, ok := s.Get("a"); ok {
+			t.Errorf("expected: %v, actual: %v", false, ok)
+		}
+		if ok := s.Delete("a"); ok {
+			t.Errorf("expected: %v, actual: %v", false, ok)
+		}
+	})
+
+	t.Run("setting expired store value returns false", func(t *testing.T) {
+		s := store()
+		s.SetWithTTL("a", []byte("b"), time.Millisecond)
+
+		time.Sleep(time.Millisecond * 5)
+
+		if ok := s.Set("a", []byte("c")); ok {
+			t.Errorf("expected: %v, actual: %v", false, ok)
+		}
+		if _, ok := s.Get("a"); !ok {
+			t.Errorf("expected: %v, actual: %v", true, ok)
+		}
+	})
+
 }
 
 func TestStore(t *testing.T) {
@@ -103,6 +259,901 @@ func TestBucketStore(t *testing.T) {
 	})
 }
 
+func testScan(t *testing.T, newStore func() store.Store) {
+	t.Run("scanning store returns keys in order", func(t *testing.T) {
+		fn := func(values map[string][]byte) bool {
+			s := newStore()
+			for k, v := range values {
+				s.Set(k, v)
+			}
+
+			entries, cursor := s.Scan(store.ScanOptions{})
+			if cursor != "" || len(entries) != len(values) {
+				return false
+			}
+			for k, v := range entries {
+				if k > 0 && entries[k-1].Key >= v.Key {
+					return false
+				}
+				if !reflect.DeepEqual(values[v.Key], v.Value) {
+					return false
+				}
+			}
+			return true
+		}
+		if err := quick.Check(fn, nil); err != nil {
+			t.Error(err)
+		}
+	})
+
+	t.Run("scanning store with cursor returns every key once", func(t *testing.T) {
+		fn := func(values map[string][]byte, limit uint8) bool {
+			s := newStore()
+			for k, v := range values {
+				s.Set(k, v)
+			}
+
+			var (
+				keys    []string
+				options = store.ScanOptions{
+					Limit: int(limit%10) + 1,
+				}
+			)
+			for {
+				entries, cursor := s.Scan(options)
+				if len(entries) > options.Limit {
+					return false
+				}
+				for _, v := range entries {
+					keys = append(keys, v.Key)
+				}
+				if cursor == "" {
+					break
+				}
+				options.Cursor = cursor
+			}
+
+			if len(keys) != len(values) {
+				return false
+			}
+			for k, v := range keys {
+				if k > 0 && keys[k-1] >= v {
+					return false
+				}
+			}
+			return true
+		}
+		if err := quick.Check(fn, nil); err != nil {
+			t.Error(err)
+		}
+	})
+
+	t.Run("scanning store with prefix and range", func(t *testing.T) {
+		s := newStore()
+		for _, k := range []string{"a", "a/1", "a/2", "a/3", "b", "b/1"} {
+			s.Set(k, []byte(k))
+		}
+
+		for _, testcase := range []struct {
+			options store.ScanOptions
+			keys    []string
+		}{
+			{store.ScanOptions{Prefix: "a/"}, []string{"a/1", "a/2", "a/3"}},
+			{store.ScanOptions{Prefix: "b"}, []string{"b", "b/1"}},
+			{store.ScanOptions{Prefix: "c"}, nil},
+			{store.ScanOptions{Start: "a/2", End: "b/1"}, []string{"a/2", "a/3", "b"}},
+			{store.ScanOptions{Prefix: "a/", Start: "a/2"}, []string{"a/2", "a/3"}},
+		} {
+			entries, _ := s.Scan(testcase.options)
+
+			var keys []string
+			for _, v := range entries {
+				keys = append(keys, v.Key)
+			}
+			if expected, actual := testcase.keys, keys; !reflect.DeepEqual(expected, actual) {
+				t.Errorf("%+v: expected: %v, actual: %v", testcase.options, expected, actual)
+			}
+		}
+	})
+
+	t.Run("scanning store skips deleted and expired keys", func(t *testing.T) {
+		s := newStore()
+		s.Set("a", []byte("a"))
+		s.Set("b", []byte("b"))
+		s.SetWithTTL("c", []byte("c"), time.Millisecond)
+		s.Delete("b")
+
+		time.Sleep(time.Millisecond * 5)
+
+		entries, _ := s.Scan(store.ScanOptions{})
+		if expected, actual := 1, len(entries); expected != actual {
+			t.Fatalf("expected: %v, actual: %v", expected, actual)
+		}
+		if expected, actual := "a", entries[0].Key; expected != actual {
+			t.Errorf("expected: %v, actual: %v", expected, actual)
+		}
+	})
+}
+
+func TestScan(t *testing.T) {
+	t.Parallel()
+
+	t.Run("store", func(t *testing.T) {
+		testScan(t, func() store.Store {
+			return store.New()
+		})
+	})
+
+	t.Run("bucket store", func(t *testing.T) {
+		testScan(t, func() store.Store {
+			return store.NewBucket(10)
+		})
+	})
+}
+
+func testSweeper(t *testing.T, s store.Store) {
+	for i := 0; i < 100; i++ {
+		s.SetWithTTL(fmt.Sprintf("expire-%d", i), []byte("a"), time.Millisecond)
+		s.SetWithTTL(fmt.Sprintf("keep-%d", i), []byte("b"), time.Minute)
+	}
+
+	time.Sleep(time.Millisecond * 5)
+
+	sweeper := store.NewSweeper(s, time.Minute)
+
+	// Sweeping is sampled, so it can take a few sweeps to remove everything.
+	var removed int
+	for i := 0; i < 1000 && removed < 100; i++ {
+		removed += sweeper.Sweep()
+	}
+
+	if expected, actual := 100, removed; expected != actual {
+		t.Errorf("expected: %v, actual: %v", expected, actual)
+	}
+	for i := 0; i < 100; i++ {
+		if _, ok := s.Get(fmt.Sprintf("keep-%d", i)); !ok {
+			t.Errorf("expected: %v, actual: %v", true, ok)
+		}
+	}
+}
+
+func TestSweeper(t *testing.T) {
+	t.Parallel()
+
+	t.Run("store", func(t *testing.T) {
+		testSweeper(t, store.New())
+	})
+
+	t.Run("bucket store", func(t *testing.T) {
+		testSweeper(t, store.NewBucket(10))
+	})
+
+	t.Run("run without an interval", func(t *testing.T) {
+		for _, interval := range []time.Duration{0, -time.Second} {
+			sweeper := store.NewSweeper(store.New(), interval)
+
+			done := make(chan error)
+			go func() {
+				done <- sweeper.Run()
+			}()
+			sweeper.Stop()
+
+			if err := <-done; err != nil {
+				t.Errorf("expected: %v, actual: %v", nil, err)
+			}
+		}
+	})
+}
+
+func TestBoundedStore(t *testing.T) {
+	t.Parallel()
+
+	for _, eviction := range []store.Eviction{store.EvictLRU, store.EvictLFU, store.EvictTTL} {
+		t.Run(eviction.String(), func(t *testing.T) {
+			testStore(t, func() store.Store {
+				return store.NewBoundedBucket(10, store.Limits{
+					MaxBytes: 1 << 20,
+					Eviction: eviction,
+				})
+			})
+		})
+	}
+}
+
+func TestEviction(t *testing.T) {
+	t.Parallel()
+
+	evictions := func(s store.Store) uint64 {
+		return s.(store.Reporter).Stats().Evictions
+	}
+
+	t.Run("max items", func(t *testing.T) {
+		s := store.NewBounded(store.Limits{MaxItems: 10})
+		for i := 0; i < 20; i++ {
+			s.Set(fmt.Sprintf("key-%d", i), []byte("value"))
+		}
+
+		stats := s.(store.Reporter).Stats()
+		if expected, actual := uint64(10), stats.Items; expected != actual {
+			t.Errorf("expected: %v, actual: %v", expected, actual)
+		}
+		if expected, actual := uint64(10), stats.Evictions; expected != actual {
+			t.Errorf("expected: %v, actual: %v", expected, actual)
+		}
+	})
+
+	t.Run("max bytes", func(t *testing.T) {
+		fn := func(keys []string, value []byte) bool {
+			s := store.NewBoundedBucket(4, store.Limits{MaxBytes: 256})
+			for _, key := range keys {
+				s.Set(key, value)
+			}
+			return s.(store.Reporter).Stats().Bytes <= 256
+		}
+		if err := quick.Check(fn, nil); err != nil {
+			t.Error(err)
+		}
+	})
+
+	t.Run("max bytes includes metadata", func(t *testing.T) {
+		s := store.NewBounded(store.Limits{MaxBytes: 16})
+		metadata := store.Metadata{
+			ContentType: "text/plain",
+		}
+		s.SetWithMetadata("key", []byte("value"), 0, metadata)
+
+		if _, ok := s.Get("key"); ok {
+			t.Errorf("expected: %v, actual: %v", false, ok)
+		}
+		if expected, actual := false, store.Fits(s, "key", []byte("value"), metadata); expected != actual {
+			t.Errorf("expected: %v, actual: %v", expected, actual)
+		}
+	})
+
+	t.Run("too large is refused", func(t *testing.T) {
+		s := store.NewBounded(store.Limits{MaxBytes: 16})
+		s.Set("key", []byte("value"))
+		entry, _ := s.Select("key")
+
+		if expected, actual := false, s.Set("key", make([]byte, 16)); expected != actual {
+			t.Errorf("expected: %v, actual: %v", expected, actual)
+		}
+		if version, ok := s.CompareAndSwap("key", entry.Version, make([]byte, 16)); ok || version != entry.Version {
+			t.Errorf("expected: %v, actual: %v", entry.Version, version)
+		}
+		if value, ok := s.Get("key"); !ok || string(value) != "value" {
+			t.Errorf("expected: %v, actual: %v", "value", string(value))
+		}
+		if expected, actual := uint64(0), evictions(s); expected != actual {
+			t.Errorf("expected: %v, actual: %v", expected, actual)
+		}
+	})
+
+	t.Run("too large for a shard is refused", func(t *testing.T) {
+		// The limits are spread across the shards, so each shard only holds
+		// 16 bytes.
+		s := store.NewBoundedBucket(4, store.Limits{MaxBytes: 64})
+		s.Set("key", make([]byte, 32))
+
+		if _, ok := s.Get("key"); ok {
+			t.Errorf("expected: %v, actual: %v", false, ok)
+		}
+		if expected, actual := false, store.Fits(s, "key", make([]byte, 32), store.Metadata{}); expected != actual {
+			t.Errorf("expected: %v, actual: %v", expected, actual)
+		}
+		if expected, actual := true, store.Fits(s, "key", make([]byte, 8), store.Metadata{}); expected != actual {
+			t.Errorf("expected: %v, actual: %v", expected, actual)
+		}
+	})
+
+	t.Run("lru", func(t *testing.T) {
+		s := store.NewBounded(store.Limits{MaxItems: 2, Eviction: store.EvictLRU})
+		s.Set("a", []byte("a"))
+		s.Set("b", []byte("b"))
+		s.Get("a")
+		s.Set("c", []byte("c"))
+
+		if _, ok := s.Get("b"); ok {
+			t.Errorf("expected: %v, actual: %v", false, ok)
+		}
+		if _, ok := s.Get("a"); !ok {
+			t.Errorf("expected: %v, actual: %v", true, ok)
+		}
+	})
+
+	t.Run("lfu", func(t *testing.T) {
+		s := store.NewBounded(store.Limits{MaxItems: 2, Eviction: store.EvictLFU})
+		s.Set("a", []byte("a"))
+		s.Set("b", []byte("b"))
+		s.Get("a")
+		s.Get("a")
+		s.Get("b")
+		s.Set("c", []byte("c"))
+
+		if _, ok := s.Get("b"); ok {
+			t.Errorf("expected: %v, actual: %v", false, ok)
+		}
+		if _, ok := s.Get("a"); !ok {
+			t.Errorf("expected: %v, actual: %v", true, ok)
+		}
+	})
+
+	t.Run("ttl", func(t *testing.T) {
+		s := store.NewBounded(store.Limits{MaxItems: 3, Eviction: store.EvictTTL})
+		s.Set("a", []byte("a"))
+		s.SetWithTTL("b", []byte("b"), time.Hour)
+		s.SetWithTTL("c", []byte("c"), time.Minute)
+		s.Set("d", []byte("d"))
+		s.Set("e", []byte("e"))
+
+		for key, expected := range map[string]bool{
+			"a": true,
+			"b": false,
+			"c": false,
+			"d": true,
+			"e": true,
+		} {
+			if _, actual := s.Get(key); expected != actual {
+				t.Errorf("%s expected: %v, actual: %v", key, expected, actual)
+			}
+		}
+	})
+
+	t.Run("txn never evicts its own mutations", func(t *testing.T) {
+		// The new values are used less frequently than "a", so without making
+		// room for the whole of the transaction "b" would be evicted by "c".
+		s := store.NewBounded(store.Limits{MaxItems: 2, Eviction: store.EvictLFU})
+		s.Set("a", []byte("a"))
+		s.Get("a")
+		s.Get("a")
+
+		if _, ok := s.Txn(nil, []store.Mutation{
+			{Key: "b", Value: []byte("b")},
+			{Key: "c", Value: []byte("c")},
+		}); !ok {
+			t.Fatalf("expected: %v, actual: %v", true, ok)
+		}
+		for key, expected := range map[string]bool{
+			"a": false,
+			"b": true,
+			"c": true,
+		} {
+			if _, actual := s.Get(key); expected != actual {
+				t.Errorf("%s expected: %v, actual: %v", key, expected, actual)
+			}
+		}
+	})
+
+	t.Run("txn that can't fit applies nothing", func(t *testing.T) {
+		s := store.NewBoundedBucket(1, store.Limits{MaxItems: 2, MaxBytes: 16})
+		s.Set("a", []byte("a"))
+
+		for _, mutations := range [][]store.Mutation{
+			{
+				{Key: "b", Value: []byte("b")},
+				{Key: "c", Value: []byte("c")},
+				{Key: "d", Value: []byte("d")},
+			},
+			{
+				{Key: "b", Value: make([]byte, 16)},
+			},
+		} {
+			if _, ok := s.Txn(nil, mutations); ok {
+				t.Errorf("expected: %v, actual: %v", false, ok)
+			}
+		}
+		for key, expected := range map[string]bool{
+			"a": true,
+			"b": false,
+			"c": false,
+			"d": false,
+		} {
+			if _, actual := s.Get(key); expected != actual {
+				t.Errorf("%s expected: %v, actual: %v", key, expected, actual)
+			}
+		}
+		if expected, actual := uint64(0), evictions(s); expected != actual {
+			t.Errorf("expected: %v, actual: %v", expected, actual)
+		}
+	})
+
+	t.Run("txn that deletes makes room", func(t *testing.T) {
+		s := store.NewBounded(store.Limits{MaxItems: 2})
+		s.Set("a", []byte("a"))
+		s.Set("b", []byte("b"))
+
+		if _, ok := s.Txn(nil, []store.Mutation{
+			{Key: "c", Value: []byte("c")},
+			{Key: "a", Operation: store.OperationDelete},
+		}); !ok {
+			t.Fatalf("expected: %v, actual: %v", true, ok)
+		}
+		for key, expected := range map[string]bool{
+			"a": false,
+			"b": true,
+			"c": true,
+		} {
+			if _, actual := s.Get(key); expected != actual {
+				t.Errorf("%s expected: %v, actual: %v", key, expected, actual)
+			}
+		}
+		if expected, actual := uint64(0), evictions(s); expected != actual {
+			t.Errorf("expected: %v, actual: %v", expected, actual)
+		}
+	})
+}
+
+func testTxn(t *testing.T, newStore func() store.Store) {
+	t.Run("txn without conditions applies mutations", func(t *testing.T) {
+		fn := func(values map[string][]byte) bool {
+			s := newStore()
+
+			var mutations []store.Mutation
+			for k, v := range values {
+				mutations = append(mutations, store.Mutation{
+					Key:   k,
+					Value: v,
+				})
+			}
+			versions, ok := s.Txn(nil, mutations)
+			if !ok || len(versions) != len(mutations) {
+				return false
+			}
+			for k, v := range mutations {
+				entry, ok := s.Select(v.Key)
+				if !ok || entry.Version != versions[k] || !bytes.Equal(entry.Value, v.Value) {
+					return false
+				}
+			}
+			return true
+		}
+		if err := quick.Check(fn, nil); err != nil {
+			t.Error(err)
+		}
+	})
+
+	t.Run("txn with failing condition applies nothing", func(t *testing.T) {
+		s := newStore()
+		s.Set("a", []byte("a"))
+		entry, _ := s.Select("a")
+
+		versions, ok := s.Txn([]store.Condition{
+			{Key: "a", Check: store.CheckExists},
+			{Key: "b", Check: store.CheckExists},
+		}, []store.Mutation{
+			{Key: "a", Operation: store.OperationDelete},
+			{Key: "c", Value: []byte("c")},
+		})
+		if ok {
+			t.Errorf("expected: %v, actual: %v", false, ok)
+		}
+		if expected, actual := []uint64{entry.Version, 0}, versions; !reflect.DeepEqual(expected, actual) {
+			t.Errorf("expected: %v, actual: %v", expected, actual)
+		}
+		if _, ok := s.Get("a"); !ok {
+			t.Errorf("expected: %v, actual: %v", true, ok)
+		}
+		if _, ok := s.Get("c"); ok {
+			t.Errorf("expected: %v, actual: %v", false, ok)
+		}
+	})
+
+	t.Run("txn with holding conditions applies everything", func(t *testing.T) {
+		s := newStore()
+		s.Set("a", []byte("a"))
+		entry, _ := s.Select("a")
+
+		versions, ok := s.Txn([]store.Condition{
+			{Key: "a", Check: store.CheckVersion, Version: entry.Version},
+			{Key: "b", Check: store.CheckMissing},
+		}, []store.Mutation{
+			{Key: "a", Operation: store.OperationDelete},
+			{Key: "b", Value: []byte("b"), TTL: time.Minute},
+		})
+		if !ok {
+			t.Errorf("expected: %v, actual: %v", true, ok)
+		}
+		if len(versions) != 2 || versions[0] != 0 || versions[1] == 0 {
+			t.Errorf("expected: [0 >0], actual: %v", versions)
+		}
+		if _, ok := s.Get("a"); ok {
+			t.Errorf("expected: %v, actual: %v", false, ok)
+		}
+		if value, _ := s.Get("b"); !bytes.Equal(value, []byte("b")) {
+			t.Errorf("expected: %v, actual: %v", "b", string(value))
+		}
+	})
+
+	t.Run("concurrent txns are atomic", func(t *testing.T) {
+		const (
+			accounts = 10
+			workers  = 8
+			rounds   = 200
+		)
+
+		s := newStore()
+		for i := 0; i < accounts; i++ {
+			s.Set(fmt.Sprintf("account-%d", i), []byte{10})
+		}
+
+		// Move a unit between two accounts, using the versions to make sure
+		// that nothing changed in between reading and writing.
+		transfer := func(from, to string) {
+			for {
+				a, _ := s.Select(from)
+				b, _ := s.Select(to)
+				if a.Value[0] == 0 {
+					return
+				}
+				if _, ok := s.Txn([]store.Condition{
+					{Key: from, Check: store.CheckVersion, Version: a.Version},
+					{Key: to, Check: store.CheckVersion, Version: b.Version},
+				}, []store.Mutation{
+					{Key: from, Value: []byte{a.Value[0] - 1}},
+					{Key: to, Value: []byte{b.Value[0] + 1}},
+				}); ok {
+					return
+				}
+			}
+		}
+
+		var wg sync.WaitGroup
+		for w := 0; w < workers; w++ {
+			wg.Add(1)
+			go func(w int) {
+				defer wg.Done()
+				for r := 0; r < rounds; r++ {
+					from, to := (w+r)%accounts, (w*r+1)%accounts
+					if from != to {
+						transfer(fmt.Sprintf("account-%d", from), fmt.Sprintf("account-%d", to))
+					}
+				}
+			}(w)
+		}
+		wg.Wait()
+
+		var total int
+		entries, _ := s.Scan(store.ScanOptions{Prefix: "account-"})
+		for _, entry := range entries {
+			total += int(entry.Value[0])
+		}
+		if expected, actual := accounts*10, total; expected != actual {
+			t.Errorf("expected: %v, actual: %v", expected, actual)
+		}
+	})
+}
+
+func TestTxn(t *testing.T) {
+	t.Parallel()
+
+	t.Run("store", func(t *testing.T) {
+		testTxn(t, func() store.Store {
+			return store.New()
+		})
+	})
+
+	t.Run("bucket store", func(t *testing.T) {
+		testTxn(t, func() store.Store {
+			return store.NewBucket(10)
+		})
+	})
+}
+
+func testMetadata(t *testing.T, newStore func() store.Store) {
+	t.Run("setting store value with metadata returns metadata", func(t *testing.T) {
+		fn := func(key string, value []byte, contentType string, user map[string]string) bool {
+			s := newStore()
+			metadata := store.Metadata{
+				ContentType: contentType,
+				User:        user,
+			}
+			s.SetWithMetadata(key, value, 0, metadata)
+			entry, ok := s.Select(key)
+			return ok && reflect.DeepEqual(metadata, entry.Metadata)
+		}
+		if err := quick.Check(fn, nil); err != nil {
+			t.Error(err)
+		}
+	})
+
+	t.Run("txn mutation with metadata returns metadata", func(t *testing.T) {
+		s := newStore()
+		metadata := store.Metadata{ContentEncoding: "gzip"}
+		s.Txn(nil, []store.Mutation{
+			{Key: "a", Value: []byte("a"), Metadata: metadata},
+		})
+
+		entry, _ := s.Select("a")
+		if expected, actual := metadata, entry.Metadata; !reflect.DeepEqual(expected, actual) {
+			t.Errorf("expected: %v, actual: %v", expected, actual)
+		}
+	})
+
+	t.Run("compare and swap keeps ttl and metadata", func(t *testing.T) {
+		s := newStore()
+		metadata := store.Metadata{ContentType: "text/plain"}
+		s.SetWithMetadata("a", []byte("a"), time.Minute, metadata)
+		entry, _ := s.Select("a")
+
+		if _, ok := s.CompareAndSwap("a", entry.Version, []byte("b")); !ok {
+			t.Fatalf("expected: %v, actual: %v", true, ok)
+		}
+
+		swapped, _ := s.Select("a")
+		if expected, actual := metadata, swapped.Metadata; !reflect.DeepEqual(expected, actual) {
+			t.Errorf("expected: %v, actual: %v", expected, actual)
+		}
+		if expected, actual := entry.Expires, swapped.Expires; !expected.Equal(actual) {
+			t.Errorf("expected: %v, actual: %v", expected, actual)
+		}
+	})
+
+	t.Run("compare and swap with metadata replaces ttl and metadata", func(t *testing.T) {
+		s := newStore()
+		s.SetWithMetadata("a", []byte("a"), 0, store.Metadata{ContentType: "text/plain"})
+		entry, _ := s.Select("a")
+
+		metadata := store.Metadata{User: map[string]string{"owner": "a"}}
+		if _, ok := store.CompareAndSwapWithMetadata(s, "a", entry.Version, []byte("b"), time.Minute, metadata); !ok {
+			t.Fatalf("expected: %v, actual: %v", true, ok)
+		}
+
+		swapped, _ := s.Select("a")
+		if expected, actual := metadata, swapped.Metadata; !reflect.DeepEqual(expected, actual) {
+			t.Errorf("expected: %v, actual: %v", expected, actual)
+		}
+		if swapped.Expires.IsZero() {
+			t.Errorf("expected: %v, actual: %v", "expires", swapped.Expires)
+		}
+	})
+
+	t.Run("setting store value without metadata clears metadata", func(t *testing.T) {
+		s := newStore()
+		s.SetWithMetadata("a", []byte("a"), 0, store.Metadata{ContentType: "text/plain"})
+		s.Set("a", []byte("b"))
+
+		entry, _ := s.Select("a")
+		if !entry.Metadata.IsZero() {
+			t.Errorf("expected: %v, actual: %v", store.Metadata{}, entry.Metadata)
+		}
+	})
+}
+
+func TestMetadata(t *testing.T) {
+	t.Parallel()
+
+	t.Run("store", func(t *testing.T) {
+		testMetadata(t, func() store.Store {
+			return store.New()
+		})
+	})
+
+	t.Run("bucket store", func(t *testing.T) {
+		testMetadata(t, func() store.Store {
+			return store.NewBucket(10)
+		})
+	})
+}
+
+func testBatch(t *testing.T, newStore func() store.Store) {
+	t.Run("batch matches applying each operation", func(t *testing.T) {
+		fn := func(keys []uint8, types []uint8) bool {
+			ops := make([]store.BatchOp, len(keys))
+			for k, key := range keys {
+				ops[k] = store.BatchOp{
+					Key:   fmt.Sprintf("%d", key%16),
+					Value: []byte{key},
+				}
+				if k < len(types) {
+					ops[k].Type = store.BatchOpType(types[k] % 3)
+				}
+			}
+
+			// Hiding the Batcher makes the operations apply one at a time.
+			s := newStore()
+			actual := store.Batch(s, ops)
+			expected := store.Batch(struct{ store.Store }{newStore()}, ops)
+
+			for k, op := range ops {
+				if expected[k].OK != actual[k].OK {
+					return false
+				}
+				if op.Type == store.BatchGet && !bytes.Equal(expected[k].Entry.Value, actual[k].Entry.Value) {
+					return false
+				}
+			}
+			return true
+		}
+		if err := quick.Check(fn, nil); err != nil {
+			t.Error(err)
+		}
+	})
+
+	t.Run("batch applies operations on a key in order", func(t *testing.T) {
+		s := newStore()
+		metadata := store.Metadata{ContentType: "text/plain"}
+		results := store.Batch(s, []store.BatchOp{
+			{Type: store.BatchGet, Key: "a"},
+			{Type: store.BatchSet, Key: "a", Value: []byte("a"), Metadata: metadata},
+			{Type: store.BatchGet, Key: "a"},
+			{Type: store.BatchDelete, Key: "a"},
+			{Type: store.BatchDelete, Key: "a"},
+			{Type: store.BatchSet, Key: "b", Value: []byte("b"), TTL: time.Minute},
+		})
+
+		if expected, actual := []bool{false, false, true, true, false, false}, []bool{
+			results[0].OK, results[1].OK, results[2].OK, results[3].OK, results[4].OK, results[5].OK,
+		}; !reflect.DeepEqual(expected, actual) {
+			t.Errorf("expected: %v, actual: %v", expected, actual)
+		}
+		if expected, actual := results[1].Entry.Version, results[2].Entry.Version; expected != actual {
+			t.Errorf("expected: %v, actual: %v", expected, actual)
+		}
+		if expected, actual := []byte("a"), results[2].Entry.Value; !bytes.Equal(expected, actual) {
+			t.Errorf("expected: %v, actual: %v", expected, actual)
+		}
+		if expected, actual := metadata, results[2].Entry.Metadata; !reflect.DeepEqual(expected, actual) {
+			t.Errorf("expected: %v, actual: %v", expected, actual)
+		}
+		if _, ok := s.Get("a"); ok {
+			t.Errorf("expected: %v, actual: %v", false, ok)
+		}
+		if entry, _ := s.Select("b"); entry.Expires.IsZero() {
+			t.Errorf("expected: %v, actual: %v", "expires", entry.Expires)
+		}
+	})
+
+	t.Run("batch skips expired values", func(t *testing.T) {
+		s := newStore()
+		s.SetWithTTL("a", []byte("a"), time.Millisecond)
+
+		time.Sleep(time.Millisecond * 5)
+
+		results := store.Batch(s, []store.BatchOp{
+			{Type: store.BatchGet, Key: "a"},
+			{Type: store.BatchDelete, Key: "a"},
+		})
+		if results[0].OK || results[1].OK {
+			t.Errorf("expected: %v, actual: %v", false, true)
+		}
+	})
+}
+
+func TestBatch(t *testing.T) {
+	t.Parallel()
+
+	t.Run("store", func(t *testing.T) {
+		testBatch(t, func() store.Store {
+			return store.New()
+		})
+	})
+
+	t.Run("bucket store", func(t *testing.T) {
+		testBatch(t, func() store.Store {
+			return store.NewBucket(10)
+		})
+	})
+}
+
+func testWatch(t *testing.T, newStore func() store.Store) {
+	next := func(t *testing.T, sub *store.Subscription) store.Event {
+		select {
+		case event, ok := <-sub.Events():
+			if !ok {
+				t.Fatalf("expected: %v, actual: %v", true, ok)
+			}
+			return event
+		case <-time.After(time.Second):
+			t.Fatal("timed out waiting for event")
+		}
+		return store.Event{}
+	}
+
+	t.Run("watch receives puts and deletes", func(t *testing.T) {
+		s := newStore()
+		sub := s.(store.Watcher).Watch(store.WatchOptions{})
+		defer sub.Close()
+
+		s.Set("a", []byte("a"))
+		entry, _ := s.Select("a")
+		s.Delete("a")
+		s.Delete("a")
+
+		if expected, actual := (store.Event{
+			Type:    store.EventPut,
+			Key:     "a",
+			Value:   []byte("a"),
+			Version: entry.Version,
+		}), next(t, sub); !reflect.DeepEqual(expected, actual) {
+			t.Errorf("expected: %v, actual: %v", expected, actual)
+		}
+		if expected, actual := (store.Event{
+			Type:    store.EventDelete,
+			Key:     "a",
+			Version: entry.Version,
+		}), next(t, sub); !reflect.DeepEqual(expected, actual) {
+			t.Errorf("expected: %v, actual: %v", expected, actual)
+		}
+		if expected, actual := 0, len(sub.Events()); expected != actual {
+			t.Errorf("expected: %v, actual: %v", expected, actual)
+		}
+	})
+
+	t.Run("watch filters by prefix and key", func(t *testing.T) {
+		s := newStore()
+		prefix := s.(store.Watcher).Watch(store.WatchOptions{Prefix: "config/"})
+		defer prefix.Close()
+		key := s.(store.Watcher).Watch(store.WatchOptions{Key: "config/b"})
+		defer key.Close()
+
+		s.Set("other", []byte("x"))
+		s.Set("config/a", []byte("a"))
+		s.Set("config/b", []byte("b"))
+
+		if expected, actual := "config/a", next(t, prefix).Key; expected != actual {
+			t.Errorf("expected: %v, actual: %v", expected, actual)
+		}
+		if expected, actual := "config/b", next(t, prefix).Key; expected != actual {
+			t.Errorf("expected: %v, actual: %v", expected, actual)
+		}
+		if expected, actual := "config/b", next(t, key).Key; expected != actual {
+			t.Errorf("expected: %v, actual: %v", expected, actual)
+		}
+	})
+
+	t.Run("watch overflows when full", func(t *testing.T) {
+		s := newStore()
+		sub := s.(store.Watcher).Watch(store.WatchOptions{Buffer: 2})
+		defer sub.Close()
+
+		for i := 0; i < 3; i++ {
+			s.Set(fmt.Sprintf("key-%d", i), []byte("a"))
+		}
+
+		var received int
+		for range sub.Events() {
+			received++
+		}
+		if expected, actual := 2, received; expected != actual {
+			t.Errorf("expected: %v, actual: %v", expected, actual)
+		}
+		if expected, actual := store.ErrOverflow, sub.Err(); expected != actual {
+			t.Errorf("expected: %v, actual: %v", expected, actual)
+		}
+	})
+
+	t.Run("watch stops once closed", func(t *testing.T) {
+		s := newStore()
+		sub := s.(store.Watcher).Watch(store.WatchOptions{})
+		sub.Close()
+
+		s.Set("a", []byte("a"))
+
+		if _, ok := <-sub.Events(); ok {
+			t.Errorf("expected: %v, actual: %v", false, ok)
+		}
+		if err := sub.Err(); err != nil {
+			t.Errorf("expected: %v, actual: %v", nil, err)
+		}
+	})
+}
+
+func TestWatch(t *testing.T) {
+	t.Parallel()
+
+	t.Run("store", func(t *testing.T) {
+		testWatch(t, func() store.Store {
+			return store.New()
+		})
+	})
+
+	t.Run("bucket store", func(t *testing.T) {
+		testWatch(t, func() store.Store {
+			return store.NewBucket(10)
+		})
+	})
+}
+
 // value used to make sure that we don't get compiled away
 var benchResult []byte
 
@@ -138,3 +1189,319 @@ func BenchmarkStore8(b *testing.B)  { benchmarkStore(b, store.NewBucket(8)) }
 func BenchmarkStore16(b *testing.B) { benchmarkStore(b, store.NewBucket(16)) }
 func BenchmarkStore32(b *testing.B) { benchmarkStore(b, store.NewBucket(32)) }
 func BenchmarkStore64(b *testing.B) { benchmarkStore(b, store.NewBucket(64)) }
+
+func TestDurableStore(t *testing.T) {
+	t.Parallel()
+
+	open := func(t *testing.T, dir string, s store.Store) (store.Store, *wal.Log) {
+		log, err := wal.Open(dir, wal.Options{
+			SegmentSize: 1024,
+			Sync:        wal.SyncNever,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		durable, err := store.NewDurable(s, log)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return durable, log
+	}
+
+	t.Run("replaying restores the store", func(t *testing.T) {
+		fn := func(values map[string][]byte, deletes map[string]bool) bool {
+			dir, err := ioutil.TempDir("", "durable")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(dir)
+
+			s, log := open(t, dir, store.New())
+			for k, v := range values {
+				s.Set(k, v)
+				if deletes[k] {
+					s.Delete(k)
+				}
+			}
+			expected, _ := s.Scan(store.ScanOptions{})
+			log.Close()
+
+			s, log = open(t, dir, store.NewBucket(4))
+			defer log.Close()
+
+			actual, _ := s.Scan(store.ScanOptions{})
+			if len(expected) != len(actual) {
+				return false
+			}
+			for k, v := range expected {
+				if v.Key != actual[k].Key || v.Version != actual[k].Version ||
+					!bytes.Equal(v.Value, actual[k].Value) || !v.Modified.Equal(actual[k].Modified) {
+					return false
+				}
+			}
+			return true
+		}
+		if err := quick.Check(fn, nil); err != nil {
+			t.Error(err)
+		}
+	})
+
+	t.Run("replaying continues the versions", func(t *testing.T) {
+		dir, err := ioutil.TempDir("", "durable")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(dir)
+
+		s, log := open(t, dir, store.New())
+		s.Set("a", []byte("a"))
+		version, _ := s.CompareAndSwap("a", 1, []byte("b"))
+		s.SetWithTTL("b", []byte("b"), time.Millisecond)
+		s.SetWithTTL("c", []byte("c"), time.Minute)
+		log.Close()
+
+		time.Sleep(time.Millisecond * 5)
+
+		s, log = open(t, dir, store.New())
+		defer log.Close()
+
+		entry, ok := s.Select("a")
+		if !ok {
+			t.Fatalf("expected: %v, actual: %v", true, ok)
+		}
+		if expected, actual := version, entry.Version; expected != actual {
+			t.Errorf("expected: %v, actual: %v", expected, actual)
+		}
+		if expected, actual := []byte("b"), entry.Value; !reflect.DeepEqual(expected, actual) {
+			t.Errorf("expected: %v, actual: %v", expected, actual)
+		}
+		if _, ok := s.Get("b"); ok {
+			t.Errorf("expected: %v, actual: %v", false, ok)
+		}
+		if _, ok := s.Get("c"); !ok {
+			t.Errorf("expected: %v, actual: %v", true, ok)
+		}
+		if _, ok := s.CompareAndSwap("a", version, []byte("c")); !ok {
+			t.Errorf("expected: %v, actual: %v", true, ok)
+		}
+	})
+
+	t.Run("replaying restores txns", func(t *testing.T) {
+		dir, err := ioutil.TempDir("", "durable")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(dir)
+
+		s, log := open(t, dir, store.NewBucket(4))
+		s.Set("z", []byte("z"))
+		s.Txn([]store.Condition{
+			{Key: "z", Check: store.CheckMissing},
+		}, []store.Mutation{
+			{Key: "z", Operation: store.OperationDelete},
+		})
+		s.Txn(nil, []store.Mutation{
+			{Key: "x", Value: []byte("x")},
+			{Key: "y", Value: []byte("y")},
+			{Key: "x", Operation: store.OperationDelete},
+		})
+		expected, _ := s.Scan(store.ScanOptions{})
+		log.Close()
+
+		s, log = open(t, dir, store.New())
+		defer log.Close()
+
+		actual, _ := s.Scan(store.ScanOptions{})
+		if !reflect.DeepEqual(expected, actual) {
+			t.Errorf("expected: %v, actual: %v", expected, actual)
+		}
+	})
+
+	t.Run("replaying restores metadata", func(t *testing.T) {
+		dir, err := ioutil.TempDir("", "durable")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(dir)
+
+		s, log := open(t, dir, store.NewBucket(4))
+		s.SetWithMetadata("a", []byte("a"), 0, store.Metadata{
+			ContentType:     "application/json",
+			ContentEncoding: "gzip",
+			User:            map[string]string{"owner": "a", "tag": "b"},
+		})
+		s.Txn(nil, []store.Mutation{
+			{Key: "b", Value: []byte("b"), Metadata: store.Metadata{ContentType: "text/plain"}},
+			{Key: "c", Value: []byte("c")},
+		})
+		if err := s.(store.Snapshotter).Snapshot(); err != nil {
+			t.Fatal(err)
+		}
+		s.SetWithMetadata("d", []byte("d"), time.Minute, store.Metadata{
+			User: map[string]string{"owner": "d"},
+		})
+		expected, _ := s.Scan(store.ScanOptions{})
+		log.Close()
+
+		s, log = open(t, dir, store.New())
+		defer log.Close()
+
+		actual, _ := s.Scan(store.ScanOptions{})
+		if !reflect.DeepEqual(expected, actual) {
+			t.Errorf("expected: %v, actual: %v", expected, actual)
+		}
+	})
+
+	t.Run("replaying restores batches", func(t *testing.T) {
+		dir, err := ioutil.TempDir("", "durable")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(dir)
+
+		s, log := open(t, dir, store.New())
+		s.Set("z", []byte("z"))
+		store.Batch(s, []store.BatchOp{
+			{Type: store.BatchSet, Key: "x", Value: []byte("x")},
+			{Type: store.BatchGet, Key: "z"},
+			{Type: store.BatchDelete, Key: "z"},
+			{Type: store.BatchSet, Key: "y", Value: []byte("y"), Metadata: store.Metadata{ContentType: "text/plain"}},
+			{Type: store.BatchSet, Key: "x", Value: []byte("w")},
+		})
+		expected, _ := s.Scan(store.ScanOptions{})
+		log.Close()
+
+		s, log = open(t, dir, store.NewBucket(4))
+		defer log.Close()
+
+		actual, _ := s.Scan(store.ScanOptions{})
+		if !reflect.DeepEqual(expected, actual) {
+			t.Errorf("expected: %v, actual: %v", expected, actual)
+		}
+	})
+
+	t.Run("replaying older logs", func(t *testing.T) {
+		dir, err := ioutil.TempDir("", "durable")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(dir)
+
+		log, err := wal.Open(dir, wal.Options{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		// A set of "a" to "b" at version 3, from before entries recorded when
+		// they were modified.
+		if _, err := log.Append([]byte{1, 1, 1, 'a', 3, 0, 1, 'b'}); err != nil {
+			t.Fatal(err)
+		}
+		log.Close()
+
+		s, log := open(t, dir, store.New())
+		defer log.Close()
+
+		entry, ok := s.Select("a")
+		if !ok {
+			t.Fatalf("expected: %v, actual: %v", true, ok)
+		}
+		if expected, actual := (store.Entry{Key: "a", Value: []byte("b"), Version: 3}), entry; !reflect.DeepEqual(expected, actual) {
+			t.Errorf("expected: %v, actual: %v", expected, actual)
+		}
+	})
+
+	t.Run("snapshot restores the store", func(t *testing.T) {
+		dir, err := ioutil.TempDir("", "durable")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(dir)
+
+		s, log := open(t, dir, store.NewBucket(4))
+		for i := 0; i < 100; i++ {
+			s.Set(fmt.Sprintf("key-%d", i), []byte("a"))
+		}
+		for i := 0; i < 10; i++ {
+			s.Delete(fmt.Sprintf("key-%d", i))
+		}
+		deleted, _ := s.Select("key-99")
+		s.Delete("key-99")
+
+		// Write whilst taking a snapshot, the snapshot shouldn't be affected by
+		// the writes and the writes should still be replayed from the log.
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			for i := 0; i < 100; i++ {
+				s.Set(fmt.Sprintf("key-%d", i), []byte("b"))
+			}
+		}()
+		if err := s.(store.Snapshotter).Snapshot(); err != nil {
+			t.Fatal(err)
+		}
+		<-done
+
+		s.Delete("key-50")
+		expected, _ := s.Scan(store.ScanOptions{})
+		log.Close()
+
+		s, log = open(t, dir, store.New())
+		defer log.Close()
+
+		actual, _ := s.Scan(store.ScanOptions{})
+		if !reflect.DeepEqual(expected, actual) {
+			t.Errorf("expected: %v, actual: %v", expected, actual)
+		}
+
+		// Versions of deleted keys should never be handed out again.
+		version, _ := s.CompareAndSwap("key-99", 0, []byte("c"))
+		if version <= deleted.Version {
+			t.Errorf("expected: %v > %v", version, deleted.Version)
+		}
+	})
+	t.Run("failing to append rolls back the write", func(t *testing.T) {
+		dir, err := ioutil.TempDir("", "durable")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(dir)
+
+		s, log := open(t, dir, store.NewBucket(4))
+		s.Set("a", []byte("a"))
+		expected, _ := s.Scan(store.ScanOptions{})
+		if err := store.Err(s); err != nil {
+			t.Fatalf("expected: %v, actual: %v", nil, err)
+		}
+
+		// Closing the log means that every append fails.
+		log.Close()
+
+		if ok := s.Set("a", []byte("b")); ok {
+			t.Errorf("expected: %v, actual: %v", false, ok)
+		}
+		if ok := s.Set("b", []byte("b")); ok {
+			t.Errorf("expected: %v, actual: %v", false, ok)
+		}
+		if ok := s.Delete("a"); ok {
+			t.Errorf("expected: %v, actual: %v", false, ok)
+		}
+		if versions, ok := s.Txn(nil, []store.Mutation{
+			{Key: "c", Value: []byte("c")},
+		}); ok || len(versions) != 0 {
+			t.Errorf("expected: %v, actual: %v", false, ok)
+		}
+		if results := store.Batch(s, []store.BatchOp{
+			{Type: store.BatchSet, Key: "d", Value: []byte("d")},
+		}); results[0].OK {
+			t.Errorf("expected: %v, actual: %v", false, results[0].OK)
+		}
+		if err := store.Err(s); err == nil {
+			t.Errorf("expected: error, actual: %v", err)
+		}
+
+		actual, _ := s.Scan(store.ScanOptions{})
+		if !reflect.DeepEqual(expected, actual) {
+			t.Errorf("expected: %v, actual: %v", expected, actual)
+		}
+	})
+}
@@ -0,0 +1,65 @@
+package store
+
+import "time"
+
+// sweeper defines a store that can remove the values that have expired.
+type sweeper interface {
+	sweep(now time.Time) int
+}
+
+// Sweeper periodically reclaims the expired values from a store. Expired values
+// are never returned from a store, but without the sweeper they're only
+// reclaimed once they're accessed again.
+type Sweeper struct {
+	store    Store
+	interval time.Duration
+	stop     chan chan struct{}
+}
+
+// NewSweeper creates a Sweeper with the correct dependencies
+func NewSweeper(store Store, interval time.Duration) *Sweeper {
+	return &Sweeper{
+		store:    store,
+		interval: interval,
+		stop:     make(chan chan struct{}),
+	}
+}
+
+// Run the sweeper until it's stopped. A sweeper without a positive interval
+// never sweeps, it only waits to be stopped.
+func (s *Sweeper) Run() error {
+	if s.interval <= 0 {
+		q := <-s.stop
+		close(q)
+		return nil
+	}
+
+	step := time.NewTicker(s.interval)
+	defer step.Stop()
+
+	for {
+		select {
+		case <-step.C:
+			s.Sweep()
+		case q := <-s.stop:
+			close(q)
+			return nil
+		}
+	}
+}
+
+// Sweep removes the expired values from the store, returning the number of
+// values that where removed.
+func (s *Sweeper) Sweep() int {
+	if sw, ok := s.store.(sweeper); ok {
+		return sw.sweep(time.Now())
+	}
+	return 0
+}
+
+// Stop the sweeper
+func (s *Sweeper) Stop() {
+	q := make(chan struct{})
+	s.stop <- q
+	<-q
+}
@@ -0,0 +1,198 @@
+package store
+
+import (
+	"sort"
+	"time"
+)
+
+// Check defines what a condition of a transaction checks about a key.
+type Check int
+
+const (
+	// CheckExists checks that the key exists.
+	CheckExists Check = iota
+	// CheckMissing checks that the key doesn't exist.
+	CheckMissing
+	// CheckVersion checks that the current version of the key matches the
+	// version of the condition. A version of zero expects that the key doesn't
+	// exist.
+	CheckVersion
+)
+
+// Condition is a check that has to hold for a transaction to be applied.
+type Condition struct {
+	Key     string
+	Check   Check
+	Version uint64
+}
+
+// holds returns true if the condition holds for the current version of the
+// key, a missing key has a version of zero.
+func (c Condition) holds(version uint64) bool {
+	switch c.Check {
+	case CheckExists:
+		return version > 0
+	case CheckMissing:
+		return version == 0
+	case CheckVersion:
+		return version == c.Version
+	default:
+		return false
+	}
+}
+
+// Operation defines how a mutation of a transaction changes a key.
+type Operation int
+
+const (
+	// OperationSet stores the value for the key.
+	OperationSet Operation = iota
+	// OperationDelete removes the value for the key.
+	OperationDelete
+)
+
+// Mutation is a change to a key that is applied as part of a transaction.
+// A ttl of zero or less means that the value will never expire. The metadata
+// is stored along with the value.
+type Mutation struct {
+	Key       string
+	Operation Operation
+	Value     []byte
+	TTL       time.Duration
+	Metadata  Metadata
+}
+
+func (m *memory) Txn(conditions []Condition, mutations []Mutation) ([]uint64, bool) {
+	// Lock every bucket that the transaction touches, always in the order of
+	// the buckets, so that concurrent transactions can never deadlock.
+	seen := make(map[uint]struct{})
+	for _, c := range conditions {
+		seen[m.index(c.Key)] = struct{}{}
+	}
+	for _, mutation := range mutations {
+		seen[m.index(mutation.Key)] = struct{}{}
+	}
+	indexes := make([]int, 0, len(seen))
+	for index := range seen {
+		indexes = append(indexes, int(index))
+	}
+	sort.Ints(indexes)
+
+	for _, index := range indexes {
+		m.buckets[index].mutex.Lock()
+	}
+	defer func() {
+		for k := len(indexes) - 1; k >= 0; k-- {
+			m.buckets[indexes[k]].mutex.Unlock()
+		}
+	}()
+
+	return txn(m.bucket, conditions, mutations)
+}
+
+func (b *bucket) Txn(conditions []Condition, mutations []Mutation) ([]uint64, bool) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	return txn(func(string) *bucket {
+		return b
+	}, conditions, mutations)
+}
+
+// txn checks all the conditions and only if every condition holds, applies all
+// the mutations in order. On a bounded bucket, room is made for every value
+// that's set before any of the mutations are applied, so that a mutation never
+// evicts a value set by the same transaction. If the values can't all fit, then
+// none of the mutations are applied.
+// Returns true if the mutations were applied along with the new version of each
+// mutation, a deleted key has a version of zero. Otherwise the current version
+// of the key of each condition is returned.
+// txn expects the caller to hold the write lock of every bucket.
+func txn(bucketOf func(string) *bucket, conditions []Condition, mutations []Mutation) ([]uint64, bool) {
+	now := time.Now()
+
+	var (
+		ok       = true
+		versions = make([]uint64, len(conditions))
+	)
+	for k, c := range conditions {
+		// A missing value has a version of zero.
+		e, _ := bucketOf(c.Key).lookup(c.Key, now.UnixNano())
+		versions[k] = e.version
+		if !c.holds(e.version) {
+			ok = false
+		}
+	}
+	if !ok {
+		return versions, false
+	}
+
+	// Work out what every bucket holds for the keys of the transaction, once
+	// the mutations are applied, so that room can be made for all of them.
+	footprints := make(map[*bucket]footprint)
+	for _, mutation := range mutations {
+		b := bucketOf(mutation.Key)
+		f, ok := footprints[b]
+		if !ok {
+			f = newFootprint()
+			footprints[b] = f
+		}
+		f.keys[mutation.Key] = struct{}{}
+		switch mutation.Operation {
+		case OperationSet:
+			f.sizes[mutation.Key] = usage(mutation.Key, entry{
+				value:    mutation.Value,
+				metadata: mutation.Metadata,
+			})
+		case OperationDelete:
+			delete(f.sizes, mutation.Key)
+		}
+	}
+	for b, f := range footprints {
+		if !b.fits(f) {
+			return versions, false
+		}
+	}
+	for b, f := range footprints {
+		b.reserveAll(f)
+	}
+
+	versions = make([]uint64, len(mutations))
+	for k, mutation := range mutations {
+		b := bucketOf(mutation.Key)
+		switch mutation.Operation {
+		case OperationSet:
+			var expires int64
+			if mutation.TTL > 0 {
+				expires = now.Add(mutation.TTL).UnixNano()
+			}
+			b.clock++
+			b.place(mutation.Key, entry{
+				value:    mutation.Value,
+				version:  b.clock,
+				expires:  expires,
+				modified: now.UnixNano(),
+				metadata: mutation.Metadata,
+			})
+			versions[k] = b.clock
+		case OperationDelete:
+			b.remove(mutation.Key)
+		}
+	}
+	return versions, true
+}
+
+// CompareAndSwapWithMetadata is CompareAndSwap, but the value is stored along
+// with the ttl and metadata, replacing those of the existing value. A ttl of
+// zero or less means that the value will never expire. It's a transaction of a
+// single condition and mutation, so it works with any Store.
+// Returns true if the value was swapped along with the new version, otherwise
+// the current version is returned.
+func CompareAndSwapWithMetadata(store Store, key string, version uint64, value []byte, ttl time.Duration, metadata Metadata) (uint64, bool) {
+	versions, ok := store.Txn([]Condition{
+		{Key: key, Check: CheckVersion, Version: version},
+	}, []Mutation{
+		{Key: key, Value: value, TTL: ttl, Metadata: metadata},
+	})
+	return versions[0], ok
+}
@@ -0,0 +1,199 @@
+package store
+
+import (
+	"errors"
+	"strings"
+	"sync"
+)
+
+// EventType defines what change happened to a key.
+type EventType int
+
+const (
+	// EventPut is emitted when a value is stored for a key.
+	EventPut EventType = iota
+	// EventDelete is emitted when a value is removed from a key, this includes
+	// values that have expired or been evicted.
+	EventDelete
+)
+
+func (t EventType) String() string {
+	switch t {
+	case EventPut:
+		return "put"
+	case EventDelete:
+		return "delete"
+	default:
+		return "unknown"
+	}
+}
+
+// Event represents a change to a key with in the store. For a put the value is
+// the new value along with the new version, for a delete the value is empty and
+// the version is the version of the value that was removed.
+type Event struct {
+	Type    EventType
+	Key     string
+	Value   []byte
+	Version uint64
+}
+
+// DefaultWatchBuffer is the amount of events that can be buffered for a
+// subscription when no buffer is supplied.
+const DefaultWatchBuffer = 128
+
+// ErrOverflow is the error of a subscription that couldn't keep up with the
+// events, so events were dropped.
+var ErrOverflow = errors.New("subscription overflowed")
+
+// WatchOptions defines which events are sent to a subscription. Either a key or
+// a prefix can be supplied, if neither are supplied then every event is sent.
+type WatchOptions struct {
+	// Key that the events must match exactly.
+	Key string
+	// Prefix that the keys of the events must start with.
+	Prefix string
+	// Buffer is the amount of events that can be buffered for the subscription
+	// before it overflows, zero uses the DefaultWatchBuffer.
+	Buffer int
+}
+
+// matches returns true if the key matches the options.
+func (o WatchOptions) matches(key string) bool {
+	if o.Key != "" {
+		return key == o.Key
+	}
+	return strings.HasPrefix(key, o.Prefix)
+}
+
+// Watcher defines a store that can notify subscribers of changes to the store.
+type Watcher interface {
+
+	// Watch subscribes to the changes with in the store that match the
+	// options. The subscription should be closed once it's no longer needed.
+	Watch(options WatchOptions) *Subscription
+}
+
+// Subscription receives the events of a store. Events are never blocked on a
+// slow subscription, instead once the buffer of the subscription is full the
+// subscription overflows: the events channel is closed and Err returns
+// ErrOverflow. The subscriber is then expected to read the current state of
+// the store again and resubscribe.
+type Subscription struct {
+	options WatchOptions
+	hub     *hub
+
+	mutex  sync.Mutex
+	events chan Event
+	closed bool
+	err    error
+}
+
+// Events returns the channel of events, the channel is closed when the
+// subscription is closed or overflows.
+func (s *Subscription) Events() <-chan Event {
+	return s.events
+}
+
+// Err returns ErrOverflow if the subscription overflowed, otherwise nil.
+func (s *Subscription) Err() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.err
+}
+
+// Close stops the subscription from receiving any more events.
+func (s *Subscription) Close() {
+	s.hub.unsubscribe(s)
+
+	s.mutex.Lock()
+	s.stop(nil)
+	s.mutex.Unlock()
+}
+
+// send the event without ever blocking.
+func (s *Subscription) send(e Event) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.closed {
+		return
+	}
+	select {
+	case s.events <- e:
+	default:
+		s.stop(ErrOverflow)
+	}
+}
+
+// stop expects the caller to hold the lock.
+func (s *Subscription) stop(err error) {
+	if s.closed {
+		return
+	}
+	s.closed = true
+	s.err = err
+	close(s.events)
+}
+
+// hub fans out the events of a store to the subscriptions. Events are
+// published whilst the write lock of the bucket is held, so events for the same
+// key are always published in the order they happened.
+type hub struct {
+	mutex         sync.RWMutex
+	subscriptions map[*Subscription]struct{}
+}
+
+func newHub() *hub {
+	return &hub{
+		subscriptions: make(map[*Subscription]struct{}),
+	}
+}
+
+func (h *hub) subscribe(options WatchOptions) *Subscription {
+	buffer := options.Buffer
+	if buffer <= 0 {
+		buffer = DefaultWatchBuffer
+	}
+
+	s := &Subscription{
+		options: options,
+		hub:     h,
+		events:  make(chan Event, buffer),
+	}
+
+	h.mutex.Lock()
+	h.subscriptions[s] = struct{}{}
+	h.mutex.Unlock()
+	return s
+}
+
+func (h *hub) unsubscribe(s *Subscription) {
+	h.mutex.Lock()
+	delete(h.subscriptions, s)
+	h.mutex.Unlock()
+}
+
+func (h *hub) publish(e Event) {
+	h.mutex.RLock()
+	defer h.mutex.RUnlock()
+
+	for s := range h.subscriptions {
+		if s.options.matches(e.Key) {
+			s.send(e)
+		}
+	}
+}
+
+func (m *memory) Watch(options WatchOptions) *Subscription {
+	// Every bucket shares the same hub.
+	return m.buckets[0].hub.subscribe(options)
+}
+
+func (b *bucket) Watch(options WatchOptions) *Subscription {
+	return b.hub.subscribe(options)
+}
+
+func (d *durable) Watch(options WatchOptions) *Subscription {
+	return d.watcher.Watch(options)
+}
@@ -0,0 +1,99 @@
+package tcp
+
+import (
+	"bufio"
+	"net"
+
+	keyvalNet "github.com/SimonRichardson/keyval/pkg/net"
+)
+
+// handleBinaryConn reads the requests of the binary protocol, the requests are
+// turned into queries so that they're handled the same as any other query.
+func (s *Server) handleBinaryConn(conn net.Conn) {
+	defer conn.Close()
+
+	var (
+		reader = bufio.NewReader(conn)
+		writer = bufio.NewWriter(conn)
+		dec    = keyvalNet.NewBinaryDecoder(reader, s.MaxValueSize)
+		enc    = keyvalNet.NewBinaryEncoder(writer)
+	)
+	for {
+		// Wait for the next request to start arriving, before expecting the
+		// whole of the request to be read in time.
+		conn.SetReadDeadline(deadline(s.IdleTimeout))
+		if _, err := reader.Peek(1); err != nil {
+			return
+		}
+		conn.SetReadDeadline(deadline(s.ReadTimeout))
+		conn.SetWriteDeadline(deadline(s.WriteTimeout))
+
+		var m keyvalNet.BinaryMessage
+		if err := dec.Decode(&m); err != nil {
+			// The stream can't be recovered, so send the error and give up.
+			// If the connection failed there's nobody to send it to.
+			status := keyvalNet.ServerError
+			switch err {
+			case keyvalNet.ErrBinaryTooLarge:
+				status = keyvalNet.BadRequest
+			case keyvalNet.ErrBinaryVersion, keyvalNet.ErrBinaryMalformed:
+			default:
+				return
+			}
+			write(binaryEncoder{enc: enc, op: m.Opcode, id: m.ID}, status)
+			writer.Flush()
+			return
+		}
+
+		e := binaryEncoder{
+			enc:   enc,
+			op:    m.Opcode,
+			id:    m.ID,
+			quiet: m.Flags&keyvalNet.FlagQuiet != 0,
+		}
+		if method, ok := m.Opcode.Method(); ok {
+			s.handleQuery(e, keyvalNet.Query{
+				ID:      m.ID,
+				Method:  method,
+				Key:     m.Key,
+				Value:   m.Value,
+				TTL:     m.TTL,
+				Version: m.Version,
+			})
+		} else {
+			write(e, keyvalNet.NotFound)
+		}
+
+		// Only flush once there are no more pipelined requests waiting, so
+		// the responses of pipelined requests are written together.
+		if reader.Buffered() > 0 {
+			continue
+		}
+		conn.SetWriteDeadline(deadline(s.WriteTimeout))
+		if err := writer.Flush(); err != nil {
+			return
+		}
+	}
+}
+
+// binaryEncoder writes the result as a response of the binary protocol, with
+// the opcode and ID of the request.
+type binaryEncoder struct {
+	enc   *keyvalNet.BinaryEncoder
+	op    keyvalNet.Opcode
+	id    uint64
+	quiet bool
+}
+
+func (e binaryEncoder) Encode(result keyvalNet.Result) error {
+	if e.quiet && (result.Status == keyvalNet.OK || result.Status == keyvalNet.Created) {
+		return nil
+	}
+	return e.enc.Encode(keyvalNet.BinaryMessage{
+		Opcode:  e.op,
+		Status:  result.Status,
+		ID:      e.id,
+		Version: result.Version,
+		Value:   result.Value,
+	})
+}
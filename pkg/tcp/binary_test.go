@@ -0,0 +1,232 @@
+package tcp
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"net"
+	"testing"
+	"testing/quick"
+	"time"
+
+	keyvalNet "github.com/SimonRichardson/keyval/pkg/net"
+	keyvalStore "github.com/SimonRichardson/keyval/pkg/store"
+	"github.com/go-kit/kit/log"
+)
+
+func TestBinaryMode(t *testing.T) {
+	t.Parallel()
+
+	port := 9029
+
+	server := NewServer(keyvalStore.New(), log.NewNopLogger())
+	server.Mode = ModeBinary
+	server.MaxValueSize = 1024
+	listener := setupServer(server, port)
+	defer listener.Close()
+
+	t.Run("set then get", func(t *testing.T) {
+		conn, enc, dec := dialBinary(t, port)
+		defer conn.Close()
+
+		fn := func(a []byte, value []byte) bool {
+			if len(value) > 1024 {
+				value = value[:1024]
+			}
+			key := buildKey(a)
+
+			enc.Encode(keyvalNet.BinaryMessage{Opcode: keyvalNet.OpSet, ID: 1, Key: key, Value: value})
+			enc.Encode(keyvalNet.BinaryMessage{Opcode: keyvalNet.OpGet, ID: 2, Key: key})
+
+			set, get := readBinary(t, dec), readBinary(t, dec)
+			return set.ID == 1 &&
+				set.Opcode == keyvalNet.OpSet &&
+				(set.Status == keyvalNet.OK || set.Status == keyvalNet.Created) &&
+				get.ID == 2 &&
+				get.Status == keyvalNet.OK &&
+				get.Version > 0 &&
+				bytes.Equal(get.Value, value)
+		}
+		if err := quick.Check(fn, nil); err != nil {
+			t.Error(err)
+		}
+	})
+
+	t.Run("statuses", func(t *testing.T) {
+		conn, enc, dec := dialBinary(t, port)
+		defer conn.Close()
+
+		for _, testcase := range []struct {
+			request  keyvalNet.BinaryMessage
+			expected keyvalNet.Status
+		}{
+			{keyvalNet.BinaryMessage{Opcode: keyvalNet.OpGet, Key: "missing"}, keyvalNet.NotFound},
+			{keyvalNet.BinaryMessage{Opcode: keyvalNet.OpGet}, keyvalNet.BadRequest},
+			{keyvalNet.BinaryMessage{Opcode: keyvalNet.OpDelete, Key: "missing"}, keyvalNet.NotFound},
+			{keyvalNet.BinaryMessage{Opcode: keyvalNet.OpCompareAndSwap, Key: "cas", Value: []byte("a")}, keyvalNet.Created},
+			{keyvalNet.BinaryMessage{Opcode: keyvalNet.OpCompareAndSwap, Key: "cas", Value: []byte("b")}, keyvalNet.Conflict},
+			{keyvalNet.BinaryMessage{Opcode: keyvalNet.OpCompareAndDelete, Key: "cas", Version: 1 << 62}, keyvalNet.Conflict},
+			{keyvalNet.BinaryMessage{Opcode: 0xff, Key: "a"}, keyvalNet.NotFound},
+		} {
+			testcase.request.ID = 7
+			enc.Encode(testcase.request)
+			res := readBinary(t, dec)
+			if expected, actual := testcase.expected, res.Status; expected != actual {
+				t.Errorf("%s: expected: %v, actual: %v", testcase.request.Opcode, expected, actual)
+			}
+			if expected, actual := testcase.request.Opcode, res.Opcode; expected != actual {
+				t.Errorf("expected: %v, actual: %v", expected, actual)
+			}
+		}
+	})
+
+	t.Run("quiet", func(t *testing.T) {
+		conn, enc, dec := dialBinary(t, port)
+		defer conn.Close()
+
+		// Only the failure and the final get have a response.
+		enc.Encode(keyvalNet.BinaryMessage{Opcode: keyvalNet.OpSet, Flags: keyvalNet.FlagQuiet, ID: 1, Key: "quiet", Value: []byte("a")})
+		enc.Encode(keyvalNet.BinaryMessage{Opcode: keyvalNet.OpDelete, Flags: keyvalNet.FlagQuiet, ID: 2, Key: "quiet-missing"})
+		enc.Encode(keyvalNet.BinaryMessage{Opcode: keyvalNet.OpGet, ID: 3, Key: "quiet"})
+
+		for _, expected := range []keyvalNet.BinaryMessage{
+			{Opcode: keyvalNet.OpDelete, Status: keyvalNet.NotFound, ID: 2},
+			{Opcode: keyvalNet.OpGet, Status: keyvalNet.OK, ID: 3, Value: []byte("a")},
+		} {
+			actual := readBinary(t, dec)
+			if expected.ID != actual.ID || expected.Status != actual.Status || !bytes.Equal(expected.Value, actual.Value) {
+				t.Errorf("expected: %v, actual: %v", expected, actual)
+			}
+		}
+	})
+
+	t.Run("ttl", func(t *testing.T) {
+		conn, enc, dec := dialBinary(t, port)
+		defer conn.Close()
+
+		enc.Encode(keyvalNet.BinaryMessage{Opcode: keyvalNet.OpSet, ID: 1, Key: "ttl", Value: []byte("a"), TTL: time.Millisecond})
+		readBinary(t, dec)
+		time.Sleep(time.Millisecond * 5)
+
+		enc.Encode(keyvalNet.BinaryMessage{Opcode: keyvalNet.OpGet, ID: 2, Key: "ttl"})
+		if expected, actual := keyvalNet.NotFound, readBinary(t, dec).Status; expected != actual {
+			t.Errorf("expected: %v, actual: %v", expected, actual)
+		}
+	})
+
+	t.Run("value too large", func(t *testing.T) {
+		conn, enc, dec := dialBinary(t, port)
+		defer conn.Close()
+
+		enc.Encode(keyvalNet.BinaryMessage{Opcode: keyvalNet.OpSet, ID: 9, Key: "large", Value: make([]byte, 1025)})
+		res := readBinary(t, dec)
+		if res.ID != 9 || res.Status != keyvalNet.BadRequest {
+			t.Errorf("expected: %v, actual: %v", keyvalNet.BadRequest, res)
+		}
+		if err := dec.Decode(&res); err != io.EOF {
+			t.Errorf("expected: %v, actual: %v", io.EOF, err)
+		}
+	})
+
+	t.Run("unsupported version", func(t *testing.T) {
+		conn, _, dec := dialBinary(t, port)
+		defer conn.Close()
+
+		b, _ := keyvalNet.AppendBinaryHeader(nil, keyvalNet.BinaryMessage{Opcode: keyvalNet.OpGet, ID: 9})
+		b[0] = keyvalNet.BinaryVersion + 1
+		conn.Write(b)
+
+		res := readBinary(t, dec)
+		if res.ID != 0 || res.Status != keyvalNet.ServerError {
+			t.Errorf("expected: %v, actual: %v", keyvalNet.ServerError, res)
+		}
+		if err := dec.Decode(&res); err != io.EOF {
+			t.Errorf("expected: %v, actual: %v", io.EOF, err)
+		}
+	})
+}
+
+// BenchmarkPipelinedBinaryConn sends many requests over the binary protocol
+// before reading any of the responses, to compare with BenchmarkPipelinedConn.
+func BenchmarkPipelinedBinaryConn(b *testing.B) {
+	server := NewServer(keyvalStore.New(), log.NewNopLogger())
+	server.Mode = ModeBinary
+	listener := setupServer(server, 9061)
+	defer listener.Close()
+
+	conn, err := net.Dial("tcp", "0.0.0.0:9061")
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer conn.Close()
+
+	done := make(chan error)
+	go func() {
+		dec := keyvalNet.NewBinaryDecoder(bufio.NewReader(conn), keyvalNet.DefaultMaxBinaryValueSize)
+		for i := 0; i < b.N; i++ {
+			var res keyvalNet.BinaryMessage
+			if err := dec.Decode(&res); err != nil {
+				done <- err
+				return
+			}
+		}
+		done <- nil
+	}()
+
+	b.ResetTimer()
+	begin := time.Now()
+	writer := bufio.NewWriter(conn)
+	enc := keyvalNet.NewBinaryEncoder(writer)
+	for i := 0; i < b.N; i++ {
+		if err := enc.Encode(keyvalNet.BinaryMessage{
+			Opcode: keyvalNet.OpSet,
+			ID:     uint64(i + 1),
+			Key:    "key",
+			Value:  []byte("value"),
+		}); err != nil {
+			b.Fatal(err)
+		}
+	}
+	if err := writer.Flush(); err != nil {
+		b.Fatal(err)
+	}
+	if err := <-done; err != nil {
+		b.Fatal(err)
+	}
+	b.ReportMetric(float64(b.N)/time.Since(begin).Seconds(), "ops/s")
+}
+
+// binaryWriter flushes every message straight away.
+type binaryWriter struct {
+	enc    *keyvalNet.BinaryEncoder
+	writer *bufio.Writer
+}
+
+func (w binaryWriter) Encode(m keyvalNet.BinaryMessage) {
+	w.enc.Encode(m)
+	w.writer.Flush()
+}
+
+func dialBinary(t *testing.T, port int) (net.Conn, binaryWriter, *keyvalNet.BinaryDecoder) {
+	conn, err := net.Dial("tcp", fmt.Sprintf("0.0.0.0:%d", port))
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.SetDeadline(time.Now().Add(time.Second * 10))
+
+	writer := bufio.NewWriter(conn)
+	enc := binaryWriter{
+		enc:    keyvalNet.NewBinaryEncoder(writer),
+		writer: writer,
+	}
+	return conn, enc, keyvalNet.NewBinaryDecoder(conn, keyvalNet.DefaultMaxBinaryValueSize)
+}
+
+func readBinary(t *testing.T, dec *keyvalNet.BinaryDecoder) keyvalNet.BinaryMessage {
+	var res keyvalNet.BinaryMessage
+	if err := dec.Decode(&res); err != nil {
+		t.Fatal(err)
+	}
+	return res
+}
@@ -1,27 +1,96 @@
 package tcp
 
 import (
-	"encoding/gob"
+	"bufio"
 	"io"
+	"io/ioutil"
 	"net"
+	"strings"
 	"time"
 
 	keyvalNet "github.com/SimonRichardson/keyval/pkg/net"
 	"github.com/SimonRichardson/keyval/pkg/store"
 	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/pkg/errors"
 )
 
+const (
+	// DefaultIdleTimeout is how long a connection can wait for the next query
+	// before the connection is closed.
+	DefaultIdleTimeout = 5 * time.Minute
+	// DefaultReadTimeout is how long reading a query can take, once the query
+	// has started to arrive.
+	DefaultReadTimeout = 10 * time.Second
+	// DefaultWriteTimeout is how long writing the results can take.
+	DefaultWriteTimeout = 10 * time.Second
+)
+
+// Mode is the protocol that the server speaks
+type Mode int
+
+const (
+	// ModeCodec reads queries and writes results with one of the codecs of
+	// keyvalNet, picked by the handshake of the connection.
+	ModeCodec Mode = iota
+	// ModeBinary reads and writes messages of the binary protocol (see
+	// keyvalNet.BinaryMessage).
+	ModeBinary
+)
+
+func (m Mode) String() string {
+	switch m {
+	case ModeBinary:
+		return "binary"
+	default:
+		return "codec"
+	}
+}
+
+// ParseMode returns the Mode for the name.
+func ParseMode(name string) (Mode, error) {
+	for _, m := range []Mode{ModeCodec, ModeBinary} {
+		if strings.ToLower(name) == m.String() {
+			return m, nil
+		}
+	}
+	return 0, errors.Errorf("%s: unsupported mode", name)
+}
+
 // Server represents a way to interact with the underlying key/val store over tcp
+// Connections are long lived, queries are read one after another from the
+// same stream and the results are written back in the same order. Clients
+// can pipeline queries, by sending many queries before reading the results,
+// the ID of each query is echoed in the result so they can be matched up.
+// A timeout of zero means that there is no timeout.
 type Server struct {
 	store  store.Store
 	logger log.Logger
+
+	IdleTimeout  time.Duration
+	ReadTimeout  time.Duration
+	WriteTimeout time.Duration
+
+	// Mode is the protocol that connections speak.
+	Mode Mode
+	// MaxValueSize is the largest value that's read in the binary mode.
+	MaxValueSize int
+	// MaxMessageSize is the largest query that's read in the codec mode, the
+	// same as the largest query over udp by default.
+	MaxMessageSize int
 }
 
 // NewServer creates a Server with the correct dependencies
 func NewServer(store store.Store, logger log.Logger) *Server {
 	return &Server{
-		store:  store,
-		logger: logger,
+		store:          store,
+		logger:         logger,
+		IdleTimeout:    DefaultIdleTimeout,
+		ReadTimeout:    DefaultReadTimeout,
+		WriteTimeout:   DefaultWriteTimeout,
+		Mode:           ModeCodec,
+		MaxValueSize:   keyvalNet.DefaultMaxBinaryValueSize,
+		MaxMessageSize: keyvalNet.DefaultMaxMessageSize,
 	}
 }
 
@@ -32,6 +101,10 @@ func (s *Server) Serve(listener net.Listener) error {
 		if err != nil {
 			return err
 		}
+		if s.Mode == ModeBinary {
+			go s.handleBinaryConn(conn)
+			continue
+		}
 		go s.handleConn(conn)
 	}
 }
@@ -39,86 +112,181 @@ func (s *Server) Serve(listener net.Listener) error {
 func (s *Server) handleConn(conn net.Conn) {
 	defer conn.Close()
 
-	dec := gob.NewDecoder(conn)
+	var (
+		reader = bufio.NewReader(conn)
+		writer = bufio.NewWriter(conn)
+	)
 
-	var query keyvalNet.Query
-	if err := dec.Decode(&query); err != nil {
-		// send error
-		write(conn, keyvalNet.ServerError)
+	// The codec is picked by the handshake, if there is one, before the first
+	// query.
+	conn.SetReadDeadline(deadline(s.IdleTimeout))
+	codec, ok, err := keyvalNet.ReadHandshake(reader)
+	if err == keyvalNet.ErrUnknownCodec {
+		conn.SetWriteDeadline(deadline(s.WriteTimeout))
+		conn.Write([]byte{keyvalNet.HandshakeMagic, 0})
+		return
+	} else if err != nil {
 		return
 	}
+	if ok {
+		conn.SetWriteDeadline(deadline(s.WriteTimeout))
+		if _, err := conn.Write(keyvalNet.Handshake(codec)); err != nil {
+			return
+		}
+	}
 
+	var (
+		dec = keyvalNet.NewDecoderSize(codec, reader, s.MaxMessageSize)
+		enc = codec.NewEncoder(writer)
+	)
+	for {
+		// Wait for the next query to start arriving, before expecting the
+		// whole of the query to be read in time.
+		conn.SetReadDeadline(deadline(s.IdleTimeout))
+		if _, err := reader.Peek(1); err != nil {
+			return
+		}
+		conn.SetReadDeadline(deadline(s.ReadTimeout))
+		conn.SetWriteDeadline(deadline(s.WriteTimeout))
+
+		var query keyvalNet.Query
+		if err := dec.Decode(&query); err != nil {
+			// The stream can't be recovered, so send the error and give up.
+			write(encoder{enc: enc}, keyvalNet.ServerError)
+			writer.Flush()
+			return
+		}
+
+		e := encoder{
+			enc: enc,
+			id:  query.ID,
+		}
+		if query.Method == keyvalNet.Watch {
+			// A watch takes over the rest of the connection.
+			s.handleWatch(conn, reader, writer, e, query)
+			return
+		}
+		s.handleQuery(e, query)
+
+		// Only flush once there are no more pipelined queries waiting, so the
+		// results of pipelined queries are written together.
+		if reader.Buffered() > 0 {
+			continue
+		}
+		conn.SetWriteDeadline(deadline(s.WriteTimeout))
+		if err := writer.Flush(); err != nil {
+			return
+		}
+	}
+}
+
+func (s *Server) handleQuery(enc keyvalNet.Encoder, query keyvalNet.Query) {
 	switch query.Method {
 	case keyvalNet.Select:
-		s.handleSelect(conn, query)
+		s.handleSelect(enc, query)
 	case keyvalNet.Insert:
-		s.handleInsert(conn, query)
+		s.handleInsert(enc, query)
 	case keyvalNet.Delete:
-		s.handleDelete(conn, query)
+		s.handleDelete(enc, query)
+	case keyvalNet.CompareAndSwap:
+		s.handleCompareAndSwap(enc, query)
+	case keyvalNet.CompareAndDelete:
+		s.handleCompareAndDelete(enc, query)
+	case keyvalNet.Scan:
+		s.handleScan(enc, query)
+	case keyvalNet.Txn:
+		s.handleTxn(enc, query)
 	default:
 		// send error
-		write(conn, keyvalNet.NotFound)
+		write(enc, keyvalNet.NotFound)
 	}
 }
 
-func (s *Server) handleSelect(w io.Writer, q keyvalNet.Query) {
+func (s *Server) handleSelect(enc keyvalNet.Encoder, q keyvalNet.Query) {
 	// useful metrics
 	begin := time.Now()
 
 	// Validate user input.
 	var qp keyvalNet.QueryParams
 	if err := qp.DecodeFrom(q); err != nil {
-		write(w, keyvalNet.BadRequest)
+		write(enc, keyvalNet.BadRequest)
 		return
 	}
 
-	value, ok := s.store.Get(qp.Key)
+	entry, ok := s.store.Select(qp.Key)
 	if !ok {
-		write(w, keyvalNet.NotFound)
+		write(enc, keyvalNet.NotFound)
 		return
 	}
 
 	qr := keyvalNet.SelectQueryResult{Params: qp}
-	qr.Value = value
+	qr.Value = entry.Value
+	qr.Version = entry.Version
+	qr.ContentType = entry.Metadata.ContentType
+	qr.ContentEncoding = entry.Metadata.ContentEncoding
+	qr.Metadata = entry.Metadata.User
 
 	// Finish
 	qr.Duration = time.Since(begin).String()
-	qr.EncodeTo(w)
+	qr.EncodeTo(enc)
 }
 
-func (s *Server) handleInsert(w io.Writer, q keyvalNet.Query) {
+func (s *Server) handleInsert(enc keyvalNet.Encoder, q keyvalNet.Query) {
 	// useful metrics
 	begin := time.Now()
 
 	// Validate user input.
 	var qp keyvalNet.QueryParams
 	if err := qp.DecodeFrom(q); err != nil {
-		write(w, keyvalNet.BadRequest)
+		write(enc, keyvalNet.BadRequest)
+		return
+	}
+
+	// A value that can never fit with in a bounded store is refused.
+	if !store.Fits(s.store, qp.Key, q.Value, metadataOf(qp)) {
+		write(enc, keyvalNet.ServerError)
 		return
 	}
 
 	qr := keyvalNet.InsertQueryResult{Params: qp}
-	qr.Created = s.store.Set(qp.Key, q.Value)
+	// The store reports whether the value replaced an existing value, so the
+	// value is only created if it didn't, the same as a compare and swap.
+	var replaced bool
+	if metadata := metadataOf(qp); !metadata.IsZero() {
+		replaced = s.store.SetWithMetadata(qp.Key, q.Value, qp.TTL, metadata)
+	} else if qp.TTL > 0 {
+		replaced = s.store.SetWithTTL(qp.Key, q.Value, qp.TTL)
+	} else {
+		replaced = s.store.Set(qp.Key, q.Value)
+	}
+	qr.Created = !replaced
+	if err := store.Err(s.store); err != nil {
+		s.failed(enc, err)
+		return
+	}
 
 	// Finish
 	qr.Duration = time.Since(begin).String()
-	qr.EncodeTo(w)
+	qr.EncodeTo(enc)
 }
 
-func (s *Server) handleDelete(w io.Writer, q keyvalNet.Query) {
+func (s *Server) handleDelete(enc keyvalNet.Encoder, q keyvalNet.Query) {
 	// useful metrics
 	begin := time.Now()
 
 	// Validate user input.
 	var qp keyvalNet.QueryParams
 	if err := qp.DecodeFrom(q); err != nil {
-		write(w, keyvalNet.BadRequest)
+		write(enc, keyvalNet.BadRequest)
 		return
 	}
 
 	ok := s.store.Delete(qp.Key)
-	if !ok {
-		write(w, keyvalNet.NotFound)
+	if err := store.Err(s.store); err != nil {
+		s.failed(enc, err)
+		return
+	} else if !ok {
+		write(enc, keyvalNet.NotFound)
 		return
 	}
 
@@ -126,15 +294,276 @@ func (s *Server) handleDelete(w io.Writer, q keyvalNet.Query) {
 
 	// Finish
 	qr.Duration = time.Since(begin).String()
-	qr.EncodeTo(w)
+	qr.EncodeTo(enc)
 }
 
-func write(w io.Writer, status keyvalNet.Status) {
-	enc := gob.NewEncoder(w)
+func (s *Server) handleCompareAndSwap(enc keyvalNet.Encoder, q keyvalNet.Query) {
+	// useful metrics
+	begin := time.Now()
+
+	// Validate user input.
+	var qp keyvalNet.QueryParams
+	if err := qp.DecodeFrom(q); err != nil {
+		write(enc, keyvalNet.BadRequest)
+		return
+	}
+
+	// A value that can never fit with in a bounded store is refused.
+	if !store.Fits(s.store, qp.Key, q.Value, metadataOf(qp)) {
+		write(enc, keyvalNet.ServerError)
+		return
+	}
+
+	// The ttl and metadata replace those of the existing value, the same as an
+	// insert.
+	version, ok := store.CompareAndSwapWithMetadata(s.store, qp.Key, qp.Version, q.Value, qp.TTL, metadataOf(qp))
+	if err := store.Err(s.store); err != nil {
+		s.failed(enc, err)
+		return
+	} else if !ok {
+		write(enc, keyvalNet.Conflict)
+		return
+	}
+
+	qr := keyvalNet.InsertQueryResult{Params: qp}
+	// A version of zero only matches a missing value, so the value is only
+	// created then, the same as an insert.
+	qr.Created = qp.Version == 0
+	qr.Version = version
+
+	// Finish
+	qr.Duration = time.Since(begin).String()
+	qr.EncodeTo(enc)
+}
+
+func (s *Server) handleCompareAndDelete(enc keyvalNet.Encoder, q keyvalNet.Query) {
+	// useful metrics
+	begin := time.Now()
+
+	// Validate user input.
+	var qp keyvalNet.QueryParams
+	if err := qp.DecodeFrom(q); err != nil {
+		write(enc, keyvalNet.BadRequest)
+		return
+	}
+
+	ok := s.store.CompareAndDelete(qp.Key, qp.Version)
+	if err := store.Err(s.store); err != nil {
+		s.failed(enc, err)
+		return
+	} else if !ok {
+		write(enc, keyvalNet.Conflict)
+		return
+	}
+
+	qr := keyvalNet.DeleteQueryResult{Params: qp}
+
+	// Finish
+	qr.Duration = time.Since(begin).String()
+	qr.EncodeTo(enc)
+}
+
+func (s *Server) handleScan(enc keyvalNet.Encoder, q keyvalNet.Query) {
+	// useful metrics
+	begin := time.Now()
+
+	// Validate user input.
+	var qp keyvalNet.ScanQueryParams
+	if err := qp.DecodeFrom(q); err != nil {
+		write(enc, keyvalNet.BadRequest)
+		return
+	}
+
+	entries, cursor := s.store.Scan(store.ScanOptions{
+		Prefix: qp.Prefix,
+		Start:  qp.Start,
+		End:    qp.End,
+		Limit:  qp.Limit,
+		Cursor: qp.Cursor,
+	})
+
+	qr := keyvalNet.ScanQueryResult{Params: qp}
+	qr.Entries = make([]keyvalNet.Entry, len(entries))
+	for k, v := range entries {
+		qr.Entries[k] = keyvalNet.Entry{
+			Key:     v.Key,
+			Value:   v.Value,
+			Version: v.Version,
+		}
+	}
+	qr.Cursor = cursor
+
+	// Finish
+	qr.Duration = time.Since(begin).String()
+	qr.EncodeTo(enc)
+}
+
+func (s *Server) handleTxn(enc keyvalNet.Encoder, q keyvalNet.Query) {
+	// useful metrics
+	begin := time.Now()
+
+	// Validate user input.
+	var qp keyvalNet.TxnQueryParams
+	if err := qp.DecodeFrom(q); err != nil {
+		write(enc, keyvalNet.BadRequest)
+		return
+	}
+
+	conditions := make([]store.Condition, len(qp.Conditions))
+	for k, v := range qp.Conditions {
+		conditions[k] = store.Condition{
+			Key:     v.Key,
+			Check:   store.Check(v.Check),
+			Version: v.Version,
+		}
+	}
+	mutations := make([]store.Mutation, len(qp.Mutations))
+	for k, v := range qp.Mutations {
+		mutations[k] = store.Mutation{
+			Key:       v.Key,
+			Operation: store.Operation(v.Operation),
+			Value:     v.Value,
+			TTL:       v.TTL,
+			Metadata: store.Metadata{
+				ContentType:     v.ContentType,
+				ContentEncoding: v.ContentEncoding,
+				User:            v.Metadata,
+			},
+		}
+	}
+
+	qr := keyvalNet.TxnQueryResult{Params: qp}
+	qr.Versions, qr.Applied = s.store.Txn(conditions, mutations)
+	if err := store.Err(s.store); err != nil {
+		s.failed(enc, err)
+		return
+	}
+
+	// Finish
+	qr.Duration = time.Since(begin).String()
+	qr.EncodeTo(enc)
+}
+
+// handleWatch streams the events to the connection until either the
+// connection is closed or the watch overflows.
+func (s *Server) handleWatch(conn net.Conn, reader *bufio.Reader, writer *bufio.Writer, enc keyvalNet.Encoder, q keyvalNet.Query) {
+	// flush the results straight away, as there are no more queries.
+	flush := func() error {
+		conn.SetWriteDeadline(deadline(s.WriteTimeout))
+		return writer.Flush()
+	}
+	defer flush()
+
+	// Validate user input.
+	var qp keyvalNet.WatchQueryParams
+	if err := qp.DecodeFrom(q); err != nil {
+		write(enc, keyvalNet.BadRequest)
+		return
+	}
+
+	watcher, ok := s.store.(store.Watcher)
+	if !ok {
+		write(enc, keyvalNet.NotFound)
+		return
+	}
+
+	sub := watcher.Watch(store.WatchOptions{
+		Key:    qp.Key,
+		Prefix: qp.Prefix,
+	})
+	defer sub.Close()
+
+	// Nothing else is read from the connection, so once the read fails the
+	// client has gone away. The connection is expected to be idle, so there
+	// is no read deadline.
+	conn.SetReadDeadline(time.Time{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		io.Copy(ioutil.Discard, reader)
+	}()
+
+	// Let the client know that the subscription has been made.
 	if err := enc.Encode(keyvalNet.Result{
-		Status: status,
+		Status: keyvalNet.OK,
 		Value:  []byte{},
 	}); err != nil {
-		panic(err)
+		return
+	}
+	if err := flush(); err != nil {
+		return
+	}
+
+	for {
+		select {
+		case event, ok := <-sub.Events():
+			if !ok {
+				if sub.Err() == store.ErrOverflow {
+					write(enc, keyvalNet.Overflow)
+				}
+				return
+			}
+			if err := enc.Encode(keyvalNet.Result{
+				Status: keyvalNet.OK,
+				Value:  []byte{},
+				Event: keyvalNet.Event{
+					Type:    keyvalNet.EventType(event.Type),
+					Key:     event.Key,
+					Value:   event.Value,
+					Version: event.Version,
+				},
+			}); err != nil {
+				return
+			}
+			if err := flush(); err != nil {
+				return
+			}
+		case <-done:
+			return
+		}
+	}
+}
+
+// encoder echoes the ID of the query in the result.
+type encoder struct {
+	enc keyvalNet.MessageEncoder
+	id  uint64
+}
+
+func (e encoder) Encode(result keyvalNet.Result) error {
+	result.ID = e.id
+	return e.enc.Encode(result)
+}
+
+// deadline returns the deadline for the timeout, a timeout of zero means that
+// there is no deadline.
+func deadline(timeout time.Duration) time.Time {
+	if timeout <= 0 {
+		return time.Time{}
 	}
+	return time.Now().Add(timeout)
+}
+
+// failed reports a write that the store couldn't apply, even though the write
+// itself is valid.
+func (s *Server) failed(enc keyvalNet.Encoder, err error) {
+	level.Error(s.logger).Log("err", err)
+	write(enc, keyvalNet.ServerError)
+}
+
+// metadataOf returns the metadata of the value of the query.
+func metadataOf(qp keyvalNet.QueryParams) store.Metadata {
+	return store.Metadata{
+		ContentType:     qp.ContentType,
+		ContentEncoding: qp.ContentEncoding,
+		User:            qp.Metadata,
+	}
+}
+
+func write(enc keyvalNet.Encoder, status keyvalNet.Status) {
+	// Errors are returned when the results are flushed to the connection.
+	enc.Encode(keyvalNet.Result{
+		Status: status,
+		Value:  []byte{},
+	})
 }
@@ -1,15 +1,21 @@
 package tcp
 
 import (
+	"bufio"
+	"bytes"
 	"encoding/base64"
 	"encoding/gob"
 	"fmt"
+	"io"
 	"net"
 	"reflect"
+	"strings"
 	"testing"
 	"testing/quick"
+	"time"
 
 	keyvalNet "github.com/SimonRichardson/keyval/pkg/net"
+	keyvalStore "github.com/SimonRichardson/keyval/pkg/store"
 	"github.com/SimonRichardson/keyval/pkg/store/mocks"
 	"github.com/go-kit/kit/log"
 	"github.com/golang/mock/gomock"
@@ -33,7 +39,7 @@ func TestAPISelect(t *testing.T) {
 		fn := func(a []byte) bool {
 			key := buildKey(a)
 
-			store.EXPECT().Get(key).Return(nil, false)
+			store.EXPECT().Select(key).Return(keyvalStore.Entry{}, false)
 
 			resp := Request(port, keyvalNet.Query{
 				Method: keyvalNet.Select,
@@ -62,7 +68,7 @@ func TestAPISelect(t *testing.T) {
 		fn := func(a, b []byte) bool {
 			key := buildKey(a)
 
-			store.EXPECT().Get(key).Return(b, true)
+			store.EXPECT().Select(key).Return(keyvalStore.Entry{Key: key, Value: b, Version: 1}, true)
 
 			resp := Request(port, keyvalNet.Query{
 				Method: keyvalNet.Select,
@@ -84,6 +90,44 @@ func TestAPISelect(t *testing.T) {
 			t.Error(err)
 		}
 	})
+
+	t.Run("select with metadata", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+
+		store := mocks.NewMockStore(ctrl)
+
+		port := 9062
+
+		server := NewServer(store, log.NewNopLogger())
+		listener := setupServer(server, port)
+		defer listener.Close()
+
+		metadata := keyvalStore.Metadata{
+			ContentType:     "application/json",
+			ContentEncoding: "gzip",
+			User:            map[string]string{"owner": "a"},
+		}
+		store.EXPECT().Select("key").Return(keyvalStore.Entry{Key: "key", Value: []byte("value"), Version: 1, Metadata: metadata}, true)
+
+		resp := Request(port, keyvalNet.Query{
+			Method: keyvalNet.Select,
+			Key:    "key",
+		})
+
+		if expected, actual := keyvalNet.OK, resp.Status; expected != actual {
+			t.Errorf("expected: %v, actual: %v", expected, actual)
+		}
+		if expected, actual := metadata.ContentType, resp.ContentType; expected != actual {
+			t.Errorf("expected: %v, actual: %v", expected, actual)
+		}
+		if expected, actual := metadata.ContentEncoding, resp.ContentEncoding; expected != actual {
+			t.Errorf("expected: %v, actual: %v", expected, actual)
+		}
+		if expected, actual := metadata.User, resp.Metadata; !reflect.DeepEqual(expected, actual) {
+			t.Errorf("expected: %v, actual: %v", expected, actual)
+		}
+	})
 }
 
 func TestAPIInsert(t *testing.T) {
@@ -116,7 +160,7 @@ func TestAPIInsert(t *testing.T) {
 				Value:  b,
 			})
 
-			return resp.Status == keyvalNet.OK
+			return resp.Status == keyvalNet.Created
 		}
 		if err := quick.Check(fn, nil); err != nil {
 			t.Error(err)
@@ -150,12 +194,253 @@ func TestAPIInsert(t *testing.T) {
 				Value:  b,
 			})
 
-			return resp.Status == keyvalNet.Created
+			return resp.Status == keyvalNet.OK
 		}
 		if err := quick.Check(fn, nil); err != nil {
 			t.Error(err)
 		}
 	})
+
+	t.Run("insert with ttl", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+
+		store := mocks.NewMockStore(ctrl)
+
+		port := 9006
+
+		server := NewServer(store, log.NewNopLogger())
+		listener := setupServer(server, port)
+		defer listener.Close()
+
+		fn := func(a, b []byte) bool {
+			if len(b) == 0 {
+				b = []byte{0}
+			}
+
+			key := buildKey(a)
+
+			store.EXPECT().SetWithTTL(key, b, time.Second).Return(true)
+
+			resp := Request(port, keyvalNet.Query{
+				Method: keyvalNet.Insert,
+				Key:    key,
+				Value:  b,
+				TTL:    time.Second,
+			})
+
+			return resp.Status == keyvalNet.OK
+		}
+		if err := quick.Check(fn, nil); err != nil {
+			t.Error(err)
+		}
+	})
+
+	t.Run("insert with metadata", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+
+		store := mocks.NewMockStore(ctrl)
+
+		port := 9063
+
+		server := NewServer(store, log.NewNopLogger())
+		listener := setupServer(server, port)
+		defer listener.Close()
+
+		store.EXPECT().SetWithMetadata("key", []byte("value"), time.Second, keyvalStore.Metadata{
+			ContentType: "text/plain",
+			User:        map[string]string{"owner": "a"},
+		}).Return(true)
+
+		resp := Request(port, keyvalNet.Query{
+			Method:      keyvalNet.Insert,
+			Key:         "key",
+			Value:       []byte("value"),
+			TTL:         time.Second,
+			ContentType: "text/plain",
+			Metadata:    map[string]string{"owner": "a"},
+		})
+
+		if expected, actual := keyvalNet.OK, resp.Status; expected != actual {
+			t.Errorf("expected: %v, actual: %v", expected, actual)
+		}
+	})
+
+	t.Run("insert with invalid metadata", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+
+		store := mocks.NewMockStore(ctrl)
+
+		port := 9064
+
+		server := NewServer(store, log.NewNopLogger())
+		listener := setupServer(server, port)
+		defer listener.Close()
+
+		for _, metadata := range []map[string]string{
+			{"": "a"},
+			{"owner": "a\r\nb"},
+			{"owner": strings.Repeat("a", keyvalNet.MaxMetadataSize)},
+		} {
+			resp := Request(port, keyvalNet.Query{
+				Method:   keyvalNet.Insert,
+				Key:      "key",
+				Value:    []byte("value"),
+				Metadata: metadata,
+			})
+
+			if expected, actual := keyvalNet.BadRequest, resp.Status; expected != actual {
+				t.Errorf("expected: %v, actual: %v", expected, actual)
+			}
+		}
+	})
+}
+
+func TestAPICompareAndSwap(t *testing.T) {
+	t.Parallel()
+
+	t.Run("compare and swap with matching version", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+
+		store := mocks.NewMockStore(ctrl)
+
+		port := 9007
+
+		server := NewServer(store, log.NewNopLogger())
+		listener := setupServer(server, port)
+		defer listener.Close()
+
+		fn := func(a, b []byte) bool {
+			if len(b) == 0 {
+				b = []byte{0}
+			}
+
+			key := buildKey(a)
+
+			store.EXPECT().Txn([]keyvalStore.Condition{
+				{Key: key, Check: keyvalStore.CheckVersion, Version: 1},
+			}, []keyvalStore.Mutation{
+				{Key: key, Value: b, TTL: time.Minute},
+			}).Return([]uint64{2}, true)
+
+			resp := Request(port, keyvalNet.Query{
+				Method:  keyvalNet.CompareAndSwap,
+				Key:     key,
+				Value:   b,
+				TTL:     time.Minute,
+				Version: 1,
+			})
+
+			return resp.Status == keyvalNet.OK && resp.Version == 2
+		}
+		if err := quick.Check(fn, nil); err != nil {
+			t.Error(err)
+		}
+	})
+
+	t.Run("compare and swap with no existing value", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+
+		store := mocks.NewMockStore(ctrl)
+
+		port := 9066
+
+		server := NewServer(store, log.NewNopLogger())
+		listener := setupServer(server, port)
+		defer listener.Close()
+
+		fn := func(a, b []byte) bool {
+			if len(b) == 0 {
+				b = []byte{0}
+			}
+
+			key := buildKey(a)
+
+			store.EXPECT().Txn([]keyvalStore.Condition{
+				{Key: key, Check: keyvalStore.CheckVersion, Version: 0},
+			}, []keyvalStore.Mutation{
+				{Key: key, Value: b},
+			}).Return([]uint64{1}, true)
+
+			resp := Request(port, keyvalNet.Query{
+				Method: keyvalNet.CompareAndSwap,
+				Key:    key,
+				Value:  b,
+			})
+
+			return resp.Status == keyvalNet.Created && resp.Version == 1
+		}
+		if err := quick.Check(fn, nil); err != nil {
+			t.Error(err)
+		}
+	})
+
+	t.Run("compare and swap with stale version", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+
+		store := mocks.NewMockStore(ctrl)
+
+		port := 9008
+
+		server := NewServer(store, log.NewNopLogger())
+		listener := setupServer(server, port)
+		defer listener.Close()
+
+		fn := func(a, b []byte) bool {
+			if len(b) == 0 {
+				b = []byte{0}
+			}
+
+			key := buildKey(a)
+
+			store.EXPECT().Txn([]keyvalStore.Condition{
+				{Key: key, Check: keyvalStore.CheckVersion, Version: 1},
+			}, []keyvalStore.Mutation{
+				{Key: key, Value: b},
+			}).Return([]uint64{2}, false)
+
+			resp := Request(port, keyvalNet.Query{
+				Method:  keyvalNet.CompareAndSwap,
+				Key:     key,
+				Value:   b,
+				Version: 1,
+			})
+
+			return resp.Status == keyvalNet.Conflict
+		}
+		if err := quick.Check(fn, nil); err != nil {
+			t.Error(err)
+		}
+	})
+
+	t.Run("compare and swap agrees with insert", func(t *testing.T) {
+		port := 9067
+
+		server := NewServer(keyvalStore.New(), log.NewNopLogger())
+		listener := setupServer(server, port)
+		defer listener.Close()
+
+		// Both are only created when the key didn't exist before.
+		for _, testcase := range []struct {
+			query    keyvalNet.Query
+			expected keyvalNet.Status
+		}{
+			{keyvalNet.Query{Method: keyvalNet.Insert, Key: "a", Value: []byte("a")}, keyvalNet.Created},
+			{keyvalNet.Query{Method: keyvalNet.Insert, Key: "a", Value: []byte("b")}, keyvalNet.OK},
+			{keyvalNet.Query{Method: keyvalNet.CompareAndSwap, Key: "b", Value: []byte("a")}, keyvalNet.Created},
+			{keyvalNet.Query{Method: keyvalNet.CompareAndSwap, Key: "b", Value: []byte("b"), Version: 3}, keyvalNet.OK},
+		} {
+			resp := Request(port, testcase.query)
+			if expected, actual := testcase.expected, resp.Status; expected != actual {
+				t.Errorf("%v %s expected: %v, actual: %v", testcase.query.Method, testcase.query.Key, expected, actual)
+			}
+		}
+	})
 }
 
 func TestAPIDelete(t *testing.T) {
@@ -220,6 +505,466 @@ func TestAPIDelete(t *testing.T) {
 	})
 }
 
+func TestAPIScan(t *testing.T) {
+	t.Parallel()
+
+	t.Run("scan", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+
+		store := mocks.NewMockStore(ctrl)
+
+		port := 9009
+
+		server := NewServer(store, log.NewNopLogger())
+		listener := setupServer(server, port)
+		defer listener.Close()
+
+		fn := func(a, b []byte) bool {
+			if len(b) == 0 {
+				b = []byte{0}
+			}
+
+			key := buildKey(a)
+
+			store.EXPECT().Scan(keyvalStore.ScanOptions{
+				Prefix: key,
+				Limit:  keyvalNet.DefaultScanLimit,
+			}).Return([]keyvalStore.Entry{
+				{Key: key, Value: b, Version: 1},
+			}, "cursor")
+
+			resp := Request(port, keyvalNet.Query{
+				Method: keyvalNet.Scan,
+				Prefix: key,
+			})
+
+			return resp.Status == keyvalNet.OK &&
+				resp.Cursor == "cursor" &&
+				reflect.DeepEqual(resp.Entries, []keyvalNet.Entry{
+					{Key: key, Value: b, Version: 1},
+				})
+		}
+		if err := quick.Check(fn, nil); err != nil {
+			t.Error(err)
+		}
+	})
+}
+
+func TestAPITxn(t *testing.T) {
+	t.Parallel()
+
+	t.Run("txn", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+
+		store := mocks.NewMockStore(ctrl)
+
+		port := 9010
+
+		server := NewServer(store, log.NewNopLogger())
+		listener := setupServer(server, port)
+		defer listener.Close()
+
+		fn := func(a, b []byte) bool {
+			if len(b) == 0 {
+				b = []byte{0}
+			}
+
+			key := buildKey(a)
+
+			store.EXPECT().Txn([]keyvalStore.Condition{
+				{Key: key, Check: keyvalStore.CheckMissing},
+			}, []keyvalStore.Mutation{
+				{Key: key, Operation: keyvalStore.OperationSet, Value: b},
+			}).Return([]uint64{2}, true)
+
+			resp := Request(port, keyvalNet.Query{
+				Method: keyvalNet.Txn,
+				Conditions: []keyvalNet.Condition{
+					{Key: key, Check: keyvalNet.CheckMissing},
+				},
+				Mutations: []keyvalNet.Mutation{
+					{Key: key, Operation: keyvalNet.OperationSet, Value: b},
+				},
+			})
+
+			return resp.Status == keyvalNet.OK &&
+				reflect.DeepEqual(resp.Versions, []uint64{2})
+		}
+		if err := quick.Check(fn, nil); err != nil {
+			t.Error(err)
+		}
+	})
+
+	t.Run("txn with failing condition", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+
+		store := mocks.NewMockStore(ctrl)
+
+		port := 9020
+
+		server := NewServer(store, log.NewNopLogger())
+		listener := setupServer(server, port)
+		defer listener.Close()
+
+		fn := func(a []byte) bool {
+			key := buildKey(a)
+
+			store.EXPECT().Txn([]keyvalStore.Condition{
+				{Key: key, Check: keyvalStore.CheckVersion, Version: 1},
+			}, []keyvalStore.Mutation{
+				{Key: key, Operation: keyvalStore.OperationDelete},
+			}).Return([]uint64{3}, false)
+
+			resp := Request(port, keyvalNet.Query{
+				Method: keyvalNet.Txn,
+				Conditions: []keyvalNet.Condition{
+					{Key: key, Check: keyvalNet.CheckVersion, Version: 1},
+				},
+				Mutations: []keyvalNet.Mutation{
+					{Key: key, Operation: keyvalNet.OperationDelete},
+				},
+			})
+
+			return resp.Status == keyvalNet.Conflict &&
+				reflect.DeepEqual(resp.Versions, []uint64{3})
+		}
+		if err := quick.Check(fn, nil); err != nil {
+			t.Error(err)
+		}
+	})
+}
+
+func TestAPIWatch(t *testing.T) {
+	t.Parallel()
+
+	t.Run("watch", func(t *testing.T) {
+		s := keyvalStore.New()
+
+		port := 9021
+
+		server := NewServer(s, log.NewNopLogger())
+		listener := setupServer(server, port)
+		defer listener.Close()
+
+		conn, err := net.Dial("tcp", fmt.Sprintf("0.0.0.0:%d", port))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+
+		if err := gob.NewEncoder(conn).Encode(keyvalNet.Query{
+			Method: keyvalNet.Watch,
+			Prefix: "config/",
+		}); err != nil {
+			t.Fatal(err)
+		}
+
+		dec := gob.NewDecoder(conn)
+		var res keyvalNet.Result
+		if err := dec.Decode(&res); err != nil {
+			t.Fatal(err)
+		}
+		if expected, actual := keyvalNet.OK, res.Status; expected != actual {
+			t.Fatalf("expected: %v, actual: %v", expected, actual)
+		}
+
+		s.Set("other", []byte("x"))
+		s.Set("config/a", []byte("a"))
+		s.Delete("config/a")
+
+		for _, expected := range []keyvalNet.Event{
+			{Type: keyvalNet.EventPut, Key: "config/a", Value: []byte("a"), Version: 2},
+			{Type: keyvalNet.EventDelete, Key: "config/a", Version: 2},
+		} {
+			var res keyvalNet.Result
+			if err := dec.Decode(&res); err != nil {
+				t.Fatal(err)
+			}
+			if actual := res.Event; !reflect.DeepEqual(expected, actual) {
+				t.Errorf("expected: %v, actual: %v", expected, actual)
+			}
+		}
+	})
+
+	t.Run("watch with key and prefix", func(t *testing.T) {
+		port := 9022
+
+		server := NewServer(keyvalStore.New(), log.NewNopLogger())
+		listener := setupServer(server, port)
+		defer listener.Close()
+
+		resp := Request(port, keyvalNet.Query{
+			Method: keyvalNet.Watch,
+			Key:    "a",
+			Prefix: "b",
+		})
+		if expected, actual := keyvalNet.BadRequest, resp.Status; expected != actual {
+			t.Errorf("expected: %v, actual: %v", expected, actual)
+		}
+	})
+}
+
+func TestPersistentConn(t *testing.T) {
+	t.Parallel()
+
+	t.Run("pipelined queries", func(t *testing.T) {
+		port := 9023
+
+		server := NewServer(keyvalStore.New(), log.NewNopLogger())
+		listener := setupServer(server, port)
+		defer listener.Close()
+
+		conn, err := net.Dial("tcp", fmt.Sprintf("0.0.0.0:%d", port))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+
+		// Send all the queries before reading any of the results.
+		enc := gob.NewEncoder(conn)
+		for i := 0; i < 100; i++ {
+			key := fmt.Sprintf("key-%d", i/2)
+			query := keyvalNet.Query{
+				ID:     uint64(i + 1),
+				Method: keyvalNet.Insert,
+				Key:    key,
+				Value:  []byte("value"),
+			}
+			if i%2 == 1 {
+				query.Method = keyvalNet.Select
+			}
+			if err := enc.Encode(query); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		dec := gob.NewDecoder(conn)
+		for i := 0; i < 100; i++ {
+			var res keyvalNet.Result
+			if err := dec.Decode(&res); err != nil {
+				t.Fatal(err)
+			}
+			if expected, actual := uint64(i+1), res.ID; expected != actual {
+				t.Errorf("expected: %v, actual: %v", expected, actual)
+			}
+			if i%2 == 1 && !bytes.Equal(res.Value, []byte("value")) {
+				t.Errorf("expected: %v, actual: %v", "value", string(res.Value))
+			}
+		}
+	})
+
+	t.Run("idle timeout", func(t *testing.T) {
+		port := 9024
+
+		server := NewServer(keyvalStore.New(), log.NewNopLogger())
+		server.IdleTimeout = time.Millisecond * 50
+		listener := setupServer(server, port)
+		defer listener.Close()
+
+		conn, err := net.Dial("tcp", fmt.Sprintf("0.0.0.0:%d", port))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+
+		conn.SetReadDeadline(time.Now().Add(time.Second))
+		if _, err := conn.Read(make([]byte, 1)); err != io.EOF {
+			t.Errorf("expected: %v, actual: %v", io.EOF, err)
+		}
+	})
+}
+
+func TestCodecs(t *testing.T) {
+	t.Parallel()
+
+	port := 9028
+
+	server := NewServer(keyvalStore.New(), log.NewNopLogger())
+	listener := setupServer(server, port)
+	defer listener.Close()
+
+	dial := func(t *testing.T, handshake []byte) net.Conn {
+		conn, err := net.Dial("tcp", fmt.Sprintf("0.0.0.0:%d", port))
+		if err != nil {
+			t.Fatal(err)
+		}
+		conn.SetDeadline(time.Now().Add(time.Second * 5))
+		if _, err := conn.Write(handshake); err != nil {
+			t.Fatal(err)
+		}
+		reply := make([]byte, len(handshake))
+		if _, err := io.ReadFull(conn, reply); err != nil {
+			t.Fatal(err)
+		}
+		if expected, actual := handshake, reply; !bytes.Equal(expected, actual) {
+			t.Errorf("expected: %v, actual: %v", expected, actual)
+		}
+		return conn
+	}
+
+	for _, codec := range keyvalNet.Codecs() {
+		codec := codec
+		t.Run(codec.String(), func(t *testing.T) {
+			conn := dial(t, keyvalNet.Handshake(codec))
+			defer conn.Close()
+
+			key := fmt.Sprintf("key-%s", codec)
+			enc := codec.NewEncoder(conn)
+			for _, query := range []keyvalNet.Query{
+				{ID: 1, Method: keyvalNet.Insert, Key: key, Value: []byte("value")},
+				{ID: 2, Method: keyvalNet.Select, Key: key},
+			} {
+				if err := enc.Encode(query); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			dec := codec.NewDecoder(conn)
+			for _, expected := range []keyvalNet.Result{
+				{ID: 1, Status: keyvalNet.Created},
+				{ID: 2, Status: keyvalNet.OK, Value: []byte("value")},
+			} {
+				var actual keyvalNet.Result
+				if err := dec.Decode(&actual); err != nil {
+					t.Fatal(err)
+				}
+				if expected.ID != actual.ID ||
+					expected.Status != actual.Status ||
+					!bytes.Equal(expected.Value, actual.Value) {
+					t.Errorf("expected: %v, actual: %v", expected, actual)
+				}
+			}
+		})
+	}
+
+	t.Run("unknown codec", func(t *testing.T) {
+		conn, err := net.Dial("tcp", fmt.Sprintf("0.0.0.0:%d", port))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		conn.SetDeadline(time.Now().Add(time.Second * 5))
+
+		if _, err := conn.Write([]byte{keyvalNet.HandshakeMagic, 0xff}); err != nil {
+			t.Fatal(err)
+		}
+		reply, err := io.ReadAll(conn)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if expected, actual := []byte{keyvalNet.HandshakeMagic, 0}, reply; !bytes.Equal(expected, actual) {
+			t.Errorf("expected: %v, actual: %v", expected, actual)
+		}
+	})
+}
+
+func benchmarkConn(b *testing.B, port int, fn func(b *testing.B, addr string)) {
+	server := NewServer(keyvalStore.New(), log.NewNopLogger())
+	listener := setupServer(server, port)
+	defer listener.Close()
+
+	b.ResetTimer()
+	begin := time.Now()
+	fn(b, fmt.Sprintf("0.0.0.0:%d", port))
+	b.ReportMetric(float64(b.N)/time.Since(begin).Seconds(), "ops/s")
+}
+
+// BenchmarkOneShotConn uses a new connection for every query.
+func BenchmarkOneShotConn(b *testing.B) {
+	benchmarkConn(b, 9025, func(b *testing.B, addr string) {
+		for i := 0; i < b.N; i++ {
+			conn, err := net.Dial("tcp", addr)
+			if err != nil {
+				b.Fatal(err)
+			}
+			if err := gob.NewEncoder(conn).Encode(keyvalNet.Query{
+				Method: keyvalNet.Insert,
+				Key:    "key",
+				Value:  []byte("value"),
+			}); err != nil {
+				b.Fatal(err)
+			}
+			var res keyvalNet.Result
+			if err := gob.NewDecoder(conn).Decode(&res); err != nil {
+				b.Fatal(err)
+			}
+			conn.Close()
+		}
+	})
+}
+
+// BenchmarkPersistentConn uses the same connection, waiting for each result
+// before sending the next query.
+func BenchmarkPersistentConn(b *testing.B) {
+	benchmarkConn(b, 9026, func(b *testing.B, addr string) {
+		conn, err := net.Dial("tcp", addr)
+		if err != nil {
+			b.Fatal(err)
+		}
+		defer conn.Close()
+
+		enc, dec := gob.NewEncoder(conn), gob.NewDecoder(conn)
+		for i := 0; i < b.N; i++ {
+			if err := enc.Encode(keyvalNet.Query{
+				ID:     uint64(i),
+				Method: keyvalNet.Insert,
+				Key:    "key",
+				Value:  []byte("value"),
+			}); err != nil {
+				b.Fatal(err)
+			}
+			var res keyvalNet.Result
+			if err := dec.Decode(&res); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+// BenchmarkPipelinedConn uses the same connection, sending queries without
+// waiting for the results.
+func BenchmarkPipelinedConn(b *testing.B) {
+	benchmarkConn(b, 9027, func(b *testing.B, addr string) {
+		conn, err := net.Dial("tcp", addr)
+		if err != nil {
+			b.Fatal(err)
+		}
+		defer conn.Close()
+
+		errs := make(chan error, 1)
+		go func() {
+			writer := bufio.NewWriter(conn)
+			enc := gob.NewEncoder(writer)
+			for i := 0; i < b.N; i++ {
+				if err := enc.Encode(keyvalNet.Query{
+					ID:     uint64(i),
+					Method: keyvalNet.Insert,
+					Key:    "key",
+					Value:  []byte("value"),
+				}); err != nil {
+					errs <- err
+					return
+				}
+			}
+			errs <- writer.Flush()
+		}()
+
+		dec := gob.NewDecoder(conn)
+		for i := 0; i < b.N; i++ {
+			var res keyvalNet.Result
+			if err := dec.Decode(&res); err != nil {
+				b.Fatal(err)
+			}
+		}
+		if err := <-errs; err != nil {
+			b.Fatal(err)
+		}
+	})
+}
+
 func setupServer(server *Server, port int) net.Listener {
 	apiListener, err := net.Listen("tcp", fmt.Sprintf("0.0.0.0:%d", port))
 	if err != nil {
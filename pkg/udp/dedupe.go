@@ -0,0 +1,124 @@
+package udp
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// dedupeKey identifies a query, the IDs of different clients can clash so the
+// address of the client is part of the key.
+type dedupeKey struct {
+	addr string
+	id   uint64
+}
+
+type dedupeEntry struct {
+	key     dedupeKey
+	result  []byte
+	done    bool
+	expires time.Time
+}
+
+// dedupe remembers the results of queries, so that a query that's sent again
+// by a client (because the result was lost) gets the same result back rather
+// than being handled a second time. The results are bounded by both the amount
+// of them and their size, the oldest results are forgotten first.
+type dedupe struct {
+	maxEntries int
+	maxBytes   int
+	ttl        time.Duration
+
+	mutex   sync.Mutex
+	entries map[dedupeKey]*list.Element
+	order   *list.List
+	bytes   int
+}
+
+func newDedupe(maxEntries, maxBytes int, ttl time.Duration) *dedupe {
+	return &dedupe{
+		maxEntries: maxEntries,
+		maxBytes:   maxBytes,
+		ttl:        ttl,
+		entries:    make(map[dedupeKey]*list.Element),
+		order:      list.New(),
+	}
+}
+
+// Begin marks the query as being handled. If the query has already been
+// handled then the result is returned, if it's still being handled then ok is
+// false and the query should be ignored.
+func (d *dedupe) Begin(key dedupeKey) (result []byte, ok bool) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	now := time.Now()
+	if element, exists := d.entries[key]; exists {
+		entry := element.Value.(*dedupeEntry)
+		if now.Before(entry.expires) {
+			if !entry.done {
+				return nil, false
+			}
+			return entry.result, true
+		}
+		d.remove(element)
+	}
+
+	d.entries[key] = d.order.PushBack(&dedupeEntry{
+		key:     key,
+		expires: now.Add(d.ttl),
+	})
+	d.evict()
+	return nil, true
+}
+
+// Finish records the result of the query, so that it can be sent again.
+func (d *dedupe) Finish(key dedupeKey, result []byte) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	element, ok := d.entries[key]
+	if !ok {
+		return
+	}
+	entry := element.Value.(*dedupeEntry)
+	if len(result) > d.maxBytes {
+		// A result that's too large to remember is forgotten straight away.
+		d.remove(element)
+		return
+	}
+
+	entry.result = result
+	entry.done = true
+	d.bytes += len(result)
+	d.evict()
+}
+
+// Forget the query, so that if it's sent again it's handled as if it's new.
+func (d *dedupe) Forget(key dedupeKey) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	if element, ok := d.entries[key]; ok {
+		d.remove(element)
+	}
+}
+
+// Len returns the amount of queries that are remembered.
+func (d *dedupe) Len() int {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	return d.order.Len()
+}
+
+func (d *dedupe) evict() {
+	for d.order.Len() > d.maxEntries || d.bytes > d.maxBytes {
+		d.remove(d.order.Front())
+	}
+}
+
+func (d *dedupe) remove(element *list.Element) {
+	entry := d.order.Remove(element).(*dedupeEntry)
+	delete(d.entries, entry.key)
+	d.bytes -= len(entry.result)
+}
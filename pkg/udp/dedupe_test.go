@@ -0,0 +1,96 @@
+package udp
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func TestDedupe(t *testing.T) {
+	t.Parallel()
+
+	t.Run("pending then done", func(t *testing.T) {
+		d := newDedupe(10, 1024, time.Minute)
+		key := dedupeKey{"addr", 1}
+
+		if result, ok := d.Begin(key); !ok || result != nil {
+			t.Errorf("expected: new, actual: %v, %v", result, ok)
+		}
+		if _, ok := d.Begin(key); ok {
+			t.Errorf("expected: pending, actual: %v", ok)
+		}
+
+		d.Finish(key, []byte("result"))
+		result, ok := d.Begin(key)
+		if expected, actual := []byte("result"), result; !ok || !bytes.Equal(expected, actual) {
+			t.Errorf("expected: %s, actual: %s", expected, actual)
+		}
+	})
+
+	t.Run("addresses are separate", func(t *testing.T) {
+		d := newDedupe(10, 1024, time.Minute)
+
+		d.Begin(dedupeKey{"a", 1})
+		d.Finish(dedupeKey{"a", 1}, []byte("result"))
+		if result, ok := d.Begin(dedupeKey{"b", 1}); !ok || result != nil {
+			t.Errorf("expected: new, actual: %v, %v", result, ok)
+		}
+	})
+
+	t.Run("bounded by entries", func(t *testing.T) {
+		d := newDedupe(2, 1024, time.Minute)
+		for id := uint64(1); id <= 3; id++ {
+			d.Begin(dedupeKey{"addr", id})
+			d.Finish(dedupeKey{"addr", id}, []byte("result"))
+		}
+		if expected, actual := 2, d.Len(); expected != actual {
+			t.Errorf("expected: %v, actual: %v", expected, actual)
+		}
+		// The oldest is forgotten first.
+		if result, ok := d.Begin(dedupeKey{"addr", 1}); !ok || result != nil {
+			t.Errorf("expected: new, actual: %v, %v", result, ok)
+		}
+	})
+
+	t.Run("bounded by bytes", func(t *testing.T) {
+		d := newDedupe(10, 8, time.Minute)
+
+		d.Begin(dedupeKey{"addr", 1})
+		d.Finish(dedupeKey{"addr", 1}, []byte("abcdef"))
+		d.Begin(dedupeKey{"addr", 2})
+		d.Finish(dedupeKey{"addr", 2}, []byte("abcdef"))
+		if expected, actual := 1, d.Len(); expected != actual {
+			t.Errorf("expected: %v, actual: %v", expected, actual)
+		}
+
+		// A result that's too large isn't remembered at all.
+		d.Begin(dedupeKey{"addr", 3})
+		d.Finish(dedupeKey{"addr", 3}, make([]byte, 9))
+		if result, ok := d.Begin(dedupeKey{"addr", 3}); !ok || result != nil {
+			t.Errorf("expected: new, actual: %v, %v", result, ok)
+		}
+	})
+
+	t.Run("forget", func(t *testing.T) {
+		d := newDedupe(10, 1024, time.Minute)
+		key := dedupeKey{"addr", 1}
+
+		d.Begin(key)
+		d.Forget(key)
+		if result, ok := d.Begin(key); !ok || result != nil {
+			t.Errorf("expected: new, actual: %v, %v", result, ok)
+		}
+	})
+
+	t.Run("expires", func(t *testing.T) {
+		d := newDedupe(10, 1024, time.Millisecond)
+		key := dedupeKey{"addr", 1}
+
+		d.Begin(key)
+		d.Finish(key, []byte("result"))
+		time.Sleep(time.Millisecond * 5)
+		if result, ok := d.Begin(key); !ok || result != nil {
+			t.Errorf("expected: new, actual: %v, %v", result, ok)
+		}
+	})
+}
@@ -2,175 +2,584 @@ package udp
 
 import (
 	"bytes"
-	"encoding/gob"
-	"io"
 	"net"
+	"runtime"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	keyvalNet "github.com/SimonRichardson/keyval/pkg/net"
 	"github.com/SimonRichardson/keyval/pkg/store"
 	"github.com/go-kit/kit/log"
 	"github.com/go-kit/kit/log/level"
+	"github.com/pkg/errors"
 )
 
+const (
+	defaultDedupeSize     = 10000
+	defaultDedupeMaxBytes = 16 * 1024 * 1024
+	defaultDedupeTTL      = 30 * time.Second
+
+	// DefaultQueueSize is how many queries can wait to be handled, before
+	// queries are shed.
+	DefaultQueueSize = 1024
+)
+
+// Shedding is what happens to a query that arrives when the queue is full
+type Shedding int
+
+const (
+	// ShedReply replies to the query with a ServerError, so the client finds
+	// out straight away that the server is overloaded.
+	ShedReply Shedding = iota
+	// ShedDrop drops the query without replying, so the client sends the
+	// query again once it has waited for the result.
+	ShedDrop
+)
+
+func (s Shedding) String() string {
+	switch s {
+	case ShedDrop:
+		return "drop"
+	default:
+		return "reply"
+	}
+}
+
+// ParseShedding returns the Shedding for the name.
+func ParseShedding(name string) (Shedding, error) {
+	for _, s := range []Shedding{ShedReply, ShedDrop} {
+		if strings.ToLower(name) == s.String() {
+			return s, nil
+		}
+	}
+	return 0, errors.Errorf("%s: unsupported shedding", name)
+}
+
+// Stats represents how the server is coping with the queries it's sent
+type Stats struct {
+	Workers    int    `json:"workers"`
+	QueueDepth int    `json:"queue_depth"`
+	QueueSize  int    `json:"queue_size"`
+	Handled    uint64 `json:"handled"`
+	Shed       uint64 `json:"shed"`
+	Dropped    uint64 `json:"dropped"`
+}
+
 type client struct {
-	Addr  *net.UDPAddr
-	Query keyvalNet.Query
+	Addr      *net.UDPAddr
+	ID        uint64
+	Query     keyvalNet.Query
+	Codec     keyvalNet.Codec
+	Handshake bool
+	Unframed  bool
 }
 
+// Server represents a way to interact with the underlying key/val store over
+// udp. Queries and results are split into frames (see keyvalNet.Frame), so that
+// values larger than a datagram can be sent. The frames of a query are put
+// back together before the query is handled and the result is sent back as
+// frames with the same ID as the query. A datagram that isn't a frame is
+// handled as a whole gob query, as sent by clients from before queries were
+// framed, and the result is sent back without any frames.
+//
+// A query can start with a handshake to pick the codec (see
+// keyvalNet.Codec), the result is then sent back with the same handshake.
+// Queries without a handshake use gob.
+//
+// Clients send a query again when the result doesn't arrive, so the results
+// of queries that change the store are remembered by the address of the
+// client and the ID of the query. A query that's sent again gets the same
+// result, rather than being handled twice.
+//
+// Queries are handled by a fixed amount of workers, queries wait in a bounded
+// queue for a worker to be free. Once the queue is full, any more queries are
+// shed until the workers catch up.
 type Server struct {
-	store   store.Store
-	clients chan client
-	stop    chan chan struct{}
-	logger  log.Logger
+	store  store.Store
+	stop   chan chan struct{}
+	done   chan struct{}
+	logger log.Logger
+
+	// FrameSize is the size of the frames that results are split into.
+	FrameSize int
+	// MaxMessageSize is the largest query that is accepted.
+	MaxMessageSize int
+	// MaxReassemblyBytes is the most memory used for putting the frames of
+	// queries back together at any one time.
+	MaxReassemblyBytes int
+	// ReassemblyTimeout is how long the frames of a query can take to arrive.
+	ReassemblyTimeout time.Duration
+	// DedupeSize is how many results are remembered, zero disables it.
+	DedupeSize int
+	// DedupeMaxBytes is the most memory used for remembering results.
+	DedupeMaxBytes int
+	// DedupeTTL is how long a result is remembered for, clients have to send
+	// a query again within this time for it to not be handled twice.
+	DedupeTTL time.Duration
+	// Workers is how many queries are handled at the same time.
+	Workers int
+	// QueueSize is how many queries can wait for a worker.
+	QueueSize int
+	// Shedding is what happens to queries once the queue is full.
+	Shedding Shedding
+
+	depth   int64
+	handled uint64
+	shed    uint64
+	dropped uint64
 }
 
 // NewServer creates a Server with the correct dependencies
 func NewServer(store store.Store, logger log.Logger) *Server {
 	return &Server{
-		store:   store,
-		clients: make(chan client, 100),
-		stop:    make(chan chan struct{}),
-		logger:  logger,
+		store:              store,
+		stop:               make(chan chan struct{}),
+		done:               make(chan struct{}),
+		logger:             logger,
+		FrameSize:          keyvalNet.DefaultFrameSize,
+		MaxMessageSize:     keyvalNet.DefaultMaxMessageSize,
+		MaxReassemblyBytes: keyvalNet.DefaultMaxReassemblyBytes,
+		ReassemblyTimeout:  keyvalNet.DefaultReassemblyTimeout,
+		DedupeSize:         defaultDedupeSize,
+		DedupeMaxBytes:     defaultDedupeMaxBytes,
+		DedupeTTL:          defaultDedupeTTL,
+		Workers:            runtime.NumCPU(),
+		QueueSize:          DefaultQueueSize,
+		Shedding:           ShedReply,
 	}
 }
 
-// Serve the listener for the server
+// Serve the listener for the server, until the server is stopped or the
+// listener is closed.
 func (s *Server) Serve(conn *net.UDPConn) error {
-	go s.handleClients(conn)
-	go s.handleRequests(conn)
+	defer close(s.done)
 
-	for {
-		select {
-		case q := <-s.stop:
-			close(q)
-			return nil
-		}
+	// The buffer is best effort, a smaller buffer only means frames are
+	// more likely to be dropped.
+	conn.SetReadBuffer(keyvalNet.DefaultReadBufferSize)
+
+	var (
+		reassembler = keyvalNet.NewReassembler(s.MaxMessageSize, s.MaxReassemblyBytes, s.ReassemblyTimeout)
+		dedupe      = newDedupe(s.DedupeSize, s.DedupeMaxBytes, s.DedupeTTL)
+		queue       = make(chan client, s.QueueSize)
+		stopping    = make(chan struct{})
+		reading     = make(chan struct{})
+		workers     sync.WaitGroup
+	)
+
+	for i := 0; i < s.Workers || i == 0; i++ {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			s.handleClients(conn, queue, dedupe)
+		}()
+	}
+	go func() {
+		defer close(reading)
+		s.handleRequests(conn, queue, reassembler, dedupe, stopping)
+	}()
+
+	// Once the server stops reading, the queries already in the queue are
+	// still handled before returning.
+	var q chan struct{}
+	select {
+	case q = <-s.stop:
+		close(stopping)
+		conn.SetReadDeadline(time.Now())
+		<-reading
+	case <-reading:
 	}
+	close(queue)
+	workers.Wait()
+
+	if q != nil {
+		close(q)
+	}
+	return nil
 }
 
-// Stop the server
+// Stop the server from reading any more queries, waiting for the queries that
+// have already been read to be handled.
 func (s *Server) Stop() {
 	q := make(chan struct{})
-	s.stop <- q
-	<-q
+	select {
+	case s.stop <- q:
+		<-q
+	case <-s.done:
+	}
 }
 
-func (s *Server) handleClients(conn *net.UDPConn) {
-	for {
-		select {
-		case client := <-s.clients:
-			addr := client.Addr
-			query := client.Query
-
-			var res bytes.Buffer
-			switch query.Method {
-			case keyvalNet.Select:
-				s.handleSelect(&res, query)
-			case keyvalNet.Insert:
-				s.handleInsert(&res, query)
-			case keyvalNet.Delete:
-				s.handleDelete(&res, query)
-			default:
-				// send error
-				write(conn, keyvalNet.NotFound)
-			}
+// Stats returns how the server is coping with the queries it's sent.
+func (s *Server) Stats() Stats {
+	return Stats{
+		Workers:    s.Workers,
+		QueueDepth: int(atomic.LoadInt64(&s.depth)),
+		QueueSize:  s.QueueSize,
+		Handled:    atomic.LoadUint64(&s.handled),
+		Shed:       atomic.LoadUint64(&s.shed),
+		Dropped:    atomic.LoadUint64(&s.dropped),
+	}
+}
+
+func (s *Server) handleClients(conn *net.UDPConn, queue <-chan client, dedupe *dedupe) {
+	for client := range queue {
+		atomic.AddInt64(&s.depth, -1)
 
+		addr := client.Addr
+		query := client.Query
+
+		var res bytes.Buffer
+		enc := newEncoder(&res, client.Codec, client.Handshake)
+		switch query.Method {
+		case keyvalNet.Select:
+			s.handleSelect(enc, query)
+		case keyvalNet.Insert:
+			s.handleInsert(enc, query)
+		case keyvalNet.Delete:
+			s.handleDelete(enc, query)
+		case keyvalNet.CompareAndSwap:
+			s.handleCompareAndSwap(enc, query)
+		case keyvalNet.CompareAndDelete:
+			s.handleCompareAndDelete(enc, query)
+		default:
+			// send error
+			write(enc, keyvalNet.NotFound)
+		}
+		atomic.AddUint64(&s.handled, 1)
+
+		if client.Unframed {
 			if _, err := conn.WriteToUDP(res.Bytes(), addr); err != nil {
 				level.Warn(s.logger).Log("err", err)
-				return
 			}
+			continue
+		}
+
+		if query.Method != keyvalNet.Select {
+			dedupe.Finish(dedupeKey{addr.String(), client.ID}, res.Bytes())
+		}
+		if err := s.writeFrames(conn, addr, client.ID, res.Bytes()); err != nil {
+			level.Warn(s.logger).Log("err", err)
 		}
 	}
 }
 
-func (s *Server) handleRequests(conn *net.UDPConn) {
+func (s *Server) handleRequests(conn *net.UDPConn, queue chan<- client, reassembler *keyvalNet.Reassembler, dedupe *dedupe, stopping <-chan struct{}) {
+	// The buffer is reused for every datagram, as the reassembler copies
+	// anything it holds on to.
+	buf := make([]byte, keyvalNet.MaxFrameSize)
 	for {
-		var buf [1024]byte
+		n, addr, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			select {
+			case <-stopping:
+				return
+			default:
+			}
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			continue
+		}
 
-		n, addr, err := conn.ReadFromUDP(buf[0:])
+		frame, err := keyvalNet.ParseFrame(buf[:n])
 		if err != nil {
+			// A gob stream never starts with the frame version, so it's a
+			// query from a client that doesn't frame its queries.
+			s.handleUnframed(conn, queue, addr, buf[:n])
 			continue
 		}
 
-		dec := gob.NewDecoder(bytes.NewBuffer(buf[0:n]))
-		var query keyvalNet.Query
-		if err := dec.Decode(&query); err != nil {
-			var res bytes.Buffer
-			write(&res, keyvalNet.ServerError)
-			if _, err := conn.WriteToUDP(res.Bytes(), addr); err != nil {
-				level.Warn(s.logger).Log("err", err)
+		message, ok, err := reassembler.Add(addr.String(), frame)
+		if err != nil {
+			status := keyvalNet.ServerError
+			if err == keyvalNet.ErrMessageTooLarge {
+				status = keyvalNet.BadRequest
+			}
+			codec, handshake, err := frameCodec(frame)
+			if err != nil {
+				s.writeUnknownCodec(conn, addr, frame.ID)
 				continue
 			}
+			s.writeStatus(conn, addr, frame.ID, codec, handshake, status)
+			continue
+		}
+		if !ok {
+			continue
 		}
 
-		go func() {
-			s.clients <- client{
-				Addr:  addr,
-				Query: query,
+		codec, message, handshake, err := keyvalNet.SplitHandshake(message)
+		if err != nil {
+			s.writeUnknownCodec(conn, addr, frame.ID)
+			continue
+		}
+		var query keyvalNet.Query
+		if err := keyvalNet.NewDecoderSize(codec, bytes.NewReader(message), s.MaxMessageSize).Decode(&query); err != nil {
+			s.writeStatus(conn, addr, frame.ID, codec, handshake, keyvalNet.ServerError)
+			continue
+		}
+
+		// Selects don't change the store, so it doesn't matter if they're
+		// handled more than once.
+		key := dedupeKey{addr.String(), frame.ID}
+		if query.Method != keyvalNet.Select {
+			result, ok := dedupe.Begin(key)
+			if !ok {
+				// The query is still being handled.
+				continue
 			}
-		}()
+			if result != nil {
+				if err := s.writeFrames(conn, addr, frame.ID, result); err != nil {
+					level.Warn(s.logger).Log("err", err)
+				}
+				continue
+			}
+		}
+
+		atomic.AddInt64(&s.depth, 1)
+		select {
+		case queue <- client{
+			Addr:      addr,
+			ID:        frame.ID,
+			Query:     query,
+			Codec:     codec,
+			Handshake: handshake,
+		}:
+			continue
+		default:
+		}
+
+		// The queue is full, so the query is shed. The query wasn't handled,
+		// so it mustn't be remembered as if it had been.
+		atomic.AddInt64(&s.depth, -1)
+		atomic.AddUint64(&s.shed, 1)
+		dedupe.Forget(key)
+		if s.Shedding == ShedReply {
+			s.writeStatus(conn, addr, frame.ID, codec, handshake, keyvalNet.ServerError)
+		}
 	}
 }
 
-func (s *Server) handleSelect(w io.Writer, q keyvalNet.Query) {
+// handleUnframed handles a query from a client that sends the whole of a gob
+// query in a single datagram without any frames, as clients did before queries
+// were framed. The result is sent back the same way, so it has to fit within a
+// datagram, and the query isn't deduplicated as it has no ID.
+func (s *Server) handleUnframed(conn *net.UDPConn, queue chan<- client, addr *net.UDPAddr, b []byte) {
+	var query keyvalNet.Query
+	if err := keyvalNet.NewDecoderSize(keyvalNet.Gob, bytes.NewReader(b), s.MaxMessageSize).Decode(&query); err != nil {
+		// It's neither a frame nor a query.
+		level.Debug(s.logger).Log("err", err)
+		atomic.AddUint64(&s.dropped, 1)
+		return
+	}
+
+	atomic.AddInt64(&s.depth, 1)
+	select {
+	case queue <- client{
+		Addr:     addr,
+		Query:    query,
+		Codec:    keyvalNet.Gob,
+		Unframed: true,
+	}:
+		return
+	default:
+	}
+
+	atomic.AddInt64(&s.depth, -1)
+	atomic.AddUint64(&s.shed, 1)
+	if s.Shedding == ShedReply {
+		var res bytes.Buffer
+		write(keyvalNet.NewCodecEncoder(keyvalNet.Gob, &res), keyvalNet.ServerError)
+		if _, err := conn.WriteToUDP(res.Bytes(), addr); err != nil {
+			level.Warn(s.logger).Log("err", err)
+		}
+	}
+}
+
+// writeFrames splits the result into frames and sends them to the address.
+func (s *Server) writeFrames(conn *net.UDPConn, addr *net.UDPAddr, id uint64, result []byte) error {
+	frames, err := keyvalNet.Fragment(id, result, s.FrameSize)
+	if err != nil {
+		return err
+	}
+
+	buf := make([]byte, 0, s.FrameSize)
+	for _, frame := range frames {
+		if _, err := conn.WriteToUDP(frame.AppendTo(buf[:0]), addr); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *Server) writeStatus(conn *net.UDPConn, addr *net.UDPAddr, id uint64, codec keyvalNet.Codec, handshake bool, status keyvalNet.Status) {
+	var res bytes.Buffer
+	write(newEncoder(&res, codec, handshake), status)
+	if err := s.writeFrames(conn, addr, id, res.Bytes()); err != nil {
+		level.Warn(s.logger).Log("err", err)
+	}
+}
+
+// writeUnknownCodec replies with a handshake with an ID of zero, so that the
+// client knows the codec isn't supported.
+func (s *Server) writeUnknownCodec(conn *net.UDPConn, addr *net.UDPAddr, id uint64) {
+	if err := s.writeFrames(conn, addr, id, []byte{keyvalNet.HandshakeMagic, 0}); err != nil {
+		level.Warn(s.logger).Log("err", err)
+	}
+}
+
+func (s *Server) handleSelect(enc keyvalNet.Encoder, q keyvalNet.Query) {
 	// useful metrics
 	begin := time.Now()
 
 	// Validate user input.
 	var qp keyvalNet.QueryParams
 	if err := qp.DecodeFrom(q); err != nil {
-		write(w, keyvalNet.BadRequest)
+		write(enc, keyvalNet.BadRequest)
 		return
 	}
 
-	value, ok := s.store.Get(qp.Key)
+	entry, ok := s.store.Select(qp.Key)
 	if !ok {
-		write(w, keyvalNet.NotFound)
+		write(enc, keyvalNet.NotFound)
 		return
 	}
 
 	qr := keyvalNet.SelectQueryResult{Params: qp}
-	qr.Value = value
+	qr.Value = entry.Value
+	qr.Version = entry.Version
+	qr.ContentType = entry.Metadata.ContentType
+	qr.ContentEncoding = entry.Metadata.ContentEncoding
+	qr.Metadata = entry.Metadata.User
 
 	// Finish
 	qr.Duration = time.Since(begin).String()
-	qr.EncodeTo(w)
+	qr.EncodeTo(enc)
 }
 
-func (s *Server) handleInsert(w io.Writer, q keyvalNet.Query) {
+func (s *Server) handleInsert(enc keyvalNet.Encoder, q keyvalNet.Query) {
 	// useful metrics
 	begin := time.Now()
 
 	// Validate user input.
 	var qp keyvalNet.QueryParams
 	if err := qp.DecodeFrom(q); err != nil {
-		write(w, keyvalNet.BadRequest)
+		write(enc, keyvalNet.BadRequest)
+		return
+	}
+
+	// A value that can never fit with in a bounded store is refused.
+	if !store.Fits(s.store, qp.Key, q.Value, metadataOf(qp)) {
+		write(enc, keyvalNet.ServerError)
 		return
 	}
 
 	qr := keyvalNet.InsertQueryResult{Params: qp}
-	qr.Created = s.store.Set(qp.Key, q.Value)
+	// The store reports whether the value replaced an existing value, so the
+	// value is only created if it didn't, the same as a compare and swap.
+	var replaced bool
+	if metadata := metadataOf(qp); !metadata.IsZero() {
+		replaced = s.store.SetWithMetadata(qp.Key, q.Value, qp.TTL, metadata)
+	} else if qp.TTL > 0 {
+		replaced = s.store.SetWithTTL(qp.Key, q.Value, qp.TTL)
+	} else {
+		replaced = s.store.Set(qp.Key, q.Value)
+	}
+	qr.Created = !replaced
+	if err := store.Err(s.store); err != nil {
+		s.failed(enc, err)
+		return
+	}
 
 	// Finish
 	qr.Duration = time.Since(begin).String()
-	qr.EncodeTo(w)
+	qr.EncodeTo(enc)
 }
 
-func (s *Server) handleDelete(w io.Writer, q keyvalNet.Query) {
+func (s *Server) handleDelete(enc keyvalNet.Encoder, q keyvalNet.Query) {
 	// useful metrics
 	begin := time.Now()
 
 	// Validate user input.
 	var qp keyvalNet.QueryParams
 	if err := qp.DecodeFrom(q); err != nil {
-		write(w, keyvalNet.BadRequest)
+		write(enc, keyvalNet.BadRequest)
 		return
 	}
 
 	ok := s.store.Delete(qp.Key)
-	if !ok {
-		write(w, keyvalNet.NotFound)
+	if err := store.Err(s.store); err != nil {
+		s.failed(enc, err)
+		return
+	} else if !ok {
+		write(enc, keyvalNet.NotFound)
+		return
+	}
+
+	qr := keyvalNet.DeleteQueryResult{Params: qp}
+
+	// Finish
+	qr.Duration = time.Since(begin).String()
+	qr.EncodeTo(enc)
+}
+
+func (s *Server) handleCompareAndSwap(enc keyvalNet.Encoder, q keyvalNet.Query) {
+	// useful metrics
+	begin := time.Now()
+
+	// Validate user input.
+	var qp keyvalNet.QueryParams
+	if err := qp.DecodeFrom(q); err != nil {
+		write(enc, keyvalNet.BadRequest)
+		return
+	}
+
+	// A value that can never fit with in a bounded store is refused.
+	if !store.Fits(s.store, qp.Key, q.Value, metadataOf(qp)) {
+		write(enc, keyvalNet.ServerError)
+		return
+	}
+
+	// The ttl and metadata replace those of the existing value, the same as an
+	// insert.
+	version, ok := store.CompareAndSwapWithMetadata(s.store, qp.Key, qp.Version, q.Value, qp.TTL, metadataOf(qp))
+	if err := store.Err(s.store); err != nil {
+		s.failed(enc, err)
+		return
+	} else if !ok {
+		write(enc, keyvalNet.Conflict)
+		return
+	}
+
+	qr := keyvalNet.InsertQueryResult{Params: qp}
+	// A version of zero only matches a missing value, so the value is only
+	// created then, the same as an insert.
+	qr.Created = qp.Version == 0
+	qr.Version = version
+
+	// Finish
+	qr.Duration = time.Since(begin).String()
+	qr.EncodeTo(enc)
+}
+
+func (s *Server) handleCompareAndDelete(enc keyvalNet.Encoder, q keyvalNet.Query) {
+	// useful metrics
+	begin := time.Now()
+
+	// Validate user input.
+	var qp keyvalNet.QueryParams
+	if err := qp.DecodeFrom(q); err != nil {
+		write(enc, keyvalNet.BadRequest)
+		return
+	}
+
+	ok := s.store.CompareAndDelete(qp.Key, qp.Version)
+	if err := store.Err(s.store); err != nil {
+		s.failed(enc, err)
+		return
+	} else if !ok {
+		write(enc, keyvalNet.Conflict)
 		return
 	}
 
@@ -178,11 +587,26 @@ func (s *Server) handleDelete(w io.Writer, q keyvalNet.Query) {
 
 	// Finish
 	qr.Duration = time.Since(begin).String()
-	qr.EncodeTo(w)
+	qr.EncodeTo(enc)
+}
+
+// failed reports a write that the store couldn't apply, even though the write
+// itself is valid.
+func (s *Server) failed(enc keyvalNet.Encoder, err error) {
+	level.Error(s.logger).Log("err", err)
+	write(enc, keyvalNet.ServerError)
 }
 
-func write(w io.Writer, status keyvalNet.Status) {
-	enc := gob.NewEncoder(w)
+// metadataOf returns the metadata of the value of the query.
+func metadataOf(qp keyvalNet.QueryParams) store.Metadata {
+	return store.Metadata{
+		ContentType:     qp.ContentType,
+		ContentEncoding: qp.ContentEncoding,
+		User:            qp.Metadata,
+	}
+}
+
+func write(enc keyvalNet.Encoder, status keyvalNet.Status) {
 	if err := enc.Encode(keyvalNet.Result{
 		Status: status,
 		Value:  []byte{},
@@ -190,3 +614,23 @@ func write(w io.Writer, status keyvalNet.Status) {
 		panic(err)
 	}
 }
+
+// newEncoder creates an encoder for the result, if the query started with a
+// handshake then so does the result.
+func newEncoder(res *bytes.Buffer, codec keyvalNet.Codec, handshake bool) keyvalNet.Encoder {
+	if handshake {
+		res.Write(keyvalNet.Handshake(codec))
+	}
+	return keyvalNet.NewCodecEncoder(codec, res)
+}
+
+// frameCodec returns the codec of a query from one of its frames, for when
+// the query can't be put back together. Only the first frame holds the
+// handshake, so the codec of any other frame is assumed to be gob.
+func frameCodec(frame keyvalNet.Frame) (keyvalNet.Codec, bool, error) {
+	if frame.Index != 0 {
+		return keyvalNet.Gob, false, nil
+	}
+	codec, _, handshake, err := keyvalNet.SplitHandshake(frame.Fragment)
+	return codec, handshake, err
+}
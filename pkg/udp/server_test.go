@@ -9,8 +9,10 @@ import (
 	"reflect"
 	"sync"
 	"testing"
+	"time"
 
 	keyvalNet "github.com/SimonRichardson/keyval/pkg/net"
+	keyvalStore "github.com/SimonRichardson/keyval/pkg/store"
 	"github.com/SimonRichardson/keyval/pkg/store/mocks"
 	"github.com/go-kit/kit/log"
 	"github.com/golang/mock/gomock"
@@ -39,18 +41,12 @@ func TestAPISelect(t *testing.T) {
 		key := buildKey([]byte("abc"))
 		value := []byte("def")
 
-		store.EXPECT().Get(key).Return(value, true)
+		store.EXPECT().Select(key).Return(keyvalStore.Entry{Key: key, Value: value, Version: 1}, true)
 
-		var buf bytes.Buffer
-		enc := gob.NewEncoder(&buf)
-		if err := enc.Encode(keyvalNet.Query{
+		writeQuery(t, client, 1, keyvalNet.Query{
 			Method: keyvalNet.Select,
 			Key:    key,
-		}); err != nil {
-			t.Fatal(err)
-		}
-
-		client.Write(buf.Bytes())
+		})
 
 		var wg sync.WaitGroup
 		wg.Add(1)
@@ -58,16 +54,7 @@ func TestAPISelect(t *testing.T) {
 		go func() {
 			defer wg.Done()
 
-			var buf [512]byte
-			if _, err := client.Read(buf[0:]); err != nil {
-				t.Fatal(err)
-			}
-
-			var res keyvalNet.Result
-			dec := gob.NewDecoder(bytes.NewBuffer(buf[:]))
-			if err := dec.Decode(&res); err != nil {
-				t.Fatal(err)
-			}
+			res := readResult(t, client, 1)
 
 			if expected, actual := keyvalNet.OK, res.Status; expected != actual {
 				t.Errorf("expected: %v, actual: %v", expected, actual)
@@ -104,19 +91,13 @@ func TestAPIInsert(t *testing.T) {
 		key := buildKey([]byte("abc"))
 		value := []byte("def")
 
-		store.EXPECT().Set(key, value).Return(true)
+		store.EXPECT().Set(key, value).Return(false)
 
-		var buf bytes.Buffer
-		enc := gob.NewEncoder(&buf)
-		if err := enc.Encode(keyvalNet.Query{
+		writeQuery(t, client, 1, keyvalNet.Query{
 			Method: keyvalNet.Insert,
 			Key:    key,
 			Value:  value,
-		}); err != nil {
-			t.Fatal(err)
-		}
-
-		client.Write(buf.Bytes())
+		})
 
 		var wg sync.WaitGroup
 		wg.Add(1)
@@ -124,16 +105,7 @@ func TestAPIInsert(t *testing.T) {
 		go func() {
 			defer wg.Done()
 
-			var buf [512]byte
-			if _, err := client.Read(buf[0:]); err != nil {
-				t.Fatal(err)
-			}
-
-			var res keyvalNet.Result
-			dec := gob.NewDecoder(bytes.NewBuffer(buf[:]))
-			if err := dec.Decode(&res); err != nil {
-				t.Fatal(err)
-			}
+			res := readResult(t, client, 1)
 
 			if expected, actual := keyvalNet.Created, res.Status; expected != actual {
 				t.Errorf("expected: %v, actual: %v", expected, actual)
@@ -142,6 +114,46 @@ func TestAPIInsert(t *testing.T) {
 
 		wg.Wait()
 	})
+
+	t.Run("insert with metadata", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+
+		store := mocks.NewMockStore(ctrl)
+
+		port := 9065
+
+		// Setup server
+		server := NewServer(store, log.NewNopLogger())
+		listener, _ := setupServer(server, port)
+		defer listener.Close()
+
+		// Setup a client
+		client := setupClient(port)
+		defer client.Close()
+
+		key := buildKey([]byte("abc"))
+		value := []byte("def")
+
+		store.EXPECT().SetWithMetadata(key, value, time.Duration(0), keyvalStore.Metadata{
+			ContentEncoding: "gzip",
+			User:            map[string]string{"owner": "a"},
+		}).Return(true)
+
+		writeQuery(t, client, 1, keyvalNet.Query{
+			Method:          keyvalNet.Insert,
+			Key:             key,
+			Value:           value,
+			ContentEncoding: "gzip",
+			Metadata:        map[string]string{"owner": "a"},
+		})
+
+		res := readResult(t, client, 1)
+
+		if expected, actual := keyvalNet.OK, res.Status; expected != actual {
+			t.Errorf("expected: %v, actual: %v", expected, actual)
+		}
+	})
 }
 
 func TestAPIDelete(t *testing.T) {
@@ -168,16 +180,10 @@ func TestAPIDelete(t *testing.T) {
 
 		store.EXPECT().Delete(key).Return(true)
 
-		var buf bytes.Buffer
-		enc := gob.NewEncoder(&buf)
-		if err := enc.Encode(keyvalNet.Query{
+		writeQuery(t, client, 1, keyvalNet.Query{
 			Method: keyvalNet.Delete,
 			Key:    key,
-		}); err != nil {
-			t.Fatal(err)
-		}
-
-		client.Write(buf.Bytes())
+		})
 
 		var wg sync.WaitGroup
 		wg.Add(1)
@@ -185,16 +191,7 @@ func TestAPIDelete(t *testing.T) {
 		go func() {
 			defer wg.Done()
 
-			var buf [512]byte
-			if _, err := client.Read(buf[0:]); err != nil {
-				t.Fatal(err)
-			}
-
-			var res keyvalNet.Result
-			dec := gob.NewDecoder(bytes.NewBuffer(buf[:]))
-			if err := dec.Decode(&res); err != nil {
-				t.Fatal(err)
-			}
+			res := readResult(t, client, 1)
 
 			if expected, actual := keyvalNet.OK, res.Status; expected != actual {
 				t.Errorf("expected: %v, actual: %v", expected, actual)
@@ -205,6 +202,367 @@ func TestAPIDelete(t *testing.T) {
 	})
 }
 
+func TestAPILargeValues(t *testing.T) {
+	t.Parallel()
+
+	port := 9014
+
+	// Setup server
+	server := NewServer(keyvalStore.New(), log.NewNopLogger())
+	server.MaxMessageSize = 512 * 1024
+	listener, _ := setupServer(server, port)
+	defer listener.Close()
+
+	// Setup a client
+	client := setupClient(port)
+	defer client.Close()
+
+	t.Run("insert and select", func(t *testing.T) {
+		value := bytes.Repeat([]byte("abcdefgh"), 8*1024)
+
+		writeQuery(t, client, 1, keyvalNet.Query{
+			Method: keyvalNet.Insert,
+			Key:    "large",
+			Value:  value,
+		})
+		if expected, actual := keyvalNet.Created, readResult(t, client, 1).Status; expected != actual {
+			t.Errorf("expected: %v, actual: %v", expected, actual)
+		}
+
+		writeQuery(t, client, 2, keyvalNet.Query{
+			Method: keyvalNet.Select,
+			Key:    "large",
+		})
+		res := readResult(t, client, 2)
+		if expected, actual := keyvalNet.OK, res.Status; expected != actual {
+			t.Errorf("expected: %v, actual: %v", expected, actual)
+		}
+		if expected, actual := value, res.Value; !bytes.Equal(expected, actual) {
+			t.Errorf("expected: %v, actual: %v", len(expected), len(actual))
+		}
+	})
+
+	t.Run("frames out of order", func(t *testing.T) {
+		var buf bytes.Buffer
+		if err := gob.NewEncoder(&buf).Encode(keyvalNet.Query{
+			Method: keyvalNet.Insert,
+			Key:    "unordered",
+			Value:  bytes.Repeat([]byte("a"), 4096),
+		}); err != nil {
+			t.Fatal(err)
+		}
+		frames, err := keyvalNet.Fragment(3, buf.Bytes(), keyvalNet.DefaultFrameSize)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// Send the frames backwards, with the last frame sent twice.
+		client.Write(frames[len(frames)-1].AppendTo(nil))
+		for k := len(frames) - 1; k >= 0; k-- {
+			client.Write(frames[k].AppendTo(nil))
+		}
+		if expected, actual := keyvalNet.Created, readResult(t, client, 3).Status; expected != actual {
+			t.Errorf("expected: %v, actual: %v", expected, actual)
+		}
+	})
+
+	t.Run("too large", func(t *testing.T) {
+		writeQuery(t, client, 4, keyvalNet.Query{
+			Method: keyvalNet.Insert,
+			Key:    "too-large",
+			Value:  make([]byte, server.MaxMessageSize*2),
+		})
+		if expected, actual := keyvalNet.BadRequest, readResult(t, client, 4).Status; expected != actual {
+			t.Errorf("expected: %v, actual: %v", expected, actual)
+		}
+	})
+}
+
+func TestAPIDedupe(t *testing.T) {
+	t.Parallel()
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	store := mocks.NewMockStore(ctrl)
+
+	port := 9015
+
+	// Setup server
+	server := NewServer(store, log.NewNopLogger())
+	listener, _ := setupServer(server, port)
+	defer listener.Close()
+
+	// Setup a client
+	client := setupClient(port)
+	defer client.Close()
+
+	key := buildKey([]byte("abc"))
+
+	// The query is only handled once for each ID, no matter how many times
+	// it's sent.
+	store.EXPECT().Delete(key).Return(true)
+	store.EXPECT().Delete(key).Return(false)
+
+	for _, testcase := range []struct {
+		id     uint64
+		status keyvalNet.Status
+	}{
+		{1, keyvalNet.OK},
+		{1, keyvalNet.OK},
+		{2, keyvalNet.NotFound},
+		{1, keyvalNet.OK},
+		{2, keyvalNet.NotFound},
+	} {
+		writeQuery(t, client, testcase.id, keyvalNet.Query{
+			Method: keyvalNet.Delete,
+			Key:    key,
+		})
+		if expected, actual := testcase.status, readResult(t, client, testcase.id).Status; expected != actual {
+			t.Errorf("expected: %v, actual: %v", expected, actual)
+		}
+	}
+}
+
+func TestAPIShedding(t *testing.T) {
+	t.Parallel()
+
+	for _, testcase := range []struct {
+		shedding Shedding
+		port     int
+	}{
+		{ShedReply, 9016},
+		{ShedDrop, 9017},
+	} {
+		testcase := testcase
+		t.Run(testcase.shedding.String(), func(t *testing.T) {
+			t.Parallel()
+
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+
+			store := mocks.NewMockStore(ctrl)
+
+			// Setup server, with a single worker that's kept busy and room
+			// for only one query to wait.
+			server := NewServer(store, log.NewNopLogger())
+			server.Workers = 1
+			server.QueueSize = 1
+			server.Shedding = testcase.shedding
+			listener, _ := setupServer(server, testcase.port)
+			defer listener.Close()
+
+			// Setup a client
+			client := setupClient(testcase.port)
+			defer client.Close()
+
+			var (
+				started = make(chan struct{})
+				release = make(chan struct{})
+			)
+			store.EXPECT().Select("a").DoAndReturn(func(string) (keyvalStore.Entry, bool) {
+				close(started)
+				<-release
+				return keyvalStore.Entry{Key: "a", Value: []byte("b")}, true
+			})
+			store.EXPECT().Select("a").Return(keyvalStore.Entry{Key: "a", Value: []byte("b")}, true)
+
+			writeQuery(t, client, 1, keyvalNet.Query{Method: keyvalNet.Select, Key: "a"})
+			<-started
+			writeQuery(t, client, 2, keyvalNet.Query{Method: keyvalNet.Select, Key: "a"})
+			writeQuery(t, client, 3, keyvalNet.Query{Method: keyvalNet.Select, Key: "a"})
+
+			if testcase.shedding == ShedReply {
+				if expected, actual := keyvalNet.ServerError, readResult(t, client, 3).Status; expected != actual {
+					t.Errorf("expected: %v, actual: %v", expected, actual)
+				}
+			}
+			waitFor(t, func() bool { return server.Stats().Shed == 1 })
+			if expected, actual := 1, server.Stats().QueueDepth; expected != actual {
+				t.Errorf("expected: %v, actual: %v", expected, actual)
+			}
+
+			close(release)
+			for _, id := range []uint64{1, 2} {
+				if expected, actual := keyvalNet.OK, readResult(t, client, id).Status; expected != actual {
+					t.Errorf("expected: %v, actual: %v", expected, actual)
+				}
+			}
+			if expected, actual := (Stats{
+				Workers:   1,
+				QueueSize: 1,
+				Handled:   2,
+				Shed:      1,
+			}), server.Stats(); expected != actual {
+				t.Errorf("expected: %v, actual: %v", expected, actual)
+			}
+		})
+	}
+}
+
+func TestAPIStop(t *testing.T) {
+	t.Parallel()
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	store := mocks.NewMockStore(ctrl)
+
+	port := 9018
+
+	// Setup server
+	server := NewServer(store, log.NewNopLogger())
+	server.Workers = 1
+	listener, _ := setupServer(server, port)
+	defer listener.Close()
+
+	// Setup a client
+	client := setupClient(port)
+	defer client.Close()
+
+	var (
+		started = make(chan struct{})
+		release = make(chan struct{})
+	)
+	store.EXPECT().Delete("a").DoAndReturn(func(string) bool {
+		close(started)
+		<-release
+		return true
+	})
+	store.EXPECT().Delete("b").Return(true)
+
+	writeQuery(t, client, 1, keyvalNet.Query{Method: keyvalNet.Delete, Key: "a"})
+	<-started
+	writeQuery(t, client, 2, keyvalNet.Query{Method: keyvalNet.Delete, Key: "b"})
+	waitFor(t, func() bool { return server.Stats().QueueDepth == 1 })
+
+	// Stopping waits for the queries that have been read to be handled.
+	stopped := make(chan struct{})
+	go func() {
+		server.Stop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+		t.Fatal("expected stop to wait")
+	case <-time.After(time.Millisecond * 50):
+	}
+
+	close(release)
+	select {
+	case <-stopped:
+	case <-time.After(time.Second * 5):
+		t.Fatal("expected stop to finish")
+	}
+	for _, id := range []uint64{1, 2} {
+		if expected, actual := keyvalNet.OK, readResult(t, client, id).Status; expected != actual {
+			t.Errorf("expected: %v, actual: %v", expected, actual)
+		}
+	}
+
+	// Stopping a stopped server doesn't block.
+	server.Stop()
+}
+
+func TestAPIUnknownCodec(t *testing.T) {
+	t.Parallel()
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	store := mocks.NewMockStore(ctrl)
+
+	port := 9019
+
+	// Setup server
+	server := NewServer(store, log.NewNopLogger())
+	listener, _ := setupServer(server, port)
+	defer listener.Close()
+
+	// Setup a client
+	client := setupClient(port)
+	defer client.Close()
+
+	frame := keyvalNet.Frame{
+		ID:       1,
+		Total:    1,
+		Fragment: []byte{keyvalNet.HandshakeMagic, 0xff, 'a'},
+	}
+	if _, err := client.Write(frame.AppendTo(nil)); err != nil {
+		t.Fatal(err)
+	}
+
+	client.SetReadDeadline(time.Now().Add(time.Second * 5))
+	buf := make([]byte, keyvalNet.MaxFrameSize)
+	n, err := client.Read(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := keyvalNet.ParseFrame(buf[:n])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected, actual := []byte{keyvalNet.HandshakeMagic, 0}, res.Fragment; !bytes.Equal(expected, actual) {
+		t.Errorf("expected: %v, actual: %v", expected, actual)
+	}
+}
+
+func TestAPIUnframed(t *testing.T) {
+	t.Parallel()
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	store := mocks.NewMockStore(ctrl)
+
+	port := 9068
+
+	// Setup server
+	server := NewServer(store, log.NewNopLogger())
+	listener, _ := setupServer(server, port)
+	defer listener.Close()
+
+	// Setup a client
+	client := setupClient(port)
+	defer client.Close()
+
+	key := buildKey([]byte("abc"))
+	value := []byte("def")
+
+	store.EXPECT().Select(key).Return(keyvalStore.Entry{Key: key, Value: value, Version: 1}, true)
+
+	// A client from before queries were framed sends the gob query as is.
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(keyvalNet.Query{
+		Method: keyvalNet.Select,
+		Key:    key,
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.Write(buf.Bytes()); err != nil {
+		t.Fatal(err)
+	}
+
+	client.SetReadDeadline(time.Now().Add(time.Second * 5))
+	b := make([]byte, keyvalNet.MaxFrameSize)
+	n, err := client.Read(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The result is sent back without any frames.
+	var res keyvalNet.Result
+	if err := gob.NewDecoder(bytes.NewReader(b[:n])).Decode(&res); err != nil {
+		t.Fatal(err)
+	}
+	if expected, actual := keyvalNet.OK, res.Status; expected != actual {
+		t.Errorf("expected: %v, actual: %v", expected, actual)
+	}
+	if expected, actual := value, res.Value; !reflect.DeepEqual(expected, actual) {
+		t.Errorf("expected: %v, actual: %v", expected, actual)
+	}
+}
+
 func setupServer(server *Server, port int) (*net.UDPConn, *net.UDPAddr) {
 	udpAddr, err := net.ResolveUDPAddr("udp", fmt.Sprintf("0.0.0.0:%d", port))
 	if err != nil {
@@ -230,6 +588,75 @@ func setupClient(port int) *net.UDPConn {
 	return conn
 }
 
+// writeQuery sends the query as frames, paced so that the socket buffers of
+// the server don't overflow.
+func writeQuery(t *testing.T, conn *net.UDPConn, id uint64, query keyvalNet.Query) {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(query); err != nil {
+		t.Fatal(err)
+	}
+	frames, err := keyvalNet.Fragment(id, buf.Bytes(), keyvalNet.DefaultFrameSize)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for k, frame := range frames {
+		if _, err := conn.Write(frame.AppendTo(nil)); err != nil {
+			t.Fatal(err)
+		}
+		if k%32 == 31 {
+			time.Sleep(time.Millisecond)
+		}
+	}
+}
+
+// readResult reads frames until the result with the id has been reassembled.
+func readResult(t *testing.T, conn *net.UDPConn, id uint64) keyvalNet.Result {
+	conn.SetReadDeadline(time.Now().Add(time.Second * 5))
+
+	var (
+		buf         = make([]byte, keyvalNet.MaxFrameSize)
+		reassembler = keyvalNet.NewReassembler(
+			keyvalNet.DefaultMaxMessageSize,
+			keyvalNet.DefaultMaxReassemblyBytes,
+			keyvalNet.DefaultReassemblyTimeout,
+		)
+	)
+	for {
+		n, err := conn.Read(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		frame, err := keyvalNet.ParseFrame(buf[:n])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if frame.ID != id {
+			continue
+		}
+		message, ok, err := reassembler.Add("", frame)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !ok {
+			continue
+		}
+
+		var res keyvalNet.Result
+		if err := gob.NewDecoder(bytes.NewReader(message)).Decode(&res); err != nil {
+			t.Fatal(err)
+		}
+		return res
+	}
+}
+
+func waitFor(t *testing.T, fn func() bool) {
+	for begin := time.Now(); !fn(); time.Sleep(time.Millisecond) {
+		if time.Since(begin) > time.Second*5 {
+			t.Fatal("timed out waiting")
+		}
+	}
+}
+
 func buildKey(a []byte) string {
 	v := base64.RawURLEncoding.EncodeToString(a)
 	if v == "" {
@@ -0,0 +1,569 @@
+package wal
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// SyncPolicy defines when the log is flushed to stable storage.
+type SyncPolicy int
+
+const (
+	// SyncAlways flushes the log after every append, before the append returns.
+	SyncAlways SyncPolicy = iota
+	// SyncInterval flushes the log periodically, according to the interval.
+	SyncInterval
+	// SyncNever leaves flushing the log up to the operating system.
+	SyncNever
+)
+
+// Options defines how the log is written.
+type Options struct {
+	// SegmentSize is the size in bytes a segment can grow to before a new
+	// segment is created.
+	SegmentSize int64
+	// Sync is the policy for flushing the log to stable storage.
+	Sync SyncPolicy
+	// SyncInterval is how often the log is flushed, when using SyncInterval.
+	SyncInterval time.Duration
+}
+
+const (
+	// DefaultSegmentSize is the segment size used when none is supplied.
+	DefaultSegmentSize = 64 << 20
+
+	// headerSize is the size of the header that precedes every record, made
+	// up of the length of the record, the checksum and the sequence.
+	headerSize = 4 + 4 + 8
+	// maxRecordSize is used to detect corruption of the length of a record,
+	// before trying to allocate it.
+	maxRecordSize = 1 << 30
+
+	segmentExt  = ".wal"
+	snapshotExt = ".snap"
+	tempExt     = ".tmp"
+)
+
+// ErrCorrupt is returned when a record fails to pass the checksum or is only
+// partially written.
+var ErrCorrupt = errors.New("corrupt record")
+
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+// segment is a file of the log, the name of the file is the sequence of the
+// first record with in the segment.
+type segment struct {
+	first uint64
+	path  string
+}
+
+// Log is an append only, segmented write-ahead log. Every record is assigned a
+// sequence that increases by one for every record appended. Each record is
+// checksummed, so a partially written record (e.g. from a crash) at the end of
+// the log is detected and removed when the log is opened.
+// The log can also hold a snapshot, which replaces all the records up to and
+// including the sequence of the snapshot, so that the log doesn't grow without
+// bound.
+type Log struct {
+	mutex    sync.Mutex
+	dir      string
+	options  Options
+	segments []segment
+	file     *os.File
+	size     int64
+	seq      uint64
+	dirty    bool
+	stop     chan chan struct{}
+}
+
+// Open opens the log with in the directory, creating the directory if it
+// doesn't exist.
+func Open(dir string, options Options) (*Log, error) {
+	if options.SegmentSize <= 0 {
+		options.SegmentSize = DefaultSegmentSize
+	}
+	if options.Sync == SyncInterval && options.SyncInterval <= 0 {
+		return nil, errors.New("sync interval must be greater than zero")
+	}
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, errors.Wrap(err, "unable to create log directory")
+	}
+
+	segments, err := readFiles(dir, segmentExt)
+	if err != nil {
+		return nil, err
+	}
+	snapshots, err := readFiles(dir, snapshotExt)
+	if err != nil {
+		return nil, err
+	}
+	if err := removeTemp(dir); err != nil {
+		return nil, err
+	}
+
+	l := &Log{
+		dir:      dir,
+		options:  options,
+		segments: segments,
+		stop:     make(chan chan struct{}),
+	}
+
+	if len(segments) == 0 {
+		// Carry on from the latest snapshot, otherwise new records would be
+		// hidden by the snapshot.
+		first := uint64(1)
+		if len(snapshots) > 0 {
+			first = snapshots[len(snapshots)-1].first + 1
+		}
+		if err := l.createSegment(first); err != nil {
+			return nil, err
+		}
+	} else if err := l.openSegment(segments[len(segments)-1]); err != nil {
+		return nil, err
+	}
+
+	if options.Sync == SyncInterval {
+		go l.run()
+	}
+	return l, nil
+}
+
+// Append appends the record to the log, returning the sequence of the record.
+func (l *Log) Append(record []byte) (uint64, error) {
+	if len(record) > maxRecordSize {
+		return 0, errors.Errorf("record too large (%d bytes)", len(record))
+	}
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if l.file == nil {
+		return 0, errors.New("log is closed")
+	}
+
+	if l.size >= l.options.SegmentSize {
+		if err := l.rotate(); err != nil {
+			return 0, err
+		}
+	}
+
+	seq := l.seq + 1
+
+	n, err := l.file.Write(encodeRecord(seq, record))
+	l.size += int64(n)
+	if err != nil {
+		return 0, errors.Wrap(err, "unable to write record")
+	}
+	l.seq = seq
+
+	if l.options.Sync == SyncAlways {
+		if err := l.file.Sync(); err != nil {
+			return 0, errors.Wrap(err, "unable to sync log")
+		}
+	} else {
+		l.dirty = true
+	}
+	return seq, nil
+}
+
+// Replay calls the function for every record with in the log that has a
+// sequence greater than the sequence supplied, in order of the sequence.
+func (l *Log) Replay(after uint64, fn func(seq uint64, record []byte) error) error {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	for k, s := range l.segments {
+		// Every record with in the segment is before the sequence.
+		if k+1 < len(l.segments) && l.segments[k+1].first <= after+1 {
+			continue
+		}
+
+		if err := replaySegment(s, after, fn); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Snapshot writes a snapshot that replaces every record up to and including the
+// sequence. The function is called with a write function for writing each
+// record of the snapshot. Once the snapshot is written, any previous snapshots
+// and any segments that only contain records before the sequence are removed.
+// Appends to the log can continue whilst the snapshot is being written.
+func (l *Log) Snapshot(seq uint64, fn func(write func(record []byte) error) error) error {
+	path := filepath.Join(l.dir, fmt.Sprintf("%020d%s", seq, snapshotExt))
+
+	file, err := os.OpenFile(path+tempExt, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		return errors.Wrap(err, "unable to create snapshot")
+	}
+	defer os.Remove(path + tempExt)
+	defer file.Close()
+
+	writer := bufio.NewWriter(file)
+	if err := fn(func(record []byte) error {
+		if len(record) > maxRecordSize {
+			return errors.Errorf("record too large (%d bytes)", len(record))
+		}
+		_, err := writer.Write(encodeRecord(seq, record))
+		return err
+	}); err != nil {
+		return errors.Wrap(err, "unable to write snapshot")
+	}
+
+	if err := writer.Flush(); err != nil {
+		return errors.Wrap(err, "unable to write snapshot")
+	}
+	if err := file.Sync(); err != nil {
+		return errors.Wrap(err, "unable to sync snapshot")
+	}
+	if err := file.Close(); err != nil {
+		return errors.Wrap(err, "unable to close snapshot")
+	}
+	if err := os.Rename(path+tempExt, path); err != nil {
+		return errors.Wrap(err, "unable to rename snapshot")
+	}
+	if err := syncDir(l.dir); err != nil {
+		return err
+	}
+
+	return l.compact(seq)
+}
+
+// Restore calls the function for every record with in the latest snapshot,
+// returning the sequence of the snapshot. If there is no snapshot, then the
+// sequence is zero.
+func (l *Log) Restore(fn func(record []byte) error) (uint64, error) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	snapshots, err := readFiles(l.dir, snapshotExt)
+	if err != nil || len(snapshots) == 0 {
+		return 0, err
+	}
+
+	s := snapshots[len(snapshots)-1]
+	return s.first, replaySegment(s, 0, func(_ uint64, record []byte) error {
+		return fn(record)
+	})
+}
+
+// compact removes the snapshots and the segments that are before the sequence.
+// The segment currently being appended to is never removed.
+func (l *Log) compact(seq uint64) error {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	snapshots, err := readFiles(l.dir, snapshotExt)
+	if err != nil {
+		return err
+	}
+	for _, s := range snapshots {
+		if s.first < seq {
+			if err := os.Remove(s.path); err != nil {
+				return errors.Wrap(err, "unable to remove snapshot")
+			}
+		}
+	}
+
+	var removed int
+	for k := 0; k+1 < len(l.segments) && l.segments[k+1].first <= seq+1; k++ {
+		if err := os.Remove(l.segments[k].path); err != nil {
+			return errors.Wrap(err, "unable to remove segment")
+		}
+		removed++
+	}
+	l.segments = l.segments[removed:]
+
+	return syncDir(l.dir)
+}
+
+// Sequence returns the sequence of the last record appended to the log.
+func (l *Log) Sequence() uint64 {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	return l.seq
+}
+
+// Sync flushes the log to stable storage.
+func (l *Log) Sync() error {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	return l.sync()
+}
+
+// Close flushes and then closes the log.
+func (l *Log) Close() error {
+	if l.options.Sync == SyncInterval {
+		q := make(chan struct{})
+		l.stop <- q
+		<-q
+	}
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if l.file == nil {
+		return nil
+	}
+	if err := l.sync(); err != nil {
+		return err
+	}
+	err := l.file.Close()
+	l.file = nil
+	return err
+}
+
+func (l *Log) run() {
+	step := time.NewTicker(l.options.SyncInterval)
+	defer step.Stop()
+
+	for {
+		select {
+		case <-step.C:
+			// Errors are reported on the next append or close, as the file
+			// will fail those too.
+			l.Sync()
+		case q := <-l.stop:
+			close(q)
+			return
+		}
+	}
+}
+
+// sync expects the caller to hold the lock.
+func (l *Log) sync() error {
+	if !l.dirty || l.file == nil {
+		return nil
+	}
+	if err := l.file.Sync(); err != nil {
+		return errors.Wrap(err, "unable to sync log")
+	}
+	l.dirty = false
+	return nil
+}
+
+// rotate closes the current segment and creates a new one.
+// rotate expects the caller to hold the lock.
+func (l *Log) rotate() error {
+	if err := l.file.Sync(); err != nil {
+		return errors.Wrap(err, "unable to sync segment")
+	}
+	if err := l.file.Close(); err != nil {
+		return errors.Wrap(err, "unable to close segment")
+	}
+	l.dirty = false
+
+	return l.createSegment(l.seq + 1)
+}
+
+// createSegment expects the caller to hold the lock.
+func (l *Log) createSegment(first uint64) error {
+	s := segment{
+		first: first,
+		path:  filepath.Join(l.dir, fmt.Sprintf("%020d%s", first, segmentExt)),
+	}
+
+	file, err := os.OpenFile(s.path, os.O_CREATE|os.O_EXCL|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return errors.Wrap(err, "unable to create segment")
+	}
+	if err := syncDir(l.dir); err != nil {
+		file.Close()
+		return err
+	}
+
+	l.segments = append(l.segments, s)
+	l.file = file
+	l.size = 0
+	l.seq = first - 1
+	return nil
+}
+
+// openSegment opens the segment for appending, truncating any partially
+// written record from the end of the segment.
+func (l *Log) openSegment(s segment) error {
+	file, err := os.OpenFile(s.path, os.O_RDWR, 0644)
+	if err != nil {
+		return errors.Wrap(err, "unable to open segment")
+	}
+
+	var (
+		seq    = s.first - 1
+		offset int64
+		reader = bufio.NewReader(file)
+	)
+	for {
+		n, recordSeq, _, err := readRecord(reader)
+		if err == io.EOF {
+			break
+		} else if err == ErrCorrupt {
+			if err := file.Truncate(offset); err != nil {
+				file.Close()
+				return errors.Wrap(err, "unable to truncate segment")
+			}
+			break
+		} else if err != nil {
+			file.Close()
+			return errors.Wrap(err, "unable to read segment")
+		}
+
+		offset += n
+		seq = recordSeq
+	}
+
+	if _, err := file.Seek(offset, io.SeekStart); err != nil {
+		file.Close()
+		return errors.Wrap(err, "unable to seek segment")
+	}
+
+	l.file = file
+	l.size = offset
+	l.seq = seq
+	return nil
+}
+
+func replaySegment(s segment, after uint64, fn func(uint64, []byte) error) error {
+	file, err := os.Open(s.path)
+	if err != nil {
+		return errors.Wrap(err, "unable to open segment")
+	}
+	defer file.Close()
+
+	reader := bufio.NewReader(file)
+	for {
+		_, seq, record, err := readRecord(reader)
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return errors.Wrapf(err, "unable to replay segment %s", s.path)
+		}
+
+		if seq <= after {
+			continue
+		}
+		if err := fn(seq, record); err != nil {
+			return err
+		}
+	}
+}
+
+// encodeRecord prefixes the record with the header.
+func encodeRecord(seq uint64, record []byte) []byte {
+	buf := make([]byte, headerSize+len(record))
+	binary.BigEndian.PutUint32(buf[0:], uint32(len(record)))
+	binary.BigEndian.PutUint64(buf[8:], seq)
+	copy(buf[headerSize:], record)
+	binary.BigEndian.PutUint32(buf[4:], crc32.Checksum(buf[8:], crcTable))
+	return buf
+}
+
+// readRecord reads the next record, returning the number of bytes read along
+// with the sequence and the record. io.EOF is returned only if there are no
+// more records.
+func readRecord(r io.Reader) (int64, uint64, []byte, error) {
+	var header [headerSize]byte
+	if _, err := io.ReadFull(r, header[:]); err == io.EOF {
+		return 0, 0, nil, io.EOF
+	} else if err == io.ErrUnexpectedEOF {
+		return 0, 0, nil, ErrCorrupt
+	} else if err != nil {
+		return 0, 0, nil, err
+	}
+
+	length := binary.BigEndian.Uint32(header[0:])
+	if length > maxRecordSize {
+		return 0, 0, nil, ErrCorrupt
+	}
+
+	buf := make([]byte, 8+int(length))
+	copy(buf, header[8:])
+	if _, err := io.ReadFull(r, buf[8:]); err == io.EOF || err == io.ErrUnexpectedEOF {
+		return 0, 0, nil, ErrCorrupt
+	} else if err != nil {
+		return 0, 0, nil, err
+	}
+
+	if crc32.Checksum(buf, crcTable) != binary.BigEndian.Uint32(header[4:]) {
+		return 0, 0, nil, ErrCorrupt
+	}
+
+	return int64(headerSize + length), binary.BigEndian.Uint64(buf), buf[8:], nil
+}
+
+// readFiles returns the files with in the directory that have the extension,
+// ordered by the sequence the file is named after.
+func readFiles(dir, ext string) ([]segment, error) {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to read log directory")
+	}
+
+	var segments []segment
+	for _, f := range files {
+		name := f.Name()
+		if f.IsDir() || !strings.HasSuffix(name, ext) {
+			continue
+		}
+
+		first, err := strconv.ParseUint(strings.TrimSuffix(name, ext), 10, 64)
+		if err != nil {
+			continue
+		}
+		segments = append(segments, segment{
+			first: first,
+			path:  filepath.Join(dir, name),
+		})
+	}
+
+	sort.Slice(segments, func(i, j int) bool {
+		return segments[i].first < segments[j].first
+	})
+	return segments, nil
+}
+
+// removeTemp removes any partially written snapshots.
+func removeTemp(dir string) error {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return errors.Wrap(err, "unable to read log directory")
+	}
+	for _, f := range files {
+		if !f.IsDir() && strings.HasSuffix(f.Name(), tempExt) {
+			if err := os.Remove(filepath.Join(dir, f.Name())); err != nil {
+				return errors.Wrap(err, "unable to remove temporary file")
+			}
+		}
+	}
+	return nil
+}
+
+// syncDir flushes the directory, so that new or removed files are persisted.
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return errors.Wrap(err, "unable to open log directory")
+	}
+	defer d.Close()
+
+	if err := d.Sync(); err != nil {
+		return errors.Wrap(err, "unable to sync log directory")
+	}
+	return nil
+}
@@ -0,0 +1,310 @@
+package wal
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"testing/quick"
+)
+
+func TestLog(t *testing.T) {
+	t.Parallel()
+
+	t.Run("replaying returns appended records", func(t *testing.T) {
+		fn := func(records [][]byte) bool {
+			dir := tempDir(t)
+			defer os.RemoveAll(dir)
+
+			log, err := Open(dir, Options{SegmentSize: 64})
+			if err != nil {
+				t.Error(err)
+				return false
+			}
+			for k, v := range records {
+				seq, err := log.Append(v)
+				if err != nil || seq != uint64(k+1) {
+					return false
+				}
+			}
+			if err := log.Close(); err != nil {
+				t.Error(err)
+				return false
+			}
+
+			// Compare the bytes as the records may be nil or empty.
+			replayed := replay(t, dir, 0)
+			if len(records) != len(replayed) {
+				return false
+			}
+			for k, v := range records {
+				if !bytes.Equal(v, replayed[k]) {
+					return false
+				}
+			}
+			return true
+		}
+		if err := quick.Check(fn, nil); err != nil {
+			t.Error(err)
+		}
+	})
+
+	t.Run("replaying after a sequence", func(t *testing.T) {
+		dir := tempDir(t)
+		defer os.RemoveAll(dir)
+
+		log, err := Open(dir, Options{SegmentSize: 64})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var records [][]byte
+		for i := 0; i < 20; i++ {
+			record := []byte(fmt.Sprintf("record-%d", i))
+			records = append(records, record)
+			if _, err := log.Append(record); err != nil {
+				t.Fatal(err)
+			}
+		}
+		log.Close()
+
+		if expected, actual := records[12:], replay(t, dir, 12); !reflect.DeepEqual(expected, actual) {
+			t.Errorf("expected: %q, actual: %q", expected, actual)
+		}
+	})
+
+	t.Run("appending after reopening continues the sequence", func(t *testing.T) {
+		dir := tempDir(t)
+		defer os.RemoveAll(dir)
+
+		for i := 0; i < 3; i++ {
+			log, err := Open(dir, Options{SegmentSize: 64})
+			if err != nil {
+				t.Fatal(err)
+			}
+			for j := 0; j < 10; j++ {
+				seq, err := log.Append([]byte("abcdefghijklmnopqrstuvwxyz"))
+				if err != nil {
+					t.Fatal(err)
+				}
+				if expected, actual := uint64((i*10)+j+1), seq; expected != actual {
+					t.Fatalf("expected: %v, actual: %v", expected, actual)
+				}
+			}
+			log.Close()
+		}
+
+		if expected, actual := 30, len(replay(t, dir, 0)); expected != actual {
+			t.Errorf("expected: %v, actual: %v", expected, actual)
+		}
+	})
+
+	t.Run("opening truncates a partially written record", func(t *testing.T) {
+		dir := tempDir(t)
+		defer os.RemoveAll(dir)
+
+		log, err := Open(dir, Options{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		log.Append([]byte("abc"))
+		log.Append([]byte("def"))
+		log.Close()
+
+		// Chop the last record in half.
+		path := filepath.Join(dir, fmt.Sprintf("%020d%s", 1, segmentExt))
+		info, err := os.Stat(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Truncate(path, info.Size()-5); err != nil {
+			t.Fatal(err)
+		}
+
+		log, err = Open(dir, Options{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		seq, err := log.Append([]byte("ghi"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		log.Close()
+
+		if expected, actual := uint64(2), seq; expected != actual {
+			t.Errorf("expected: %v, actual: %v", expected, actual)
+		}
+		if expected, actual := [][]byte{[]byte("abc"), []byte("ghi")}, replay(t, dir, 0); !reflect.DeepEqual(expected, actual) {
+			t.Errorf("expected: %q, actual: %q", expected, actual)
+		}
+	})
+
+	t.Run("replaying detects a corrupt record", func(t *testing.T) {
+		dir := tempDir(t)
+		defer os.RemoveAll(dir)
+
+		log, err := Open(dir, Options{SegmentSize: 1})
+		if err != nil {
+			t.Fatal(err)
+		}
+		log.Append([]byte("abc"))
+		log.Append([]byte("def"))
+		log.Close()
+
+		// Flip a bit in the first segment, which isn't the last segment so
+		// isn't truncated when opened.
+		path := filepath.Join(dir, fmt.Sprintf("%020d%s", 1, segmentExt))
+		b, err := ioutil.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b[len(b)-1] ^= 0xff
+		if err := ioutil.WriteFile(path, b, 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		log, err = Open(dir, Options{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer log.Close()
+
+		if err := log.Replay(0, func(uint64, []byte) error { return nil }); err == nil {
+			t.Errorf("expected error")
+		}
+	})
+
+	t.Run("snapshot replaces the records before it", func(t *testing.T) {
+		dir := tempDir(t)
+		defer os.RemoveAll(dir)
+
+		log, err := Open(dir, Options{SegmentSize: 1})
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < 10; i++ {
+			log.Append([]byte(fmt.Sprintf("record-%d", i)))
+		}
+
+		if err := log.Snapshot(5, func(write func([]byte) error) error {
+			return write([]byte("snapshot"))
+		}); err != nil {
+			t.Fatal(err)
+		}
+		log.Append([]byte("record-10"))
+		log.Close()
+
+		segments, err := readFiles(dir, segmentExt)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if expected, actual := uint64(6), segments[0].first; expected != actual {
+			t.Errorf("expected: %v, actual: %v", expected, actual)
+		}
+
+		log, err = Open(dir, Options{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer log.Close()
+
+		var snapshot [][]byte
+		seq, err := log.Restore(func(record []byte) error {
+			snapshot = append(snapshot, record)
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if expected, actual := uint64(5), seq; expected != actual {
+			t.Errorf("expected: %v, actual: %v", expected, actual)
+		}
+		if expected, actual := [][]byte{[]byte("snapshot")}, snapshot; !reflect.DeepEqual(expected, actual) {
+			t.Errorf("expected: %q, actual: %q", expected, actual)
+		}
+
+		var records [][]byte
+		if err := log.Replay(seq, func(_ uint64, record []byte) error {
+			records = append(records, record)
+			return nil
+		}); err != nil {
+			t.Fatal(err)
+		}
+		if expected, actual := 6, len(records); expected != actual {
+			t.Errorf("expected: %v, actual: %v", expected, actual)
+		}
+	})
+
+	t.Run("failed snapshot leaves the log untouched", func(t *testing.T) {
+		dir := tempDir(t)
+		defer os.RemoveAll(dir)
+
+		log, err := Open(dir, Options{SegmentSize: 1})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer log.Close()
+
+		for i := 0; i < 10; i++ {
+			log.Append([]byte(fmt.Sprintf("record-%d", i)))
+		}
+
+		if err := log.Snapshot(5, func(write func([]byte) error) error {
+			return fmt.Errorf("bad")
+		}); err == nil {
+			t.Fatal("expected error")
+		}
+
+		seq, err := log.Restore(func([]byte) error { return nil })
+		if err != nil {
+			t.Fatal(err)
+		}
+		if expected, actual := uint64(0), seq; expected != actual {
+			t.Errorf("expected: %v, actual: %v", expected, actual)
+		}
+
+		segments, err := readFiles(dir, segmentExt)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if expected, actual := 10, len(segments); expected != actual {
+			t.Errorf("expected: %v, actual: %v", expected, actual)
+		}
+	})
+
+	t.Run("sync interval requires an interval", func(t *testing.T) {
+		dir := tempDir(t)
+		defer os.RemoveAll(dir)
+
+		if _, err := Open(dir, Options{Sync: SyncInterval}); err == nil {
+			t.Errorf("expected error")
+		}
+	})
+}
+
+func tempDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "wal")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+func replay(t *testing.T, dir string, after uint64) [][]byte {
+	log, err := Open(dir, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer log.Close()
+
+	var records [][]byte
+	if err := log.Replay(after, func(seq uint64, record []byte) error {
+		records = append(records, record)
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	return records
+}